@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	encryption "onion_routing/encryption"
+	onionclient "onion_routing/onionclient"
+	utils "onion_routing/utils"
+)
+
+const commandUsage = `usage: client [flags] [command]
+
+commands:
+  greet <message>    send a greeting to the server (@file sends the file)
+  fib <n>            ask the server for the nth Fibonacci number (0-40)
+  rand <n>           ask the server for n random numbers (1-10000)
+  batch <file>       run one command per line of file ("-" for stdin);
+                     blank lines and lines starting with # are skipped,
+                     -parallel requests are in flight at once
+  circuit show       build a circuit and show its relays
+
+Without a command the client prompts for request types, or serves a SOCKS5
+proxy with -socks-addr. Flags go before the command; -output json prints one
+JSON object per request.
+
+exit status: 0 success, 1 a request failed, 2 invalid usage or
+configuration, 3 no circuit could be built
+
+flags:
+`
+
+const (
+	exitOK        = 0
+	exitFailed    = 1
+	exitUsage     = 2
+	exitNoCircuit = 3
+)
+
+const (
+	maxFibonacci   = 40      // the server computes it recursively
+	maxRandomCount = 1000000 // a reply of about 4 MB
+)
+
+// command is a parsed request for the server.
+type command struct {
+	Name     string `json:"command"`
+	Argument string `json:"argument"`
+	reqType  byte
+	message  []byte // what is sent; the argument, or the file it names
+}
+
+// commandResult is what a request prints.
+type commandResult struct {
+	command
+	Reply      string  `json:"reply,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+func parseCommand(args []string) (command, error) {
+	if len(args) == 0 {
+		return command{}, fmt.Errorf("missing command")
+	}
+	name, rest := args[0], args[1:]
+	switch name {
+	case "greet":
+		argument := strings.Join(rest, " ")
+		if argument == "" {
+			return command{}, fmt.Errorf("greet needs a message")
+		}
+		message := []byte(argument)
+		if len(rest) == 1 && strings.HasPrefix(argument, "@") {
+			var err error
+			message, err = os.ReadFile(argument[1:])
+			if err != nil {
+				return command{}, fmt.Errorf("greet: %v", err)
+			}
+		}
+		return command{Name: name, Argument: argument, reqType: encryption.GREET_REQUEST, message: message}, nil
+	case "fib", "rand":
+		if len(rest) != 1 {
+			return command{}, fmt.Errorf("%s needs exactly one number", name)
+		}
+		n, err := strconv.Atoi(rest[0])
+		if err != nil {
+			return command{}, fmt.Errorf("%s: %q is not a number", name, rest[0])
+		}
+		if name == "fib" {
+			if n < 0 || n > maxFibonacci {
+				return command{}, fmt.Errorf("fib: n must be between 0 and %d, got %d", maxFibonacci, n)
+			}
+			return command{Name: name, Argument: rest[0], reqType: encryption.FIBONACCI_REQUEST, message: []byte(rest[0])}, nil
+		}
+		if n < 1 || n > maxRandomCount {
+			return command{}, fmt.Errorf("rand: n must be between 1 and %d, got %d", maxRandomCount, n)
+		}
+		return command{Name: name, Argument: rest[0], reqType: encryption.RANDOM_REQUEST, message: []byte(rest[0])}, nil
+	}
+	return command{}, fmt.Errorf("unknown command %q", name)
+}
+
+// parseBatch reads one command per line. All lines are checked before any
+// request is sent.
+func parseBatch(r io.Reader) ([]command, error) {
+	commands := []command{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		cmd, err := parseCommand(strings.Fields(text))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		commands = append(commands, cmd)
+	}
+	return commands, scanner.Err()
+}
+
+// cliRunner runs the commands given on the command line.
+type cliRunner struct {
+	cfg    ClientConfig
+	client *onionclient.Client
+	pool   *onionclient.Pool
+	out    io.Writer
+}
+
+// parseArgs checks the command line before anything is built, so usage
+// errors do not need a network.
+func parseArgs(args []string) ([]command, error) {
+	switch args[0] {
+	case "batch":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("batch needs exactly one file")
+		}
+		in := io.Reader(os.Stdin)
+		if args[1] != "-" {
+			file, err := os.Open(args[1])
+			if err != nil {
+				return nil, err
+			}
+			defer file.Close()
+			in = file
+		}
+		return parseBatch(in)
+	case "circuit":
+		if len(args) != 2 || args[1] != "show" {
+			return nil, fmt.Errorf("usage: circuit show")
+		}
+		return nil, nil
+	}
+	cmd, err := parseCommand(args)
+	if err != nil {
+		return nil, err
+	}
+	return []command{cmd}, nil
+}
+
+// run executes the commands and returns the exit status.
+func (r *cliRunner) run(args []string, commands []command) int {
+	if err := r.pool.Warm(context.Background()); err != nil {
+		r.printError(fmt.Errorf("building a circuit: %w", err))
+		return exitNoCircuit
+	}
+	if args[0] == "circuit" {
+		return r.showCircuits()
+	}
+
+	// up to Parallel requests share the pool's circuits at once; results are
+	// printed in command order
+	results := make([]chan commandResult, len(commands))
+	slots := make(chan struct{}, r.cfg.Parallel)
+	for i, cmd := range commands {
+		results[i] = make(chan commandResult, 1)
+		go func() {
+			slots <- struct{}{}
+			results[i] <- r.do(cmd)
+			<-slots
+		}()
+	}
+	status := exitOK
+	for _, result := range results {
+		result := <-result
+		r.printResult(result)
+		if result.Error != "" {
+			status = exitFailed
+		}
+	}
+	return status
+}
+
+func (r *cliRunner) do(cmd command) commandResult {
+	start := time.Now()
+	reply, err := r.pool.Do(context.Background(), cmd.reqType, cmd.message)
+	result := commandResult{command: cmd, DurationMS: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		result.Error = err.Error()
+		clientLogger.PrintLog("%s %s failed: %v", cmd.Name, cmd.Argument, err)
+		return result
+	}
+	result.Reply = string(reply)
+	clientLogger.PrintLog("Response received from server(Decrypted): %v", result.Reply)
+	clientLogger.PrintLog("Request-Response time: %v", time.Since(start))
+	return result
+}
+
+func (r *cliRunner) printResult(result commandResult) {
+	if r.cfg.Output == "json" {
+		json.NewEncoder(r.out).Encode(result)
+		return
+	}
+	if result.Error != "" {
+		fmt.Fprintf(os.Stderr, "%s %s: error: %s\n", result.Name, result.Argument, result.Error)
+		return
+	}
+	fmt.Fprintln(r.out, result.Reply)
+}
+
+func (r *cliRunner) printError(err error) {
+	if r.cfg.Output == "json" {
+		json.NewEncoder(r.out).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	fmt.Fprintln(os.Stderr, "error:", err)
+}
+
+type hopView struct {
+	Hop     int      `json:"hop"`
+	Address string   `json:"address"`
+	Flags   []string `json:"flags"`
+}
+
+type circuitView struct {
+	ID          uint16    `json:"id"`
+	AgeMS       int64     `json:"age_ms"`
+	Uses        int       `json:"uses"`
+	Destination string    `json:"destination,omitempty"`
+	IdleTimeout int64     `json:"idle_timeout_ms"`
+	ExpiresIn   int64     `json:"expires_in_ms"`
+	SendWindow  int       `json:"send_window"`
+	Hops        []hopView `json:"hops"`
+}
+
+type circuitsView struct {
+	Circuits       []circuitView `json:"circuits"`
+	BuildTimeoutMS int64         `json:"build_timeout_ms"`
+	Guards         []string      `json:"guards"`
+}
+
+func (r *cliRunner) showCircuits() int {
+	view := circuitsView{
+		Circuits:       []circuitView{},
+		BuildTimeoutMS: r.client.BuildTimeout().Milliseconds(),
+		Guards:         []string{},
+	}
+	for _, guard := range r.client.Guards() {
+		view.Guards = append(view.Guards, guard.Address)
+	}
+	for _, pc := range r.pool.Circuits() {
+		cv := circuitView{ID: pc.ID, AgeMS: pc.Age.Milliseconds(), Uses: pc.Uses, Destination: pc.Destination,
+			IdleTimeout: pc.IdleTimeout.Milliseconds(), ExpiresIn: pc.ExpiresIn.Milliseconds(), SendWindow: pc.SendWindow, Hops: []hopView{}}
+		for i, node := range pc.Relays {
+			cv.Hops = append(cv.Hops, hopView{Hop: i + 1, Address: node.Address, Flags: node.Flags})
+		}
+		view.Circuits = append(view.Circuits, cv)
+	}
+	if len(view.Circuits) == 0 {
+		r.printError(utils.ErrCircuitClosed)
+		return exitNoCircuit
+	}
+
+	if r.cfg.Output == "json" {
+		json.NewEncoder(r.out).Encode(view)
+		return exitOK
+	}
+	for _, cv := range view.Circuits {
+		fmt.Fprintf(r.out, "circuit %d  age %v  uses %d  idle timeout %v  expires in %v  send window %d\n", cv.ID, time.Duration(cv.AgeMS)*time.Millisecond, cv.Uses,
+			time.Duration(cv.IdleTimeout)*time.Millisecond, time.Duration(cv.ExpiresIn)*time.Millisecond, cv.SendWindow)
+		for _, hop := range cv.Hops {
+			fmt.Fprintf(r.out, "  hop %d  %-22s %s\n", hop.Hop, hop.Address, strings.Join(hop.Flags, ","))
+		}
+	}
+	fmt.Fprintf(r.out, "build timeout %v\n", time.Duration(view.BuildTimeoutMS)*time.Millisecond)
+	if len(view.Guards) > 0 {
+		fmt.Fprintf(r.out, "entry guards %s\n", strings.Join(view.Guards, ", "))
+	}
+	return exitOK
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	encryption "onion_routing/encryption"
+	onionclient "onion_routing/onionclient"
+	utils "onion_routing/utils"
+)
+
+const clientEnvPrefix = "ONION_CLIENT_"
+
+type ClientConfig struct {
+	CircuitID       int           `yaml:"circuit_id" flag:"id" env:"CIRCUIT_ID" usage:"circuit id for the client"`
+	ServerAddr      string        `yaml:"server_addr" flag:"server-addr" env:"SERVER_ADDR" usage:"address of the destination server reached through the exit"`
+	PathLength      int           `yaml:"path_length" flag:"hops" env:"PATH_LENGTH" usage:"number of relays in a circuit (1 for testing; relays enforce a maximum)"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout" flag:"idle-timeout" env:"IDLE_TIMEOUT" usage:"idle timeout asked of relays when building circuits; relays clamp it to their limits (0 for their default)"`
+	SocksAddr       string        `yaml:"socks_addr" flag:"socks-addr" env:"SOCKS_ADDR" usage:"serve a SOCKS5 proxy on this address, e.g. localhost:9050, instead of the interactive prompt"`
+	BadRelayTimeout time.Duration `yaml:"bad_relay_timeout" flag:"bad-relay-timeout" env:"BAD_RELAY_TIMEOUT" usage:"leave a failed relay out of new circuits for this long"`
+	RetryTimeout    time.Duration `yaml:"retry_timeout" flag:"retry-timeout" env:"RETRY_TIMEOUT" usage:"keep retrying a failed request on new circuits for this long"`
+	RequestTimeout  time.Duration `yaml:"request_timeout" flag:"request-timeout" env:"REQUEST_TIMEOUT" usage:"deadline of a request or stream connect, retries included; relays give up on it when it passes (0 for none)"`
+	Parallel        int           `yaml:"parallel" flag:"parallel" env:"PARALLEL" usage:"requests of a batch sent at once; they share the pool's circuits"`
+	Output          string        `yaml:"output" flag:"output" env:"OUTPUT" usage:"result format of commands: human or json"`
+	LogsDir         string        `yaml:"logs_dir" flag:"logs-dir" env:"LOGS_DIR" usage:"directory for client session logs"`
+	Pool            struct {
+		Size    int           `yaml:"size" flag:"pool-size" env:"POOL_SIZE" usage:"circuits kept built ahead of time"`
+		MaxAge  time.Duration `yaml:"max_age" flag:"pool-max-age" env:"POOL_MAX_AGE" usage:"retire circuits this old"`
+		MaxUses int           `yaml:"max_uses" flag:"pool-max-uses" env:"POOL_MAX_USES" usage:"retire circuits after this many requests and streams"`
+		MaxIdle time.Duration `yaml:"max_idle" flag:"pool-max-idle" env:"POOL_MAX_IDLE" usage:"retire circuits unused this long"`
+	} `yaml:"pool"`
+	Guards struct {
+		Count              int           `yaml:"count" flag:"guards" env:"GUARDS" usage:"entry guards to choose first hops from (0 picks a fresh first hop every time)"`
+		StateFile          string        `yaml:"state_file" flag:"guard-state" env:"GUARD_STATE" usage:"file the entry guards are kept in across restarts"`
+		Lifetime           time.Duration `yaml:"lifetime" flag:"guard-lifetime" env:"GUARD_LIFETIME" usage:"replace a guard this long after it was chosen"`
+		UnreachableTimeout time.Duration `yaml:"unreachable_timeout" flag:"guard-unreachable-timeout" env:"GUARD_UNREACHABLE_TIMEOUT" usage:"replace a guard that has been unreachable this long"`
+	} `yaml:"guards"`
+	BuildTimeout struct {
+		StateFile  string        `yaml:"state_file" flag:"build-times" env:"BUILD_TIMES" usage:"file the circuit build time history is kept in across restarts"`
+		Fallback   time.Duration `yaml:"fallback" flag:"build-timeout" env:"BUILD_TIMEOUT" usage:"circuit build deadline until enough build times are known"`
+		MinSamples int           `yaml:"min_samples" flag:"build-timeout-min-samples" env:"BUILD_TIMEOUT_MIN_SAMPLES" usage:"completed builds needed before the timeout adapts"`
+		Quantile   float64       `yaml:"quantile" flag:"build-timeout-quantile" env:"BUILD_TIMEOUT_QUANTILE" usage:"abandon builds slower than this share of builds"`
+		MinTimeout time.Duration `yaml:"min_timeout" flag:"build-timeout-min" env:"BUILD_TIMEOUT_MIN" usage:"never abandon builds sooner than this, however fast past builds were"`
+	} `yaml:"build_timeout"`
+	Directory struct {
+		Servers       []string      `yaml:"servers" flag:"directory-servers" env:"DIRECTORY_SERVERS" usage:"comma separated addresses of directory servers to fetch the consensus from (relays are read from etcd when empty)"`
+		CacheFile     string        `yaml:"cache_file" flag:"directory-cache" env:"DIRECTORY_CACHE" usage:"file the relays last listed by the directory are kept in, to start while it is down"`
+		Validity      time.Duration `yaml:"validity" flag:"directory-validity" env:"DIRECTORY_VALIDITY" usage:"use a relay listing this long before asking the directory again"`
+		MaxAge        time.Duration `yaml:"max_age" flag:"directory-max-age" env:"DIRECTORY_MAX_AGE" usage:"drop cached relays the directory has not listed for this long"`
+		FallbackFile  string        `yaml:"fallback_file" flag:"directory-fallback" env:"DIRECTORY_FALLBACK" usage:"JSON list of relays used when neither the directory nor the cache has relays"`
+		Authorities   []string      `yaml:"authorities" flag:"directory-authorities" env:"DIRECTORY_AUTHORITIES" usage:"comma separated base64 public keys of the directory authorities whose countersignatures are required"`
+		MinSignatures int           `yaml:"min_signatures" flag:"directory-min-signatures" env:"DIRECTORY_MIN_SIGNATURES" usage:"authority countersignatures (or consensus documents listing it) a relay descriptor needs"`
+	} `yaml:"directory"`
+	TLS  utils.TLSFiles     `yaml:"tls"`
+	Etcd utils.EtcdSettings `yaml:"etcd"`
+}
+
+func defaultClientConfig() ClientConfig {
+	cfg := ClientConfig{
+		CircuitID:  1001,
+		PathLength: onionclient.DefaultPathLength,
+		ServerAddr: utils.ServerAddr,
+		LogsDir:    "logs/client",
+		Output:     "human",
+		TLS: utils.TLSFiles{
+			CA:   "certificates/ca.crt",
+			Cert: "certificates/client.crt",
+			Key:  "certificates/client.key",
+		},
+		Etcd: utils.DefaultEtcdSettings(),
+	}
+	cfg.IdleTimeout = onionclient.DefaultIdleTimeout
+	cfg.Parallel = 1
+	cfg.BadRelayTimeout = 5 * time.Minute
+	cfg.RetryTimeout = 30 * time.Second
+	cfg.RequestTimeout = onionclient.DefaultRequestTimeout
+	cfg.Pool.Size = 2
+	cfg.Pool.MaxAge = 10 * time.Minute
+	cfg.Pool.MaxUses = 100
+	cfg.Pool.MaxIdle = 5 * time.Minute
+	cfg.Guards.Count = 3
+	cfg.Guards.StateFile = "state/client_guards.json"
+	cfg.Guards.Lifetime = 30 * 24 * time.Hour
+	cfg.Guards.UnreachableTimeout = time.Hour
+	cfg.BuildTimeout.StateFile = "state/client_build_times.json"
+	cfg.BuildTimeout.Fallback = 10 * time.Second
+	cfg.BuildTimeout.MinSamples = 20
+	cfg.BuildTimeout.Quantile = 0.8
+	cfg.BuildTimeout.MinTimeout = time.Second
+	cfg.Directory.CacheFile = "state/client_directory.json"
+	cfg.Directory.Validity = 10 * time.Minute
+	cfg.Directory.MaxAge = 24 * time.Hour
+	cfg.Directory.MinSignatures = 1
+	return cfg
+}
+
+func (c ClientConfig) Validate() error {
+	if c.CircuitID < 0 || c.CircuitID > 0xffff {
+		return utils.ConfigError("circuit_id", "must be between 0 and 65535, got %d", c.CircuitID)
+	}
+	if _, _, err := net.SplitHostPort(c.ServerAddr); err != nil {
+		return utils.ConfigError("server_addr", "%v", err)
+	}
+	if c.PathLength < 1 {
+		return utils.ConfigError("path_length", "must be at least 1, got %d", c.PathLength)
+	}
+	if c.IdleTimeout < 0 {
+		return utils.ConfigError("idle_timeout", "must not be negative, got %v", c.IdleTimeout)
+	}
+	if c.SocksAddr != "" {
+		if _, _, err := net.SplitHostPort(c.SocksAddr); err != nil {
+			return utils.ConfigError("socks_addr", "%v", err)
+		}
+	}
+	if c.BadRelayTimeout <= 0 || c.RetryTimeout <= 0 {
+		return utils.ConfigError("retry", "bad_relay_timeout and retry_timeout must be positive")
+	}
+	if c.RequestTimeout < 0 {
+		return utils.ConfigError("request_timeout", "must not be negative, got %v", c.RequestTimeout)
+	}
+	if c.Pool.Size < 1 {
+		return utils.ConfigError("pool.size", "must be at least 1, got %d", c.Pool.Size)
+	}
+	if c.Pool.MaxAge <= 0 || c.Pool.MaxIdle <= 0 {
+		return utils.ConfigError("pool", "max_age and max_idle must be positive")
+	}
+	if c.Pool.MaxUses < 1 {
+		return utils.ConfigError("pool.max_uses", "must be at least 1, got %d", c.Pool.MaxUses)
+	}
+	if c.Guards.Count < 0 {
+		return utils.ConfigError("guards.count", "must not be negative, got %d", c.Guards.Count)
+	}
+	if c.Guards.Count > 0 && (c.Guards.Lifetime <= 0 || c.Guards.UnreachableTimeout <= 0) {
+		return utils.ConfigError("guards", "lifetime and unreachable_timeout must be positive")
+	}
+	if c.BuildTimeout.Fallback <= 0 || c.BuildTimeout.MinSamples < 1 || c.BuildTimeout.MinTimeout <= 0 {
+		return utils.ConfigError("build_timeout", "fallback, min_samples and min_timeout must be positive")
+	}
+	if c.BuildTimeout.Quantile <= 0 || c.BuildTimeout.Quantile >= 1 {
+		return utils.ConfigError("build_timeout.quantile", "must be between 0 and 1, got %v", c.BuildTimeout.Quantile)
+	}
+	if c.Directory.Validity <= 0 || c.Directory.MaxAge <= 0 {
+		return utils.ConfigError("directory", "validity and max_age must be positive")
+	}
+	for _, addr := range c.Directory.Servers {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return utils.ConfigError("directory.servers", "%v", err)
+		}
+	}
+	for _, key := range c.Directory.Authorities {
+		if _, err := encryption.ParseIdentityKey(key); err != nil {
+			return utils.ConfigError("directory.authorities", "%v", err)
+		}
+	}
+	if len(c.Directory.Authorities) == 0 {
+		return utils.ConfigError("directory.authorities", "must list at least one authority public key")
+	}
+	if c.Directory.MinSignatures < 1 || c.Directory.MinSignatures > len(c.Directory.Authorities) {
+		return utils.ConfigError("directory.min_signatures", "must be between 1 and the number of authorities, got %d", c.Directory.MinSignatures)
+	}
+	if c.Parallel < 1 {
+		return utils.ConfigError("parallel", "must be at least 1, got %d", c.Parallel)
+	}
+	if c.Output != "human" && c.Output != "json" {
+		return utils.ConfigError("output", "must be human or json, got %q", c.Output)
+	}
+	if c.LogsDir == "" {
+		return utils.ConfigError("logs_dir", "must be set")
+	}
+	if err := c.TLS.Validate("tls"); err != nil {
+		return err
+	}
+	return c.Etcd.Validate("etcd")
+}
+
+// loadClientConfig parses the command line and builds the client configuration.
+// The client is short lived, so it does not reload its configuration on SIGHUP.
+func loadClientConfig() ClientConfig {
+	cfg := defaultClientConfig()
+	configPath := flag.String("config", os.Getenv(clientEnvPrefix+"CONFIG"), "path to the client's YAML config file")
+	configFlags := utils.RegisterConfigFlags(flag.CommandLine, &cfg)
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, commandUsage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	cfg = defaultClientConfig()
+	err := utils.LoadConfig(*configPath, &cfg, clientEnvPrefix, configFlags)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		log.Printf("Invalid client configuration: %v", err)
+		os.Exit(exitUsage)
+	}
+	return cfg
+}
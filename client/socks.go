@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	onionclient "onion_routing/onionclient"
+	utils "onion_routing/utils"
+)
+
+// SOCKS5 (RFC 1928) front-end. Each accepted CONNECT becomes a stream on the
+// client's circuit, opened by the exit relay, which also resolves host names.
+
+const (
+	socksVersion = 5
+
+	socksCmdConnect = 1
+
+	socksAtypIPv4   = 1
+	socksAtypDomain = 3
+	socksAtypIPv6   = 4
+
+	socksReplySucceeded          = 0
+	socksReplyGeneralFailure     = 1
+	socksReplyNotAllowed         = 2
+	socksReplyHostUnreachable    = 4
+	socksReplyConnectionRefused  = 5
+	socksReplyCommandUnsupported = 7
+	socksReplyAtypUnsupported    = 8
+
+	// After the local side stops sending, replies are still delivered until
+	// the stream has been quiet this long.
+	socksHalfCloseLinger = 1 * time.Second
+)
+
+var errSocksHandshake = errors.New("invalid SOCKS5 handshake")
+
+// socksProxy opens streams through the client's circuit pool, which gives
+// each destination its own circuit.
+type socksProxy struct {
+	pool *onionclient.Pool
+}
+
+func (p *socksProxy) listenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("SOCKS5 proxy listening on %s", addr)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go p.handleConn(conn)
+	}
+}
+
+func (p *socksProxy) handleConn(conn net.Conn) {
+	defer conn.Close()
+	target, err := readSocksRequest(conn)
+	if err != nil {
+		log.Printf("SOCKS request from %s rejected: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	stream, err := p.pool.Dial(context.Background(), "tcp", target)
+	if err != nil {
+		log.Printf("Stream to %s failed: %v", target, err)
+		writeSocksReply(conn, socksReplyForError(err))
+		return
+	}
+	defer stream.Close()
+	clientLogger.PrintLog("Stream connected to %s", target)
+	if err := writeSocksReply(conn, socksReplySucceeded); err != nil {
+		return
+	}
+
+	var lastReply atomic.Int64
+	downstreamDone := make(chan struct{})
+	go func() {
+		io.Copy(&activityWriter{conn, &lastReply}, stream)
+		conn.(*net.TCPConn).CloseWrite()
+		close(downstreamDone)
+	}()
+	io.Copy(stream, conn)
+
+	lastReply.Store(time.Now().UnixNano())
+	for {
+		select {
+		case <-downstreamDone:
+			return
+		case <-time.After(socksHalfCloseLinger / 4):
+			if time.Since(time.Unix(0, lastReply.Load())) > socksHalfCloseLinger {
+				return
+			}
+		}
+	}
+}
+
+// activityWriter records when data was last delivered to the local side.
+type activityWriter struct {
+	io.Writer
+	last *atomic.Int64
+}
+
+func (w *activityWriter) Write(b []byte) (int, error) {
+	w.last.Store(time.Now().UnixNano())
+	return w.Writer.Write(b)
+}
+
+// readSocksRequest performs the no-authentication handshake and returns the
+// "host:port" of a CONNECT request. Other requests get an error reply.
+func readSocksRequest(conn net.Conn) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != socksVersion {
+		return "", errSocksHandshake
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	noAuth := false
+	for _, method := range methods {
+		noAuth = noAuth || method == 0
+	}
+	if !noAuth {
+		conn.Write([]byte{socksVersion, 0xff})
+		return "", errors.New("client does not offer the no-authentication method")
+	}
+	if _, err := conn.Write([]byte{socksVersion, 0}); err != nil {
+		return "", err
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+	if request[0] != socksVersion {
+		return "", errSocksHandshake
+	}
+	if request[1] != socksCmdConnect {
+		writeSocksReply(conn, socksReplyCommandUnsupported)
+		return "", fmt.Errorf("unsupported SOCKS command %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case socksAtypIPv4, socksAtypIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if request[3] == socksAtypIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksAtypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		writeSocksReply(conn, socksReplyAtypUnsupported)
+		return "", fmt.Errorf("unsupported SOCKS address type %d", request[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// writeSocksReply answers a request. The bound address is not meaningful for
+// a stream opened at the exit, so it is always 0.0.0.0:0.
+func writeSocksReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socksVersion, code, 0, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// socksReplyForError maps why a stream could not be opened to a SOCKS reply
+// code.
+func socksReplyForError(err error) byte {
+	reason := err.Error()
+	switch {
+	case errors.Is(err, utils.ErrExitPolicy), errors.Is(err, utils.ErrNoValidPath):
+		return socksReplyNotAllowed
+	case !errors.Is(err, utils.ErrStreamRefused):
+		return socksReplyGeneralFailure
+	case containsAny(reason, utils.ErrExitPolicy.Error()):
+		return socksReplyNotAllowed
+	case containsAny(reason, "connection refused"):
+		return socksReplyConnectionRefused
+	case containsAny(reason, "no such host", "i/o timeout", "no route to host", "network is unreachable"):
+		return socksReplyHostUnreachable
+	}
+	return socksReplyGeneralFailure
+}
+
+func containsAny(s string, substrings ...string) bool {
+	for _, sub := range substrings {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	utils "onion_routing/utils"
+)
+
+const directoryEnvPrefix = "ONION_DIRECTORY_"
+
+type DirectoryConfig struct {
+	ListenAddr        string             `yaml:"listen_addr" flag:"listen-addr" env:"LISTEN_ADDR" usage:"address the directory service is served on"`
+	TLS               utils.TLSFiles     `yaml:"tls"`
+	Storage           string             `yaml:"storage" flag:"storage" env:"STORAGE" usage:"where relay records are kept: etcd or memory"`
+	LivenessTimeout   time.Duration      `yaml:"liveness_timeout" flag:"liveness-timeout" env:"LIVENESS_TIMEOUT" usage:"drop relays that have not sent a heartbeat for this long"`
+	ConsensusLifetime time.Duration      `yaml:"consensus_lifetime" flag:"consensus-lifetime" env:"CONSENSUS_LIFETIME" usage:"how long clients may use a consensus document"`
+	IdentityKey       string             `yaml:"identity_key" flag:"identity-key" env:"IDENTITY_KEY" usage:"file with the authority's Ed25519 signing key, created if missing"`
+	PinFile           string             `yaml:"pin_file" flag:"pin-file" env:"PIN_FILE" usage:"file the identity pinned to each relay address is kept in"`
+	ApprovedRelays    []string           `yaml:"approved_relays" flag:"approved-relays" env:"APPROVED_RELAYS" usage:"comma separated identity fingerprints of the only relays to list and countersign (any relay when empty)"`
+	LogsDir           string             `yaml:"logs_dir" flag:"logs-dir" env:"LOGS_DIR" usage:"directory for directory server session logs"`
+	Etcd              utils.EtcdSettings `yaml:"etcd"`
+}
+
+func defaultDirectoryConfig() DirectoryConfig {
+	return DirectoryConfig{
+		ListenAddr: "localhost:45040",
+		TLS: utils.TLSFiles{
+			CA:   "certificates/ca.crt",
+			Cert: "certificates/server.crt",
+			Key:  "certificates/server.key",
+		},
+		Storage:           "etcd",
+		LivenessTimeout:   10 * time.Second,
+		ConsensusLifetime: time.Hour,
+		IdentityKey:       "state/directory_authority.key",
+		PinFile:           "state/directory_pins.json",
+		LogsDir:           "logs/directory",
+		Etcd:              utils.DefaultEtcdSettings(),
+	}
+}
+
+func (c DirectoryConfig) Validate() error {
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		return utils.ConfigError("listen_addr", "%v", err)
+	}
+	if err := c.TLS.Validate("tls"); err != nil {
+		return err
+	}
+	if c.Storage != "etcd" && c.Storage != "memory" {
+		return utils.ConfigError("storage", "must be etcd or memory, got %q", c.Storage)
+	}
+	if c.LivenessTimeout < time.Second {
+		return utils.ConfigError("liveness_timeout", "must be at least 1s, got %v", c.LivenessTimeout)
+	}
+	if c.ConsensusLifetime <= 0 {
+		return utils.ConfigError("consensus_lifetime", "must be positive, got %v", c.ConsensusLifetime)
+	}
+	if c.IdentityKey == "" {
+		return utils.ConfigError("identity_key", "must be set")
+	}
+	if c.PinFile == "" {
+		return utils.ConfigError("pin_file", "must be set")
+	}
+	for _, fingerprint := range c.ApprovedRelays {
+		if len(fingerprint) != 40 {
+			return utils.ConfigError("approved_relays", "%q is not a 40 character identity fingerprint", fingerprint)
+		}
+	}
+	if c.LogsDir == "" {
+		return utils.ConfigError("logs_dir", "must be set")
+	}
+	return c.Etcd.Validate("etcd")
+}
+
+// loadDirectoryConfig parses the command line and builds the directory
+// server configuration.
+func loadDirectoryConfig() DirectoryConfig {
+	cfg := defaultDirectoryConfig()
+	configPath := flag.String("config", os.Getenv(directoryEnvPrefix+"CONFIG"), "path to the directory server's YAML config file")
+	configFlags := utils.RegisterConfigFlags(flag.CommandLine, &cfg)
+	flag.Parse()
+
+	cfg = defaultDirectoryConfig()
+	err := utils.LoadConfig(*configPath, &cfg, directoryEnvPrefix, configFlags)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		log.Fatalf("Invalid directory configuration: %v", err)
+	}
+	return cfg
+}
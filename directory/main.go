@@ -1 +1,289 @@
-package main
\ No newline at end of file
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	encryption "onion_routing/encryption"
+	routingpb "onion_routing/protofiles"
+	utils "onion_routing/utils"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// The directory server acts as a directory authority. Relays upload their
+// signed descriptors to its directory service (service.go), which keeps them
+// in a relayStore and serves the relays that are up as a signed consensus.
+// With etcd storage it also watches the relay records in etcd, including
+// those of relays registering there directly, checks each relay's signed
+// descriptor and countersigns it under utils.CountersignaturePrefix, where
+// clients reading etcd look for the signatures of the authorities they trust.
+// A relay address is pinned to the first identity seen for it (or to the
+// approved identities), so a descriptor that swaps in another identity is
+// never listed or countersigned.
+
+// resyncInterval is how often every record is checked again, in case a
+// watch event was missed.
+const resyncInterval = time.Minute
+
+var (
+	directoryLogger *utils.Logger
+	authorityKey    ed25519.PrivateKey
+	authorityID     string
+	etcdClient      *clientv3.Client
+)
+
+// descriptorBody holds the parts of a relay descriptor the authority checks.
+type descriptorBody struct {
+	Address   string         `json:"address"`
+	PubKey    *rsa.PublicKey `json:"pub_key"`
+	Published time.Time      `json:"published"`
+}
+
+// relayRecord is what is stored for each relay, in the format relays
+// registering in etcd directly use.
+type relayRecord struct {
+	Descriptor    encryption.SignedDescriptor `json:"descriptor"`
+	Load          int                         `json:"load"`
+	PowDifficulty uint32                      `json:"pow_difficulty"`
+}
+
+type authority struct {
+	approved map[string]bool
+	pinFile  string
+
+	lock   sync.Mutex
+	pins   map[string]string // relay address to identity fingerprint
+	signed map[string]string // node to the digest last countersigned
+}
+
+func newAuthority(cfg DirectoryConfig) (*authority, error) {
+	a := &authority{
+		approved: make(map[string]bool),
+		pinFile:  cfg.PinFile,
+		pins:     make(map[string]string),
+		signed:   make(map[string]string),
+	}
+	for _, fingerprint := range cfg.ApprovedRelays {
+		a.approved[strings.ToLower(fingerprint)] = true
+	}
+	data, err := os.ReadFile(cfg.PinFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &a.pins); err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.PinFile, err)
+	}
+	return a, nil
+}
+
+// check decides whether a relay record may be countersigned and returns its
+// descriptor.
+func (a *authority) check(value []byte) (encryption.SignedDescriptor, error) {
+	var record relayRecord
+	if err := json.Unmarshal(value, &record); err != nil {
+		return encryption.SignedDescriptor{}, err
+	}
+	return record.Descriptor, a.checkDescriptor(record.Descriptor)
+}
+
+// checkDescriptor decides whether a relay's descriptor may be listed and
+// countersigned.
+func (a *authority) checkDescriptor(d encryption.SignedDescriptor) error {
+	if err := d.Verify(); err != nil {
+		return err
+	}
+	var body descriptorBody
+	if err := json.Unmarshal(d.Body, &body); err != nil {
+		return err
+	}
+	if body.Address == "" || body.PubKey == nil {
+		return fmt.Errorf("descriptor lacks an address or onion key")
+	}
+	if err := encryption.CheckFreshness(body.Published, time.Now()); err != nil {
+		return err
+	}
+	identity := d.Identity()
+	if len(a.approved) > 0 && !a.approved[identity] {
+		return fmt.Errorf("identity %s is not approved", identity)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	pinned, exists := a.pins[body.Address]
+	if exists && pinned != identity {
+		return fmt.Errorf("%s is pinned to identity %s, descriptor is signed by %s", body.Address, pinned, identity)
+	}
+	if !exists {
+		a.pins[body.Address] = identity
+		if err := a.savePinsLocked(); err != nil {
+			log.Printf("Saving pinned identities failed: %v", err)
+		}
+	}
+	return nil
+}
+
+func (a *authority) savePinsLocked() error {
+	data, err := json.MarshalIndent(a.pins, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(a.pinFile), 0700); err != nil {
+		return err
+	}
+	tmp := a.pinFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.pinFile)
+}
+
+func countersignatureKey(node string) string {
+	return utils.CountersignaturePrefix(utils.EtcdKeyPrefix) + authorityID + "/" + node
+}
+
+// handleRecord countersigns a relay's record if it passes the checks and has
+// not been countersigned yet.
+func (a *authority) handleRecord(ctx context.Context, node string, value []byte) {
+	d, err := a.check(value)
+	if err != nil {
+		directoryLogger.PrintLog("Refusing to countersign %s: %v", node, err)
+		log.Printf("Refusing to countersign %s: %v", node, err)
+		return
+	}
+	digest := string(d.Digest())
+	a.lock.Lock()
+	done := a.signed[node] == digest
+	a.lock.Unlock()
+	if done {
+		return
+	}
+	data, _ := json.Marshal(encryption.Countersign(d, authorityKey))
+	if _, err := etcdClient.Put(ctx, countersignatureKey(node), string(data)); err != nil {
+		log.Printf("Failed to store countersignature of %s: %v", node, err)
+		return
+	}
+	a.lock.Lock()
+	a.signed[node] = digest
+	a.lock.Unlock()
+	directoryLogger.PrintLog("Countersigned descriptor of %s (identity %s)", node, d.Identity())
+}
+
+func (a *authority) handleDelete(ctx context.Context, node string) {
+	a.lock.Lock()
+	delete(a.signed, node)
+	a.lock.Unlock()
+	if _, err := etcdClient.Delete(ctx, countersignatureKey(node)); err != nil {
+		log.Printf("Failed to remove countersignature of %s: %v", node, err)
+	}
+}
+
+// resync checks every relay record and removes the countersignatures of
+// relays that are gone.
+func (a *authority) resync(ctx context.Context) (int64, error) {
+	resp, err := etcdClient.Get(ctx, utils.EtcdKeyPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return 0, err
+	}
+	present := make(map[string]bool)
+	for _, kv := range resp.Kvs {
+		node := strings.TrimPrefix(string(kv.Key), utils.EtcdKeyPrefix)
+		present[node] = true
+		a.handleRecord(ctx, node, kv.Value)
+	}
+	prefix := utils.CountersignaturePrefix(utils.EtcdKeyPrefix) + authorityID + "/"
+	sigs, err := etcdClient.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return 0, err
+	}
+	for _, kv := range sigs.Kvs {
+		if node := strings.TrimPrefix(string(kv.Key), prefix); !present[node] {
+			a.handleDelete(ctx, node)
+		}
+	}
+	return resp.Header.Revision, nil
+}
+
+// watch countersigns relay records as they change.
+func (a *authority) watch(ctx context.Context) {
+	for {
+		revision, err := a.resync(ctx)
+		if err != nil {
+			log.Printf("Failed to read relay records: %v", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		watchCtx, cancel := context.WithTimeout(ctx, resyncInterval)
+		events := etcdClient.Watch(watchCtx, utils.EtcdKeyPrefix, clientv3.WithPrefix(), clientv3.WithRev(revision+1))
+		for resp := range events {
+			for _, event := range resp.Events {
+				node := strings.TrimPrefix(string(event.Kv.Key), utils.EtcdKeyPrefix)
+				if event.Type == clientv3.EventTypeDelete {
+					a.handleDelete(ctx, node)
+				} else {
+					a.handleRecord(ctx, node, event.Kv.Value)
+				}
+			}
+		}
+		cancel()
+	}
+}
+
+func main() {
+	cfg := loadDirectoryConfig()
+	cfg.Etcd.Apply()
+	directoryLogger = utils.NewLogger(cfg.LogsDir)
+
+	var err error
+	authorityKey, err = encryption.LoadIdentityKey(cfg.IdentityKey)
+	if err != nil {
+		log.Fatalf("Failed to load the authority key: %v", err)
+	}
+	authorityID = encryption.Fingerprint(authorityKey.Public().(ed25519.PublicKey))
+	log.Printf("Directory authority %s, public key %s", authorityID, encryption.EncodeIdentityKey(authorityKey.Public().(ed25519.PublicKey)))
+
+	a, err := newAuthority(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load pinned identities: %v", err)
+	}
+	var store relayStore = newMemoryStore()
+	if cfg.Storage == "etcd" {
+		etcdClient, err = clientv3.New(clientv3.Config{
+			Endpoints:   []string{utils.EtcdServerAddr},
+			DialTimeout: utils.EtcdTimeOutInterval,
+		})
+		if err != nil {
+			log.Fatalf("Failed to initialize etcd client: %v", err)
+		}
+		defer etcdClient.Close()
+		store = etcdStore{}
+		go a.watch(context.Background())
+	}
+
+	listener, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		log.Fatalf("Directory server failed to listen: %v", err)
+	}
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(utils.LoadServerTLSConfigWithKeyLog(cfg.TLS.CA, cfg.TLS.Cert, cfg.TLS.Key))))
+	routingpb.RegisterDirectoryServer(server, newDirectoryService(a, store, cfg))
+	log.Printf("Directory service running on %s (%s storage)", cfg.ListenAddr, cfg.Storage)
+	if err := server.Serve(listener); err != nil {
+		log.Fatalf("Directory server failed to serve: %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"sync"
+	"time"
+
+	encryption "onion_routing/encryption"
+	netdir "onion_routing/netdir"
+	routingpb "onion_routing/protofiles"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The directory service takes the relays' descriptors, checked like the
+// ones the authority countersigns, and keeps each relay listed while it
+// sends heartbeats. It serves the relays that are up as a consensus document
+// signed with the authority key, with the uptimes it saw itself rather than
+// the ones relays claim.
+
+// consensusInterval is how long a signed consensus document is served before
+// it is built again, unless a relay uploads a new descriptor.
+const consensusInterval = 5 * time.Second
+
+type directoryService struct {
+	routingpb.UnimplementedDirectoryServer
+	authority         *authority
+	store             relayStore
+	livenessTimeout   time.Duration
+	consensusLifetime time.Duration
+
+	lock           sync.Mutex
+	lastHeartbeat  map[string]time.Time // node to when its last heartbeat was sent
+	uptimes        *uptimes
+	consensus      *routingpb.ConsensusDocument
+	consensusBuilt time.Time
+}
+
+func newDirectoryService(a *authority, store relayStore, cfg DirectoryConfig) *directoryService {
+	return &directoryService{
+		authority:         a,
+		store:             store,
+		livenessTimeout:   cfg.LivenessTimeout,
+		consensusLifetime: cfg.ConsensusLifetime,
+		lastHeartbeat:     make(map[string]time.Time),
+		uptimes:           newUptimes(cfg.LivenessTimeout),
+	}
+}
+
+func (s *directoryService) UploadDescriptor(ctx context.Context, req *routingpb.UploadDescriptorRequest) (*routingpb.UploadDescriptorResponse, error) {
+	if req.NodeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "node id missing")
+	}
+	d := encryption.SignedDescriptor{Body: req.Body, IdentityKey: req.IdentityKey, Signature: req.Signature}
+	if err := s.authority.checkDescriptor(d); err != nil {
+		directoryLogger.PrintLog("Refusing descriptor of %s: %v", req.NodeId, err)
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	// a node name belongs to the relay holding it until that relay's
+	// record expires
+	existing, found, err := s.store.Get(ctx, req.NodeId)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	if found && existing.Descriptor.Identity() != d.Identity() {
+		return nil, status.Errorf(codes.PermissionDenied, "%s is registered with identity %s", req.NodeId, existing.Descriptor.Identity())
+	}
+	record := relayRecord{Descriptor: d, Load: int(req.Load), PowDifficulty: req.PowDifficulty}
+	if err := s.store.Put(ctx, req.NodeId, record, s.livenessTimeout); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	s.uptimes.seen(d.Identity(), time.Now())
+	s.lock.Lock()
+	s.consensus = nil
+	s.lock.Unlock()
+	if !found || string(existing.Descriptor.Digest()) != string(d.Digest()) {
+		directoryLogger.PrintLog("Accepted descriptor of %s (identity %s)", req.NodeId, d.Identity())
+	}
+	return &routingpb.UploadDescriptorResponse{HeartbeatIntervalMs: (s.livenessTimeout / 3).Milliseconds()}, nil
+}
+
+func (s *directoryService) Heartbeat(ctx context.Context, req *routingpb.HeartbeatRequest) (*routingpb.HeartbeatResponse, error) {
+	record, found, err := s.store.Get(ctx, req.NodeId)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	if !found {
+		return nil, status.Errorf(codes.NotFound, "no descriptor for %s", req.NodeId)
+	}
+	sent := time.UnixMilli(req.SentUnixMs)
+	err = encryption.VerifyHeartbeat(record.Descriptor.IdentityKey, req.NodeId, req.Load, req.PowDifficulty, sent, req.Signature, time.Now())
+	s.lock.Lock()
+	if err == nil && !sent.After(s.lastHeartbeat[req.NodeId]) {
+		err = encryption.ErrBadHeartbeat
+	}
+	if err == nil {
+		s.lastHeartbeat[req.NodeId] = sent
+	}
+	s.lock.Unlock()
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	record.Load, record.PowDifficulty = int(req.Load), req.PowDifficulty
+	if err := s.store.Put(ctx, req.NodeId, record, s.livenessTimeout); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	s.uptimes.seen(record.Descriptor.Identity(), time.Now())
+	return &routingpb.HeartbeatResponse{}, nil
+}
+
+func (s *directoryService) Withdraw(ctx context.Context, req *routingpb.WithdrawRequest) (*routingpb.WithdrawResponse, error) {
+	record, found, err := s.store.Get(ctx, req.NodeId)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	if !found {
+		return &routingpb.WithdrawResponse{}, nil
+	}
+	sent := time.UnixMilli(req.SentUnixMs)
+	err = encryption.VerifyWithdrawal(record.Descriptor.IdentityKey, req.NodeId, sent, req.Signature, time.Now())
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err == nil && !sent.After(s.lastHeartbeat[req.NodeId]) {
+		err = encryption.ErrBadHeartbeat
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	s.lastHeartbeat[req.NodeId] = sent
+	if err := s.store.Delete(ctx, req.NodeId); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	s.uptimes.down(record.Descriptor.Identity())
+	s.consensus = nil
+	directoryLogger.PrintLog("%s withdrew", req.NodeId)
+	return &routingpb.WithdrawResponse{}, nil
+}
+
+func (s *directoryService) GetConsensus(ctx context.Context, req *routingpb.GetConsensusRequest) (*routingpb.ConsensusDocument, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.consensus != nil && time.Since(s.consensusBuilt) < consensusInterval {
+		return s.consensus, nil
+	}
+	records, err := s.store.List(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	now := time.Now()
+	entries := []netdir.ConsensusEntry{}
+	for node, record := range records {
+		if s.authority.checkDescriptor(record.Descriptor) != nil {
+			continue
+		}
+		upSince, firstSeen := s.uptimes.get(record.Descriptor.Identity(), now)
+		entries = append(entries, netdir.ConsensusEntry{
+			Node:          node,
+			Descriptor:    record.Descriptor,
+			Load:          record.Load,
+			PowDifficulty: record.PowDifficulty,
+			UpSince:       upSince,
+			FirstSeen:     firstSeen,
+		})
+	}
+	doc := netdir.NewConsensusDocument(entries, now, s.consensusLifetime)
+	doc.Authority = authorityID
+	body, _ := json.Marshal(doc)
+	s.consensus = &routingpb.ConsensusDocument{
+		Body:         body,
+		AuthorityKey: authorityKey.Public().(ed25519.PublicKey),
+		Signature:    encryption.SignConsensus(body, authorityKey),
+	}
+	s.consensusBuilt = now
+	return s.consensus, nil
+}
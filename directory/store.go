@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	utils "onion_routing/utils"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// relayStore keeps the records of the relays that are up. A record is
+// dropped once its ttl passes without being put again, which is how relays
+// that stop sending heartbeats leave the consensus.
+type relayStore interface {
+	Put(ctx context.Context, node string, record relayRecord, ttl time.Duration) error
+	Get(ctx context.Context, node string) (relayRecord, bool, error)
+	List(ctx context.Context) (map[string]relayRecord, error)
+	Delete(ctx context.Context, node string) error
+}
+
+// etcdStore keeps the records where relays used to register themselves, in
+// the same format and under leases, so clients reading etcd directly and the
+// countersigning watch still see them.
+type etcdStore struct{}
+
+func (etcdStore) Put(ctx context.Context, node string, record relayRecord, ttl time.Duration) error {
+	lease, err := etcdClient.Grant(ctx, int64((ttl+time.Second-1)/time.Second))
+	if err != nil {
+		return err
+	}
+	data, _ := json.Marshal(record)
+	_, err = etcdClient.Put(ctx, utils.EtcdKeyPrefix+node, string(data), clientv3.WithLease(lease.ID))
+	return err
+}
+
+func (etcdStore) Get(ctx context.Context, node string) (relayRecord, bool, error) {
+	resp, err := etcdClient.Get(ctx, utils.EtcdKeyPrefix+node)
+	if err != nil || len(resp.Kvs) == 0 {
+		return relayRecord{}, false, err
+	}
+	var record relayRecord
+	if err := json.Unmarshal(resp.Kvs[0].Value, &record); err != nil {
+		return relayRecord{}, false, err
+	}
+	return record, true, nil
+}
+
+func (etcdStore) List(ctx context.Context) (map[string]relayRecord, error) {
+	resp, err := etcdClient.Get(ctx, utils.EtcdKeyPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	records := make(map[string]relayRecord)
+	for _, kv := range resp.Kvs {
+		var record relayRecord
+		if err := json.Unmarshal(kv.Value, &record); err != nil {
+			continue
+		}
+		records[strings.TrimPrefix(string(kv.Key), utils.EtcdKeyPrefix)] = record
+	}
+	return records, nil
+}
+
+func (etcdStore) Delete(ctx context.Context, node string) error {
+	_, err := etcdClient.Delete(ctx, utils.EtcdKeyPrefix+node)
+	return err
+}
+
+// memoryStore keeps the records in the directory server's memory only; relays
+// upload their descriptors again after it restarts.
+type memoryStore struct {
+	lock    sync.Mutex
+	records map[string]storedRecord
+}
+
+type storedRecord struct {
+	record  relayRecord
+	expires time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{records: make(map[string]storedRecord)}
+}
+
+func (s *memoryStore) Put(ctx context.Context, node string, record relayRecord, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.records[node] = storedRecord{record: record, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, node string) (relayRecord, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.dropExpiredLocked()
+	stored, ok := s.records[node]
+	return stored.record, ok, nil
+}
+
+func (s *memoryStore) List(ctx context.Context) (map[string]relayRecord, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.dropExpiredLocked()
+	records := make(map[string]relayRecord)
+	for node, stored := range s.records {
+		records[node] = stored.record
+	}
+	return records, nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, node string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.records, node)
+	return nil
+}
+
+func (s *memoryStore) dropExpiredLocked() {
+	now := time.Now()
+	for node, stored := range s.records {
+		if now.After(stored.expires) {
+			delete(s.records, node)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// uptimes is the authority's record of when it first saw each relay identity
+// and since when the relay has been up, from its descriptor uploads and
+// heartbeats. A relay that goes longer than the liveness timeout without
+// either, or withdraws, starts its uptime again when it comes back. The
+// record lives in memory: after the authority restarts every relay is new.
+type uptimes struct {
+	lock   sync.Mutex
+	gap    time.Duration
+	relays map[string]*relayUptime
+}
+
+type relayUptime struct {
+	firstSeen time.Time
+	upSince   time.Time
+	lastSeen  time.Time
+}
+
+func newUptimes(gap time.Duration) *uptimes {
+	return &uptimes{gap: gap, relays: make(map[string]*relayUptime)}
+}
+
+func (u *uptimes) seen(identity string, now time.Time) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	relay, exists := u.relays[identity]
+	if !exists {
+		relay = &relayUptime{firstSeen: now}
+		u.relays[identity] = relay
+	}
+	if relay.upSince.IsZero() || now.Sub(relay.lastSeen) > u.gap {
+		relay.upSince = now
+	}
+	relay.lastSeen = now
+}
+
+func (u *uptimes) down(identity string) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if relay, exists := u.relays[identity]; exists {
+		relay.upSince = time.Time{}
+	}
+}
+
+// get returns when the relay came up, zero if it is not up, and when it was
+// first seen.
+func (u *uptimes) get(identity string, now time.Time) (upSince time.Time, firstSeen time.Time) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	relay, exists := u.relays[identity]
+	if !exists {
+		return time.Time{}, time.Time{}
+	}
+	if now.Sub(relay.lastSeen) > u.gap {
+		return time.Time{}, relay.firstSeen
+	}
+	return relay.upSince, relay.firstSeen
+}
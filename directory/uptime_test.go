@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUptimes(t *testing.T) {
+	start := time.Now()
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+	u := newUptimes(10 * time.Second)
+	for d := time.Duration(0); d <= time.Minute; d += 3 * time.Second {
+		u.seen("relay", at(d))
+	}
+	if up, first := u.get("relay", at(time.Minute)); !up.Equal(start) || !first.Equal(start) {
+		t.Errorf("up since %v, first seen %v, want both at the first heartbeat", up, first)
+	}
+
+	// heartbeats stop for longer than the liveness timeout
+	if up, first := u.get("relay", at(2*time.Minute)); !up.IsZero() || !first.Equal(start) {
+		t.Errorf("after a gap: up since %v, first seen %v, want down and first seen at the start", up, first)
+	}
+	u.seen("relay", at(2*time.Minute))
+	if up, _ := u.get("relay", at(2*time.Minute)); !up.Equal(at(2 * time.Minute)) {
+		t.Errorf("up since %v after coming back, want %v", up, at(2*time.Minute))
+	}
+
+	u.down("relay")
+	if up, first := u.get("relay", at(2*time.Minute)); !up.IsZero() || !first.Equal(start) {
+		t.Errorf("after withdrawing: up since %v, first seen %v, want down and first seen at the start", up, first)
+	}
+	u.seen("relay", at(2*time.Minute+time.Second))
+	if up, _ := u.get("relay", at(2*time.Minute+time.Second)); !up.Equal(at(2*time.Minute + time.Second)) {
+		t.Errorf("up since %v after withdrawing and coming back, want %v", up, at(2*time.Minute+time.Second))
+	}
+
+	if up, first := u.get("unknown", start); !up.IsZero() || !first.IsZero() {
+		t.Errorf("a relay never seen is up since %v, first seen %v", up, first)
+	}
+}
@@ -0,0 +1,256 @@
+package encryption
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Relays publish a descriptor: their relay record, JSON encoded, signed with
+// their long-term Ed25519 identity key. Directory authorities check it and
+// countersign its digest, and clients only use relays whose descriptor
+// carries valid signatures of both and is fresh. The onion key is part of
+// the record, so it cannot be swapped without the identity key.
+const (
+	// DescriptorLifetime is how long a descriptor is accepted after it was
+	// published; relays publish a new one after half of it.
+	DescriptorLifetime = time.Hour
+	// DescriptorClockSkew is how far in the future a descriptor's publication
+	// time may lie.
+	DescriptorClockSkew = time.Minute
+)
+
+var (
+	ErrBadDescriptor        = errors.New("descriptor signature does not verify")
+	ErrStaleDescriptor      = errors.New("descriptor is expired or not yet valid")
+	ErrMissingCountersigns  = errors.New("descriptor lacks enough directory authority signatures")
+	ErrBadIdentityKeyFormat = errors.New("malformed identity key")
+	ErrBadHeartbeat         = errors.New("heartbeat or withdrawal signature does not verify or is not current")
+	ErrBadConsensus         = errors.New("consensus signature does not verify")
+	ErrStaleConsensus       = errors.New("consensus is expired or not yet valid")
+)
+
+// SignedDescriptor is a relay record signed by the relay's identity key.
+type SignedDescriptor struct {
+	Body        []byte            `json:"body"`
+	IdentityKey ed25519.PublicKey `json:"identity_key"`
+	Signature   []byte            `json:"signature"`
+}
+
+// Countersignature is a directory authority's signature over a descriptor's
+// digest.
+type Countersignature struct {
+	Authority string `json:"authority"` // Fingerprint of the authority's key
+	Digest    []byte `json:"digest"`
+	Signature []byte `json:"signature"`
+}
+
+func SignDescriptor(body []byte, identity ed25519.PrivateKey) SignedDescriptor {
+	d := SignedDescriptor{Body: body, IdentityKey: identity.Public().(ed25519.PublicKey)}
+	d.Signature = ed25519.Sign(identity, d.signedData())
+	return d
+}
+
+func (d SignedDescriptor) signedData() []byte {
+	data := append([]byte("RELAY DESCRIPTOR"), d.IdentityKey...)
+	return append(data, d.Body...)
+}
+
+// Verify checks the relay's own signature.
+func (d SignedDescriptor) Verify() error {
+	if len(d.IdentityKey) != ed25519.PublicKeySize || !ed25519.Verify(d.IdentityKey, d.signedData(), d.Signature) {
+		return ErrBadDescriptor
+	}
+	return nil
+}
+
+// Digest identifies the descriptor, signature included.
+func (d SignedDescriptor) Digest() []byte {
+	sum := sha256.Sum256(append(d.signedData(), d.Signature...))
+	return sum[:]
+}
+
+// Identity returns the fingerprint of the relay's identity key.
+func (d SignedDescriptor) Identity() string {
+	return Fingerprint(d.IdentityKey)
+}
+
+// CheckFreshness checks a descriptor's publication time against now.
+func CheckFreshness(published time.Time, now time.Time) error {
+	if published.After(now.Add(DescriptorClockSkew)) || now.Sub(published) > DescriptorLifetime {
+		return ErrStaleDescriptor
+	}
+	return nil
+}
+
+// Countersign returns an authority's countersignature of d.
+func Countersign(d SignedDescriptor, authority ed25519.PrivateKey) Countersignature {
+	digest := d.Digest()
+	return Countersignature{
+		Authority: Fingerprint(authority.Public().(ed25519.PublicKey)),
+		Digest:    digest,
+		Signature: ed25519.Sign(authority, append([]byte("COUNTERSIGNATURE"), digest...)),
+	}
+}
+
+// CountCountersignatures returns how many of the authorities have a valid
+// countersignature of d among sigs.
+func CountCountersignatures(d SignedDescriptor, sigs []Countersignature, authorities []ed25519.PublicKey) int {
+	digest := d.Digest()
+	count := 0
+	for _, authority := range authorities {
+		fingerprint := Fingerprint(authority)
+		for _, sig := range sigs {
+			if sig.Authority == fingerprint && string(sig.Digest) == string(digest) &&
+				ed25519.Verify(authority, append([]byte("COUNTERSIGNATURE"), digest...), sig.Signature) {
+				count++
+				break
+			}
+		}
+	}
+	return count
+}
+
+// SignHeartbeat signs a relay's heartbeat to the directory server: the node
+// it is registered as, its current load and proof-of-work difficulty and when
+// it was sent, so that it cannot be replayed to keep a dead relay listed.
+func SignHeartbeat(identity ed25519.PrivateKey, node string, load int32, powDifficulty uint32, sent time.Time) []byte {
+	return ed25519.Sign(identity, heartbeatData(node, load, powDifficulty, sent))
+}
+
+// VerifyHeartbeat checks a heartbeat's signature by the relay's identity key
+// and that it was sent within DescriptorClockSkew of now.
+func VerifyHeartbeat(identity ed25519.PublicKey, node string, load int32, powDifficulty uint32, sent time.Time, signature []byte, now time.Time) error {
+	if sent.Before(now.Add(-DescriptorClockSkew)) || sent.After(now.Add(DescriptorClockSkew)) ||
+		len(identity) != ed25519.PublicKeySize || !ed25519.Verify(identity, heartbeatData(node, load, powDifficulty, sent), signature) {
+		return ErrBadHeartbeat
+	}
+	return nil
+}
+
+func heartbeatData(node string, load int32, powDifficulty uint32, sent time.Time) []byte {
+	data := append([]byte("HEARTBEAT"), node...)
+	data = binary.BigEndian.AppendUint32(data, uint32(load))
+	data = binary.BigEndian.AppendUint32(data, powDifficulty)
+	return binary.BigEndian.AppendUint64(data, uint64(sent.UnixMilli()))
+}
+
+// SignWithdrawal signs a draining relay's request to be dropped from the
+// directory at once.
+func SignWithdrawal(identity ed25519.PrivateKey, node string, sent time.Time) []byte {
+	return ed25519.Sign(identity, withdrawalData(node, sent))
+}
+
+func VerifyWithdrawal(identity ed25519.PublicKey, node string, sent time.Time, signature []byte, now time.Time) error {
+	if sent.Before(now.Add(-DescriptorClockSkew)) || sent.After(now.Add(DescriptorClockSkew)) ||
+		len(identity) != ed25519.PublicKeySize || !ed25519.Verify(identity, withdrawalData(node, sent), signature) {
+		return ErrBadHeartbeat
+	}
+	return nil
+}
+
+func withdrawalData(node string, sent time.Time) []byte {
+	data := append([]byte("WITHDRAW"), node...)
+	return binary.BigEndian.AppendUint64(data, uint64(sent.UnixMilli()))
+}
+
+// SignForwardedCell signs a cell a relay forwards to the next hop, so that
+// the next hop can tell it apart from cells sent by clients.
+func SignForwardedCell(identity ed25519.PrivateKey, message []byte, create bool, powNonce uint64, hopCount uint32) []byte {
+	return ed25519.Sign(identity, forwardedCellData(message, create, powNonce, hopCount))
+}
+
+func VerifyForwardedCell(identity ed25519.PublicKey, message []byte, create bool, powNonce uint64, hopCount uint32, signature []byte) bool {
+	return len(identity) == ed25519.PublicKeySize && ed25519.Verify(identity, forwardedCellData(message, create, powNonce, hopCount), signature)
+}
+
+func forwardedCellData(message []byte, create bool, powNonce uint64, hopCount uint32) []byte {
+	data := []byte("FORWARD")
+	if create {
+		data = append(data, 1)
+	} else {
+		data = append(data, 0)
+	}
+	data = binary.BigEndian.AppendUint64(data, powNonce)
+	data = binary.BigEndian.AppendUint32(data, hopCount)
+	return append(data, message...)
+}
+
+// SignConsensus returns an authority's signature of a consensus document.
+func SignConsensus(body []byte, authority ed25519.PrivateKey) []byte {
+	return ed25519.Sign(authority, append([]byte("CONSENSUS"), body...))
+}
+
+func VerifyConsensus(body []byte, authority ed25519.PublicKey, signature []byte) error {
+	if len(authority) != ed25519.PublicKeySize || !ed25519.Verify(authority, append([]byte("CONSENSUS"), body...), signature) {
+		return ErrBadConsensus
+	}
+	return nil
+}
+
+// Fingerprint is the hex SHA-256 of an identity key, shortened to 40
+// characters.
+func Fingerprint(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:20])
+}
+
+// EncodeIdentityKey and ParseIdentityKey convert a public identity key to and
+// from the base64 form used in configuration files.
+func EncodeIdentityKey(key ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func ParseIdentityKey(s string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: %q", ErrBadIdentityKeyFormat, s)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// LoadIdentityKey reads a PEM encoded Ed25519 private key, generating and
+// saving a new one if the file does not exist.
+func LoadIdentityKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		return key, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: %w", path, ErrBadIdentityKeyFormat)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", path, ErrBadIdentityKeyFormat)
+	}
+	return key, nil
+}
@@ -0,0 +1,105 @@
+package encryption
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Requests and replies are split into fragments that fit one cell, so every
+// cell's relay payload stays within PAYLOADSIZE however large the message.
+// A fragment is [message length 4][sequence number 4][data]; every fragment
+// but the last carries FRAGMENTDATASIZE bytes.
+//
+// Every fragment sent and fetched is a cell against the guard's per-address
+// cell limit, so MAXMESSAGESIZE is kept small enough that a request and its
+// reply (about 520 cells) fit in the relays' default dos.cell_burst of 1000.
+const (
+	FRAGMENTHEADERSIZE = 8
+	FRAGMENTDATASIZE   = PAYLOADSIZE - RELAYHEADERSIZE - FRAGMENTHEADERSIZE
+	MAXMESSAGESIZE     = 256 << 10 // largest request or reply, in bytes
+)
+
+var (
+	ErrBadFragment     = errors.New("malformed fragment")
+	ErrMessageTooLarge = errors.New("message exceeds the maximum size")
+)
+
+// FragmentCount returns how many fragments a message of length bytes takes.
+func FragmentCount(length int) int {
+	return max(1, (length+FRAGMENTDATASIZE-1)/FRAGMENTDATASIZE)
+}
+
+// BuildFragment returns fragment seq of message.
+func BuildFragment(message []byte, seq int) []byte {
+	start := seq * FRAGMENTDATASIZE
+	end := min(start+FRAGMENTDATASIZE, len(message))
+	data := make([]byte, FRAGMENTHEADERSIZE+end-start)
+	binary.BigEndian.PutUint32(data[0:4], uint32(len(message)))
+	binary.BigEndian.PutUint32(data[4:8], uint32(seq))
+	copy(data[FRAGMENTHEADERSIZE:], message[start:end])
+	return data
+}
+
+// ParseFragment returns the message length, sequence number and data of a
+// fragment, checking that they are consistent with each other.
+func ParseFragment(data []byte) (int, int, []byte, error) {
+	if len(data) < FRAGMENTHEADERSIZE {
+		return 0, 0, nil, ErrBadFragment
+	}
+	length := int(binary.BigEndian.Uint32(data[0:4]))
+	seq := int(binary.BigEndian.Uint32(data[4:8]))
+	chunk := data[FRAGMENTHEADERSIZE:]
+	if length > MAXMESSAGESIZE {
+		return 0, 0, nil, ErrMessageTooLarge
+	}
+	if seq >= FragmentCount(length) || len(chunk) != min(FRAGMENTDATASIZE, length-seq*FRAGMENTDATASIZE) {
+		return 0, 0, nil, ErrBadFragment
+	}
+	return length, seq, chunk, nil
+}
+
+// BuildFetchData asks the exit for fragment seq of a reply.
+func BuildFetchData(seq int) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(seq))
+}
+
+func ParseFetchData(data []byte) (int, error) {
+	if len(data) != 4 {
+		return 0, ErrBadFragment
+	}
+	return int(binary.BigEndian.Uint32(data)), nil
+}
+
+// Reassembly collects the fragments of one message.
+type Reassembly struct {
+	message  []byte
+	received []bool
+	missing  int
+}
+
+func NewReassembly(length int) *Reassembly {
+	count := FragmentCount(length)
+	return &Reassembly{message: make([]byte, length), received: make([]bool, count), missing: count}
+}
+
+// Add stores a fragment parsed by ParseFragment and reports whether the
+// message is complete. Repeated fragments are ignored.
+func (r *Reassembly) Add(length int, seq int, chunk []byte) (bool, error) {
+	if length != len(r.message) {
+		return false, ErrBadFragment
+	}
+	if !r.received[seq] {
+		copy(r.message[seq*FRAGMENTDATASIZE:], chunk)
+		r.received[seq] = true
+		r.missing--
+	}
+	return r.missing == 0, nil
+}
+
+func (r *Reassembly) Message() []byte {
+	return r.message
+}
+
+func (r *Reassembly) Len() int {
+	return len(r.message)
+}
@@ -0,0 +1,128 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func testMessage(length int) []byte {
+	message := make([]byte, length)
+	rand.New(rand.NewSource(int64(length))).Read(message)
+	return message
+}
+
+// reassemble parses the fragments of message in the given order and checks
+// that the message is complete exactly when the last missing one arrives.
+func reassemble(t *testing.T, message []byte, order []int) []byte {
+	t.Helper()
+	r := NewReassembly(len(message))
+	seen := make(map[int]bool)
+	for i, seq := range order {
+		length, parsedSeq, chunk, err := ParseFragment(BuildFragment(message, seq))
+		if err != nil {
+			t.Fatalf("fragment %d: %v", seq, err)
+		}
+		if length != len(message) || parsedSeq != seq {
+			t.Fatalf("fragment %d parsed as length %d seq %d", seq, length, parsedSeq)
+		}
+		if len(chunk)+FRAGMENTHEADERSIZE > PAYLOADSIZE-RELAYHEADERSIZE {
+			t.Fatalf("fragment %d has %d data bytes, more than a cell holds", seq, len(chunk))
+		}
+		complete, err := r.Add(length, seq, chunk)
+		if err != nil {
+			t.Fatalf("adding fragment %d: %v", seq, err)
+		}
+		seen[seq] = true
+		if want := len(seen) == FragmentCount(len(message)); complete != want {
+			t.Fatalf("after %d fragments complete = %v, want %v", i+1, complete, want)
+		}
+	}
+	return r.Message()
+}
+
+func TestFragmentRoundTrip(t *testing.T) {
+	for _, length := range []int{
+		0,
+		1,
+		FRAGMENTDATASIZE - 1,
+		FRAGMENTDATASIZE,
+		FRAGMENTDATASIZE + 1,
+		3 * FRAGMENTDATASIZE,
+		100<<10 + 123,
+		MAXMESSAGESIZE,
+	} {
+		message := testMessage(length)
+		count := FragmentCount(length)
+		if length > 0 && (count-1)*FRAGMENTDATASIZE >= length {
+			t.Errorf("length %d: %d fragments leave the last one empty", length, count)
+		}
+		order := make([]int, count)
+		for i := range order {
+			order[i] = i
+		}
+		if got := reassemble(t, message, order); !bytes.Equal(got, message) {
+			t.Errorf("length %d: reassembled message differs", length)
+		}
+	}
+}
+
+func TestFragmentsOutOfOrderAndRepeated(t *testing.T) {
+	message := testMessage(200<<10 + 7)
+	count := FragmentCount(len(message))
+	rng := rand.New(rand.NewSource(1))
+	order := rng.Perm(count)
+	// repeat a tenth of the fragments at random points
+	for i := 0; i < count/10; i++ {
+		at := rng.Intn(len(order) + 1)
+		order = append(order[:at], append([]int{order[rng.Intn(len(order))]}, order[at:]...)...)
+	}
+	if got := reassemble(t, message, order); !bytes.Equal(got, message) {
+		t.Error("reassembled message differs")
+	}
+}
+
+func fragmentHeader(length int, seq int, dataLen int) []byte {
+	data := make([]byte, FRAGMENTHEADERSIZE+dataLen)
+	binary.BigEndian.PutUint32(data[0:4], uint32(length))
+	binary.BigEndian.PutUint32(data[4:8], uint32(seq))
+	return data
+}
+
+func TestParseFragmentRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"short header", make([]byte, FRAGMENTHEADERSIZE-1), ErrBadFragment},
+		{"too large", fragmentHeader(MAXMESSAGESIZE+1, 0, FRAGMENTDATASIZE), ErrMessageTooLarge},
+		{"seq past the end", fragmentHeader(2*FRAGMENTDATASIZE, 2, FRAGMENTDATASIZE), ErrBadFragment},
+		{"seq of an empty message", fragmentHeader(0, 1, 0), ErrBadFragment},
+		{"short middle fragment", fragmentHeader(2*FRAGMENTDATASIZE, 0, FRAGMENTDATASIZE-1), ErrBadFragment},
+		{"long last fragment", fragmentHeader(FRAGMENTDATASIZE+10, 1, 11), ErrBadFragment},
+		{"short last fragment", fragmentHeader(FRAGMENTDATASIZE+10, 1, 9), ErrBadFragment},
+		{"data for an empty message", fragmentHeader(0, 0, 1), ErrBadFragment},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, _, err := ParseFragment(tt.data); !errors.Is(err, tt.want) {
+				t.Errorf("ParseFragment = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReassemblyRejectsOtherLength(t *testing.T) {
+	r := NewReassembly(2 * FRAGMENTDATASIZE)
+	message := testMessage(3 * FRAGMENTDATASIZE)
+	length, seq, chunk, err := ParseFragment(BuildFragment(message, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Add(length, seq, chunk); !errors.Is(err, ErrBadFragment) {
+		t.Errorf("Add of a fragment of another message = %v, want %v", err, ErrBadFragment)
+	}
+}
@@ -17,6 +17,13 @@ import (
 
 const PAYLOADSIZE = 1024
 
+// HEADERSIZE is the size of a cell header before RSA encryption and
+// ENCRYPTEDHEADERSIZE its size on the wire (one RSA-2048 block).
+const (
+	HEADERSIZE          = 64
+	ENCRYPTEDHEADERSIZE = 256
+)
+
 type CellType byte
 
 var (
@@ -33,21 +40,22 @@ type OnionCell struct {
 	BackEncryption byte     // 1 byte (1 = RC4)
 	Port       uint16   // 2 bytes
 	IP         [4]byte  // 4 bytes (IPv4)
-	Expiration uint32   // 4 bytes (UNIX timestamp)
+	Expiration uint32   // 4 bytes (idle timeout in seconds the client asks for in CREATE)
 	KeySeed    [16]byte // 16 bytes (128-bit key seed)
+	NextPowNonce uint64 // 8 bytes (proof-of-work nonce for the CREATE this relay forwards)
 	Payload    []byte   // Variable length payload
 }
 
 func (cell OnionCell) String() string {
 	return fmt.Sprintf(
-		"CellType: %d\nCircuitID: %d\nisExitNode: %d\nRequestType: %d\nBackEncryption: %d\nPort: %d\nIP: %d.%d.%d.%d\nExpiration: %d\nKeySeed: %x\nPayload: %s",
+		"CellType: %d\nCircuitID: %d\nisExitNode: %d\nRequestType: %d\nBackEncryption: %d\nPort: %d\nIP: %d.%d.%d.%d\nExpiration: %d\nKeySeed: %x\nNextPowNonce: %d\nPayload: %s",
 		cell.CellType, cell.CircuitID, cell.IsExitNode, cell.RequestType, cell.BackEncryption, cell.Port,
-		cell.IP[0], cell.IP[1], cell.IP[2], cell.IP[3], cell.Expiration, cell.KeySeed, string(cell.Payload),
+		cell.IP[0], cell.IP[1], cell.IP[2], cell.IP[3], cell.Expiration, cell.KeySeed, cell.NextPowNonce, string(cell.Payload),
 	)
 }
 
 func BuildMessage(cell OnionCell) []byte {
-	data := make([]byte, 32+len(cell.Payload))
+	data := make([]byte, HEADERSIZE+len(cell.Payload))
 
 	data[0] = cell.CellType
 	binary.BigEndian.PutUint16(data[1:3], cell.CircuitID)
@@ -58,7 +66,8 @@ func BuildMessage(cell OnionCell) []byte {
 	copy(data[8:12], cell.IP[:])
 	binary.BigEndian.PutUint32(data[12:16], cell.Expiration)
 	copy(data[16:32], cell.KeySeed[:])
-	copy(data[32:], cell.Payload)
+	binary.BigEndian.PutUint64(data[32:40], cell.NextPowNonce)
+	copy(data[HEADERSIZE:], cell.Payload)
 
 	return data
 }
@@ -75,7 +84,8 @@ func RebuildMessage(data []byte) OnionCell {
 	copy(cell.IP[:], data[8:12])
 	cell.Expiration = binary.BigEndian.Uint32(data[12:16])
 	copy(cell.KeySeed[:], data[16:32])
-	cell.Payload = data[32:]
+	cell.NextPowNonce = binary.BigEndian.Uint64(data[32:40])
+	cell.Payload = data[HEADERSIZE:]
 
 	return cell
 }
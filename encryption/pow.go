@@ -0,0 +1,45 @@
+package encryption
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/bits"
+)
+
+// Relays under load ask for a proof-of-work on CREATE cells: a nonce such that
+// SHA-256(encrypted header || nonce) starts with at least difficulty zero bits.
+// The work is bound to the RSA block, so it can be checked before the relay
+// spends a private-key operation on the cell.
+
+func powLeadingZeros(encryptedHeader []byte, nonce uint64) int {
+	buf := make([]byte, len(encryptedHeader)+8)
+	copy(buf, encryptedHeader)
+	binary.BigEndian.PutUint64(buf[len(encryptedHeader):], nonce)
+	sum := sha256.Sum256(buf)
+	zeros := 0
+	for i := 0; i < len(sum); i += 8 {
+		word := binary.BigEndian.Uint64(sum[i : i+8])
+		zeros += bits.LeadingZeros64(word)
+		if word != 0 {
+			break
+		}
+	}
+	return zeros
+}
+
+// SolvePow finds a nonce for the given difficulty. A difficulty of 0 needs no
+// work and returns 0.
+func SolvePow(encryptedHeader []byte, difficulty uint32) uint64 {
+	if difficulty == 0 {
+		return 0
+	}
+	for nonce := uint64(1); ; nonce++ {
+		if powLeadingZeros(encryptedHeader, nonce) >= int(difficulty) {
+			return nonce
+		}
+	}
+}
+
+func VerifyPow(encryptedHeader []byte, nonce uint64, difficulty uint32) bool {
+	return difficulty == 0 || powLeadingZeros(encryptedHeader, nonce) >= int(difficulty)
+}
@@ -0,0 +1,32 @@
+package encryption
+
+import "testing"
+
+func TestPow(t *testing.T) {
+	header := make([]byte, ENCRYPTEDHEADERSIZE)
+	for i := range header {
+		header[i] = byte(i)
+	}
+	other := append([]byte{}, header...)
+	other[0] ^= 1
+	for difficulty := uint32(0); difficulty <= 14; difficulty += 2 {
+		nonce := SolvePow(header, difficulty)
+		if !VerifyPow(header, nonce, difficulty) {
+			t.Errorf("difficulty %d: solved nonce %d does not verify", difficulty, nonce)
+		}
+		if difficulty > 0 && powLeadingZeros(header, nonce) < int(difficulty) {
+			t.Errorf("difficulty %d: nonce %d has %d leading zero bits", difficulty, nonce, powLeadingZeros(header, nonce))
+		}
+	}
+	// the work is bound to the header it was solved for
+	nonce := SolvePow(header, 12)
+	if VerifyPow(other, nonce, 12) && VerifyPow(other, nonce+1, 12) {
+		t.Error("a nonce solved for one header verifies for another")
+	}
+	if !VerifyPow(other, 12345, 0) {
+		t.Error("difficulty 0 asks for work")
+	}
+	if VerifyPow(header, 0, 12) {
+		t.Error("the zero nonce passes difficulty 12")
+	}
+}
@@ -0,0 +1,117 @@
+package encryption
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// Request types carried in the exit node's cell header. The first three are
+// forwarded to the test server's RPCs; STREAM_REQUEST carries a RelayCell for
+// a TCP stream opened by the exit node.
+const (
+	GREET_REQUEST     = 1
+	FIBONACCI_REQUEST = 2
+	RANDOM_REQUEST    = 3
+	STREAM_REQUEST    = 4
+	EXTEND_REQUEST    = 5
+	KEEPALIVE_REQUEST = 6 // answered by the exit with the circuit's remaining lifetime
+)
+
+// Relay commands exchanged end to end between the client and the exit node.
+const (
+	RELAY_BEGIN     = 1 // Data: "host:port" to connect to, resolved by the exit
+	RELAY_CONNECTED = 2
+	RELAY_DATA      = 3  // Data: bytes for the stream (may be empty to poll for replies)
+	RELAY_END       = 4  // Data: optional reason
+	RELAY_EXTEND    = 5  // Data: see BuildExtendData
+	RELAY_EXTENDED  = 6  // Data: the new hop's CreatedConfirmation
+	RELAY_REQUEST   = 7  // Data: a fragment of the request type (GREET_REQUEST...) followed by the request for the server
+	RELAY_RESPONSE  = 8  // Data: a fragment of the server's reply
+	RELAY_RECEIVED  = 9  // no data: the exit stored a request fragment and waits for the others
+	RELAY_FETCH     = 10 // Data: see BuildFetchData
+)
+
+const RELAYHEADERSIZE = 7
+
+var ErrShortRelayCell = errors.New("relay cell too short")
+
+type RelayCell struct {
+	Command  byte   // 1 byte
+	StreamID uint16 // 2 bytes
+	Data     []byte // 4 bytes length + data
+}
+
+func BuildRelayCell(cell RelayCell) []byte {
+	data := make([]byte, RELAYHEADERSIZE+len(cell.Data))
+	data[0] = cell.Command
+	binary.BigEndian.PutUint16(data[1:3], cell.StreamID)
+	binary.BigEndian.PutUint32(data[3:7], uint32(len(cell.Data)))
+	copy(data[RELAYHEADERSIZE:], cell.Data)
+	return data
+}
+
+func ParseRelayCell(data []byte) (RelayCell, error) {
+	if len(data) < RELAYHEADERSIZE {
+		return RelayCell{}, ErrShortRelayCell
+	}
+	length := binary.BigEndian.Uint32(data[3:7])
+	if uint64(len(data)-RELAYHEADERSIZE) < uint64(length) {
+		return RelayCell{}, ErrShortRelayCell
+	}
+	return RelayCell{
+		Command:  data[0],
+		StreamID: binary.BigEndian.Uint16(data[1:3]),
+		Data:     data[RELAYHEADERSIZE : RELAYHEADERSIZE+int(length)],
+	}, nil
+}
+
+// BuildExtendData asks the last hop of a circuit to forward a CREATE cell to
+// the relay at addr: [pow nonce 8][addr length 2][addr][CREATE message].
+func BuildExtendData(addr string, powNonce uint64, create []byte) []byte {
+	data := make([]byte, 10+len(addr)+len(create))
+	binary.BigEndian.PutUint64(data[0:8], powNonce)
+	binary.BigEndian.PutUint16(data[8:10], uint16(len(addr)))
+	copy(data[10:], addr)
+	copy(data[10+len(addr):], create)
+	return data
+}
+
+func ParseExtendData(data []byte) (string, uint64, []byte, error) {
+	if len(data) < 10 {
+		return "", 0, nil, ErrShortRelayCell
+	}
+	addrLen := int(binary.BigEndian.Uint16(data[8:10]))
+	if len(data) < 10+addrLen {
+		return "", 0, nil, ErrShortRelayCell
+	}
+	return string(data[10 : 10+addrLen]), binary.BigEndian.Uint64(data[0:8]), data[10+addrLen:], nil
+}
+
+// CreatedConfirmation is what a relay answers a CREATE cell with: the idle
+// timeout in seconds it granted, followed by a hash over the key seed and that
+// timeout. Only the relay holding the onion key can have read the key seed,
+// so a matching hash confirms the hop's keys and the granted timeout.
+func CreatedConfirmation(keySeed [16]byte, idleTimeout uint32) []byte {
+	data := make([]byte, 4, 4+sha256.Size)
+	binary.BigEndian.PutUint32(data, idleTimeout)
+	return append(data, createdHash(keySeed, idleTimeout)...)
+}
+
+// ParseCreated checks a CreatedConfirmation against the key seed sent in the
+// CREATE and returns the granted idle timeout in seconds.
+func ParseCreated(data []byte, keySeed [16]byte) (uint32, bool) {
+	if len(data) != 4+sha256.Size {
+		return 0, false
+	}
+	idleTimeout := binary.BigEndian.Uint32(data[:4])
+	return idleTimeout, hmac.Equal(data[4:], createdHash(keySeed, idleTimeout))
+}
+
+func createdHash(keySeed [16]byte, idleTimeout uint32) []byte {
+	data := append([]byte("CREATED"), keySeed[:]...)
+	data = binary.BigEndian.AppendUint32(data, idleTimeout)
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
@@ -0,0 +1,408 @@
+package encryption
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// Flow control works like Tor's SENDME windows. The sender of data cells
+// (RELAY_REQUEST and RELAY_RESPONSE fragments, RELAY_DATA with data) takes one
+// unit of the circuit window and one of the stream window per cell and waits
+// when either is empty. The receiver answers every CIRCWINDOW_INCREMENT cells
+// of a circuit and every STREAMWINDOW_INCREMENT cells of a stream with a
+// RELAY_SENDME, which opens the window by that much again.
+//
+// SENDMEs travel behind the relay cell they are sent with, in the same
+// payload, and are numbered per window. Each carries an HMAC over its number,
+// stream and direction keyed with the exit's third key, which only the client
+// and the exit know, so the hops in between cannot forge or replay them.
+const (
+	CIRCWINDOW_START       = 1000
+	CIRCWINDOW_INCREMENT   = 100
+	STREAMWINDOW_START     = 500
+	STREAMWINDOW_INCREMENT = 50
+
+	RELAY_SENDME = 11 // Data: see BuildSendme; stream 0 for the circuit window
+)
+
+// Directions of a SENDME, by who sends it.
+const (
+	SENDME_FROM_CLIENT = 'C'
+	SENDME_FROM_EXIT   = 'E'
+)
+
+var (
+	ErrBadSendme      = errors.New("unauthenticated or unexpected SENDME")
+	ErrWindowExceeded = errors.New("flow control window exceeded")
+)
+
+// IsDataCell reports whether a relay cell counts against the windows.
+func IsDataCell(cell RelayCell) bool {
+	switch cell.Command {
+	case RELAY_REQUEST, RELAY_RESPONSE:
+		return true
+	case RELAY_DATA:
+		return len(cell.Data) > 0
+	}
+	return false
+}
+
+// BuildSendme returns SENDME number seq for the stream's window (0 for the
+// circuit's): [seq 4][HMAC 32].
+func BuildSendme(key []byte, direction byte, streamID uint16, seq uint32) RelayCell {
+	data := binary.BigEndian.AppendUint32(nil, seq)
+	return RelayCell{Command: RELAY_SENDME, StreamID: streamID, Data: append(data, sendmeTag(key, direction, streamID, seq)...)}
+}
+
+// ParseSendme checks a SENDME's HMAC and returns its number.
+func ParseSendme(key []byte, direction byte, cell RelayCell) (uint32, error) {
+	if cell.Command != RELAY_SENDME || len(cell.Data) != 4+sha256.Size {
+		return 0, ErrBadSendme
+	}
+	seq := binary.BigEndian.Uint32(cell.Data[:4])
+	if seq == 0 || !hmac.Equal(cell.Data[4:], sendmeTag(key, direction, cell.StreamID, seq)) {
+		return 0, ErrBadSendme
+	}
+	return seq, nil
+}
+
+func sendmeTag(key []byte, direction byte, streamID uint16, seq uint32) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte{'S', 'E', 'N', 'D', 'M', 'E', direction})
+	mac.Write(binary.BigEndian.AppendUint16(nil, streamID))
+	mac.Write(binary.BigEndian.AppendUint32(nil, seq))
+	return mac.Sum(nil)
+}
+
+// AppendRelayCells appends relay cells, such as the SENDMEs sent with a
+// relay cell, to data.
+func AppendRelayCells(data []byte, cells []RelayCell) []byte {
+	for _, cell := range cells {
+		data = append(data, BuildRelayCell(cell)...)
+	}
+	return data
+}
+
+// ParseRelayCells splits a payload into the relay cell and the SENDMEs
+// appended to it.
+func ParseRelayCells(data []byte) (RelayCell, []RelayCell, error) {
+	cell, err := ParseRelayCell(data)
+	if err != nil {
+		return RelayCell{}, nil, err
+	}
+	var sendmes []RelayCell
+	for rest := data[RELAYHEADERSIZE+len(cell.Data):]; len(rest) > 0; {
+		sendme, err := ParseRelayCell(rest)
+		if err != nil {
+			return RelayCell{}, nil, err
+		}
+		if sendme.Command != RELAY_SENDME {
+			return RelayCell{}, nil, ErrBadSendme
+		}
+		sendmes = append(sendmes, sendme)
+		rest = rest[RELAYHEADERSIZE+len(sendme.Data):]
+	}
+	return cell, sendmes, nil
+}
+
+// PackageWindow is the sending side of a window.
+type PackageWindow struct {
+	lock      sync.Mutex
+	opened    chan struct{} // closed and replaced when the window opens
+	size      int
+	start     int
+	increment int
+	packaged  int             // cells sent
+	acked     uint32          // every SENDME up to this one arrived
+	ackedLate map[uint32]bool // SENDMEs after acked that arrived early
+}
+
+func NewPackageWindow(start int, increment int) *PackageWindow {
+	return &PackageWindow{opened: make(chan struct{}), size: start, start: start, increment: increment}
+}
+
+// TryTake takes one unit of the window if it is open.
+func (w *PackageWindow) TryTake() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.size == 0 {
+		return false
+	}
+	w.size--
+	w.packaged++
+	return true
+}
+
+// Take waits until the window is open and takes one unit of it.
+func (w *PackageWindow) Take(ctx context.Context) error {
+	for {
+		w.lock.Lock()
+		opened := w.opened
+		w.lock.Unlock()
+		if w.TryTake() {
+			return nil
+		}
+		select {
+		case <-opened:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Return gives back a unit taken for a cell that was not sent after all.
+func (w *PackageWindow) Return() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.size++
+	w.packaged--
+}
+
+// Ack opens the window for SENDME number seq. SENDMEs may arrive out of
+// order; repeated ones are ignored, and one acknowledging cells that were
+// never sent is an error.
+func (w *PackageWindow) Ack(seq uint32) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if int(seq)*w.increment > w.packaged {
+		return ErrBadSendme
+	}
+	if seq <= w.acked || w.ackedLate[seq] {
+		return nil
+	}
+	if seq == w.acked+1 {
+		w.acked = seq
+		for w.ackedLate[w.acked+1] {
+			delete(w.ackedLate, w.acked+1)
+			w.acked++
+		}
+	} else {
+		if w.ackedLate == nil {
+			w.ackedLate = make(map[uint32]bool)
+		}
+		w.ackedLate[seq] = true
+	}
+	w.size = min(w.size+w.increment, w.start)
+	close(w.opened)
+	w.opened = make(chan struct{})
+	return nil
+}
+
+// Size returns how many cells may be sent before a SENDME arrives.
+func (w *PackageWindow) Size() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.size
+}
+
+// DeliverWindow is the receiving side of a window.
+type DeliverWindow struct {
+	lock      sync.Mutex
+	start     int
+	increment int
+	delivered int
+	sent      uint32 // SENDMEs issued
+}
+
+func NewDeliverWindow(start int, increment int) *DeliverWindow {
+	return &DeliverWindow{start: start, increment: increment}
+}
+
+// Deliver counts a received data cell and returns the number of the SENDME
+// to send for it, or 0 if none is due. A sender that overran the window gets
+// ErrWindowExceeded.
+func (w *DeliverWindow) Deliver() (uint32, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.delivered >= w.start+int(w.sent)*w.increment {
+		return 0, ErrWindowExceeded
+	}
+	w.delivered++
+	if w.delivered%w.increment != 0 {
+		return 0, nil
+	}
+	w.sent++
+	return w.sent, nil
+}
+
+// CircuitFlow holds the windows of one end of a circuit, the client or the
+// exit: a package and a deliver window for the circuit and for every stream,
+// and the SENDMEs due to the other end.
+type CircuitFlow struct {
+	key      []byte
+	sendFrom byte // direction of the SENDMEs this end sends
+	packageW *PackageWindow
+	deliverW *DeliverWindow
+
+	lock    sync.Mutex
+	streams map[uint16]*streamFlow
+	pending []RelayCell
+}
+
+type streamFlow struct {
+	packageW *PackageWindow
+	deliverW *DeliverWindow
+}
+
+// NewCircuitFlow returns the windows of a fresh circuit; key is the exit's
+// third key.
+func NewCircuitFlow(key []byte, isExit bool) *CircuitFlow {
+	sendFrom := byte(SENDME_FROM_CLIENT)
+	if isExit {
+		sendFrom = SENDME_FROM_EXIT
+	}
+	return &CircuitFlow{
+		key:      key,
+		sendFrom: sendFrom,
+		packageW: NewPackageWindow(CIRCWINDOW_START, CIRCWINDOW_INCREMENT),
+		deliverW: NewDeliverWindow(CIRCWINDOW_START, CIRCWINDOW_INCREMENT),
+		streams:  make(map[uint16]*streamFlow),
+	}
+}
+
+func (f *CircuitFlow) stream(streamID uint16, create bool) *streamFlow {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	stream, exists := f.streams[streamID]
+	if !exists && create {
+		stream = &streamFlow{
+			packageW: NewPackageWindow(STREAMWINDOW_START, STREAMWINDOW_INCREMENT),
+			deliverW: NewDeliverWindow(STREAMWINDOW_START, STREAMWINDOW_INCREMENT),
+		}
+		f.streams[streamID] = stream
+	}
+	return stream
+}
+
+// Take waits for room in the circuit's and the stream's window for one data
+// cell.
+func (f *CircuitFlow) Take(ctx context.Context, streamID uint16) error {
+	stream := f.stream(streamID, true)
+	if err := stream.packageW.Take(ctx); err != nil {
+		return err
+	}
+	if err := f.packageW.Take(ctx); err != nil {
+		stream.packageW.Return()
+		return err
+	}
+	return nil
+}
+
+// TryTake is Take without waiting.
+func (f *CircuitFlow) TryTake(streamID uint16) bool {
+	stream := f.stream(streamID, true)
+	if !stream.packageW.TryTake() {
+		return false
+	}
+	if !f.packageW.TryTake() {
+		stream.packageW.Return()
+		return false
+	}
+	return true
+}
+
+// Return gives back what Take took for a cell that was not sent.
+func (f *CircuitFlow) Return(streamID uint16) {
+	f.packageW.Return()
+	if stream := f.stream(streamID, false); stream != nil {
+		stream.packageW.Return()
+	}
+}
+
+// Acknowledge checks the SENDMEs from the other end and opens the windows
+// they are for. SENDMEs for streams closed in the meantime are ignored.
+func (f *CircuitFlow) Acknowledge(sendmes []RelayCell) error {
+	from := byte(SENDME_FROM_EXIT)
+	if f.sendFrom == SENDME_FROM_EXIT {
+		from = SENDME_FROM_CLIENT
+	}
+	for _, sendme := range sendmes {
+		seq, err := ParseSendme(f.key, from, sendme)
+		if err != nil {
+			return err
+		}
+		window := f.packageW
+		if sendme.StreamID != 0 {
+			stream := f.stream(sendme.StreamID, false)
+			if stream == nil {
+				continue
+			}
+			window = stream.packageW
+		}
+		if err := window.Ack(seq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deliver counts a data cell from the other end and queues the SENDMEs it
+// makes due.
+func (f *CircuitFlow) Deliver(cell RelayCell) error {
+	if !IsDataCell(cell) {
+		return nil
+	}
+	seq, err := f.deliverW.Deliver()
+	if err != nil {
+		return err
+	}
+	streamSeq, err := f.stream(cell.StreamID, true).deliverW.Deliver()
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if seq != 0 {
+		f.pending = append(f.pending, BuildSendme(f.key, f.sendFrom, 0, seq))
+	}
+	if streamSeq != 0 {
+		f.pending = append(f.pending, BuildSendme(f.key, f.sendFrom, cell.StreamID, streamSeq))
+	}
+	return nil
+}
+
+// Sendmes returns the SENDMEs due and forgets them; the caller sends them
+// with its next cell.
+func (f *CircuitFlow) Sendmes() []RelayCell {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	sendmes := f.pending
+	f.pending = nil
+	return sendmes
+}
+
+// Requeue puts back SENDMEs whose cell may not have arrived. The other end
+// ignores those it already has.
+func (f *CircuitFlow) Requeue(sendmes []RelayCell) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, sendme := range sendmes {
+		if _, open := f.streams[sendme.StreamID]; open || sendme.StreamID == 0 {
+			f.pending = append(f.pending, sendme)
+		}
+	}
+}
+
+// CloseStream forgets a stream's windows and the SENDMEs due for it.
+func (f *CircuitFlow) CloseStream(streamID uint16) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.streams, streamID)
+	pending := f.pending[:0]
+	for _, sendme := range f.pending {
+		if sendme.StreamID != streamID {
+			pending = append(pending, sendme)
+		}
+	}
+	f.pending = pending
+}
+
+// Window returns how many data cells may be sent on the circuit before a
+// SENDME arrives.
+func (f *CircuitFlow) Window() int {
+	return f.packageW.Size()
+}
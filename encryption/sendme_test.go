@@ -0,0 +1,209 @@
+package encryption
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var (
+	testFlowKey  = []byte("0123456789abcdef")
+	otherFlowKey = []byte("fedcba9876543210")
+)
+
+func TestParseSendme(t *testing.T) {
+	valid := BuildSendme(testFlowKey, SENDME_FROM_EXIT, 3, 7)
+	if seq, err := ParseSendme(testFlowKey, SENDME_FROM_EXIT, valid); err != nil || seq != 7 {
+		t.Fatalf("ParseSendme = %d, %v, want 7", seq, err)
+	}
+	moved := valid
+	moved.StreamID = 4
+	renumbered := BuildSendme(testFlowKey, SENDME_FROM_EXIT, 3, 7)
+	renumbered.Data[3] = 8
+	truncated := valid
+	truncated.Data = valid.Data[:20]
+	tests := []struct {
+		name      string
+		key       []byte
+		direction byte
+		cell      RelayCell
+	}{
+		{"forged by a middle relay", testFlowKey, SENDME_FROM_EXIT, BuildSendme(otherFlowKey, SENDME_FROM_EXIT, 3, 7)},
+		{"reflected back to its sender", testFlowKey, SENDME_FROM_CLIENT, valid},
+		{"moved to another stream", testFlowKey, SENDME_FROM_EXIT, moved},
+		{"renumbered", testFlowKey, SENDME_FROM_EXIT, renumbered},
+		{"number zero", testFlowKey, SENDME_FROM_EXIT, BuildSendme(testFlowKey, SENDME_FROM_EXIT, 3, 0)},
+		{"truncated", testFlowKey, SENDME_FROM_EXIT, truncated},
+		{"not a SENDME", testFlowKey, SENDME_FROM_EXIT, RelayCell{Command: RELAY_DATA, StreamID: 3, Data: valid.Data}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseSendme(tt.key, tt.direction, tt.cell); !errors.Is(err, ErrBadSendme) {
+				t.Errorf("ParseSendme = %v, want %v", err, ErrBadSendme)
+			}
+		})
+	}
+}
+
+func TestPackageWindowStopsAtZero(t *testing.T) {
+	w := NewPackageWindow(4, 2)
+	for i := 0; i < 4; i++ {
+		if !w.TryTake() {
+			t.Fatalf("cell %d of the window refused", i+1)
+		}
+	}
+	if w.TryTake() || w.Size() != 0 {
+		t.Fatalf("window of size %d let a cell through", w.Size())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := w.Take(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Take on an empty window = %v, want it to wait", err)
+	}
+
+	// a SENDME wakes the waiting sender
+	taken := make(chan error)
+	go func() { taken <- w.Take(context.Background()) }()
+	select {
+	case err := <-taken:
+		t.Fatalf("Take returned %v before a SENDME arrived", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if err := w.Ack(1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-taken:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Take still waiting after a SENDME")
+	}
+	if w.Size() != 1 {
+		t.Errorf("window %d after one SENDME and one cell, want 1", w.Size())
+	}
+}
+
+func TestPackageWindowAck(t *testing.T) {
+	w := NewPackageWindow(10, 2)
+	for i := 0; i < 6; i++ {
+		w.TryTake()
+	}
+	if err := w.Ack(4); !errors.Is(err, ErrBadSendme) {
+		t.Errorf("SENDME for cells never sent = %v, want %v", err, ErrBadSendme)
+	}
+	// SENDMEs may arrive out of order
+	for _, ack := range []struct {
+		seq  uint32
+		size int
+	}{{2, 6}, {1, 8}} {
+		if err := w.Ack(ack.seq); err != nil {
+			t.Fatal(err)
+		}
+		if w.Size() != ack.size {
+			t.Fatalf("window %d after SENDME %d, want %d", w.Size(), ack.seq, ack.size)
+		}
+	}
+	// replays open nothing
+	for _, seq := range []uint32{1, 2, 2, 1} {
+		if err := w.Ack(seq); err != nil {
+			t.Fatal(err)
+		}
+		if w.Size() != 8 {
+			t.Fatalf("replayed SENDME %d opened the window to %d", seq, w.Size())
+		}
+	}
+	if err := w.Ack(3); err != nil {
+		t.Fatal(err)
+	}
+	// the window never grows past its start
+	if w.Size() != 10 {
+		t.Errorf("window %d after every SENDME, want 10", w.Size())
+	}
+}
+
+func TestDeliverWindow(t *testing.T) {
+	w := NewDeliverWindow(4, 2)
+	var sendmes []uint32
+	for i := 0; i < 4; i++ {
+		seq, err := w.Deliver()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seq != 0 {
+			sendmes = append(sendmes, seq)
+		}
+	}
+	if len(sendmes) != 2 || sendmes[0] != 1 || sendmes[1] != 2 {
+		t.Errorf("SENDMEs %v for 4 cells with increment 2, want [1 2]", sendmes)
+	}
+	w = NewDeliverWindow(4, 5)
+	for i := 0; i < 4; i++ {
+		if _, err := w.Deliver(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.Deliver(); !errors.Is(err, ErrWindowExceeded) {
+		t.Errorf("cell past the window = %v, want %v", err, ErrWindowExceeded)
+	}
+}
+
+// TestCircuitFlow runs the exit's data cells and the client's SENDMEs
+// through both ends of a circuit.
+func TestCircuitFlow(t *testing.T) {
+	client, exit := NewCircuitFlow(testFlowKey, false), NewCircuitFlow(testFlowKey, true)
+	data := RelayCell{Command: RELAY_DATA, StreamID: 1, Data: []byte("x")}
+	var sendmes []RelayCell
+	for i := 0; i < STREAMWINDOW_START; i++ {
+		if !exit.TryTake(1) {
+			t.Fatalf("cell %d refused", i+1)
+		}
+		if err := client.Deliver(data); err != nil {
+			t.Fatal(err)
+		}
+		sendmes = append(sendmes, client.Sendmes()...)
+	}
+	if exit.TryTake(1) {
+		t.Fatal("the exit sent past the stream window without SENDMEs")
+	}
+	// 500 cells: ten stream SENDMEs and five for the circuit
+	if len(sendmes) != STREAMWINDOW_START/STREAMWINDOW_INCREMENT+STREAMWINDOW_START/CIRCWINDOW_INCREMENT {
+		t.Fatalf("%d SENDMEs for %d cells", len(sendmes), STREAMWINDOW_START)
+	}
+
+	// the exit's own SENDMEs cannot open its window, nor can a middle relay's
+	if err := exit.Acknowledge([]RelayCell{BuildSendme(testFlowKey, SENDME_FROM_EXIT, 1, 1)}); !errors.Is(err, ErrBadSendme) {
+		t.Errorf("reflected SENDME = %v, want %v", err, ErrBadSendme)
+	}
+	if err := exit.Acknowledge([]RelayCell{BuildSendme(otherFlowKey, SENDME_FROM_CLIENT, 1, 1)}); !errors.Is(err, ErrBadSendme) {
+		t.Errorf("forged SENDME = %v, want %v", err, ErrBadSendme)
+	}
+	if exit.TryTake(1) {
+		t.Fatal("a rejected SENDME opened the window")
+	}
+
+	if err := exit.Acknowledge(sendmes[:1]); err != nil {
+		t.Fatal(err)
+	}
+	window := exit.Window()
+	// replaying the same SENDME, as a middle relay could, opens nothing more
+	for i := 0; i < 5; i++ {
+		if err := exit.Acknowledge(sendmes[:1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if exit.Window() != window {
+		t.Errorf("replayed SENDMEs moved the circuit window from %d to %d", window, exit.Window())
+	}
+	if err := exit.Acknowledge(sendmes); err != nil {
+		t.Fatal(err)
+	}
+	if exit.Window() != CIRCWINDOW_START {
+		t.Errorf("circuit window %d after every SENDME, want %d", exit.Window(), CIRCWINDOW_START)
+	}
+	if !exit.TryTake(1) {
+		t.Error("stream window still closed after its SENDMEs")
+	}
+}
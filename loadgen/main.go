@@ -0,0 +1,335 @@
+// loadgen runs many virtual clients in one process against the relays
+// listed by the directory and reports circuit build and request latencies, error
+// rates and throughput. Every virtual client builds its own circuit and sends
+// one request at a time, drawn from the request mix, either as fast as it can
+// or at its share of the total rate.
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	encryption "onion_routing/encryption"
+	netdir "onion_routing/netdir"
+	onionclient "onion_routing/onionclient"
+	utils "onion_routing/utils"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// Each client's circuits, rebuilds included, use IDs from a range of its own
+// of at least minCircuitIDs, so an ID only comes round again long after its
+// circuit was closed.
+const (
+	minCircuitIDs = 16
+	maxClients    = (1 << 16) / minCircuitIDs
+)
+
+// requestKind is one entry of the request mix.
+type requestKind struct {
+	name    string
+	reqType byte
+	weight  int
+	message []byte
+}
+
+type loadConfig struct {
+	clients        int
+	duration       time.Duration
+	rate           float64
+	mix            []requestKind
+	circuitUses    int
+	requestTimeout time.Duration
+	hops           int
+	serverAddr     string
+}
+
+func main() {
+	clients := flag.Int("clients", 10, "virtual clients, each with its own circuit")
+	duration := flag.Duration("duration", 30*time.Second, "how long to send requests")
+	rate := flag.Float64("rate", 0, "requests per second across all clients (0: every client sends as fast as it can)")
+	mix := flag.String("mix", "greet=70,fib=20,rand=10", "request mix as comma separated type=weight, types greet, fib and rand")
+	greetSize := flag.Int("greet-size", 32, "bytes in a greet message")
+	fibN := flag.Int("fib-n", 20, "Fibonacci number asked for by fib requests")
+	randN := flag.Int("rand-n", 100, "random numbers asked for by rand requests")
+	circuitUses := flag.Int("circuit-uses", 0, "build a new circuit after this many requests (0: keep it until it fails)")
+	requestTimeout := flag.Duration("request-timeout", 30*time.Second, "deadline of every request")
+	hops := flag.Int("hops", onionclient.DefaultPathLength, "relays per circuit")
+	serverAddr := flag.String("server-addr", utils.ServerAddr, "server the exits deliver requests to")
+	etcdAddr := flag.String("etcd-addr", utils.EtcdServerAddr, "etcd endpoint the relays are registered in")
+	servers := flag.String("directory-servers", "", "comma separated addresses of directory servers to fetch the consensus from instead of reading etcd")
+	authorityKeys := flag.String("directory-authorities", "", "comma separated base64 public keys of the directory authorities whose signatures relays need (required)")
+	caPath := flag.String("tls-ca", "certificates/ca.crt", "CA certificate used to verify relays")
+	certPath := flag.String("tls-cert", "certificates/client.crt", "certificate presented to relays")
+	keyPath := flag.String("tls-key", "certificates/client.key", "private key of the certificate")
+	csvPath := flag.String("csv", "", "also write the report as CSV to this file (- for stdout)")
+	jsonPath := flag.String("json", "", "also write the report as JSON to this file (- for stdout)")
+	flag.Parse()
+
+	kinds, err := parseMix(*mix, *greetSize, *fibN, *randN)
+	if err != nil {
+		log.Fatalf("Invalid -mix: %v", err)
+	}
+	if *clients < 1 || *duration <= 0 || *rate < 0 || *hops < 1 {
+		log.Fatalf("-clients and -hops must be at least 1, -duration positive and -rate not negative")
+	}
+	if *clients > maxClients {
+		log.Fatalf("-clients must be at most %d, so that each client has %d circuit IDs of its own", maxClients, minCircuitIDs)
+	}
+	cfg := loadConfig{
+		clients:        *clients,
+		duration:       *duration,
+		rate:           *rate,
+		mix:            kinds,
+		circuitUses:    *circuitUses,
+		requestTimeout: *requestTimeout,
+		hops:           *hops,
+		serverAddr:     *serverAddr,
+	}
+
+	creds, err := onionclient.LoadCredentials(*caPath, *certPath, *keyPath)
+	if err != nil {
+		log.Fatalf("Failed to load credentials: %v", err)
+	}
+	if *authorityKeys == "" {
+		log.Fatalf("-directory-authorities is required")
+	}
+	var authorities []ed25519.PublicKey
+	for _, key := range strings.Split(*authorityKeys, ",") {
+		authority, err := encryption.ParseIdentityKey(strings.TrimSpace(key))
+		if err != nil {
+			log.Fatalf("Invalid -directory-authorities: %v", err)
+		}
+		authorities = append(authorities, authority)
+	}
+	var upstream netdir.Directory
+	if *servers != "" {
+		upstream = &netdir.GRPCDirectory{Addrs: strings.Split(*servers, ","), Credentials: creds, Authorities: authorities}
+	} else {
+		utils.EtcdServerAddr = *etcdAddr
+		etcdDirectory := netdir.NewEtcdDirectory()
+		etcdDirectory.Authorities = authorities
+		upstream = etcdDirectory
+	}
+	// one listing serves every virtual client
+	directory, err := onionclient.NewCachedDirectory(upstream, onionclient.DirectoryCacheOptions{Validity: time.Minute})
+	if err != nil {
+		log.Fatalf("Failed to set up the directory: %v", err)
+	}
+	defer directory.Close()
+
+	log.Printf("Running %d clients for %v (mix %s, rate %s)", cfg.clients, cfg.duration, *mix, rateString(cfg.rate))
+	report := run(cfg, creds, sharedDirectory{directory})
+
+	toStdout := *csvPath == "-" || *jsonPath == "-"
+	if !toStdout {
+		report.printTable(os.Stdout)
+	}
+	if *csvPath != "" {
+		if err := writeReport(*csvPath, report.writeCSV); err != nil {
+			log.Fatalf("Writing the CSV report failed: %v", err)
+		}
+	}
+	if *jsonPath != "" {
+		if err := writeReport(*jsonPath, report.writeJSON); err != nil {
+			log.Fatalf("Writing the JSON report failed: %v", err)
+		}
+	}
+}
+
+// sharedDirectory hides the directory's Close from the virtual clients.
+type sharedDirectory struct {
+	netdir.Directory
+}
+
+func parseMix(mix string, greetSize int, fibN int, randN int) ([]requestKind, error) {
+	var kinds []requestKind
+	for _, entry := range strings.Split(mix, ",") {
+		name, weightText, found := strings.Cut(strings.TrimSpace(entry), "=")
+		weight, err := strconv.Atoi(weightText)
+		if !found || err != nil || weight < 0 {
+			return nil, fmt.Errorf("%q is not type=weight", entry)
+		}
+		kind := requestKind{name: name, weight: weight}
+		switch name {
+		case "greet":
+			kind.reqType = encryption.GREET_REQUEST
+			kind.message = []byte(strings.Repeat("x", greetSize))
+		case "fib":
+			kind.reqType = encryption.FIBONACCI_REQUEST
+			kind.message = []byte(strconv.Itoa(fibN))
+		case "rand":
+			kind.reqType = encryption.RANDOM_REQUEST
+			kind.message = []byte(strconv.Itoa(randN))
+		default:
+			return nil, fmt.Errorf("unknown request type %q", name)
+		}
+		if weight > 0 {
+			kinds = append(kinds, kind)
+		}
+	}
+	if len(kinds) == 0 {
+		return nil, fmt.Errorf("no request type has a positive weight")
+	}
+	return kinds, nil
+}
+
+func pickKind(kinds []requestKind, rng *rand.Rand) requestKind {
+	total := 0
+	for _, kind := range kinds {
+		total += kind.weight
+	}
+	n := rng.Intn(total)
+	for _, kind := range kinds {
+		if n < kind.weight {
+			return kind
+		}
+		n -= kind.weight
+	}
+	return kinds[len(kinds)-1]
+}
+
+func rateString(rate float64) string {
+	if rate == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%g/s", rate)
+}
+
+// run starts the virtual clients, waits for the last of their requests and
+// returns what they measured.
+func run(cfg loadConfig, creds credentials.TransportCredentials, directory netdir.Directory) *report {
+	r := newReport(cfg)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.duration)
+	defer cancel()
+
+	// every client takes its circuit IDs, rebuilds included, from its own
+	// share of the ID space so that the clients' circuits do not collide on
+	// the relays
+	idBase := uint16(rand.Intn(1 << 16))
+	idCount := (1 << 16) / cfg.clients
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.clients; i++ {
+		client, err := onionclient.New(
+			onionclient.WithCredentials(creds),
+			onionclient.WithDirectory(directory),
+			onionclient.WithServerAddr(cfg.serverAddr),
+			onionclient.WithPathLength(cfg.hops),
+			onionclient.WithRequestTimeout(cfg.requestTimeout),
+			onionclient.WithCircuitIDRange(idBase+uint16(i*idCount), idCount),
+		)
+		if err != nil {
+			log.Fatalf("Failed to create client %d: %v", i+1, err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vc := virtualClient{cfg: cfg, client: client, report: r, rng: rand.New(rand.NewSource(int64(i)))}
+			vc.run(ctx, start, i)
+		}()
+	}
+	wg.Wait()
+	r.elapsed = time.Since(start)
+	return r
+}
+
+type virtualClient struct {
+	cfg     loadConfig
+	client  *onionclient.Client
+	report  *report
+	rng     *rand.Rand
+	circuit *onionclient.Circuit
+	uses    int
+}
+
+// run sends requests until ctx ends. With a rate, client i of n starts i/n of
+// an interval late and then sends every interval, skipping ahead rather than
+// bursting when it falls behind.
+func (vc *virtualClient) run(ctx context.Context, start time.Time, i int) {
+	defer vc.closeCircuit()
+	var interval time.Duration
+	next := start
+	if vc.cfg.rate > 0 {
+		interval = time.Duration(float64(vc.cfg.clients) / vc.cfg.rate * float64(time.Second))
+		next = start.Add(interval * time.Duration(i) / time.Duration(vc.cfg.clients))
+	}
+	for ctx.Err() == nil {
+		if interval > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(next)):
+			}
+			next = next.Add(interval)
+			if now := time.Now(); next.Before(now) {
+				next = now
+			}
+		}
+		if vc.circuit == nil && !vc.buildCircuit(ctx) {
+			continue
+		}
+		kind := pickKind(vc.cfg.mix, vc.rng)
+		// a request still runs when the duration ends, so that it is
+		// measured rather than cancelled
+		sent := time.Now()
+		_, err := vc.circuit.Do(context.Background(), kind.reqType, kind.message)
+		vc.report.record(kind.name, time.Since(sent), err)
+		vc.uses++
+		if err != nil || (vc.cfg.circuitUses > 0 && vc.uses >= vc.cfg.circuitUses) {
+			vc.closeCircuit()
+		}
+	}
+}
+
+// buildCircuit builds the client's circuit, waiting a moment after a failure
+// so that a broken network is not hammered.
+func (vc *virtualClient) buildCircuit(ctx context.Context) bool {
+	start := time.Now()
+	circuit, err := vc.client.BuildCircuit(ctx)
+	if err != nil && ctx.Err() != nil {
+		return false
+	}
+	vc.report.record(opBuild, time.Since(start), err)
+	if err != nil {
+		select {
+		case <-ctx.Done():
+		case <-time.After(500 * time.Millisecond):
+		}
+		return false
+	}
+	vc.circuit, vc.uses = circuit, 0
+	return true
+}
+
+func (vc *virtualClient) closeCircuit() {
+	if vc.circuit != nil {
+		vc.circuit.Close()
+		vc.circuit = nil
+	}
+}
+
+func writeReport(path string, write func(io.Writer) error) error {
+	if path == "-" {
+		return write(os.Stdout)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
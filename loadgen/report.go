@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// opBuild and opAll name the rows for circuit builds and for all requests
+// together; the other rows are the request types of the mix.
+const (
+	opBuild = "circuit build"
+	opAll   = "all requests"
+)
+
+// maxErrorKinds bounds the distinct error messages listed in a report.
+const maxErrorKinds = 10
+
+type opSamples struct {
+	latencies []time.Duration // of successful operations
+	errors    int
+}
+
+type report struct {
+	cfg     loadConfig
+	elapsed time.Duration
+
+	lock   sync.Mutex
+	ops    map[string]*opSamples
+	errors map[string]int // error message to occurrences
+}
+
+func newReport(cfg loadConfig) *report {
+	return &report{cfg: cfg, ops: make(map[string]*opSamples), errors: make(map[string]int)}
+}
+
+func (r *report) record(op string, latency time.Duration, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	names := []string{op}
+	if op != opBuild {
+		names = append(names, opAll)
+	}
+	for _, name := range names {
+		samples := r.ops[name]
+		if samples == nil {
+			samples = &opSamples{}
+			r.ops[name] = samples
+		}
+		if err != nil {
+			samples.errors++
+		} else {
+			samples.latencies = append(samples.latencies, latency)
+		}
+	}
+	if err != nil {
+		r.errors[fmt.Sprintf("%s: %v", op, err)]++
+	}
+}
+
+// opSummary is one row of the report. Latencies are in milliseconds over
+// the successful operations; throughput counts successful operations.
+type opSummary struct {
+	Operation  string  `json:"operation"`
+	Count      int     `json:"count"`
+	Errors     int     `json:"errors"`
+	ErrorRate  float64 `json:"error_rate"`
+	P50        float64 `json:"p50_ms"`
+	P90        float64 `json:"p90_ms"`
+	P99        float64 `json:"p99_ms"`
+	Max        float64 `json:"max_ms"`
+	Mean       float64 `json:"mean_ms"`
+	Throughput float64 `json:"throughput_per_s"`
+}
+
+type errorCount struct {
+	Error string `json:"error"`
+	Count int    `json:"count"`
+}
+
+type reportSummary struct {
+	Clients    int          `json:"clients"`
+	Duration   float64      `json:"duration_s"`
+	Elapsed    float64      `json:"elapsed_s"`
+	Rate       float64      `json:"rate"` // 0 when unlimited
+	Hops       int          `json:"hops"`
+	Operations []opSummary  `json:"operations"`
+	Errors     []errorCount `json:"errors"`
+}
+
+func (r *report) summary() reportSummary {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	s := reportSummary{
+		Clients:  r.cfg.clients,
+		Duration: r.cfg.duration.Seconds(),
+		Elapsed:  r.elapsed.Seconds(),
+		Rate:     r.cfg.rate,
+		Hops:     r.cfg.hops,
+		Errors:   []errorCount{},
+	}
+	names := []string{opBuild}
+	for _, kind := range r.cfg.mix {
+		names = append(names, kind.name)
+	}
+	names = append(names, opAll)
+	for _, name := range names {
+		if samples := r.ops[name]; samples != nil {
+			s.Operations = append(s.Operations, summarize(name, samples, r.elapsed))
+		}
+	}
+	for message, count := range r.errors {
+		s.Errors = append(s.Errors, errorCount{Error: message, Count: count})
+	}
+	sort.Slice(s.Errors, func(i, j int) bool {
+		if s.Errors[i].Count != s.Errors[j].Count {
+			return s.Errors[i].Count > s.Errors[j].Count
+		}
+		return s.Errors[i].Error < s.Errors[j].Error
+	})
+	if len(s.Errors) > maxErrorKinds {
+		s.Errors = s.Errors[:maxErrorKinds]
+	}
+	return s
+}
+
+func summarize(name string, samples *opSamples, elapsed time.Duration) opSummary {
+	sorted := append([]time.Duration{}, samples.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	op := opSummary{Operation: name, Count: len(sorted) + samples.errors, Errors: samples.errors}
+	op.ErrorRate = float64(op.Errors) / float64(op.Count)
+	if len(sorted) > 0 {
+		var total time.Duration
+		for _, latency := range sorted {
+			total += latency
+		}
+		op.P50 = milliseconds(percentile(sorted, 0.50))
+		op.P90 = milliseconds(percentile(sorted, 0.90))
+		op.P99 = milliseconds(percentile(sorted, 0.99))
+		op.Max = milliseconds(sorted[len(sorted)-1])
+		op.Mean = milliseconds(total / time.Duration(len(sorted)))
+	}
+	if elapsed > 0 {
+		op.Throughput = float64(len(sorted)) / elapsed.Seconds()
+	}
+	return op
+}
+
+// percentile returns the nearest-rank percentile of sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return math.Round(float64(d.Microseconds())) / 1000
+}
+
+func (r *report) printTable(w io.Writer) {
+	s := r.summary()
+	fmt.Fprintf(w, "%d clients, %d hops, %.1fs (rate %s)\n\n", s.Clients, s.Hops, s.Elapsed, rateString(s.Rate))
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "operation\tcount\terrors\tp50 ms\tp90 ms\tp99 ms\tmax ms\tmean ms\tper s\t")
+	for _, op := range s.Operations {
+		fmt.Fprintf(tw, "%s\t%d\t%d (%.1f%%)\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t\n", op.Operation, op.Count,
+			op.Errors, 100*op.ErrorRate, op.P50, op.P90, op.P99, op.Max, op.Mean, op.Throughput)
+	}
+	tw.Flush()
+	if len(s.Errors) > 0 {
+		fmt.Fprintln(w, "\nMost frequent errors:")
+		for _, e := range s.Errors {
+			fmt.Fprintf(w, "%6d  %s\n", e.Count, e.Error)
+		}
+	}
+}
+
+func (r *report) writeCSV(out io.Writer) error {
+	s := r.summary()
+	w := csv.NewWriter(out)
+	w.Write([]string{"operation", "count", "errors", "error_rate", "p50_ms", "p90_ms", "p99_ms", "max_ms", "mean_ms", "throughput_per_s"})
+	format := func(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+	for _, op := range s.Operations {
+		w.Write([]string{op.Operation, strconv.Itoa(op.Count), strconv.Itoa(op.Errors), format(op.ErrorRate),
+			format(op.P50), format(op.P90), format(op.P99), format(op.Max), format(op.Mean), format(op.Throughput)})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func (r *report) writeJSON(out io.Writer) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r.summary())
+}
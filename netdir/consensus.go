@@ -0,0 +1,235 @@
+package netdir
+
+import (
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	utils "onion_routing/utils"
+)
+
+// Relays carry role flags assigned by the directory from what they publish
+// and, for uptime, from what the authority saw of their heartbeats:
+//
+//   - Fast: bandwidth of at least 100 KB/s, or not among the slowest eighth
+//   - Stable: up at least as long as the median relay, or for five days
+//   - Guard: Fast and Stable with at least the median bandwidth (or 2 MB/s),
+//     and known at least as long as the median relay, or for eight days
+//   - Exit: opens streams and accepts port 80 or 443
+//
+// A relay's chance of being picked for a position is its bandwidth scaled by
+// the directory's weight for that position and the relay's class, so that
+// scarce guard and exit capacity is not used up on middle hops. This follows
+// Tor's bandwidth weights with a simpler balancing rule (computeWeights).
+const (
+	FlagGuard  = "Guard"
+	FlagExit   = "Exit"
+	FlagFast   = "Fast"
+	FlagStable = "Stable"
+)
+
+const (
+	fastMinBandwidth  = 100 * 1024
+	guardMinBandwidth = 2 * 1024 * 1024
+	stableMinUptime   = 5 * 24 * time.Hour
+	guardMinKnown     = 8 * 24 * time.Hour
+
+	// unmeasuredBandwidth stands in for relays that do not publish their
+	// bandwidth; it is the relays' default bandwidth_rate.
+	unmeasuredBandwidth = 1 << 20
+)
+
+// WeightScale is the unit of BandwidthWeights: a weight of WeightScale uses
+// all of a relay's bandwidth for the position.
+const WeightScale = 10000
+
+// BandwidthWeights are named like Tor's Wxy: the weight for position x (g
+// guard, m middle, e exit) of a relay in class y (g Guard only, m neither,
+// e Exit only, d Guard and Exit). Weights of a class add up to WeightScale
+// over the positions it can take.
+type BandwidthWeights struct {
+	Wgg int `json:"wgg"`
+	Wgd int `json:"wgd"`
+	Wmg int `json:"wmg"`
+	Wmm int `json:"wmm"`
+	Wme int `json:"wme"`
+	Wmd int `json:"wmd"`
+	Wee int `json:"wee"`
+	Wed int `json:"wed"`
+}
+
+// Consensus is the directory's view of the network: the relays with their
+// flags and the weights for choosing them.
+type Consensus struct {
+	Relays  []RelayNode      `json:"relays"`
+	Weights BandwidthWeights `json:"weights"`
+}
+
+// NewConsensus assigns flags to nodes and computes the bandwidth weights, as
+// a directory does before publishing them.
+func NewConsensus(nodes []RelayNode, now time.Time) *Consensus {
+	relays := append([]RelayNode{}, nodes...)
+	assignFlags(relays, now)
+	return &Consensus{Relays: relays, Weights: computeWeights(relays)}
+}
+
+func (n RelayNode) HasFlag(flag string) bool {
+	for _, f := range n.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// EffectiveBandwidth is the bandwidth the relay is weighted by: what it
+// publishes, or the relays' default bandwidth_rate if it publishes none.
+func (n RelayNode) EffectiveBandwidth() float64 {
+	if n.Bandwidth <= 0 {
+		return unmeasuredBandwidth
+	}
+	return float64(n.Bandwidth)
+}
+
+// ExitAccepts reports whether the relay's published exit policy may accept
+// destination. Relays check host names again once resolved.
+func (n RelayNode) ExitAccepts(destination string) bool {
+	if len(n.ExitPolicy) == 0 {
+		return true
+	}
+	policy, err := utils.ParseExitPolicy(n.ExitPolicy)
+	if err != nil {
+		return false
+	}
+	host, portStr, err := net.SplitHostPort(destination)
+	if err != nil {
+		return false
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return false
+	}
+	return policy.MayAllow(host, port)
+}
+
+func (n RelayNode) uptime(now time.Time) time.Duration {
+	return since(n.StartedAt, now)
+}
+
+func (n RelayNode) known(now time.Time) time.Duration {
+	return since(n.FirstSeen, now)
+}
+
+func since(t time.Time, now time.Time) time.Duration {
+	if t.IsZero() || t.After(now) {
+		return 0
+	}
+	return now.Sub(t)
+}
+
+func assignFlags(nodes []RelayNode, now time.Time) {
+	if len(nodes) == 0 {
+		return
+	}
+	bandwidths := make([]float64, len(nodes))
+	uptimes := make([]time.Duration, len(nodes))
+	knowns := make([]time.Duration, len(nodes))
+	for i, node := range nodes {
+		bandwidths[i] = node.EffectiveBandwidth()
+		uptimes[i] = node.uptime(now)
+		knowns[i] = node.known(now)
+	}
+	sort.Float64s(bandwidths)
+	sort.Slice(uptimes, func(i, j int) bool { return uptimes[i] < uptimes[j] })
+	sort.Slice(knowns, func(i, j int) bool { return knowns[i] < knowns[j] })
+	fastThreshold := math.Min(fastMinBandwidth, bandwidths[len(bandwidths)/8])
+	guardThreshold := math.Min(guardMinBandwidth, bandwidths[len(bandwidths)/2])
+	stableThreshold := min(stableMinUptime, uptimes[len(uptimes)/2])
+	knownThreshold := min(guardMinKnown, knowns[len(knowns)/2])
+
+	for i := range nodes {
+		node := &nodes[i]
+		node.Flags = nil
+		fast := node.EffectiveBandwidth() >= fastThreshold
+		stable := node.uptime(now) >= stableThreshold
+		if fast {
+			node.Flags = append(node.Flags, FlagFast)
+		}
+		if stable {
+			node.Flags = append(node.Flags, FlagStable)
+		}
+		if fast && stable && node.EffectiveBandwidth() >= guardThreshold && node.known(now) >= knownThreshold {
+			node.Flags = append(node.Flags, FlagGuard)
+		}
+		if node.AllowStreams && (node.ExitAccepts("example.com:80") || node.ExitAccepts("example.com:443")) {
+			node.Flags = append(node.Flags, FlagExit)
+		}
+	}
+}
+
+// computeWeights balances the positions so that each gets a third of the
+// total bandwidth T where the classes allow it. Guard-only relays (G) can
+// only be guards or middles and exit-only relays (E) only exits or middles,
+// so each of them first goes to its own position, up to T/3. Relays with both
+// flags (D) then make up what the guard and exit positions still lack, the
+// exit position first if D cannot cover both. Whatever is left is used for
+// middle hops.
+func computeWeights(nodes []RelayNode) BandwidthWeights {
+	var g, m, e, d float64
+	for _, node := range nodes {
+		guard, exit := node.HasFlag(FlagGuard), node.HasFlag(FlagExit)
+		switch {
+		case guard && exit:
+			d += node.EffectiveBandwidth()
+		case guard:
+			g += node.EffectiveBandwidth()
+		case exit:
+			e += node.EffectiveBandwidth()
+		default:
+			m += node.EffectiveBandwidth()
+		}
+	}
+	third := (g + m + e + d) / 3
+
+	share := func(need float64, have float64) float64 {
+		if have <= 0 {
+			return 0
+		}
+		return math.Min(1, need/have)
+	}
+	wgg := share(third, g)
+	wee := share(third, e)
+	guardNeed := math.Max(0, third-g)
+	exitNeed := math.Max(0, third-e)
+	wed := share(exitNeed, d)
+	wgd := share(guardNeed, d)
+	if wed+wgd > 1 {
+		wed = exitNeed / (exitNeed + guardNeed)
+		wgd = 1 - wed
+	}
+
+	scale := func(w float64) int { return int(math.Round(w * WeightScale)) }
+	weights := BandwidthWeights{
+		Wgg: scale(wgg),
+		Wgd: scale(wgd),
+		Wee: scale(wee),
+		Wed: scale(wed),
+		Wmm: WeightScale,
+	}
+	weights.Wmg = WeightScale - weights.Wgg
+	weights.Wme = WeightScale - weights.Wee
+	weights.Wmd = WeightScale - weights.Wgd - weights.Wed
+	return weights
+}
+
+// HasFlag reports whether any relay carries flag.
+func (c *Consensus) HasFlag(flag string) bool {
+	for _, node := range c.Relays {
+		if node.HasFlag(flag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+// Package netdir describes the network as clients, relays and directory
+// authorities see it: the relays' signed descriptors, the consensus documents
+// authorities sign, the flags and bandwidth weights assigned to relays, and
+// the directories a consensus is read from.
+package netdir
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	encryption "onion_routing/encryption"
+	utils "onion_routing/utils"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// RelayNode is a relay's directory record as published by the relay.
+type RelayNode struct {
+	Address       string         `json:"address"`
+	PubKey        *rsa.PublicKey `json:"pub_key"`
+	Load          int            `json:"load"`
+	PowDifficulty uint32         `json:"pow_difficulty"`
+	// MaxCircuitLength is the furthest position in a circuit the relay
+	// accepts (0 when the relay does not say).
+	MaxCircuitLength int `json:"max_circuit_length"`
+	// Family is shared by relays of one operator; ExitPolicy lists the
+	// relay's utils.ExitPolicy rules (empty accepts everything).
+	Family     string   `json:"family,omitempty"`
+	ExitPolicy []string `json:"exit_policy,omitempty"`
+	// AllowStreams, Bandwidth (bytes per second), StartedAt and FirstSeen
+	// are what the directory assigns Flags from. The last two are not taken
+	// from descriptors: an authority sets them from the relay's heartbeats,
+	// as when it last came up and when it was first seen.
+	AllowStreams bool      `json:"allow_streams"`
+	Bandwidth    int64     `json:"bandwidth"`
+	StartedAt    time.Time `json:"started_at"`
+	FirstSeen    time.Time `json:"first_seen"`
+	Flags        []string  `json:"flags,omitempty"`
+	// Published is when the relay signed its descriptor, and Identity the
+	// fingerprint of the key it signed it with.
+	Published time.Time `json:"published"`
+	Identity  string    `json:"identity,omitempty"`
+}
+
+// Directory is where clients and relays learn about relays, their flags and
+// the bandwidth weights.
+type Directory interface {
+	Consensus(ctx context.Context) (*Consensus, error)
+}
+
+// StaticDirectory is a fixed list of relays. Flags and weights are computed
+// from the list.
+type StaticDirectory []RelayNode
+
+func (d StaticDirectory) Consensus(ctx context.Context) (*Consensus, error) {
+	return NewConsensus(d, time.Now()), nil
+}
+
+// EtcdDirectory reads the relay records that relays register in etcd. Only
+// relays whose descriptor is fresh, signed by the relay and countersigned by
+// at least MinSignatures of the Authorities are listed. Anyone who can write
+// to etcd can register a relay, so without Authorities nothing is listed. The
+// connection is opened on first use and released by Close.
+type EtcdDirectory struct {
+	Endpoints   []string
+	KeyPrefix   string
+	DialTimeout time.Duration
+
+	Authorities   []ed25519.PublicKey
+	MinSignatures int
+	Logger        *utils.Logger
+
+	lock   sync.Mutex
+	client *clientv3.Client
+}
+
+// NewEtcdDirectory returns a directory using the etcd settings in utils.
+func NewEtcdDirectory() *EtcdDirectory {
+	return &EtcdDirectory{
+		Endpoints:   []string{utils.EtcdServerAddr},
+		KeyPrefix:   utils.EtcdKeyPrefix,
+		DialTimeout: utils.EtcdTimeOutInterval,
+	}
+}
+
+func (d *EtcdDirectory) etcdClient() (*clientv3.Client, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.client != nil {
+		return d.client, nil
+	}
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   d.Endpoints,
+		DialTimeout: d.DialTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	d.client = client
+	return client, nil
+}
+
+// Consensus fetches every relay record under the key prefix, keeps those
+// whose descriptor verifies and assigns flags and weights, standing in for a
+// directory authority.
+func (d *EtcdDirectory) Consensus(ctx context.Context) (*Consensus, error) {
+	if len(d.Authorities) == 0 {
+		return nil, utils.ErrNoAuthorities
+	}
+	client, err := d.etcdClient()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.DialTimeout)
+	defer cancel()
+	resp, err := client.Get(ctx, d.KeyPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	sigResp, err := client.Get(ctx, utils.CountersignaturePrefix(d.KeyPrefix), clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	countersignatures := make(map[string][]encryption.Countersignature)
+	for _, kv := range sigResp.Kvs {
+		var sig encryption.Countersignature
+		if err := json.Unmarshal(kv.Value, &sig); err != nil {
+			continue
+		}
+		node := path.Base(string(kv.Key))
+		countersignatures[node] = append(countersignatures[node], sig)
+	}
+	nodes := []RelayNode{}
+	now := time.Now()
+	for _, kv := range resp.Kvs {
+		name := strings.TrimPrefix(string(kv.Key), d.KeyPrefix)
+		node, err := d.verifyRecord(kv.Value, countersignatures[name], now)
+		if err != nil {
+			d.logf("Rejected relay record %s: %v", name, err)
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	return NewConsensus(nodes, now), nil
+}
+
+type relayRecord struct {
+	Descriptor    encryption.SignedDescriptor `json:"descriptor"`
+	Load          int                         `json:"load"`
+	PowDifficulty uint32                      `json:"pow_difficulty"`
+}
+
+// verifyRecord checks a relay record's descriptor and returns the relay it
+// describes.
+func (d *EtcdDirectory) verifyRecord(data []byte, sigs []encryption.Countersignature, now time.Time) (RelayNode, error) {
+	var record relayRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return RelayNode{}, err
+	}
+	node, err := describedRelay(record.Descriptor, record.Load, record.PowDifficulty, now)
+	if err != nil {
+		return RelayNode{}, err
+	}
+	if count := encryption.CountCountersignatures(record.Descriptor, sigs, d.Authorities); count < max(d.MinSignatures, 1) {
+		return RelayNode{}, fmt.Errorf("%w: %d of %d", encryption.ErrMissingCountersigns, count, max(d.MinSignatures, 1))
+	}
+	return node, nil
+}
+
+// describedRelay checks a relay's own signature of its descriptor and its
+// freshness, and returns the relay it describes with the unsigned load and
+// proof-of-work difficulty.
+func describedRelay(descriptor encryption.SignedDescriptor, load int, powDifficulty uint32, now time.Time) (RelayNode, error) {
+	if err := descriptor.Verify(); err != nil {
+		return RelayNode{}, err
+	}
+	var node RelayNode
+	if err := json.Unmarshal(descriptor.Body, &node); err != nil {
+		return RelayNode{}, err
+	}
+	if node.PubKey == nil || node.Address == "" {
+		return RelayNode{}, fmt.Errorf("descriptor lacks an address or onion key")
+	}
+	if err := encryption.CheckFreshness(node.Published, now); err != nil {
+		return RelayNode{}, err
+	}
+	// a relay does not vouch for its own uptime
+	node.StartedAt, node.FirstSeen = time.Time{}, time.Time{}
+	node.Flags = nil
+	node.Identity = descriptor.Identity()
+	node.Load = load
+	node.PowDifficulty = powDifficulty
+	return node, nil
+}
+
+func (d *EtcdDirectory) logf(format string, a ...any) {
+	if d.Logger != nil {
+		d.Logger.PrintLog(format, a...)
+	}
+}
+
+func (d *EtcdDirectory) Close() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.client == nil {
+		return nil
+	}
+	err := d.client.Close()
+	d.client = nil
+	return err
+}
@@ -0,0 +1,20 @@
+package netdir
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	utils "onion_routing/utils"
+)
+
+func TestDirectoriesRequireAuthorities(t *testing.T) {
+	for name, directory := range map[string]Directory{
+		"etcd": &EtcdDirectory{Endpoints: []string{"localhost:1"}, KeyPrefix: "/relays/"},
+		"grpc": &GRPCDirectory{Addrs: []string{"localhost:1"}},
+	} {
+		if _, err := directory.Consensus(context.Background()); !errors.Is(err, utils.ErrNoAuthorities) {
+			t.Errorf("%s directory without authorities: Consensus = %v, want %v", name, err, utils.ErrNoAuthorities)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package netdir
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	encryption "onion_routing/encryption"
+	routingpb "onion_routing/protofiles"
+	utils "onion_routing/utils"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// ConsensusDocument is what a directory server signs and serves: the relays
+// that are up, with their signed descriptors and flags, and the bandwidth
+// weights. Clients check each descriptor themselves, so a directory server
+// can leave relays out but cannot alter them.
+type ConsensusDocument struct {
+	Authority  string           `json:"authority"` // fingerprint of the signing key
+	Published  time.Time        `json:"published"`
+	ValidUntil time.Time        `json:"valid_until"`
+	Relays     []ConsensusEntry `json:"relays"`
+	Weights    BandwidthWeights `json:"weights"`
+}
+
+type ConsensusEntry struct {
+	Node          string                      `json:"node"`
+	Descriptor    encryption.SignedDescriptor `json:"descriptor"`
+	Load          int                         `json:"load"`
+	PowDifficulty uint32                      `json:"pow_difficulty"`
+	// UpSince and FirstSeen are the authority's own record of the relay's
+	// heartbeats (zero when it has none).
+	UpSince   time.Time `json:"up_since"`
+	FirstSeen time.Time `json:"first_seen"`
+	Flags     []string  `json:"flags,omitempty"`
+}
+
+// NewConsensusDocument assigns flags to the relays of entries, with the
+// uptimes the entries carry, and computes the bandwidth weights. Entries whose descriptor does not verify or is
+// stale are left out. The document is valid for lifetime.
+func NewConsensusDocument(entries []ConsensusEntry, now time.Time, lifetime time.Duration) ConsensusDocument {
+	nodes := []RelayNode{}
+	byAddress := make(map[string]ConsensusEntry)
+	for _, entry := range entries {
+		node, err := describedRelay(entry.Descriptor, entry.Load, entry.PowDifficulty, now)
+		if err != nil {
+			continue
+		}
+		node.StartedAt, node.FirstSeen = entry.UpSince, entry.FirstSeen
+		nodes = append(nodes, node)
+		byAddress[node.Address] = entry
+	}
+	consensus := NewConsensus(nodes, now)
+	doc := ConsensusDocument{Published: now, ValidUntil: now.Add(lifetime), Relays: []ConsensusEntry{}, Weights: consensus.Weights}
+	for _, node := range consensus.Relays {
+		entry := byAddress[node.Address]
+		entry.Flags = node.Flags
+		doc.Relays = append(doc.Relays, entry)
+	}
+	return doc
+}
+
+// GRPCDirectory fetches the consensus from directory servers. Only documents
+// signed by the Authorities count, whatever key a server claims to sign with,
+// and a relay is used when MinSignatures of them list its descriptor. Without
+// Authorities no document is trusted. Connections are opened on first use and
+// released by Close.
+type GRPCDirectory struct {
+	Addrs       []string
+	Credentials credentials.TransportCredentials
+	Timeout     time.Duration // per server (default 10s)
+
+	Authorities   []ed25519.PublicKey
+	MinSignatures int
+	Logger        *utils.Logger
+
+	lock  sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+func (d *GRPCDirectory) Consensus(ctx context.Context) (*Consensus, error) {
+	if len(d.Authorities) == 0 {
+		return nil, utils.ErrNoAuthorities
+	}
+	needed := max(d.MinSignatures, 1)
+	now := time.Now()
+	docs := make(map[string]ConsensusDocument) // by authority
+	var lastErr error
+	for _, addr := range d.Addrs {
+		doc, err := d.fetch(ctx, addr, now)
+		if err != nil {
+			d.logf("Fetching the consensus from %s failed: %v", addr, err)
+			lastErr = err
+			continue
+		}
+		docs[doc.Authority] = doc
+		if needed == 1 {
+			return d.documentConsensus(doc, now), nil
+		}
+	}
+	if len(docs) < needed {
+		if lastErr == nil {
+			lastErr = encryption.ErrMissingCountersigns
+		}
+		return nil, fmt.Errorf("%d of %d consensus documents: %w", len(docs), needed, lastErr)
+	}
+	return d.combineDocuments(docs, needed, now), nil
+}
+
+// combineDocuments returns the relays whose descriptor is listed by needed
+// distinct authorities. A relay keeps the flags most of the documents listing
+// it give it, and its load and proof-of-work difficulty and the bandwidth
+// weights are the medians of the documents' values, so no single authority
+// decides them.
+func (d *GRPCDirectory) combineDocuments(docs map[string]ConsensusDocument, needed int, now time.Time) *Consensus {
+	listedBy := make(map[string]map[string]bool) // digest to authorities
+	entries := make(map[string][]ConsensusEntry)
+	var authorities, digests []string
+	for authority := range docs {
+		authorities = append(authorities, authority)
+	}
+	sort.Strings(authorities)
+	var weights []BandwidthWeights
+	for _, authority := range authorities {
+		doc := docs[authority]
+		weights = append(weights, doc.Weights)
+		for _, entry := range doc.Relays {
+			digest := string(entry.Descriptor.Digest())
+			if listedBy[digest] == nil {
+				listedBy[digest] = make(map[string]bool)
+				digests = append(digests, digest)
+			}
+			if listedBy[digest][authority] {
+				continue
+			}
+			listedBy[digest][authority] = true
+			entries[digest] = append(entries[digest], entry)
+		}
+	}
+	sort.Strings(digests)
+
+	consensus := &Consensus{Relays: []RelayNode{}, Weights: medianWeights(weights)}
+	for _, digest := range digests {
+		listing := entries[digest]
+		if len(listing) < needed {
+			continue
+		}
+		loads := make([]int, len(listing))
+		pows := make([]int, len(listing))
+		flagVotes := make(map[string]int)
+		var flags []string
+		for i, entry := range listing {
+			loads[i] = entry.Load
+			pows[i] = int(entry.PowDifficulty)
+			for _, flag := range entry.Flags {
+				if flagVotes[flag] == 0 {
+					flags = append(flags, flag)
+				}
+				flagVotes[flag]++
+			}
+		}
+		node, err := describedRelay(listing[0].Descriptor, median(loads), uint32(median(pows)), now)
+		if err != nil {
+			d.logf("Rejected relay %s: %v", listing[0].Node, err)
+			continue
+		}
+		for _, flag := range flags {
+			if 2*flagVotes[flag] > len(listing) {
+				node.Flags = append(node.Flags, flag)
+			}
+		}
+		consensus.Relays = append(consensus.Relays, node)
+	}
+	return consensus
+}
+
+func medianWeights(weights []BandwidthWeights) BandwidthWeights {
+	field := func(get func(BandwidthWeights) int) int {
+		values := make([]int, len(weights))
+		for i, w := range weights {
+			values[i] = get(w)
+		}
+		return median(values)
+	}
+	return BandwidthWeights{
+		Wgg: field(func(w BandwidthWeights) int { return w.Wgg }),
+		Wgd: field(func(w BandwidthWeights) int { return w.Wgd }),
+		Wmg: field(func(w BandwidthWeights) int { return w.Wmg }),
+		Wmm: field(func(w BandwidthWeights) int { return w.Wmm }),
+		Wme: field(func(w BandwidthWeights) int { return w.Wme }),
+		Wmd: field(func(w BandwidthWeights) int { return w.Wmd }),
+		Wee: field(func(w BandwidthWeights) int { return w.Wee }),
+		Wed: field(func(w BandwidthWeights) int { return w.Wed }),
+	}
+}
+
+// median returns the middle value, the higher one of an even count.
+func median(values []int) int {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Ints(values)
+	return values[len(values)/2]
+}
+
+// fetch gets a directory server's consensus document and checks its
+// signature and validity.
+func (d *GRPCDirectory) fetch(ctx context.Context, addr string, now time.Time) (ConsensusDocument, error) {
+	conn, err := d.conn(addr)
+	if err != nil {
+		return ConsensusDocument{}, err
+	}
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	resp, err := routingpb.NewDirectoryClient(conn).GetConsensus(ctx, &routingpb.GetConsensusRequest{})
+	if err != nil {
+		return ConsensusDocument{}, err
+	}
+	authority := ed25519.PublicKey(resp.AuthorityKey)
+	if !trustedAuthority(d.Authorities, authority) {
+		return ConsensusDocument{}, fmt.Errorf("%w: signed by unknown authority %s", encryption.ErrBadConsensus, encryption.Fingerprint(authority))
+	}
+	if err := encryption.VerifyConsensus(resp.Body, authority, resp.Signature); err != nil {
+		return ConsensusDocument{}, err
+	}
+	var doc ConsensusDocument
+	if err := json.Unmarshal(resp.Body, &doc); err != nil {
+		return ConsensusDocument{}, err
+	}
+	if doc.Authority != encryption.Fingerprint(authority) {
+		return ConsensusDocument{}, fmt.Errorf("%w: names authority %s", encryption.ErrBadConsensus, doc.Authority)
+	}
+	if doc.Published.After(now.Add(encryption.DescriptorClockSkew)) || now.After(doc.ValidUntil) {
+		return ConsensusDocument{}, encryption.ErrStaleConsensus
+	}
+	return doc, nil
+}
+
+func trustedAuthority(authorities []ed25519.PublicKey, key ed25519.PublicKey) bool {
+	for _, authority := range authorities {
+		if authority.Equal(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// documentConsensus returns the relays of a document whose descriptors
+// verify, with the flags and weights the authority assigned.
+func (d *GRPCDirectory) documentConsensus(doc ConsensusDocument, now time.Time) *Consensus {
+	consensus := &Consensus{Relays: []RelayNode{}, Weights: doc.Weights}
+	for _, entry := range doc.Relays {
+		node, err := describedRelay(entry.Descriptor, entry.Load, entry.PowDifficulty, now)
+		if err != nil {
+			d.logf("Rejected relay %s: %v", entry.Node, err)
+			continue
+		}
+		node.Flags = entry.Flags
+		consensus.Relays = append(consensus.Relays, node)
+	}
+	return consensus
+}
+
+func (d *GRPCDirectory) conn(addr string) (*grpc.ClientConn, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if conn := d.conns[addr]; conn != nil {
+		return conn, nil
+	}
+	if d.Credentials == nil {
+		return nil, utils.ErrNoCredentials
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(d.Credentials))
+	if err != nil {
+		return nil, err
+	}
+	if d.conns == nil {
+		d.conns = make(map[string]*grpc.ClientConn)
+	}
+	d.conns[addr] = conn
+	return conn, nil
+}
+
+func (d *GRPCDirectory) logf(format string, a ...any) {
+	if d.Logger != nil {
+		d.Logger.PrintLog(format, a...)
+	}
+}
+
+func (d *GRPCDirectory) Close() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for addr, conn := range d.conns {
+		conn.Close()
+		delete(d.conns, addr)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package netdir
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	encryption "onion_routing/encryption"
+)
+
+func testEntry(t *testing.T, address string, onionKey *rsa.PrivateKey, now time.Time) ConsensusEntry {
+	t.Helper()
+	_, identity, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(RelayNode{Address: address, PubKey: &onionKey.PublicKey, Published: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ConsensusEntry{Node: address, Descriptor: encryption.SignDescriptor(body, identity)}
+}
+
+func TestCombineDocuments(t *testing.T) {
+	now := time.Now()
+	onionKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := testEntry(t, "10.1.0.1:9001", onionKey, now)
+	b := testEntry(t, "10.2.0.1:9001", onionKey, now)
+	c := testEntry(t, "10.3.0.1:9001", onionKey, now)
+	with := func(entry ConsensusEntry, load int, flags ...string) ConsensusEntry {
+		entry.Load = load
+		entry.Flags = flags
+		return entry
+	}
+	weights := func(wgg int) BandwidthWeights { return BandwidthWeights{Wgg: wgg, Wmm: WeightScale} }
+	docs := map[string]ConsensusDocument{
+		// one authority listing b twice must not count as two
+		"auth1": {Relays: []ConsensusEntry{with(a, 10, FlagGuard, FlagFast), with(b, 1, FlagExit), with(b, 1, FlagExit)}, Weights: weights(9000)},
+		"auth2": {Relays: []ConsensusEntry{with(a, 20, FlagGuard), with(c, 1)}, Weights: weights(1000)},
+		"auth3": {Relays: []ConsensusEntry{with(a, 90, FlagFast), with(c, 1)}, Weights: weights(5000)},
+	}
+
+	consensus := (&GRPCDirectory{}).combineDocuments(docs, 2, now)
+	got := make(map[string]RelayNode)
+	for _, node := range consensus.Relays {
+		got[node.Address] = node
+	}
+	if _, listed := got[b.Node]; listed {
+		t.Errorf("relay listed twice by one authority was used")
+	}
+	if _, listed := got[c.Node]; !listed {
+		t.Errorf("relay listed by two authorities was left out")
+	}
+	relay, listed := got[a.Node]
+	if !listed {
+		t.Fatalf("relay listed by every authority was left out")
+	}
+	if want := []string{FlagGuard, FlagFast}; !reflect.DeepEqual(relay.Flags, want) {
+		t.Errorf("flags = %v, want those most documents give: %v", relay.Flags, want)
+	}
+	if relay.Load != 20 {
+		t.Errorf("load = %d, want the median 20", relay.Load)
+	}
+	if consensus.Weights.Wgg != 5000 || consensus.Weights.Wmm != WeightScale {
+		t.Errorf("weights = %+v, want the median of the documents'", consensus.Weights)
+	}
+}
+
+func TestConsensusDocumentUptimes(t *testing.T) {
+	now := time.Now()
+	onionKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, identity, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a new relay claiming in its descriptor to have been up for a month
+	body, err := json.Marshal(RelayNode{Address: "10.9.0.1:9001", PubKey: &onionKey.PublicKey, Published: now, StartedAt: now.Add(-30 * 24 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	liar := ConsensusEntry{Node: "liar", Descriptor: encryption.SignDescriptor(body, identity), UpSince: now.Add(-time.Minute), FirstSeen: now.Add(-time.Minute)}
+	// a relay the authority has known for weeks that just came back
+	restarted := testEntry(t, "10.8.0.1:9001", onionKey, now)
+	restarted.UpSince, restarted.FirstSeen = now.Add(-time.Hour), now.Add(-20*24*time.Hour)
+	entries := []ConsensusEntry{liar, restarted}
+	for _, address := range []string{"10.1.0.1:9001", "10.2.0.1:9001", "10.3.0.1:9001"} {
+		entry := testEntry(t, address, onionKey, now)
+		entry.UpSince, entry.FirstSeen = now.Add(-10*24*time.Hour), now.Add(-20*24*time.Hour)
+		entries = append(entries, entry)
+	}
+
+	flags := make(map[string][]string)
+	for _, entry := range NewConsensusDocument(entries, now, time.Hour).Relays {
+		flags[entry.Node] = entry.Flags
+	}
+	for node, want := range map[string][]string{
+		"liar":          {FlagFast},
+		"10.8.0.1:9001": {FlagFast},
+		"10.1.0.1:9001": {FlagFast, FlagStable, FlagGuard},
+	} {
+		if !reflect.DeepEqual(flags[node], want) {
+			t.Errorf("%s has flags %v, want %v", node, flags[node], want)
+		}
+	}
+}
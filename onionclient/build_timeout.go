@@ -0,0 +1,215 @@
+package onionclient
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Circuit builds that take much longer than usual are abandoned and retried
+// with other relays, as in Tor. The client records how long builds take and
+// fits a Pareto distribution to the history; builds running past its
+// Quantile (the 80th percentile by default) are given up. Builds that were
+// abandoned still count, as builds that took at least the cutoff. Until
+// MinSamples builds have completed the Fallback deadline applies. The cutoff
+// never drops below Minimum (Tor's cbtmintimeout), so a run of quick builds
+// does not leave later ones no time at all.
+
+// BuildTimeoutOptions configures the adaptive build timeout. Zero values
+// select the defaults.
+type BuildTimeoutOptions struct {
+	StateFile  string        // where the build history is kept; in memory only when empty
+	Fallback   time.Duration // deadline while the history is sparse (default 10s)
+	MinSamples int           // completed builds needed before fitting (default 20)
+	Quantile   float64       // share of builds expected to finish before the cutoff (default 0.8)
+	Minimum    time.Duration // lowest cutoff the fit may give (default 1s)
+	MaxHistory int           // builds remembered (default 1000)
+}
+
+func (o BuildTimeoutOptions) withDefaults() BuildTimeoutOptions {
+	if o.Fallback <= 0 {
+		o.Fallback = 10 * time.Second
+	}
+	if o.MinSamples <= 0 {
+		o.MinSamples = 20
+	}
+	if o.Quantile <= 0 || o.Quantile >= 1 {
+		o.Quantile = 0.8
+	}
+	if o.MaxHistory <= 0 {
+		o.MaxHistory = 1000
+	}
+	if o.Minimum <= 0 {
+		o.Minimum = time.Second
+	}
+	return o
+}
+
+const (
+	// buildTimeBin is the histogram bin width used to find the mode of the
+	// build times, which becomes the Pareto scale parameter.
+	buildTimeBin = 10 * time.Millisecond
+	// recentBuilds and recentTimeoutLimit detect a changed network: when
+	// most recent builds time out the history no longer applies.
+	recentBuilds       = 20
+	recentTimeoutLimit = 16
+	// saveEvery builds the history is written out; Close writes the rest.
+	saveEvery = 10
+)
+
+type buildRecord struct {
+	Millis   int64 `json:"ms"`
+	TimedOut bool  `json:"timed_out,omitempty"`
+}
+
+type buildHistory struct {
+	Builds []buildRecord `json:"builds"`
+}
+
+type buildTimer struct {
+	opts BuildTimeoutOptions
+
+	lock    sync.Mutex
+	builds  []buildRecord
+	timeout time.Duration
+	unsaved int
+}
+
+// WithBuildTimeout sets how circuit builds are timed out. Without it the
+// defaults apply and the history is not kept across restarts.
+func WithBuildTimeout(opts BuildTimeoutOptions) Option {
+	return func(c *Client) { c.buildTimeoutOpts = opts }
+}
+
+func loadBuildTimer(opts BuildTimeoutOptions) (*buildTimer, error) {
+	t := &buildTimer{opts: opts.withDefaults()}
+	t.timeout = t.opts.Fallback
+	if t.opts.StateFile == "" {
+		return t, nil
+	}
+	data, err := os.ReadFile(t.opts.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var history buildHistory
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, err
+	}
+	t.builds = history.Builds
+	if len(t.builds) > t.opts.MaxHistory {
+		t.builds = t.builds[len(t.builds)-t.opts.MaxHistory:]
+	}
+	t.timeout = t.fitLocked()
+	return t, nil
+}
+
+// BuildTimeout returns the current cutoff for circuit builds.
+func (c *Client) BuildTimeout() time.Duration {
+	c.buildTimer.lock.Lock()
+	defer c.buildTimer.lock.Unlock()
+	return c.buildTimer.timeout
+}
+
+// record adds a build to the history and refits the cutoff.
+func (t *buildTimer) record(d time.Duration, timedOut bool) (time.Duration, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.builds = append(t.builds, buildRecord{Millis: d.Milliseconds(), TimedOut: timedOut})
+	if len(t.builds) > t.opts.MaxHistory {
+		t.builds = t.builds[len(t.builds)-t.opts.MaxHistory:]
+	}
+
+	reset := false
+	if timedOut && len(t.builds) >= recentBuilds {
+		timeouts := 0
+		for _, build := range t.builds[len(t.builds)-recentBuilds:] {
+			if build.TimedOut {
+				timeouts++
+			}
+		}
+		if timeouts >= recentTimeoutLimit {
+			t.builds = nil
+			reset = true
+		}
+	}
+	t.timeout = t.fitLocked()
+
+	t.unsaved++
+	if t.unsaved >= saveEvery || reset {
+		t.saveLocked()
+	}
+	return t.timeout, reset
+}
+
+// fitLocked estimates the Pareto distribution of the build times and returns
+// its Quantile, at least Minimum, or the fallback while there are too few
+// completed builds.
+// The scale Xm is the mode of the build times; the shape is the maximum
+// likelihood estimate with abandoned builds treated as censored:
+//
+//	alpha = completed / (sum over all builds of ln(max(x, Xm) / Xm))
+func (t *buildTimer) fitLocked() time.Duration {
+	completed := 0
+	bins := map[int64]int{}
+	for _, build := range t.builds {
+		if !build.TimedOut {
+			completed++
+			bins[build.Millis/buildTimeBin.Milliseconds()]++
+		}
+	}
+	if completed < t.opts.MinSamples {
+		return t.opts.Fallback
+	}
+
+	var modeBin int64
+	modeCount := 0
+	for bin, count := range bins {
+		if count > modeCount || (count == modeCount && bin < modeBin) {
+			modeBin, modeCount = bin, count
+		}
+	}
+	xm := float64(modeBin*buildTimeBin.Milliseconds()) + float64(buildTimeBin.Milliseconds())/2
+
+	sum := 0.0
+	for _, build := range t.builds {
+		sum += math.Log(math.Max(float64(build.Millis), xm) / xm)
+	}
+	if sum <= 0 {
+		return t.opts.Fallback
+	}
+	alpha := float64(completed) / sum
+	cutoff := xm / math.Pow(1-t.opts.Quantile, 1/alpha)
+	return max(time.Duration(cutoff*float64(time.Millisecond)), t.opts.Minimum)
+}
+
+func (t *buildTimer) saveLocked() error {
+	if t.opts.StateFile == "" {
+		return nil
+	}
+	t.unsaved = 0
+	data, err := json.Marshal(buildHistory{Builds: t.builds})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(t.opts.StateFile), 0700); err != nil {
+		return err
+	}
+	tmp := t.opts.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.opts.StateFile)
+}
+
+func (t *buildTimer) save() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.saveLocked()
+}
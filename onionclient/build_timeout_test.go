@@ -0,0 +1,119 @@
+package onionclient
+
+import (
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// paretoBuilds returns n build times spread over the quantiles of a Pareto
+// distribution with scale xm and shape alpha.
+func paretoBuilds(n int, xm time.Duration, alpha float64) []buildRecord {
+	builds := make([]buildRecord, n)
+	for i := range builds {
+		q := (float64(i) + 0.5) / float64(n)
+		builds[i] = buildRecord{Millis: int64(float64(xm.Milliseconds()) / math.Pow(1-q, 1/alpha))}
+	}
+	return builds
+}
+
+func timedOutBuilds(n int, at time.Duration) []buildRecord {
+	builds := make([]buildRecord, n)
+	for i := range builds {
+		builds[i] = buildRecord{Millis: at.Milliseconds(), TimedOut: true}
+	}
+	return builds
+}
+
+func TestBuildTimeoutFit(t *testing.T) {
+	opts := BuildTimeoutOptions{Fallback: 10 * time.Second, MinSamples: 20, Quantile: 0.8, Minimum: 10 * time.Millisecond}
+	// the 80th percentile of a Pareto distribution with xm 200ms and alpha 2
+	pareto := time.Duration(200 / math.Sqrt(0.2) * float64(time.Millisecond))
+	tests := []struct {
+		name     string
+		opts     BuildTimeoutOptions
+		builds   []buildRecord
+		min, max time.Duration
+	}{
+		{"no history", opts, nil, opts.Fallback, opts.Fallback},
+		{"too few completed builds", opts, paretoBuilds(19, 200*time.Millisecond, 2), opts.Fallback, opts.Fallback},
+		{"timeouts do not count as samples", opts, append(paretoBuilds(19, 200*time.Millisecond, 2), timedOutBuilds(5, time.Second)...), opts.Fallback, opts.Fallback},
+		{"pareto quantile", opts, paretoBuilds(500, 200*time.Millisecond, 2), pareto * 9 / 10, pareto * 11 / 10},
+		// abandoned builds are censored at the cutoff, which stretches the tail
+		{"censored builds raise the cutoff", opts, append(paretoBuilds(400, 200*time.Millisecond, 2), timedOutBuilds(100, pareto)...), pareto * 11 / 10, 10 * pareto},
+		{"clamped to the minimum", BuildTimeoutOptions{Fallback: 10 * time.Second, MinSamples: 20, Quantile: 0.8, Minimum: time.Second},
+			paretoBuilds(500, 20*time.Millisecond, 2), time.Second, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timer := &buildTimer{opts: tt.opts.withDefaults(), builds: tt.builds}
+			if got := timer.fitLocked(); got < tt.min || got > tt.max {
+				t.Errorf("cutoff %v, want between %v and %v", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestBuildTimeoutReset(t *testing.T) {
+	timer, err := loadBuildTimer(BuildTimeoutOptions{Minimum: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, build := range paretoBuilds(100, 200*time.Millisecond, 2) {
+		timer.record(time.Duration(build.Millis)*time.Millisecond, false)
+	}
+	fitted := timer.timeout
+	if fitted == timer.opts.Fallback {
+		t.Fatal("no cutoff fitted from 100 builds")
+	}
+	for i := 1; i < recentTimeoutLimit; i++ {
+		if _, reset := timer.record(fitted, true); reset {
+			t.Fatalf("history reset after %d timeouts, want %d", i, recentTimeoutLimit)
+		}
+	}
+	timeout, reset := timer.record(fitted, true)
+	if !reset {
+		t.Fatalf("no reset after %d of the last %d builds timed out", recentTimeoutLimit, recentBuilds)
+	}
+	if timeout != timer.opts.Fallback || len(timer.builds) != 0 {
+		t.Errorf("after the reset: cutoff %v with %d builds, want the fallback %v and none", timeout, len(timer.builds), timer.opts.Fallback)
+	}
+}
+
+func TestBuildTimeoutPersists(t *testing.T) {
+	opts := BuildTimeoutOptions{StateFile: filepath.Join(t.TempDir(), "build_times.json"), Minimum: 10 * time.Millisecond}
+	timer, err := loadBuildTimer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, build := range paretoBuilds(50, 200*time.Millisecond, 2) {
+		timer.record(time.Duration(build.Millis)*time.Millisecond, false)
+	}
+	timer.record(3*time.Second, true)
+	if err := timer.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadBuildTimer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.builds, timer.builds) {
+		t.Errorf("loaded %d builds, want the %d saved", len(loaded.builds), len(timer.builds))
+	}
+	if loaded.timeout != timer.timeout {
+		t.Errorf("cutoff after loading %v, want %v", loaded.timeout, timer.timeout)
+	}
+
+	// a shorter history keeps the latest builds
+	opts.MaxHistory = 10
+	trimmed, err := loadBuildTimer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(trimmed.builds, timer.builds[len(timer.builds)-10:]) {
+		t.Errorf("history of %d builds loaded with max_history 10, want the last 10", len(trimmed.builds))
+	}
+}
@@ -0,0 +1,520 @@
+package onionclient
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	encryption "onion_routing/encryption"
+	netdir "onion_routing/netdir"
+	routingpb "onion_routing/protofiles"
+	utils "onion_routing/utils"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Circuit is an established path through the relays. Every relay shares a
+// key seed with the client: the forward payload is encrypted with key1 of
+// each hop and the reply comes back wrapped in key2 of each hop.
+type Circuit struct {
+	client   *Client
+	id       uint16
+	path     []netdir.RelayNode
+	keySeeds [][16]byte
+	conn     *grpc.ClientConn
+	stub     routingpb.RelayNodeServerClient
+
+	closeOnce sync.Once
+	closed    atomic.Bool
+
+	// streams holds the stream IDs of the requests and TCP streams open on
+	// the circuit; replies are matched to them by ID.
+	streamsLock  sync.Mutex
+	streams      map[uint16]bool
+	nextStreamID uint16
+
+	// idleTimeout is the shortest idle timeout granted by the hops; every
+	// cell that reaches the exit keeps the circuit alive that much longer.
+	idleTimeout time.Duration
+	lifeLock    sync.Mutex
+	expiresAt   time.Time
+
+	// flow holds the SENDME windows shared with the exit, set up with the
+	// first relay cell once the circuit is built.
+	flowOnce sync.Once
+	flow     *encryption.CircuitFlow
+}
+
+// maxExtendAttempts bounds how many relays are tried for one position of a
+// circuit before BuildCircuit gives up.
+const maxExtendAttempts = 3
+
+// HopError reports which hop of a circuit failed.
+type HopError struct {
+	Hop   int // 1 for the first relay
+	Relay string
+	Err   error
+}
+
+func (e *HopError) Error() string {
+	if e.Relay == "" {
+		return fmt.Sprintf("hop %d: %v", e.Hop, e.Err)
+	}
+	return fmt.Sprintf("hop %d (%s): %v", e.Hop, e.Relay, e.Err)
+}
+
+func (e *HopError) Unwrap() error {
+	return e.Err
+}
+
+// BuildCircuit builds a circuit to the server that Do requests go to. See
+// BuildCircuitTo.
+func (c *Client) BuildCircuit(ctx context.Context) (*Circuit, error) {
+	return c.BuildCircuitTo(ctx, c.serverAddr)
+}
+
+// BuildCircuitTo builds a circuit whose exit accepts destination, one hop at a
+// time. The first relay is an entry guard if the client has them; every relay
+// is picked from the directory under the path constraints, weighted by
+// bandwidth for its position. When a relay cannot be reached, only that
+// extension is retried, with a different relay. Builds running past the
+// adaptive build timeout are abandoned with ErrBuildTimeout.
+func (c *Client) BuildCircuitTo(ctx context.Context, destination string) (*Circuit, error) {
+	consensus, err := c.directory.Consensus(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetching relays: %w", err)
+	}
+	if len(consensus.Relays) < c.pathLength {
+		return nil, fmt.Errorf("%w: need %d, directory lists %d", utils.ErrNotEnoughRelays, c.pathLength, len(consensus.Relays))
+	}
+
+	timeout := c.BuildTimeout()
+	buildCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	circuit, err := c.buildCircuit(buildCtx, consensus, destination)
+	if err == nil {
+		c.buildTimer.record(time.Since(start), false)
+		return circuit, nil
+	}
+	if ctx.Err() != nil || !errors.Is(buildCtx.Err(), context.DeadlineExceeded) {
+		return nil, err
+	}
+	next, reset := c.buildTimer.record(timeout, true)
+	if reset {
+		c.logf("Most recent circuit builds timed out; build time history cleared")
+	}
+	err = fmt.Errorf("%w after %v (next cutoff %v): %v", utils.ErrBuildTimeout, timeout, next, err)
+	c.emit(Event{Type: EventBuildAbandoned, Err: err})
+	return nil, err
+}
+
+func (c *Client) buildCircuit(ctx context.Context, consensus *netdir.Consensus, destination string) (*Circuit, error) {
+	needGuard := consensus.HasFlag(netdir.FlagGuard)
+
+	circuit := c.newCircuit()
+	tried := c.badRelaySet()
+	for hop := 1; hop <= c.pathLength; hop++ {
+		var err error
+		for attempt := 0; attempt < maxExtendAttempts; attempt++ {
+			r := hopRequest{hop: hop, exit: hop == c.pathLength, destination: destination, path: circuit.path, exclude: tried, needGuard: needGuard}
+			var node netdir.RelayNode
+			if hop == 1 && c.guards != nil {
+				node, err = c.pickGuard(consensus, r)
+			} else {
+				node, err = pickRelay(consensus, r)
+			}
+			if err != nil {
+				err = &HopError{Hop: hop, Err: err}
+				break
+			}
+			tried[node.Address] = true
+			var retryable bool
+			retryable, err = circuit.extend(ctx, node)
+			if err == nil && hop == 1 {
+				c.guardResult(node, true)
+			}
+			if err == nil || !retryable || ctx.Err() != nil {
+				break
+			}
+			c.markBad(circuit.id, hop, node, err)
+			if attempt+1 < maxExtendAttempts {
+				c.emit(Event{Type: EventExtendRetried, CircuitID: circuit.id, Hop: hop, Relay: node.Address, Attempt: attempt + 1, Err: err})
+			}
+		}
+		if err != nil {
+			circuit.Close()
+			return nil, err
+		}
+	}
+	return circuit, nil
+}
+
+// BuildCircuitThrough builds a circuit through the given relays, first hop
+// first. The path must satisfy the path constraints, with the exit accepting
+// the server.
+func (c *Client) BuildCircuitThrough(ctx context.Context, path []netdir.RelayNode) (*Circuit, error) {
+	if len(path) == 0 {
+		return nil, utils.ErrNotEnoughRelays
+	}
+	for i, node := range path {
+		if node.MaxCircuitLength > 0 && i+1 > node.MaxCircuitLength {
+			return nil, fmt.Errorf("%w: %s accepts at most %d hops, it would be hop %d", utils.ErrCircuitTooLong, node.Address, node.MaxCircuitLength, i+1)
+		}
+	}
+	if err := checkPath(path, c.serverAddr); err != nil {
+		return nil, err
+	}
+	circuit := c.newCircuit()
+	for _, node := range path {
+		if _, err := circuit.extend(ctx, node); err != nil {
+			circuit.Close()
+			return nil, err
+		}
+	}
+	return circuit, nil
+}
+
+func (c *Client) newCircuit() *Circuit {
+	id := c.nextCircuitID.Add(1) - 1
+	if c.circuitIDs.count > 0 {
+		first := uint32(c.circuitIDs.first)
+		id = first + (id-first)%uint32(c.circuitIDs.count)
+	}
+	return &Circuit{
+		client: c,
+		id:     uint16(id),
+	}
+}
+
+// extend adds node as the circuit's new last hop: a CREATE for the first hop,
+// an EXTEND through the circuit so far for the others. The new relay answers
+// with a confirmation of the key seed. The error is retryable when the
+// circuit so far is unaffected and another relay can be tried instead.
+func (ci *Circuit) extend(ctx context.Context, node netdir.RelayNode) (bool, error) {
+	hop := len(ci.path) + 1
+	hopError := func(err error) error {
+		return &HopError{Hop: hop, Relay: node.Address, Err: err}
+	}
+	var keySeed [16]byte
+	rand.Read(keySeed[:])
+
+	// The new hop starts out as the exit; a later EXTEND moves it to the
+	// middle.
+	create, err := buildLayer(encryption.CREATE_CELL, ci.client.serverAddr, ci.id, keySeed, node.PubKey, nil, true, encryption.GREET_REQUEST, 0, ci.client.idleTimeout)
+	if err != nil {
+		return false, hopError(err)
+	}
+	powNonce := ci.client.solveCreatePow(create, node)
+	start := time.Now()
+
+	var confirmation []byte
+	if hop == 1 {
+		conn, err := grpc.NewClient(node.Address, grpc.WithTransportCredentials(ci.client.creds))
+		if err != nil {
+			return true, hopError(err)
+		}
+		stub := routingpb.NewRelayNodeServerClient(conn)
+		resp, err := stub.RelayNodeRPC(ctx, &routingpb.RelayRequest{Message: create, Create: true, PowNonce: powNonce})
+		if err != nil {
+			conn.Close()
+			return true, hopError(err)
+		}
+		ci.conn, ci.stub = conn, stub
+		confirmation = resp.Reply
+	} else {
+		extend := encryption.BuildRelayCell(encryption.RelayCell{
+			Command: encryption.RELAY_EXTEND,
+			Data:    encryption.BuildExtendData(node.Address, powNonce, create),
+		})
+		reply, err := ci.send(ctx, encryption.EXTEND_REQUEST, extend)
+		if err != nil {
+			// the circuit so far failed, not the new relay
+			return false, hopError(err)
+		}
+		cell, err := encryption.ParseRelayCell(reply)
+		if err != nil {
+			return false, hopError(err)
+		}
+		if cell.Command != encryption.RELAY_EXTENDED {
+			return true, hopError(fmt.Errorf("extend refused: %s", cell.Data))
+		}
+		confirmation = cell.Data
+	}
+
+	granted, ok := encryption.ParseCreated(confirmation, keySeed)
+	if !ok {
+		if hop == 1 {
+			ci.conn.Close()
+			ci.conn, ci.stub = nil, nil
+			return true, hopError(utils.ErrKeyConfirmation)
+		}
+		return false, hopError(utils.ErrKeyConfirmation)
+	}
+	ci.path = append(ci.path, node)
+	ci.keySeeds = append(ci.keySeeds, keySeed)
+	idleTimeout := time.Duration(granted) * time.Second
+	if hop == 1 || idleTimeout < ci.idleTimeout {
+		ci.idleTimeout = idleTimeout
+	}
+	// the EXTEND passed the earlier hops after start
+	ci.touch(start)
+	ci.client.logf("Circuit %d extended to %s (hop %d, idle timeout %v) in %v", ci.id, node.Address, hop, idleTimeout, time.Since(start))
+	return false, nil
+}
+
+func (c *Client) solveCreatePow(encryptedLayer []byte, node netdir.RelayNode) uint64 {
+	if node.PowDifficulty == 0 {
+		return 0
+	}
+	start := time.Now()
+	nonce := encryption.SolvePow(encryptedLayer[:encryption.ENCRYPTEDHEADERSIZE], node.PowDifficulty)
+	c.logf("Solved proof-of-work of difficulty %d for %s in %v", node.PowDifficulty, node.Address, time.Since(start))
+	return nonce
+}
+
+// nextAddr is where hop i forwards to: the next relay, or the server for the
+// exit.
+func (ci *Circuit) nextAddr(i int) string {
+	if i == len(ci.path)-1 {
+		return ci.client.serverAddr
+	}
+	return ci.path[i+1].Address
+}
+
+func (ci *Circuit) ID() uint16 {
+	return ci.id
+}
+
+// Path returns the circuit's relays, first hop first.
+func (ci *Circuit) Path() []netdir.RelayNode {
+	return append([]netdir.RelayNode{}, ci.path...)
+}
+
+func (ci *Circuit) Addresses() []string {
+	addrs := make([]string, len(ci.path))
+	for i, node := range ci.path {
+		addrs[i] = node.Address
+	}
+	return addrs
+}
+
+// IdleTimeout returns how long the circuit may stay unused before a relay
+// forgets it: the shortest idle timeout granted by its hops.
+func (ci *Circuit) IdleTimeout() time.Duration {
+	return ci.idleTimeout
+}
+
+// ExpiresAt returns when the circuit expires unless it is used or kept
+// alive. Relays restart their timers on every cell, so this is measured from
+// when the last successful cell was sent.
+func (ci *Circuit) ExpiresAt() time.Time {
+	ci.lifeLock.Lock()
+	defer ci.lifeLock.Unlock()
+	return ci.expiresAt
+}
+
+// touch records that a cell sent at sent reached every hop.
+func (ci *Circuit) touch(sent time.Time) {
+	ci.lifeLock.Lock()
+	defer ci.lifeLock.Unlock()
+	if expiresAt := sent.Add(ci.idleTimeout); expiresAt.After(ci.expiresAt) {
+		ci.expiresAt = expiresAt
+	}
+}
+
+// Keepalive sends a keepalive cell through the circuit, restarting the idle
+// timer on every hop, and returns the remaining lifetime the exit reports,
+// capped by the shortest timeout granted by the other hops.
+func (ci *Circuit) Keepalive(ctx context.Context) (time.Duration, error) {
+	sent := time.Now()
+	// an empty payload would read as padding at the exit
+	reply, err := ci.send(ctx, encryption.KEEPALIVE_REQUEST, []byte("keepalive"))
+	if err != nil {
+		return 0, err
+	}
+	if len(reply) != 4 {
+		return 0, fmt.Errorf("%w: keepalive reply of %d bytes", utils.ErrInvalidCell, len(reply))
+	}
+	remaining := min(time.Duration(binary.BigEndian.Uint32(reply))*time.Millisecond, ci.idleTimeout)
+	ci.lifeLock.Lock()
+	ci.expiresAt = sent.Add(remaining)
+	ci.lifeLock.Unlock()
+	return remaining, nil
+}
+
+// Do sends a request of reqType (encryption.GREET_REQUEST,
+// FIBONACCI_REQUEST or RANDOM_REQUEST) to the server and returns its reply.
+// Every request is a stream of its own, so Do may be called concurrently and
+// the requests are served in parallel. Requests and replies larger than a
+// cell are fragmented; both are limited to encryption.MAXMESSAGESIZE.
+//
+// The request must complete within the client's request timeout and the
+// deadline of ctx. When either passes or ctx is cancelled, the relays abandon
+// the request, and the exit is told to drop what it holds of it.
+func (ci *Circuit) Do(ctx context.Context, reqType byte, message []byte) ([]byte, error) {
+	request := append([]byte{reqType}, message...)
+	if len(request) > encryption.MAXMESSAGESIZE {
+		return nil, fmt.Errorf("%w: request of %d bytes", encryption.ErrMessageTooLarge, len(request))
+	}
+	streamID, err := ci.openStream()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := ci.client.requestContext(ctx)
+	defer cancel()
+	reply, err := ci.exchangeMessage(ctx, streamID, request)
+	if err != nil && ctx.Err() != nil {
+		go ci.endStream(streamID)
+		return nil, err
+	}
+	ci.closeStream(streamID)
+	return reply, err
+}
+
+// endStream tells the exit to drop a request that was given up on. The
+// stream ID stays taken until then, so that the END cannot hit a new request.
+func (ci *Circuit) endStream(streamID uint16) {
+	ctx, cancel := context.WithTimeout(context.Background(), endTimeout)
+	defer cancel()
+	ci.relay(ctx, encryption.RelayCell{Command: encryption.RELAY_END, StreamID: streamID})
+	ci.closeStream(streamID)
+}
+
+// openStream allocates a stream ID not in use on the circuit.
+func (ci *Circuit) openStream() (uint16, error) {
+	ci.streamsLock.Lock()
+	defer ci.streamsLock.Unlock()
+	if ci.streams == nil {
+		ci.streams = make(map[uint16]bool)
+	}
+	if len(ci.streams) >= maxStreams {
+		return 0, utils.ErrTooManyRequests
+	}
+	for {
+		ci.nextStreamID++
+		if ci.nextStreamID != 0 && !ci.streams[ci.nextStreamID] {
+			ci.streams[ci.nextStreamID] = true
+			return ci.nextStreamID, nil
+		}
+	}
+}
+
+func (ci *Circuit) closeStream(streamID uint16) {
+	ci.streamsLock.Lock()
+	delete(ci.streams, streamID)
+	ci.streamsLock.Unlock()
+	ci.flowControl().CloseStream(streamID)
+}
+
+func (ci *Circuit) flowControl() *encryption.CircuitFlow {
+	ci.flowOnce.Do(func() {
+		_, _, key3 := encryption.DeriveKeys(ci.keySeeds[len(ci.keySeeds)-1][:])
+		ci.flow = encryption.NewCircuitFlow(key3, false)
+	})
+	return ci.flow
+}
+
+// SendWindow returns how many more data cells the circuit may send to the
+// exit before it has to wait for a SENDME.
+func (ci *Circuit) SendWindow() int {
+	return ci.flowControl().Window()
+}
+
+// Streams returns the number of requests and TCP streams open on the
+// circuit.
+func (ci *Circuit) Streams() int {
+	ci.streamsLock.Lock()
+	defer ci.streamsLock.Unlock()
+	return len(ci.streams)
+}
+
+// send wraps payload in one data layer per hop and returns the exit's
+// decrypted reply.
+func (ci *Circuit) send(ctx context.Context, reqType byte, payload []byte) ([]byte, error) {
+	if ci.closed.Load() {
+		return nil, utils.ErrCircuitClosed
+	}
+	message := payload
+	var err error
+	for i := len(ci.path) - 1; i >= 0; i-- {
+		message, err = buildLayer(encryption.DATA_CELL, ci.nextAddr(i), ci.id, ci.keySeeds[i], ci.path[i].PubKey, message, i == len(ci.path)-1, reqType, 0, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var trailer metadata.MD
+	sent := time.Now()
+	resp, err := ci.stub.RelayNodeRPC(ctx, &routingpb.RelayRequest{Message: message}, grpc.Trailer(&trailer))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if status.Code(err) == codes.DeadlineExceeded {
+			// a relay ran out of the time left and gave up before we did
+			return nil, fmt.Errorf("%w at hop %s", utils.ErrDeadlineExceeded, strings.Join(trailer.Get(utils.TrailerErrorHop), ""))
+		}
+		return nil, ci.hopFailure(err, trailer)
+	}
+	ci.touch(sent)
+	reply := resp.Reply
+	for i := range ci.path {
+		_, key2, _ := encryption.DeriveKeys(ci.keySeeds[i][:])
+		reply = encryption.DecryptRC4(reply, key2)
+	}
+	return reply, nil
+}
+
+// Close releases the connection to the first relay. The relays drop the
+// circuit when its idle timeout passes.
+func (ci *Circuit) Close() error {
+	var err error
+	ci.closeOnce.Do(func() {
+		ci.closed.Store(true)
+		if ci.conn != nil {
+			err = ci.conn.Close()
+		}
+	})
+	return err
+}
+
+// buildLayer encrypts one hop's layer. For a CREATE, idleTimeout is the idle
+// timeout asked of the relay (0 for its default).
+func buildLayer(cellType int, nextAddr string, circuitID uint16, keySeed [16]byte, pubkey *rsa.PublicKey, payload []byte, isExitNode bool, reqType byte, nextPowNonce uint64, idleTimeout time.Duration) ([]byte, error) {
+	port, ip := utils.GetPortAndIP(nextAddr)
+	exit := byte(0)
+	if isExitNode {
+		exit = 1
+	}
+
+	var cell encryption.OnionCell
+	if cellType == encryption.CREATE_CELL {
+		cell = encryption.CreateCell(ip, port, payload, circuitID, keySeed, exit)
+		cell.NextPowNonce = nextPowNonce
+		cell.Expiration = uint32((idleTimeout + time.Second - 1) / time.Second)
+	} else {
+		cell = encryption.DataCell(payload, circuitID, exit, reqType)
+	}
+	message := encryption.BuildMessage(cell)
+
+	encryptedHeader, err := encryption.EncryptRSA(message[:encryption.HEADERSIZE], pubkey)
+	if err != nil {
+		return nil, err
+	}
+	body := message[encryption.HEADERSIZE:]
+	if cellType == encryption.DATA_CELL {
+		key1, _, _ := encryption.DeriveKeys(keySeed[:])
+		body = encryption.EncryptRC4(body, key1)
+	}
+	return append(encryptedHeader, body...), nil
+}
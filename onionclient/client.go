@@ -0,0 +1,224 @@
+// Package onionclient builds onion circuits through the relays listed in a
+// directory and sends requests and TCP streams over them.
+//
+//	directory := netdir.NewEtcdDirectory()
+//	directory.Authorities = authorities
+//	client, err := onionclient.New(onionclient.WithCredentials(creds), onionclient.WithDirectory(directory))
+//	circuit, err := client.BuildCircuit(ctx)
+//	reply, err := circuit.Do(ctx, encryption.GREET_REQUEST, []byte("hi"))
+//	conn, err := circuit.Dial(ctx, "tcp", "example.com:80")
+//
+// A Client and its Circuits are safe for concurrent use.
+package onionclient
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	netdir "onion_routing/netdir"
+	utils "onion_routing/utils"
+
+	"google.golang.org/grpc/credentials"
+)
+
+const DefaultPathLength = 3
+
+// DefaultIdleTimeout is the idle timeout asked of relays in CREATE cells.
+const DefaultIdleTimeout = 1 * time.Minute
+
+// DefaultRequestTimeout bounds a request, retries included, when the caller's
+// context has no earlier deadline.
+const DefaultRequestTimeout = 1 * time.Minute
+
+type Client struct {
+	directory     netdir.Directory
+	creds         credentials.TransportCredentials
+	pathLength    int
+	serverAddr    string
+	idleTimeout   time.Duration
+	reqTimeout    time.Duration
+	logger        *utils.Logger
+	nextCircuitID atomic.Uint32
+	circuitIDs    circuitIDRange
+
+	eventHandler    func(Event)
+	badRelayTimeout time.Duration
+	retryTimeout    time.Duration
+	bad             badRelays
+
+	guardOpts *GuardOptions
+	guards    *guardSet
+
+	buildTimeoutOpts BuildTimeoutOptions
+	buildTimer       *buildTimer
+}
+
+type Option func(*Client)
+
+// WithDirectory sets where relays are looked up. It is required: the
+// directory must know the authorities that vouch for the relays.
+func WithDirectory(directory netdir.Directory) Option {
+	return func(c *Client) { c.directory = directory }
+}
+
+// WithCredentials sets the TLS credentials presented to the first relay.
+func WithCredentials(creds credentials.TransportCredentials) Option {
+	return func(c *Client) { c.creds = creds }
+}
+
+// WithPathLength sets the number of relays in each circuit (default 3).
+func WithPathLength(length int) Option {
+	return func(c *Client) { c.pathLength = length }
+}
+
+// WithServerAddr sets the server that Circuit.Do requests are delivered to
+// (default utils.ServerAddr).
+func WithServerAddr(addr string) Option {
+	return func(c *Client) { c.serverAddr = addr }
+}
+
+// WithIdleTimeout sets the idle timeout asked of each relay when a circuit
+// is built (default DefaultIdleTimeout, 0 for the relays' default). Relays
+// clamp it to their own limits; Circuit.IdleTimeout reports what was granted.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(c *Client) { c.idleTimeout = timeout }
+}
+
+// WithRequestTimeout bounds every request and stream dial (default
+// DefaultRequestTimeout, 0 for no limit besides the caller's context). The
+// deadline travels with the cells, and each relay gives up on the request
+// when it passes.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(c *Client) { c.reqTimeout = timeout }
+}
+
+// WithCircuitIDBase sets the ID of the first circuit; later circuits count up
+// from it. By default the first ID is random.
+func WithCircuitIDBase(id uint16) Option {
+	return func(c *Client) { c.nextCircuitID.Store(uint32(id)) }
+}
+
+// WithCircuitIDRange makes circuits take the IDs first to first+count-1 (mod
+// 65536) in turn, starting over after the last, so that clients sharing
+// relays can be given disjoint ranges.
+func WithCircuitIDRange(first uint16, count int) Option {
+	return func(c *Client) {
+		c.nextCircuitID.Store(uint32(first))
+		c.circuitIDs = circuitIDRange{first: first, count: count}
+	}
+}
+
+// circuitIDRange limits the circuit IDs a client uses; count 0 allows all.
+type circuitIDRange struct {
+	first uint16
+	count int
+}
+
+// WithLogger records circuit activity in a session log.
+func WithLogger(logger *utils.Logger) Option {
+	return func(c *Client) { c.logger = logger }
+}
+
+func New(opts ...Option) (*Client, error) {
+	c := &Client{
+		pathLength:      DefaultPathLength,
+		serverAddr:      utils.ServerAddr,
+		idleTimeout:     DefaultIdleTimeout,
+		reqTimeout:      DefaultRequestTimeout,
+		badRelayTimeout: 5 * time.Minute,
+		retryTimeout:    30 * time.Second,
+	}
+	var seed [2]byte
+	rand.Read(seed[:])
+	c.nextCircuitID.Store(uint32(binary.BigEndian.Uint16(seed[:])))
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.creds == nil {
+		return nil, utils.ErrNoCredentials
+	}
+	if c.pathLength < 1 {
+		return nil, fmt.Errorf("onionclient: path length must be at least 1, got %d", c.pathLength)
+	}
+	if c.idleTimeout < 0 {
+		return nil, fmt.Errorf("onionclient: idle timeout must not be negative, got %v", c.idleTimeout)
+	}
+	if c.reqTimeout < 0 {
+		return nil, fmt.Errorf("onionclient: request timeout must not be negative, got %v", c.reqTimeout)
+	}
+	if c.circuitIDs.count < 0 || c.circuitIDs.count > 1<<16 {
+		return nil, fmt.Errorf("onionclient: circuit ID range must hold 1 to 65536 IDs, got %d", c.circuitIDs.count)
+	}
+	if c.directory == nil {
+		return nil, fmt.Errorf("onionclient: no directory given: %w", utils.ErrNoAuthorities)
+	}
+	if c.guardOpts != nil {
+		guards, err := loadGuards(*c.guardOpts)
+		if err != nil {
+			return nil, fmt.Errorf("onionclient: loading entry guards: %w", err)
+		}
+		c.guards = guards
+	}
+	buildTimer, err := loadBuildTimer(c.buildTimeoutOpts)
+	if err != nil {
+		return nil, fmt.Errorf("onionclient: loading build times: %w", err)
+	}
+	c.buildTimer = buildTimer
+	return c, nil
+}
+
+// LoadCredentials reads the CA certificate and the client's key pair.
+func LoadCredentials(caPath string, certPath string, keyPath string) (credentials.TransportCredentials, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := os.ReadFile(caPath)
+	if err != nil {
+		return nil, err
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("no certificates found in %s", caPath)
+	}
+	return credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      certPool,
+		ServerName:   "localhost",
+	}), nil
+}
+
+// Close saves the build time history and releases the directory connection.
+// Circuits are closed separately.
+func (c *Client) Close() error {
+	if err := c.buildTimer.save(); err != nil {
+		c.logf("Saving build times failed: %v", err)
+	}
+	if closer, ok := c.directory.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// requestContext applies the request timeout to ctx.
+func (c *Client) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.reqTimeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.reqTimeout)
+}
+
+func (c *Client) logf(format string, a ...any) {
+	if c.logger != nil {
+		c.logger.PrintLog(format, a...)
+	}
+}
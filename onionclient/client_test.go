@@ -0,0 +1,27 @@
+package onionclient
+
+import "testing"
+
+func TestCircuitIDRange(t *testing.T) {
+	tests := []struct {
+		name  string
+		first uint16
+		count int
+		want  []uint16
+	}{
+		{"wraps to the first ID", 100, 3, []uint16{100, 101, 102, 100, 101}},
+		{"wraps past 65535", 65534, 4, []uint16{65534, 65535, 0, 1, 65534}},
+		{"single ID", 7, 1, []uint16{7, 7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{}
+			WithCircuitIDRange(tt.first, tt.count)(c)
+			for i, want := range tt.want {
+				if got := c.newCircuit().id; got != want {
+					t.Fatalf("circuit %d got ID %d, want %d", i, got, want)
+				}
+			}
+		})
+	}
+}
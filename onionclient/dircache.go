@@ -0,0 +1,278 @@
+package onionclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	encryption "onion_routing/encryption"
+	netdir "onion_routing/netdir"
+	utils "onion_routing/utils"
+)
+
+// CachedDirectory keeps the relays last listed by another directory in memory
+// and in a cache file, so that a client starts and builds circuits while the
+// directory is down. A listing younger than Validity is used without asking
+// the directory again, and a background loop refreshes it before then. When
+// the directory cannot be reached, the cached relays are used, and once the
+// cache has none, the fallback relays.
+//
+// Relays that the directory stops listing stay in the cache until they have
+// been unlisted for MaxAge: relays drop out of etcd while they restart, so
+// recent ones are still good candidates when the directory is down. They are
+// only used then; a fresh listing is used as it is. Whatever MaxAge says, a
+// relay is dropped once its descriptor is older than
+// encryption.DescriptorLifetime: by then the relay has rotated the onion key
+// the descriptor lists, and a directory no longer accepts it either.
+
+// DirectoryCacheOptions configures a CachedDirectory. Zero values select the
+// defaults.
+type DirectoryCacheOptions struct {
+	CacheFile string             // where the relays are kept across restarts; in memory only when empty
+	Validity  time.Duration      // use a listing this long without asking the directory (default 10m)
+	MaxAge    time.Duration      // drop relays the directory has not listed for this long (default 24h)
+	Fallback  []netdir.RelayNode // used when neither the directory nor the cache has relays
+	Logger    *utils.Logger
+}
+
+func (o DirectoryCacheOptions) withDefaults() DirectoryCacheOptions {
+	if o.Validity <= 0 {
+		o.Validity = 10 * time.Minute
+	}
+	if o.MaxAge <= 0 {
+		o.MaxAge = 24 * time.Hour
+	}
+	return o
+}
+
+// directoryRetryInterval is how long the cache is used without trying the
+// directory again after it could not be reached; the refresh loop keeps
+// trying in the meantime.
+const directoryRetryInterval = time.Minute
+
+type cachedRelay struct {
+	Relay      netdir.RelayNode `json:"relay"`
+	LastListed time.Time        `json:"last_listed"`
+}
+
+type directoryCache struct {
+	Fetched time.Time     `json:"fetched"`
+	Relays  []cachedRelay `json:"relays"`
+}
+
+type CachedDirectory struct {
+	upstream netdir.Directory
+	opts     DirectoryCacheOptions
+
+	lock     sync.Mutex
+	cache    directoryCache
+	failedAt time.Time // last failed fetch, zero after a success
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewCachedDirectory loads the cache file, if any, and starts refreshing it
+// from upstream in the background. Close stops the refresh and closes
+// upstream.
+func NewCachedDirectory(upstream netdir.Directory, opts DirectoryCacheOptions) (*CachedDirectory, error) {
+	d := &CachedDirectory{upstream: upstream, opts: opts.withDefaults(), stop: make(chan struct{})}
+	if d.opts.CacheFile != "" {
+		data, err := os.ReadFile(d.opts.CacheFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &d.cache); err != nil {
+				return nil, fmt.Errorf("%s: %w", d.opts.CacheFile, err)
+			}
+		}
+	}
+	d.dropStaleLocked(time.Now())
+	go d.refreshLoop()
+	return d, nil
+}
+
+// LoadRelayList reads a JSON list of relays, each like the "relay" entries of
+// the cache file, e.g. for DirectoryCacheOptions.Fallback. The operator vouches
+// for them, so they carry no signatures.
+func LoadRelayList(path string) ([]netdir.RelayNode, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []netdir.RelayNode
+	if err := json.Unmarshal(data, &nodes); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, node := range nodes {
+		if node.Address == "" || node.PubKey == nil {
+			return nil, fmt.Errorf("%s: relay %d needs an address and a public key", path, i+1)
+		}
+	}
+	return nodes, nil
+}
+
+// Consensus returns the cached listing while it is valid and asks the
+// directory otherwise, falling back to the cached and then the fallback
+// relays when the directory cannot be reached.
+func (d *CachedDirectory) Consensus(ctx context.Context) (*netdir.Consensus, error) {
+	d.lock.Lock()
+	now := time.Now()
+	fresh := now.Sub(d.cache.Fetched) < d.opts.Validity
+	retry := now.Sub(d.failedAt) >= directoryRetryInterval
+	if fresh || !retry {
+		defer d.lock.Unlock()
+		if nodes := d.usableLocked(now, fresh); len(nodes) > 0 {
+			return netdir.NewConsensus(nodes, now), nil
+		}
+		if len(d.opts.Fallback) > 0 {
+			return netdir.NewConsensus(d.opts.Fallback, now), nil
+		}
+		return nil, fmt.Errorf("%w: directory unreachable and no cached or fallback relays", utils.ErrNotEnoughRelays)
+	}
+	d.lock.Unlock()
+
+	consensus, err := d.refresh(ctx)
+	if err == nil {
+		return consensus, nil
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if nodes := d.usableLocked(time.Now(), false); len(nodes) > 0 {
+		return netdir.NewConsensus(nodes, time.Now()), nil
+	}
+	if len(d.opts.Fallback) > 0 {
+		d.logf("Directory unreachable (%v); using %d fallback relays", err, len(d.opts.Fallback))
+		return netdir.NewConsensus(d.opts.Fallback, time.Now()), nil
+	}
+	return nil, fmt.Errorf("directory unreachable and no cached or fallback relays: %w", err)
+}
+
+// usableLocked returns the relays of the last listing, or when it is no
+// longer fresh, every cached relay that is not too old.
+func (d *CachedDirectory) usableLocked(now time.Time, fresh bool) []netdir.RelayNode {
+	d.dropStaleLocked(now)
+	nodes := []netdir.RelayNode{}
+	for _, cached := range d.cache.Relays {
+		if !fresh || cached.LastListed.Equal(d.cache.Fetched) {
+			nodes = append(nodes, cached.Relay)
+		}
+	}
+	return nodes
+}
+
+func (d *CachedDirectory) dropStaleLocked(now time.Time) {
+	relays := d.cache.Relays[:0]
+	for _, cached := range d.cache.Relays {
+		// relays without a descriptor come from an operator's list
+		expired := !cached.Relay.Published.IsZero() && encryption.CheckFreshness(cached.Relay.Published, now) != nil
+		if now.Sub(cached.LastListed) < d.opts.MaxAge && !expired {
+			relays = append(relays, cached)
+		}
+	}
+	d.cache.Relays = relays
+}
+
+// refresh fetches the relays from the directory and saves them to the cache.
+func (d *CachedDirectory) refresh(ctx context.Context) (*netdir.Consensus, error) {
+	consensus, err := d.upstream.Consensus(ctx)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	now := time.Now()
+	if err != nil {
+		if d.failedAt.IsZero() {
+			d.logf("Directory unreachable, using the relays cached %v ago: %v", now.Sub(d.cache.Fetched).Round(time.Second), err)
+		}
+		d.failedAt = now
+		return nil, err
+	}
+	d.failedAt = time.Time{}
+
+	listed := make(map[string]bool)
+	relays := []cachedRelay{}
+	for _, node := range consensus.Relays {
+		listed[relayID(node)] = true
+		relays = append(relays, cachedRelay{Relay: node, LastListed: now})
+	}
+	for _, cached := range d.cache.Relays {
+		if !listed[relayID(cached.Relay)] {
+			relays = append(relays, cached)
+		}
+	}
+	d.cache = directoryCache{Fetched: now, Relays: relays}
+	d.dropStaleLocked(now)
+	if err := d.saveLocked(); err != nil {
+		d.logf("Saving the directory cache failed: %v", err)
+	}
+	return consensus, nil
+}
+
+func (d *CachedDirectory) refreshLoop() {
+	for {
+		d.lock.Lock()
+		wait := d.opts.Validity - time.Since(d.cache.Fetched)
+		if !d.failedAt.IsZero() {
+			wait = directoryRetryInterval - time.Since(d.failedAt)
+		}
+		d.lock.Unlock()
+		// refresh a little before the listing runs out
+		wait -= wait / 10
+		select {
+		case <-d.stop:
+			return
+		case <-time.After(max(wait, time.Second)):
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		d.refresh(ctx)
+		cancel()
+	}
+}
+
+func (d *CachedDirectory) saveLocked() error {
+	if d.opts.CacheFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(d.cache)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(d.opts.CacheFile), 0700); err != nil {
+		return err
+	}
+	tmp := d.opts.CacheFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.opts.CacheFile)
+}
+
+// Fetched returns when the directory last listed the relays, zero if it never
+// has.
+func (d *CachedDirectory) Fetched() time.Time {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.cache.Fetched
+}
+
+// Close stops the background refresh and closes the directory behind the
+// cache.
+func (d *CachedDirectory) Close() error {
+	d.closeOnce.Do(func() { close(d.stop) })
+	if closer, ok := d.upstream.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (d *CachedDirectory) logf(format string, a ...any) {
+	if d.opts.Logger != nil {
+		d.opts.Logger.PrintLog(format, a...)
+	}
+}
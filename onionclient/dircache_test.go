@@ -0,0 +1,180 @@
+package onionclient
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	encryption "onion_routing/encryption"
+	netdir "onion_routing/netdir"
+)
+
+var errDirectoryDown = errors.New("directory down")
+
+// fakeDirectory lists relays until it is taken down, counting the fetches.
+type fakeDirectory struct {
+	lock    sync.Mutex
+	relays  []netdir.RelayNode
+	down    bool
+	fetches int
+}
+
+func (f *fakeDirectory) Consensus(ctx context.Context) (*netdir.Consensus, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.fetches++
+	if f.down {
+		return nil, errDirectoryDown
+	}
+	return netdir.NewConsensus(f.relays, time.Now()), nil
+}
+
+func (f *fakeDirectory) set(down bool, relays ...netdir.RelayNode) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.down = down
+	if relays != nil {
+		f.relays = relays
+	}
+}
+
+func (f *fakeDirectory) fetchCount() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.fetches
+}
+
+func listedRelay(identity string, published time.Time) netdir.RelayNode {
+	return netdir.RelayNode{Identity: identity, Address: identity + ":9001", Published: published}
+}
+
+func consensusIdentities(t *testing.T, d *CachedDirectory) []string {
+	t.Helper()
+	consensus, err := d.Consensus(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, node := range consensus.Relays {
+		ids = append(ids, node.Identity)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// expireListing makes the cached listing look fetched age ago and lets the
+// next Consensus ask the directory again.
+func expireListing(d *CachedDirectory, age time.Duration) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.cache.Fetched = time.Now().Add(-age)
+	d.failedAt = time.Time{}
+}
+
+func TestCachedDirectory(t *testing.T) {
+	now := time.Now()
+	upstream := &fakeDirectory{relays: []netdir.RelayNode{listedRelay("a", now), listedRelay("b", now)}}
+	cacheFile := filepath.Join(t.TempDir(), "directory.json")
+	d, err := NewCachedDirectory(upstream, DirectoryCacheOptions{CacheFile: cacheFile, Validity: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if got := consensusIdentities(t, d); len(got) != 2 {
+		t.Fatalf("relays %v, want a and b", got)
+	}
+	fetches := upstream.fetchCount()
+	consensusIdentities(t, d)
+	if upstream.fetchCount() != fetches {
+		t.Error("the directory was asked again while the listing was valid")
+	}
+
+	// b leaves the directory; a fresh listing is used as it is
+	upstream.set(false, listedRelay("a", now))
+	expireListing(d, 2*time.Hour)
+	if got := consensusIdentities(t, d); len(got) != 1 || got[0] != "a" {
+		t.Errorf("relays %v from a fresh listing, want only a", got)
+	}
+
+	// while the directory is down, recently unlisted relays are used too
+	upstream.set(true)
+	expireListing(d, 2*time.Hour)
+	if got := consensusIdentities(t, d); len(got) != 2 {
+		t.Errorf("relays %v with the directory down, want a and the recently unlisted b", got)
+	}
+
+	// a restarted client starts from the cache file
+	restarted, err := NewCachedDirectory(upstream, DirectoryCacheOptions{CacheFile: cacheFile, Validity: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Close()
+	expireListing(restarted, 2*time.Hour)
+	if got := consensusIdentities(t, restarted); len(got) != 2 {
+		t.Errorf("relays %v after a restart with the directory down, want a and b", got)
+	}
+}
+
+func TestCachedDirectoryDropsExpiredDescriptors(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-encryption.DescriptorLifetime - time.Minute)
+	upstream := &fakeDirectory{relays: []netdir.RelayNode{listedRelay("fresh", now), listedRelay("expiring", now)}}
+	d, err := NewCachedDirectory(upstream, DirectoryCacheOptions{Validity: time.Hour, MaxAge: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	consensusIdentities(t, d)
+
+	// the directory goes down and a cached descriptor outlives its lifetime
+	upstream.set(true)
+	d.lock.Lock()
+	for i := range d.cache.Relays {
+		if d.cache.Relays[i].Relay.Identity == "expiring" {
+			d.cache.Relays[i].Relay.Published = old
+		}
+	}
+	d.lock.Unlock()
+	expireListing(d, 2*time.Hour)
+	if got := consensusIdentities(t, d); len(got) != 1 || got[0] != "fresh" {
+		t.Errorf("relays %v, want only the one with a fresh descriptor", got)
+	}
+}
+
+func TestCachedDirectoryFallback(t *testing.T) {
+	upstream := &fakeDirectory{down: true}
+	fallback := []netdir.RelayNode{{Address: "10.9.0.1:9001"}}
+	d, err := NewCachedDirectory(upstream, DirectoryCacheOptions{Fallback: fallback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	consensus, err := d.Consensus(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(consensus.Relays) != 1 || consensus.Relays[0].Address != "10.9.0.1:9001" {
+		t.Errorf("relays %+v, want the fallback relay", consensus.Relays)
+	}
+
+	// once the directory answers its listing replaces the fallback
+	upstream.set(false, listedRelay("listed", time.Now()))
+	expireListing(d, 2*time.Hour)
+	if got := consensusIdentities(t, d); len(got) != 1 || got[0] != "listed" {
+		t.Errorf("relays %v, want the listed relay", got)
+	}
+
+	empty, err := NewCachedDirectory(&fakeDirectory{down: true}, DirectoryCacheOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer empty.Close()
+	if _, err := empty.Consensus(context.Background()); !errors.Is(err, errDirectoryDown) {
+		t.Errorf("Consensus with no cache or fallback = %v, want the directory's error", err)
+	}
+}
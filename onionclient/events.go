@@ -0,0 +1,154 @@
+package onionclient
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	netdir "onion_routing/netdir"
+	utils "onion_routing/utils"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type EventType int
+
+const (
+	EventExtendRetried  EventType = iota + 1 // an extension failed and another relay is tried
+	EventRelayMarkedBad                      // a relay failed and is avoided until Until
+	EventRequestRetried                      // a request or stream is retried on another circuit
+	EventBuildAbandoned                      // a circuit build ran past the build timeout
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventExtendRetried:
+		return "EXTEND_RETRIED"
+	case EventRelayMarkedBad:
+		return "RELAY_MARKED_BAD"
+	case EventRequestRetried:
+		return "REQUEST_RETRIED"
+	case EventBuildAbandoned:
+		return "BUILD_ABANDONED"
+	}
+	return "UNKNOWN"
+}
+
+// Event reports how the client recovers from failures. Handlers are called
+// synchronously and must not block.
+type Event struct {
+	Type      EventType
+	CircuitID uint16
+	Hop       int    // failed hop, 1 for the first relay (0 if unknown)
+	Relay     string // failed relay
+	Attempt   int
+	Until     time.Time
+	Err       error
+}
+
+func (e Event) String() string {
+	s := e.Type.String()
+	if e.CircuitID != 0 {
+		s += fmt.Sprintf(" circuit=%d", e.CircuitID)
+	}
+	if e.Hop != 0 {
+		s += fmt.Sprintf(" hop=%d", e.Hop)
+	}
+	if e.Relay != "" {
+		s += " relay=" + e.Relay
+	}
+	if e.Attempt != 0 {
+		s += fmt.Sprintf(" attempt=%d", e.Attempt)
+	}
+	return fmt.Sprintf("%s: %v", s, e.Err)
+}
+
+// WithEventHandler receives failure recovery events.
+func WithEventHandler(handler func(Event)) Option {
+	return func(c *Client) { c.eventHandler = handler }
+}
+
+// WithBadRelayTimeout sets how long a failed relay is left out of new paths
+// (default 5m).
+func WithBadRelayTimeout(d time.Duration) Option {
+	return func(c *Client) { c.badRelayTimeout = d }
+}
+
+// WithRetryTimeout bounds how long Pool.Do and Pool.Dial keep retrying on new
+// circuits after failures (default 30s).
+func WithRetryTimeout(d time.Duration) Option {
+	return func(c *Client) { c.retryTimeout = d }
+}
+
+func (c *Client) emit(event Event) {
+	c.logf("Event: %v", event)
+	if c.eventHandler != nil {
+		c.eventHandler(event)
+	}
+}
+
+type badRelays struct {
+	lock  sync.Mutex
+	until map[string]time.Time
+}
+
+func (c *Client) markBad(circuitID uint16, hop int, node netdir.RelayNode, err error) {
+	relay := node.Address
+	until := time.Now().Add(c.badRelayTimeout)
+	c.bad.lock.Lock()
+	if c.bad.until == nil {
+		c.bad.until = make(map[string]time.Time)
+	}
+	c.bad.until[relay] = until
+	c.bad.lock.Unlock()
+	if hop == 1 {
+		c.guardResult(node, false)
+	}
+	c.emit(Event{Type: EventRelayMarkedBad, CircuitID: circuitID, Hop: hop, Relay: relay, Until: until, Err: err})
+}
+
+func (c *Client) isBad(relay string) bool {
+	c.bad.lock.Lock()
+	defer c.bad.lock.Unlock()
+	until, exists := c.bad.until[relay]
+	if exists && time.Now().After(until) {
+		delete(c.bad.until, relay)
+		return false
+	}
+	return exists
+}
+
+// badRelaySet returns the relays currently avoided.
+func (c *Client) badRelaySet() map[string]bool {
+	c.bad.lock.Lock()
+	defer c.bad.lock.Unlock()
+	set := make(map[string]bool)
+	now := time.Now()
+	for relay, until := range c.bad.until {
+		if now.Before(until) {
+			set[relay] = true
+		}
+	}
+	return set
+}
+
+// hopFailure works out from the relays' trailers which hop of the circuit
+// could not be reached. Failing to reach the first relay shows up as an
+// Unavailable error without trailers.
+func (ci *Circuit) hopFailure(err error, trailer metadata.MD) error {
+	hop := 0
+	if unreachable := trailer.Get(utils.TrailerUnreachableHop); len(unreachable) > 0 {
+		hop, _ = strconv.Atoi(unreachable[0])
+	} else if len(trailer.Get(utils.TrailerErrorHop)) == 0 && status.Code(err) == codes.Unavailable {
+		hop = 1
+	}
+	if hop < 1 || hop > len(ci.path) {
+		return err
+	}
+	hopErr := &HopError{Hop: hop, Relay: ci.path[hop-1].Address, Err: err}
+	ci.client.markBad(ci.id, hop, ci.path[hop-1], err)
+	return hopErr
+}
@@ -0,0 +1,133 @@
+package onionclient
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	encryption "onion_routing/encryption"
+	utils "onion_routing/utils"
+)
+
+// Requests go to the exit in fragments that fit a cell. All but the last are
+// sent first, several at a time, and acknowledged with RELAY_RECEIVED; the
+// last completes the request and is answered with the first fragment of the
+// reply. The other reply fragments are fetched the same way.
+
+const (
+	// maxFragmentsInFlight bounds the fragments of one message sent at once.
+	maxFragmentsInFlight = 8
+	// fragmentTimeout bounds how long a fragment may take to be delivered.
+	fragmentTimeout = 30 * time.Second
+)
+
+// exchangeMessage sends request on streamID and returns the reassembled
+// reply.
+func (ci *Circuit) exchangeMessage(ctx context.Context, streamID uint16, request []byte) ([]byte, error) {
+	count := encryption.FragmentCount(len(request))
+	err := eachFragment(ctx, 0, count-1, func(ctx context.Context, seq int) error {
+		reply, err := ci.relay(ctx, encryption.RelayCell{Command: encryption.RELAY_REQUEST, StreamID: streamID, Data: encryption.BuildFragment(request, seq)})
+		if err != nil {
+			return err
+		}
+		if reply.Command != encryption.RELAY_RECEIVED {
+			return endError(reply, utils.ErrRequestFailed)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the server may take a while, so the caller's deadline applies instead
+	// of fragmentTimeout
+	reply, err := ci.relay(ctx, encryption.RelayCell{Command: encryption.RELAY_REQUEST, StreamID: streamID, Data: encryption.BuildFragment(request, count-1)})
+	if err != nil {
+		return nil, err
+	}
+	first, err := parseReplyFragment(reply, 0)
+	if err != nil {
+		return nil, err
+	}
+	message := encryption.NewReassembly(first.length)
+	message.Add(first.length, 0, first.chunk)
+
+	var lock sync.Mutex
+	err = eachFragment(ctx, 1, encryption.FragmentCount(first.length), func(ctx context.Context, seq int) error {
+		reply, err := ci.relay(ctx, encryption.RelayCell{Command: encryption.RELAY_FETCH, StreamID: streamID, Data: encryption.BuildFetchData(seq)})
+		if err != nil {
+			return err
+		}
+		fragment, err := parseReplyFragment(reply, seq)
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		_, err = message.Add(fragment.length, seq, fragment.chunk)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return message.Message(), nil
+}
+
+type replyFragment struct {
+	length int
+	chunk  []byte
+}
+
+func parseReplyFragment(reply encryption.RelayCell, seq int) (replyFragment, error) {
+	if reply.Command != encryption.RELAY_RESPONSE {
+		return replyFragment{}, endError(reply, utils.ErrRequestFailed)
+	}
+	length, got, chunk, err := encryption.ParseFragment(reply.Data)
+	if err != nil {
+		return replyFragment{}, fmt.Errorf("%w: %v", utils.ErrRequestFailed, err)
+	}
+	if got != seq {
+		return replyFragment{}, fmt.Errorf("%w: fragment %d instead of %d", encryption.ErrBadFragment, got, seq)
+	}
+	return replyFragment{length: length, chunk: chunk}, nil
+}
+
+// eachFragment calls send for the fragments from first up to end, at most
+// maxFragmentsInFlight at a time and each within fragmentTimeout, and
+// returns the first error.
+func eachFragment(ctx context.Context, first int, end int, send func(context.Context, int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	slots := make(chan struct{}, maxFragmentsInFlight)
+	for seq := first; seq < end; seq++ {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			fragmentCtx, cancelFragment := context.WithTimeout(ctx, fragmentTimeout)
+			defer cancelFragment()
+			if err := send(fragmentCtx, seq); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr == nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return firstErr
+}
@@ -0,0 +1,265 @@
+package onionclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	netdir "onion_routing/netdir"
+	utils "onion_routing/utils"
+)
+
+// A client that picks a fresh first hop for every circuit eventually enters
+// through a hostile relay. Instead the first hop comes from a small set of
+// entry guards that is kept in a state file and reused across restarts. A
+// guard is replaced only when its lifetime ends, when it has been unreachable
+// for UnreachableTimeout, or when it has left the directory.
+
+// GuardOptions configures entry guards. Zero values select the defaults.
+type GuardOptions struct {
+	StateFile          string        // where the guards are kept; in memory only when empty
+	Count              int           // guards in the set (default 3)
+	Lifetime           time.Duration // replace a guard this long after it was chosen (default 30 days)
+	UnreachableTimeout time.Duration // replace a guard that failed for this long without a success (default 1h)
+}
+
+func (o GuardOptions) withDefaults() GuardOptions {
+	if o.Count <= 0 {
+		o.Count = 3
+	}
+	if o.Lifetime <= 0 {
+		o.Lifetime = 30 * 24 * time.Hour
+	}
+	if o.UnreachableTimeout <= 0 {
+		o.UnreachableTimeout = time.Hour
+	}
+	return o
+}
+
+// unlistedGrace keeps a guard that briefly drops out of the directory, e.g.
+// while the relay restarts and its registration lease has expired.
+const unlistedGrace = 10 * time.Minute
+
+// Guard is an entry guard as kept in the state file. A guard is the relay
+// with the identity, wherever the consensus lists it; Address is where it was
+// last listed.
+type Guard struct {
+	Identity      string    `json:"identity"`
+	Address       string    `json:"address"`
+	Added         time.Time `json:"added"`
+	LastSuccess   time.Time `json:"last_success"`
+	FirstFailure  time.Time `json:"first_failure"` // start of the current run of failures
+	UnlistedSince time.Time `json:"unlisted_since"`
+}
+
+type guardState struct {
+	Guards []Guard `json:"guards"`
+}
+
+type guardSet struct {
+	opts GuardOptions
+
+	lock   sync.Mutex
+	guards []Guard
+}
+
+// WithGuards makes the first hop of every circuit one of a persistent set of
+// entry guards.
+func WithGuards(opts GuardOptions) Option {
+	return func(c *Client) { c.guardOpts = &opts }
+}
+
+// loadGuards reads the guard state file. A missing file starts an empty set.
+func loadGuards(opts GuardOptions) (*guardSet, error) {
+	g := &guardSet{opts: opts.withDefaults()}
+	if g.opts.StateFile == "" {
+		return g, nil
+	}
+	data, err := os.ReadFile(g.opts.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return g, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state guardState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	for _, guard := range state.Guards {
+		// guards kept by address alone, before they had an identity
+		if guard.Identity != "" {
+			g.guards = append(g.guards, guard)
+		}
+	}
+	return g, nil
+}
+
+// relayID identifies a relay across address changes: the fingerprint of its
+// identity key, or its address if the directory lists none.
+func relayID(node netdir.RelayNode) string {
+	if node.Identity != "" {
+		return node.Identity
+	}
+	return node.Address
+}
+
+// saveLocked writes the state file through a temporary file so a crash never
+// leaves it half written.
+func (g *guardSet) saveLocked() error {
+	if g.opts.StateFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(guardState{Guards: g.guards}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(g.opts.StateFile), 0700); err != nil {
+		return err
+	}
+	tmp := g.opts.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, g.opts.StateFile)
+}
+
+// pickGuard returns the first guard, in the order the guards were chosen, that
+// can fill the position r, after replacing guards that are due and topping
+// the set up from the consensus. Guards that cannot be used now, e.g. because
+// they are avoided after a failure, stay in the set. A guard that lost the
+// Guard flag counts as having left the directory.
+func (c *Client) pickGuard(consensus *netdir.Consensus, r hopRequest) (netdir.RelayNode, error) {
+	g := c.guards
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	now := time.Now()
+	listed := make(map[string]netdir.RelayNode, len(consensus.Relays))
+	for _, node := range consensus.Relays {
+		if !r.needGuard || node.HasFlag(netdir.FlagGuard) {
+			listed[relayID(node)] = node
+		}
+	}
+	changed := false
+	kept := g.guards[:0]
+	for _, guard := range g.guards {
+		node, isListed := listed[guard.Identity]
+		if isListed && node.Address != guard.Address {
+			c.logf("Entry guard %s moved from %s to %s", guard.Identity, guard.Address, node.Address)
+			guard.Address = node.Address
+			changed = true
+		}
+		if isListed && !guard.UnlistedSince.IsZero() {
+			guard.UnlistedSince = time.Time{}
+			changed = true
+		} else if !isListed && guard.UnlistedSince.IsZero() {
+			guard.UnlistedSince = now
+			changed = true
+		}
+		reason := ""
+		switch {
+		case now.Sub(guard.Added) > g.opts.Lifetime:
+			reason = "its lifetime ended"
+		case !guard.FirstFailure.IsZero() && now.Sub(guard.FirstFailure) > g.opts.UnreachableTimeout:
+			reason = "it has been unreachable since " + guard.FirstFailure.Format(time.RFC3339)
+		case !isListed && now.Sub(guard.UnlistedSince) > unlistedGrace:
+			reason = "it left the directory"
+		}
+		if reason != "" {
+			c.logf("Replacing entry guard %s (%s): %s", guard.Identity, guard.Address, reason)
+			changed = true
+			continue
+		}
+		kept = append(kept, guard)
+	}
+	g.guards = kept
+
+	// pickRelay excludes by address, so guards are excluded where they are
+	// listed now; unlisted guards are left out of the set by identity below
+	inSet := make(map[string]bool, len(g.guards))
+	guardIDs := make(map[string]bool, len(g.guards))
+	for _, guard := range g.guards {
+		guardIDs[guard.Identity] = true
+		if node, isListed := listed[guard.Identity]; isListed {
+			inSet[node.Address] = true
+		}
+	}
+	for len(g.guards) < g.opts.Count {
+		node, err := pickRelay(consensus, hopRequest{hop: 1, exclude: inSet, needGuard: r.needGuard})
+		if err != nil {
+			break
+		}
+		inSet[node.Address] = true
+		if guardIDs[relayID(node)] {
+			continue
+		}
+		guardIDs[relayID(node)] = true
+		g.guards = append(g.guards, Guard{Identity: relayID(node), Address: node.Address, Added: now})
+		c.logf("Added entry guard %s (%s)", relayID(node), node.Address)
+		changed = true
+	}
+	if changed {
+		if err := g.saveLocked(); err != nil {
+			c.logf("Saving entry guards failed: %v", err)
+		}
+	}
+
+	for _, guard := range g.guards {
+		node, isListed := listed[guard.Identity]
+		if isListed && r.check(node) < 0 {
+			return node, nil
+		}
+	}
+	return netdir.RelayNode{}, fmt.Errorf("%w: none of %d guards is usable", utils.ErrNoGuardAvailable, len(g.guards))
+}
+
+// guardResult records whether a circuit could be started at relay, if it is
+// a guard.
+func (c *Client) guardResult(relay netdir.RelayNode, reachable bool) {
+	g := c.guards
+	if g == nil {
+		return
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for i := range g.guards {
+		guard := &g.guards[i]
+		if guard.Identity != relayID(relay) {
+			continue
+		}
+		now := time.Now()
+		if reachable {
+			// only write the file when the guard recovers or once a minute
+			unchanged := guard.FirstFailure.IsZero() && now.Sub(guard.LastSuccess) < time.Minute
+			guard.LastSuccess = now
+			guard.FirstFailure = time.Time{}
+			if unchanged {
+				return
+			}
+		} else if guard.FirstFailure.IsZero() {
+			guard.FirstFailure = now
+		} else {
+			return
+		}
+		if err := g.saveLocked(); err != nil {
+			c.logf("Saving entry guards failed: %v", err)
+		}
+		return
+	}
+}
+
+// Guards returns the current entry guards, in the order they are used. It is
+// empty when the client does not use guards.
+func (c *Client) Guards() []Guard {
+	if c.guards == nil {
+		return nil
+	}
+	c.guards.lock.Lock()
+	defer c.guards.lock.Unlock()
+	return append([]Guard{}, c.guards.guards...)
+}
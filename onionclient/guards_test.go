@@ -0,0 +1,175 @@
+package onionclient
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	netdir "onion_routing/netdir"
+)
+
+func guardRelay(identity string, address string) netdir.RelayNode {
+	return netdir.RelayNode{Identity: identity, Address: address, Bandwidth: 1 << 20, Flags: []string{netdir.FlagGuard}}
+}
+
+func guardNetwork(count int) *netdir.Consensus {
+	consensus := &netdir.Consensus{}
+	for i := 0; i < count; i++ {
+		consensus.Relays = append(consensus.Relays, guardRelay(fmt.Sprintf("identity-%d", i), fmt.Sprintf("10.%d.0.1:9001", i)))
+	}
+	return consensus
+}
+
+func guardClient(t *testing.T, opts GuardOptions) *Client {
+	t.Helper()
+	guards, err := loadGuards(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Client{guards: guards}
+}
+
+func guardIdentities(c *Client) []string {
+	var ids []string
+	for _, guard := range c.Guards() {
+		ids = append(ids, guard.Identity)
+	}
+	return ids
+}
+
+func TestGuardsPersist(t *testing.T) {
+	opts := GuardOptions{StateFile: filepath.Join(t.TempDir(), "guards.json"), Count: 2}
+	consensus := guardNetwork(6)
+	c := guardClient(t, opts)
+	first, err := c.pickGuard(consensus, hopRequest{hop: 1, needGuard: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chosen := guardIdentities(c)
+	if len(chosen) != 2 || chosen[0] == chosen[1] {
+		t.Fatalf("guards %v, want two distinct relays", chosen)
+	}
+	if first.Identity != chosen[0] {
+		t.Errorf("picked %s, want the first guard %s", first.Identity, chosen[0])
+	}
+
+	// a restarted client keeps its guards and uses them in the same order
+	c = guardClient(t, opts)
+	if got := guardIdentities(c); fmt.Sprint(got) != fmt.Sprint(chosen) {
+		t.Fatalf("guards after a restart %v, want %v", got, chosen)
+	}
+	for i := 0; i < 10; i++ {
+		node, err := c.pickGuard(consensus, hopRequest{hop: 1, needGuard: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node.Identity != chosen[0] {
+			t.Fatalf("picked %s after a restart, want %s", node.Identity, chosen[0])
+		}
+	}
+
+	// the first guard cannot be used for this circuit: the next one is
+	node, err := c.pickGuard(consensus, hopRequest{hop: 1, needGuard: true, exclude: map[string]bool{first.Address: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Identity != chosen[1] {
+		t.Errorf("picked %s with the first guard excluded, want %s", node.Identity, chosen[1])
+	}
+	if got := guardIdentities(c); fmt.Sprint(got) != fmt.Sprint(chosen) {
+		t.Errorf("guards %v changed because one was excluded, want %v", got, chosen)
+	}
+}
+
+func TestGuardsKeyedByIdentity(t *testing.T) {
+	c := guardClient(t, GuardOptions{Count: 1})
+	if _, err := c.pickGuard(&netdir.Consensus{Relays: []netdir.RelayNode{guardRelay("guard", "10.1.0.1:9001")}}, hopRequest{hop: 1, needGuard: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the guard moved and another relay took its old address
+	consensus := &netdir.Consensus{Relays: []netdir.RelayNode{
+		guardRelay("impostor", "10.1.0.1:9001"),
+		guardRelay("guard", "10.2.0.1:9001"),
+	}}
+	node, err := c.pickGuard(consensus, hopRequest{hop: 1, needGuard: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Identity != "guard" || node.Address != "10.2.0.1:9001" {
+		t.Errorf("picked %s at %s, want the guard at its new address", node.Identity, node.Address)
+	}
+	if guards := c.Guards(); len(guards) != 1 || guards[0].Identity != "guard" || guards[0].Address != "10.2.0.1:9001" {
+		t.Errorf("guards %+v, want the guard at its new address", guards)
+	}
+
+	// a failure of the relay at the old address is not the guard's
+	c.guardResult(guardRelay("impostor", "10.1.0.1:9001"), false)
+	if guards := c.Guards(); !guards[0].FirstFailure.IsZero() {
+		t.Error("a failure of another relay was charged to the guard")
+	}
+	c.guardResult(node, false)
+	if guards := c.Guards(); guards[0].FirstFailure.IsZero() {
+		t.Error("a failure of the guard was not recorded")
+	}
+}
+
+func TestGuardReplacement(t *testing.T) {
+	now := time.Now()
+	opts := GuardOptions{Count: 1, Lifetime: 30 * 24 * time.Hour, UnreachableTimeout: time.Hour}
+	tests := []struct {
+		name     string
+		guard    Guard
+		listed   bool
+		flagged  bool
+		replaced bool
+	}{
+		{"healthy", Guard{Added: now.Add(-time.Hour)}, true, true, false},
+		{"lifetime ended", Guard{Added: now.Add(-31 * 24 * time.Hour)}, true, true, true},
+		{"failing briefly", Guard{Added: now.Add(-time.Hour), FirstFailure: now.Add(-time.Minute)}, true, true, false},
+		{"unreachable too long", Guard{Added: now.Add(-2 * time.Hour), FirstFailure: now.Add(-90 * time.Minute)}, true, true, true},
+		{"recently unlisted", Guard{Added: now.Add(-time.Hour), UnlistedSince: now.Add(-time.Minute)}, false, true, false},
+		{"unlisted past the grace period", Guard{Added: now.Add(-time.Hour), UnlistedSince: now.Add(-time.Hour)}, false, true, true},
+		{"lost the Guard flag", Guard{Added: now.Add(-time.Hour), UnlistedSince: now.Add(-time.Hour)}, true, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := guardClient(t, opts)
+			tt.guard.Identity, tt.guard.Address = "old", "10.1.0.1:9001"
+			c.guards.guards = []Guard{tt.guard}
+			consensus := &netdir.Consensus{Relays: []netdir.RelayNode{guardRelay("new", "10.2.0.1:9001")}}
+			if tt.listed {
+				old := guardRelay("old", "10.1.0.1:9001")
+				if !tt.flagged {
+					old.Flags = nil
+				}
+				consensus.Relays = append(consensus.Relays, old)
+			}
+			// an unlisted guard kept in the set leaves no usable guard
+			c.pickGuard(consensus, hopRequest{hop: 1, needGuard: true})
+			guards := c.Guards()
+			if len(guards) != 1 {
+				t.Fatalf("guards %+v, want one", guards)
+			}
+			// the replacement may be the same relay, chosen again
+			if got := !guards[0].Added.Equal(tt.guard.Added); got != tt.replaced {
+				t.Errorf("guard %+v, replaced = %v, want %v", guards[0], got, tt.replaced)
+			}
+		})
+	}
+}
+
+func TestGuardsWithoutIdentityDropped(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "guards.json")
+	state := `{"guards": [{"address": "10.1.0.1:9001", "added": "2026-01-01T00:00:00Z"},
+		{"identity": "kept", "address": "10.2.0.1:9001", "added": "2026-01-01T00:00:00Z"}]}`
+	if err := os.WriteFile(stateFile, []byte(state), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := guardClient(t, GuardOptions{StateFile: stateFile})
+	if got := guardIdentities(c); len(got) != 1 || got[0] != "kept" {
+		t.Errorf("guards %v, want only the one with an identity", got)
+	}
+}
@@ -0,0 +1,247 @@
+package onionclient
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+
+	netdir "onion_routing/netdir"
+	utils "onion_routing/utils"
+)
+
+// Path constraints keep one party from seeing both ends of a circuit:
+//
+//   - no relay twice
+//   - no two relays in the same IPv4 /16 or IPv6 /32 (relays on loopback
+//     addresses are exempt, so a test network can run on one host)
+//   - no two relays of the same declared family
+//   - the exit's policy must accept the destination
+//
+// Relays that are excluded (avoided, already tried or already in the path)
+// are never picked either, and the first hop must have the Guard flag when
+// the directory flags any relay as a guard.
+
+// hopRequest describes the relay wanted for one position of a path.
+type hopRequest struct {
+	hop         int                // 1 for the first relay
+	exit        bool               // the relay will be the exit
+	destination string             // where the exit connects, checked when exit is set
+	path        []netdir.RelayNode // relays already in the path
+	exclude     map[string]bool    // relays that must not be picked
+	needGuard   bool               // the first hop must have the Guard flag
+}
+
+func (r hopRequest) position() position {
+	switch {
+	case r.exit:
+		return positionExit
+	case r.hop == 1:
+		return positionGuard
+	}
+	return positionMiddle
+}
+
+type position int
+
+const (
+	positionGuard position = iota
+	positionMiddle
+	positionExit
+)
+
+func (p position) String() string {
+	switch p {
+	case positionGuard:
+		return "guard"
+	case positionExit:
+		return "exit"
+	}
+	return "middle"
+}
+
+// positionWeight returns the weight of node for position p, in units of
+// WeightScale.
+func positionWeight(w netdir.BandwidthWeights, p position, node netdir.RelayNode) int {
+	guard, exit := node.HasFlag(netdir.FlagGuard), node.HasFlag(netdir.FlagExit)
+	switch p {
+	case positionGuard:
+		switch {
+		case guard && exit:
+			return w.Wgd
+		case guard:
+			return w.Wgg
+		}
+		return 0
+	case positionExit:
+		switch {
+		case guard && exit:
+			return w.Wed
+		case exit:
+			return w.Wee
+		}
+		return 0
+	}
+	switch {
+	case guard && exit:
+		return w.Wmd
+	case guard:
+		return w.Wmg
+	case exit:
+		return w.Wme
+	}
+	return w.Wmm
+}
+
+// Reasons a relay is not a candidate, in the order they are checked.
+const (
+	rejectExcluded = iota
+	rejectInPath
+	rejectLength
+	rejectGuardFlag
+	rejectSubnet
+	rejectFamily
+	rejectExitPolicy
+	rejectReasons
+)
+
+var rejectDescriptions = [rejectReasons]string{
+	"excluded",
+	"already in the path",
+	"position past max_circuit_length",
+	"no Guard flag",
+	"same subnet as another hop",
+	"same family as another hop",
+	"exit policy rejects the destination",
+}
+
+// check returns why node cannot fill the position, or -1 if it can.
+func (r hopRequest) check(node netdir.RelayNode) int {
+	if r.exclude[node.Address] {
+		return rejectExcluded
+	}
+	for _, other := range r.path {
+		if node.Address == other.Address {
+			return rejectInPath
+		}
+	}
+	if node.MaxCircuitLength > 0 && r.hop > node.MaxCircuitLength {
+		return rejectLength
+	}
+	if r.needGuard && r.hop == 1 && !node.HasFlag(netdir.FlagGuard) {
+		return rejectGuardFlag
+	}
+	subnet := subnetOf(node.Address)
+	for _, other := range r.path {
+		if subnet != "" && subnet == subnetOf(other.Address) {
+			return rejectSubnet
+		}
+	}
+	for _, other := range r.path {
+		if node.Family != "" && node.Family == other.Family {
+			return rejectFamily
+		}
+	}
+	if r.exit && !node.ExitAccepts(r.destination) {
+		return rejectExitPolicy
+	}
+	return -1
+}
+
+// pickRelay picks a relay for the position from the consensus, in proportion
+// to its weighted bandwidth. When no relay qualifies the error says how many
+// relays each rule ruled out.
+func pickRelay(consensus *netdir.Consensus, r hopRequest) (netdir.RelayNode, error) {
+	candidates := []netdir.RelayNode{}
+	var rejected [rejectReasons]int
+	for _, node := range consensus.Relays {
+		if reason := r.check(node); reason >= 0 {
+			rejected[reason]++
+			continue
+		}
+		candidates = append(candidates, node)
+	}
+	if len(candidates) == 0 {
+		return netdir.RelayNode{}, r.noPathError(len(consensus.Relays), rejected)
+	}
+	return weightedChoice(candidates, consensus.Weights, r.position()), nil
+}
+
+func (r hopRequest) noPathError(listed int, rejected [rejectReasons]int) error {
+	role := "hop " + strconv.Itoa(r.hop)
+	if r.exit {
+		role += " (exit to " + r.destination + ")"
+	}
+	reasons := []string{}
+	for reason, count := range rejected {
+		if count > 0 {
+			reasons = append(reasons, fmt.Sprintf("%d %s", count, rejectDescriptions[reason]))
+		}
+	}
+	if len(reasons) == 0 {
+		return fmt.Errorf("%w for %s: the directory lists no relays", utils.ErrNoValidPath, role)
+	}
+	return fmt.Errorf("%w for %s: of %d relays listed, %s", utils.ErrNoValidPath, role, listed, strings.Join(reasons, ", "))
+}
+
+// checkPath applies the constraints to a path chosen by the caller.
+func checkPath(path []netdir.RelayNode, destination string) error {
+	for i, node := range path {
+		r := hopRequest{hop: i + 1, exit: i == len(path)-1, destination: destination, path: path[:i]}
+		if reason := r.check(node); reason >= 0 {
+			return fmt.Errorf("%w: %s as hop %d: %s", utils.ErrNoValidPath, node.Address, i+1, rejectDescriptions[reason])
+		}
+	}
+	return nil
+}
+
+// subnetOf returns the /16 (IPv4) or /32 (IPv6) of the relay's address, the
+// host name for relays listed by name, or "" for loopback relays.
+func subnetOf(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	if host == "localhost" {
+		return ""
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return strings.ToLower(host)
+	}
+	if ip.IsLoopback() {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(16, 32)).String() + "/16"
+	}
+	return ip.Mask(net.CIDRMask(32, 128)).String() + "/32"
+}
+
+// weightedChoice picks one of candidates with probability proportional to
+// its bandwidth times its weight for the position. If the weights rule out
+// every candidate, e.g. an exit is needed but no candidate has the Exit flag,
+// bandwidth alone decides.
+func weightedChoice(candidates []netdir.RelayNode, weights netdir.BandwidthWeights, p position) netdir.RelayNode {
+	values := make([]float64, len(candidates))
+	total := 0.0
+	for i, node := range candidates {
+		values[i] = node.EffectiveBandwidth() * float64(positionWeight(weights, p, node))
+		total += values[i]
+	}
+	if total <= 0 {
+		for i, node := range candidates {
+			values[i] = node.EffectiveBandwidth()
+			total += values[i]
+		}
+	}
+	r := rand.Float64() * total
+	for i, value := range values {
+		r -= value
+		if r < 0 {
+			return candidates[i]
+		}
+	}
+	return candidates[len(candidates)-1]
+}
@@ -0,0 +1,203 @@
+package onionclient
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	netdir "onion_routing/netdir"
+	utils "onion_routing/utils"
+)
+
+func TestCheckPath(t *testing.T) {
+	relay := func(address string) netdir.RelayNode { return netdir.RelayNode{Address: address} }
+	family := func(address, name string) netdir.RelayNode { return netdir.RelayNode{Address: address, Family: name} }
+	exit := func(address string, policy ...string) netdir.RelayNode {
+		return netdir.RelayNode{Address: address, ExitPolicy: policy}
+	}
+	tests := []struct {
+		name        string
+		path        []netdir.RelayNode
+		destination string
+		want        string // "" for a valid path
+	}{
+		{"distinct subnets", []netdir.RelayNode{relay("10.1.0.1:5000"), relay("10.2.0.1:5000"), relay("10.3.0.1:5000")}, "localhost:45034", ""},
+		{"same /16", []netdir.RelayNode{relay("10.1.0.1:5000"), relay("10.2.0.1:5000"), relay("10.1.200.7:5000")}, "localhost:45034", "same subnet"},
+		{"same /32", []netdir.RelayNode{relay("[2001:db8::1]:5000"), relay("[2001:db8:0:1::1]:5000")}, "localhost:45034", "same subnet"},
+		{"different /32", []netdir.RelayNode{relay("[2001:db8::1]:5000"), relay("[2001:db9::1]:5000")}, "localhost:45034", ""},
+		{"same host name", []netdir.RelayNode{relay("relay.example:5000"), relay("relay.example:5001")}, "localhost:45034", "same subnet"},
+		{"loopback exempt", []netdir.RelayNode{relay("127.0.0.1:5001"), relay("127.0.0.1:5002"), relay("localhost:5003")}, "localhost:45034", ""},
+		{"same relay twice", []netdir.RelayNode{relay("127.0.0.1:5001"), relay("127.0.0.1:5002"), relay("127.0.0.1:5001")}, "localhost:45034", "already in the path"},
+		{"same family", []netdir.RelayNode{family("10.1.0.1:5000", "ops"), relay("10.2.0.1:5000"), family("10.3.0.1:5000", "ops")}, "localhost:45034", "same family"},
+		{"different families", []netdir.RelayNode{family("10.1.0.1:5000", "ops"), family("10.2.0.1:5000", "other")}, "localhost:45034", ""},
+		{"exit accepts", []netdir.RelayNode{relay("10.1.0.1:5000"), exit("10.2.0.1:5000", "accept 127.0.0.0/8:45000-45100", "reject *:*")}, "127.0.0.1:45034", ""},
+		{"exit rejects", []netdir.RelayNode{relay("10.1.0.1:5000"), exit("10.2.0.1:5000", "accept *:443", "reject *:*")}, "127.0.0.1:45034", "exit policy"},
+		{"policy of a middle ignored", []netdir.RelayNode{exit("10.1.0.1:5000", "reject *:*"), relay("10.2.0.1:5000")}, "127.0.0.1:45034", ""},
+		{"position past maximum", []netdir.RelayNode{relay("10.1.0.1:5000"), {Address: "10.2.0.1:5000", MaxCircuitLength: 1}}, "localhost:45034", "max_circuit_length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPath(tt.path, tt.destination)
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("checkPath: %v", err)
+			case tt.want != "" && (!errors.Is(err, utils.ErrNoValidPath) || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("checkPath = %v, want %v mentioning %q", err, utils.ErrNoValidPath, tt.want)
+			}
+		})
+	}
+}
+
+func TestWeightedChoice(t *testing.T) {
+	guard := netdir.RelayNode{Address: "10.1.0.1:5000", Bandwidth: 1 << 20, Flags: []string{netdir.FlagGuard}}
+	exit := netdir.RelayNode{Address: "10.2.0.1:5000", Bandwidth: 1 << 20, Flags: []string{netdir.FlagExit}}
+	slow := netdir.RelayNode{Address: "10.3.0.1:5000", Bandwidth: 1 << 20}
+	fast := netdir.RelayNode{Address: "10.4.0.1:5000", Bandwidth: 3 << 20}
+	weights := netdir.BandwidthWeights{Wgg: netdir.WeightScale, Wmm: netdir.WeightScale, Wee: netdir.WeightScale}
+	const draws = 10000
+
+	count := func(candidates []netdir.RelayNode, p position, w netdir.BandwidthWeights) map[string]int {
+		picked := make(map[string]int)
+		for i := 0; i < draws; i++ {
+			picked[weightedChoice(candidates, w, p).Address]++
+		}
+		return picked
+	}
+
+	if picked := count([]netdir.RelayNode{guard, exit, slow}, positionGuard, weights); picked[guard.Address] != draws {
+		t.Errorf("guard position picked %v, want only the guard", picked)
+	}
+	if picked := count([]netdir.RelayNode{guard, exit, slow}, positionExit, weights); picked[exit.Address] != draws {
+		t.Errorf("exit position picked %v, want only the exit", picked)
+	}
+	// Wmg and Wme are 0: scarce guards and exits stay out of the middle
+	if picked := count([]netdir.RelayNode{guard, exit, slow}, positionMiddle, weights); picked[slow.Address] != draws {
+		t.Errorf("middle position picked %v, want only the unflagged relay", picked)
+	}
+	picked := count([]netdir.RelayNode{slow, fast}, positionMiddle, weights)
+	if share := float64(picked[fast.Address]) / draws; share < 0.72 || share > 0.78 {
+		t.Errorf("relay with 3/4 of the bandwidth picked %.3f of the time", share)
+	}
+	// no candidate has a weight for the exit position: bandwidth decides
+	picked = count([]netdir.RelayNode{slow, fast}, positionExit, weights)
+	if share := float64(picked[fast.Address]) / draws; share < 0.72 || share > 0.78 {
+		t.Errorf("without exit weights the faster relay was picked %.3f of the time", share)
+	}
+}
+
+// testNetwork makes relays with log-uniform bandwidths between 50 KB/s and
+// 10 MB/s and uptimes of up to 30 days, each in its own /16, like pathsim.
+func testNetwork(count int, exitFraction float64, seed int64, now time.Time) []netdir.RelayNode {
+	rng := rand.New(rand.NewSource(seed))
+	nodes := make([]netdir.RelayNode, count)
+	for i := range nodes {
+		nodes[i] = netdir.RelayNode{
+			Address:      fmt.Sprintf("10.%d.0.1:9001", i),
+			Bandwidth:    int64(50 * 1024 * math.Pow(200, rng.Float64())),
+			StartedAt:    now.Add(-time.Duration(rng.Float64() * float64(30*24*time.Hour))),
+			AllowStreams: rng.Float64() < exitFraction,
+		}
+	}
+	return nodes
+}
+
+func relayClass(node netdir.RelayNode) string {
+	guard, exit := node.HasFlag(netdir.FlagGuard), node.HasFlag(netdir.FlagExit)
+	switch {
+	case guard && exit:
+		return "Guard+Exit"
+	case guard:
+		return "Guard"
+	case exit:
+		return "Exit"
+	}
+	return "Middle"
+}
+
+// positionShares returns each class's share of the picks per position.
+func positionShares(loads []RelayLoad) map[position]map[string]float64 {
+	picks := map[position]map[string]float64{positionGuard: {}, positionMiddle: {}, positionExit: {}}
+	totals := map[position]float64{}
+	for _, load := range loads {
+		class := relayClass(load.Relay)
+		for p, count := range map[position]int{positionGuard: load.Guard, positionMiddle: load.Middle, positionExit: load.Exit} {
+			picks[p][class] += float64(count)
+			totals[p] += float64(count)
+		}
+	}
+	for p, classes := range picks {
+		for class := range classes {
+			classes[class] /= totals[p]
+		}
+	}
+	return picks
+}
+
+func TestSimulateLoadFollowsWeights(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	consensus := netdir.NewConsensus(testNetwork(60, 0.1, 1, now), now)
+	w := consensus.Weights
+	if w.Wme != 0 || w.Wmd != 0 {
+		t.Fatalf("exits are scarce in the test network, want Wme = Wmd = 0, got %+v", w)
+	}
+
+	loads, err := SimulateLoad(consensus, 3, 8000, "example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the share of each class in each position is its bandwidth times its
+	// weight for the position, relative to all classes
+	expected := map[position]map[string]float64{positionGuard: {}, positionMiddle: {}, positionExit: {}}
+	for p, classes := range expected {
+		total := 0.0
+		for _, node := range consensus.Relays {
+			v := node.EffectiveBandwidth() * float64(positionWeight(w, p, node))
+			classes[relayClass(node)] += v
+			total += v
+		}
+		for class := range classes {
+			classes[class] /= total
+		}
+	}
+	observed := positionShares(loads)
+	for p, classes := range expected {
+		for class, share := range classes {
+			if got := observed[p][class]; math.Abs(got-share) > 0.05 {
+				t.Errorf("%s share of %s picks = %.3f, weights give %.3f", class, p, got, share)
+			}
+		}
+	}
+
+	for _, load := range loads {
+		if load.Relay.HasFlag(netdir.FlagExit) && load.Middle > 0 {
+			t.Errorf("exit %s picked %d times as a middle while exits are scarce", load.Relay.Address, load.Middle)
+		}
+		if !load.Relay.HasFlag(netdir.FlagGuard) && load.Guard > 0 {
+			t.Errorf("%s without the Guard flag picked %d times as a guard", load.Relay.Address, load.Guard)
+		}
+		if !load.Relay.HasFlag(netdir.FlagExit) && load.Exit > 0 {
+			t.Errorf("%s without the Exit flag picked %d times as an exit", load.Relay.Address, load.Exit)
+		}
+	}
+
+	// choosing by bandwidth alone spends exit capacity on middle hops
+	flat := *consensus
+	s := netdir.WeightScale
+	flat.Weights = netdir.BandwidthWeights{Wgg: s, Wgd: s, Wmg: s, Wmm: s, Wme: s, Wmd: s, Wee: s, Wed: s}
+	unweighted, err := SimulateLoad(&flat, 3, 8000, "example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flatShares := positionShares(unweighted)
+	if exits := flatShares[positionMiddle]["Exit"] + flatShares[positionMiddle]["Guard+Exit"]; exits < 0.02 {
+		t.Errorf("bandwidth-only selection put exits in %.3f of middle positions, want a visible share", exits)
+	}
+	if diff := math.Abs(flatShares[positionMiddle]["Guard"] - observed[positionMiddle]["Guard"]); diff < 0.05 {
+		t.Errorf("weighted and bandwidth-only selection give guards nearly the same middle share (%.3f apart)", diff)
+	}
+}
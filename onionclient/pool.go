@@ -0,0 +1,436 @@
+package onionclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	netdir "onion_routing/netdir"
+	utils "onion_routing/utils"
+)
+
+// PoolOptions controls how many circuits a Pool keeps ready and when they are
+// retired. Zero values select the defaults.
+type PoolOptions struct {
+	Size    int           // circuits kept ready for new requests and destinations (default 2)
+	MaxAge  time.Duration // retire circuits this old (default 10m)
+	MaxUses int           // retire circuits after this many requests and streams (default 100)
+	MaxIdle time.Duration // retire circuits unused this long (default 5m); until then they are kept alive
+}
+
+func (o PoolOptions) withDefaults() PoolOptions {
+	if o.Size <= 0 {
+		o.Size = 2
+	}
+	if o.MaxAge <= 0 {
+		o.MaxAge = 10 * time.Minute
+	}
+	if o.MaxUses <= 0 {
+		o.MaxUses = 100
+	}
+	if o.MaxIdle <= 0 {
+		o.MaxIdle = 5 * time.Minute
+	}
+	return o
+}
+
+type pooledCircuit struct {
+	circuit     *Circuit
+	built       time.Time
+	lastUsed    time.Time
+	uses        int
+	active      int    // requests in flight and open streams
+	destination string // streams to this address use the circuit; "" for requests
+	retired     bool
+	keepalive   bool // a keepalive is in flight
+}
+
+// Pool keeps circuits built ahead of time. Requests share the general
+// circuits; streams get a circuit per destination, so connections to
+// different destinations do not share a path. Circuits are retired by age,
+// use count and idleness, and replacements are built in the background.
+// Circuits the pool keeps are sent keepalive cells before the relays' idle
+// timeout runs out.
+type Pool struct {
+	client *Client
+	opts   PoolOptions
+
+	lock     sync.Mutex
+	circuits []*pooledCircuit
+	building int
+	closed   bool
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+// PoolCircuit describes a circuit held by a Pool.
+type PoolCircuit struct {
+	ID          uint16
+	Path        []string
+	Relays      []netdir.RelayNode
+	Age         time.Duration
+	Uses        int
+	Active      int
+	Destination string
+	IdleTimeout time.Duration // shortest idle timeout granted by the relays
+	ExpiresIn   time.Duration // until the relays forget the circuit unless it is used
+	SendWindow  int           // data cells that may be sent before the exit's next SENDME
+}
+
+func (c *Client) NewPool(opts PoolOptions) *Pool {
+	p := &Pool{
+		client: c,
+		opts:   opts.withDefaults(),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go p.maintain()
+	return p
+}
+
+// Warm builds circuits until one is ready, retrying like Do, and returns the
+// build error if none can be built.
+func (p *Pool) Warm(ctx context.Context) error {
+	return p.retry(ctx, "", func(pc *pooledCircuit) error {
+		p.lock.Lock()
+		pc.uses--
+		p.lock.Unlock()
+		p.release(pc)
+		return nil
+	})
+}
+
+// Do sends a request over one of the general circuits. When the circuit
+// fails because a relay went away or forgot the circuit, the request is
+// retried on another circuit, avoiding the failed relay, until the client's
+// retry timeout.
+func (p *Pool) Do(ctx context.Context, reqType byte, message []byte) ([]byte, error) {
+	ctx, cancel := p.client.requestContext(ctx)
+	defer cancel()
+	var reply []byte
+	err := p.retry(ctx, "", func(pc *pooledCircuit) error {
+		var err error
+		reply, err = pc.circuit.Do(ctx, reqType, message)
+		p.release(pc)
+		return err
+	})
+	return reply, err
+}
+
+// Dial opens a stream to address over the circuit for that destination,
+// retrying like Do.
+func (p *Pool) Dial(ctx context.Context, network string, address string) (net.Conn, error) {
+	ctx, cancel := p.client.requestContext(ctx)
+	defer cancel()
+	var conn net.Conn
+	err := p.retry(ctx, address, func(pc *pooledCircuit) error {
+		stream, err := pc.circuit.Dial(ctx, network, address)
+		if err != nil {
+			p.release(pc)
+			return err
+		}
+		conn = &pooledConn{Conn: stream, release: sync.OnceFunc(func() { p.release(pc) })}
+		return nil
+	})
+	return conn, err
+}
+
+// retry runs attempt on circuits for destination until it succeeds, fails
+// for a reason another circuit would not fix, or the retry timeout passes.
+// attempt releases the circuit unless it keeps it.
+func (p *Pool) retry(ctx context.Context, destination string, attempt func(*pooledCircuit) error) error {
+	deadline := time.Now().Add(p.client.retryTimeout)
+	for i := 1; ; i++ {
+		pc, err := p.acquire(ctx, destination)
+		if err == nil {
+			err = attempt(pc)
+			if err == nil {
+				return nil
+			}
+			if retryable(err) {
+				p.discard(pc)
+			}
+		}
+		if !retryable(err) || ctx.Err() != nil || time.Now().After(deadline) {
+			return err
+		}
+		event := Event{Type: EventRequestRetried, Attempt: i, Err: err}
+		if pc != nil {
+			event.CircuitID = pc.circuit.ID()
+		}
+		var hopErr *HopError
+		if errors.As(err, &hopErr) {
+			event.Hop, event.Relay = hopErr.Hop, hopErr.Relay
+		}
+		p.client.emit(event)
+		if pc == nil {
+			// building failed, give the directory and relays a moment
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(500 * time.Millisecond):
+			}
+		}
+	}
+}
+
+// retryable reports whether a new circuit may succeed where this one failed.
+// Paths ruled out by the path constraints stay ruled out.
+func retryable(err error) bool {
+	if errors.Is(err, utils.ErrNoValidPath) {
+		return false
+	}
+	var hopErr *HopError
+	return errors.As(err, &hopErr) || errors.Is(err, utils.ErrBuildTimeout) || utils.IsEqual(err, utils.ErrCircuitNotFound)
+}
+
+// Circuits lists the pool's circuits, oldest first.
+func (p *Pool) Circuits() []PoolCircuit {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := time.Now()
+	list := []PoolCircuit{}
+	for _, pc := range p.circuits {
+		list = append(list, PoolCircuit{
+			ID:          pc.circuit.ID(),
+			Path:        pc.circuit.Addresses(),
+			Relays:      pc.circuit.Path(),
+			Age:         now.Sub(pc.built),
+			Uses:        pc.uses,
+			Active:      pc.active,
+			Destination: pc.destination,
+			IdleTimeout: pc.circuit.IdleTimeout(),
+			ExpiresIn:   max(pc.circuit.ExpiresAt().Sub(now), 0),
+			SendWindow:  pc.circuit.SendWindow(),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Age > list[j].Age })
+	return list
+}
+
+// Close stops background building and closes every circuit.
+func (p *Pool) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	for _, pc := range p.circuits {
+		pc.circuit.Close()
+	}
+	p.circuits = nil
+	return nil
+}
+
+// acquire hands out a circuit for destination ("" for requests), building
+// one in the foreground if none is ready.
+func (p *Pool) acquire(ctx context.Context, destination string) (*pooledCircuit, error) {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return nil, utils.ErrCircuitClosed
+	}
+	now := time.Now()
+	p.retireLocked(now)
+	var chosen *pooledCircuit
+	for _, pc := range p.circuits {
+		if pc.retired || pc.destination != destination {
+			continue
+		}
+		if chosen == nil || pc.uses < chosen.uses {
+			chosen = pc
+		}
+	}
+	if chosen == nil && destination != "" {
+		// claim a general circuit for the destination; maintain replaces it
+		for _, pc := range p.circuits {
+			if !pc.retired && pc.destination == "" && pc.circuit.exitAccepts(destination) {
+				pc.destination = destination
+				chosen = pc
+				break
+			}
+		}
+	}
+	if chosen != nil {
+		p.useLocked(chosen, now)
+		p.lock.Unlock()
+		p.signal()
+		return chosen, nil
+	}
+	p.lock.Unlock()
+
+	target := destination
+	if target == "" {
+		target = p.client.serverAddr
+	}
+	circuit, err := p.client.BuildCircuitTo(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	pc := &pooledCircuit{circuit: circuit, built: time.Now(), destination: destination}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		circuit.Close()
+		return nil, utils.ErrCircuitClosed
+	}
+	p.circuits = append(p.circuits, pc)
+	p.useLocked(pc, time.Now())
+	return pc, nil
+}
+
+func (p *Pool) useLocked(pc *pooledCircuit, now time.Time) {
+	pc.uses++
+	pc.active++
+	pc.lastUsed = now
+	if pc.uses >= p.opts.MaxUses {
+		pc.retired = true
+	}
+}
+
+func (p *Pool) release(pc *pooledCircuit) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	pc.active--
+	pc.lastUsed = time.Now()
+	p.retireLocked(pc.lastUsed)
+}
+
+// discard retires a circuit that failed.
+func (p *Pool) discard(pc *pooledCircuit) {
+	p.lock.Lock()
+	pc.retired = true
+	p.retireLocked(time.Now())
+	p.lock.Unlock()
+	p.signal()
+}
+
+// retireLocked marks circuits past their age or idle limit, or forgotten by
+// the relays, as retired and closes retired circuits nobody is using.
+func (p *Pool) retireLocked(now time.Time) {
+	kept := p.circuits[:0]
+	for _, pc := range p.circuits {
+		if now.Sub(pc.built) > p.opts.MaxAge || (pc.active == 0 && now.Sub(pc.lastUsed) > p.opts.MaxIdle) || now.After(pc.circuit.ExpiresAt()) || p.usesBadRelay(pc) {
+			pc.retired = true
+		}
+		if pc.retired && pc.active == 0 {
+			pc.circuit.Close()
+			p.client.logf("Retired circuit %d after %d uses", pc.circuit.ID(), pc.uses)
+			continue
+		}
+		kept = append(kept, pc)
+	}
+	p.circuits = kept
+}
+
+func (p *Pool) usesBadRelay(pc *pooledCircuit) bool {
+	for _, relay := range pc.circuit.Addresses() {
+		if p.client.isBad(relay) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Pool) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// maintain keeps Size general circuits ready and the pool's circuits alive.
+func (p *Pool) maintain() {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		case <-p.wake:
+		}
+
+		p.lock.Lock()
+		p.retireLocked(time.Now())
+		ready := p.building
+		for _, pc := range p.circuits {
+			if !pc.retired && pc.destination == "" {
+				ready++
+			}
+		}
+		missing := p.opts.Size - ready
+		p.building += max(missing, 0)
+		for _, pc := range p.circuits {
+			if !pc.retired && !pc.keepalive && time.Until(pc.circuit.ExpiresAt()) < pc.circuit.IdleTimeout()/2 {
+				pc.keepalive = true
+				go p.keepAlive(pc)
+			}
+		}
+		p.lock.Unlock()
+
+		for i := 0; i < missing; i++ {
+			go p.buildInBackground()
+		}
+	}
+}
+
+func (p *Pool) buildInBackground() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	circuit, err := p.client.BuildCircuit(ctx)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.building--
+	if err != nil {
+		p.client.logf("Building a pool circuit failed: %v", err)
+		return
+	}
+	if p.closed {
+		circuit.Close()
+		return
+	}
+	now := time.Now()
+	p.circuits = append(p.circuits, &pooledCircuit{circuit: circuit, built: now, lastUsed: now})
+}
+
+// keepAlive sends a keepalive on a circuit nearing its idle timeout and
+// retires the circuit if it fails.
+func (p *Pool) keepAlive(pc *pooledCircuit) {
+	ctx, cancel := context.WithDeadline(context.Background(), pc.circuit.ExpiresAt())
+	defer cancel()
+	remaining, err := pc.circuit.Keepalive(ctx)
+
+	p.lock.Lock()
+	pc.keepalive = false
+	if err != nil {
+		p.client.logf("Keepalive on circuit %d failed: %v", pc.circuit.ID(), err)
+		pc.retired = true
+		p.retireLocked(time.Now())
+	} else {
+		p.client.logf("Circuit %d kept alive, expires in %v", pc.circuit.ID(), remaining)
+	}
+	p.lock.Unlock()
+	if err != nil {
+		p.signal()
+	}
+}
+
+// pooledConn returns its circuit to the pool when closed.
+type pooledConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *pooledConn) Close() error {
+	err := c.Conn.Close()
+	c.release()
+	return err
+}
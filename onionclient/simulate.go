@@ -0,0 +1,54 @@
+package onionclient
+
+import netdir "onion_routing/netdir"
+
+// RelayLoad counts how often a relay was picked for each position in a
+// simulation.
+type RelayLoad struct {
+	Relay  netdir.RelayNode
+	Guard  int
+	Middle int
+	Exit   int
+}
+
+func (l RelayLoad) Total() int {
+	return l.Guard + l.Middle + l.Exit
+}
+
+// SimulateLoad picks paths of length hops to destination for the given number
+// of circuits the way BuildCircuitTo does, without building them and without
+// entry guards (which pin each client's first hop, but across many clients
+// are chosen with the same guard weights). It returns the picks per relay, in
+// consensus order.
+func SimulateLoad(consensus *netdir.Consensus, hops int, circuits int, destination string) ([]RelayLoad, error) {
+	index := make(map[string]int, len(consensus.Relays))
+	loads := make([]RelayLoad, len(consensus.Relays))
+	for i, node := range consensus.Relays {
+		index[node.Address] = i
+		loads[i].Relay = node
+	}
+	needGuard := consensus.HasFlag(netdir.FlagGuard)
+	for i := 0; i < circuits; i++ {
+		path := []netdir.RelayNode{}
+		exclude := map[string]bool{}
+		for hop := 1; hop <= hops; hop++ {
+			r := hopRequest{hop: hop, exit: hop == hops, destination: destination, path: path, exclude: exclude, needGuard: needGuard}
+			node, err := pickRelay(consensus, r)
+			if err != nil {
+				return loads, err
+			}
+			path = append(path, node)
+			exclude[node.Address] = true
+			load := &loads[index[node.Address]]
+			switch r.position() {
+			case positionGuard:
+				load.Guard++
+			case positionExit:
+				load.Exit++
+			default:
+				load.Middle++
+			}
+		}
+	}
+	return loads, nil
+}
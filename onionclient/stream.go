@@ -0,0 +1,378 @@
+package onionclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	encryption "onion_routing/encryption"
+	utils "onion_routing/utils"
+)
+
+// Streams are TCP connections opened by the exit relay. Every relay call is
+// one request and one response, so the exit buffers what the destination
+// sends until the client's next DATA cell, and an idle stream polls the exit
+// with empty DATA cells, backing off while nothing arrives.
+
+const (
+	minPollInterval = 20 * time.Millisecond
+	maxPollInterval = 500 * time.Millisecond
+	maxCellData     = 32 * 1024
+	maxStreams      = 64 // as many requests as exits serve at once, plus TCP streams
+	maxReadBuffered = 256 * 1024
+	endTimeout      = 5 * time.Second
+	// pollTimeout is how long the exit may take to answer a poll before the
+	// stream is given up.
+	pollTimeout = 30 * time.Second
+)
+
+type streamAddr string
+
+func (a streamAddr) Network() string { return "onion" }
+func (a streamAddr) String() string  { return string(a) }
+
+// Dial opens a TCP connection from the circuit's exit relay to address
+// ("host:port"; host names are resolved by the exit). Connecting is bounded
+// like a request by the client's request timeout.
+func (ci *Circuit) Dial(ctx context.Context, network string, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("onionclient: unsupported network %q", network)
+	}
+	if !ci.exitAccepts(address) {
+		return nil, fmt.Errorf("%w: %s", utils.ErrExitPolicy, address)
+	}
+	streamID, err := ci.openStream()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := ci.client.requestContext(ctx)
+	defer cancel()
+	reply, err := ci.relay(ctx, encryption.RelayCell{Command: encryption.RELAY_BEGIN, StreamID: streamID, Data: []byte(address)})
+	if err != nil && ctx.Err() != nil {
+		// the exit may connect after all
+		go ci.endStream(streamID)
+		return nil, err
+	}
+	if err != nil {
+		ci.closeStream(streamID)
+		return nil, err
+	}
+	if reply.Command != encryption.RELAY_CONNECTED {
+		ci.closeStream(streamID)
+		return nil, fmt.Errorf("%w: %s", utils.ErrStreamRefused, reply.Data)
+	}
+	ci.client.logf("Stream %d on circuit %d connected to %s", streamID, ci.id, address)
+
+	s := &streamConn{
+		circuit:  ci,
+		streamID: streamID,
+		remote:   streamAddr(address),
+		activity: make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	s.readable = sync.NewCond(&s.lock)
+	go s.pollLoop()
+	return s, nil
+}
+
+// exitAccepts reports whether the circuit's exit may connect to address.
+func (ci *Circuit) exitAccepts(address string) bool {
+	if len(ci.path) == 0 {
+		return false
+	}
+	return ci.path[len(ci.path)-1].ExitAccepts(address)
+}
+
+// relay sends a relay cell to the exit and returns the exit's reply, which
+// must belong to the same stream. Only an END for stream 0, sent when the
+// exit could not parse the cell, may come back on another. Data cells wait
+// for room in the SENDME windows, and the SENDMEs due to the exit go along
+// with the cell.
+func (ci *Circuit) relay(ctx context.Context, cell encryption.RelayCell) (encryption.RelayCell, error) {
+	flow := ci.flowControl()
+	if encryption.IsDataCell(cell) {
+		if err := flow.Take(ctx, cell.StreamID); err != nil {
+			return encryption.RelayCell{}, err
+		}
+	}
+	sendmes := flow.Sendmes()
+	data, err := ci.send(ctx, encryption.STREAM_REQUEST, encryption.AppendRelayCells(encryption.BuildRelayCell(cell), sendmes))
+	if err != nil {
+		flow.Requeue(sendmes)
+		return encryption.RelayCell{}, err
+	}
+	reply, replySendmes, err := encryption.ParseRelayCells(data)
+	if err != nil {
+		return encryption.RelayCell{}, err
+	}
+	if reply.StreamID != cell.StreamID && !(reply.Command == encryption.RELAY_END && reply.StreamID == 0) {
+		return encryption.RelayCell{}, fmt.Errorf("%w: reply for stream %d on stream %d", utils.ErrInvalidCell, reply.StreamID, cell.StreamID)
+	}
+	if err := flow.Acknowledge(replySendmes); err != nil {
+		ci.Close()
+		return encryption.RelayCell{}, fmt.Errorf("%w: %v", utils.ErrInvalidCell, err)
+	}
+	if err := flow.Deliver(reply); err != nil {
+		ci.Close()
+		return encryption.RelayCell{}, fmt.Errorf("%w: %v", utils.ErrInvalidCell, err)
+	}
+	return reply, nil
+}
+
+// endError turns a reply other than the expected one into an error wrapping
+// fallback, or the exit policy error the exit named.
+func endError(reply encryption.RelayCell, fallback error) error {
+	reason := string(reply.Data)
+	if reply.Command != encryption.RELAY_END {
+		reason = fmt.Sprintf("unexpected relay command %d", reply.Command)
+	}
+	if reason == utils.ErrExitPolicy.Error() {
+		return utils.ErrExitPolicy
+	}
+	if reason == utils.ErrDeadlineExceeded.Error() {
+		return fmt.Errorf("%w at the exit", utils.ErrDeadlineExceeded)
+	}
+	return fmt.Errorf("%w: %s", fallback, reason)
+}
+
+type streamConn struct {
+	circuit  *Circuit
+	streamID uint16
+	remote   streamAddr
+
+	// sendLock keeps one cell of the stream in flight at a time, so that data
+	// is collected at the exit in the order it is delivered here.
+	sendLock sync.Mutex
+
+	lock          sync.Mutex
+	readable      *sync.Cond
+	buffer        []byte
+	ended         bool  // the exit closed the stream
+	endErr        error // why, nil for a clean close
+	closed        bool  // Close was called
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	activity chan struct{}
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func (s *streamConn) notify() {
+	select {
+	case s.activity <- struct{}{}:
+	default:
+	}
+}
+
+func (s *streamConn) finish(err error) {
+	s.lock.Lock()
+	if !s.ended {
+		s.ended = true
+		s.endErr = err
+	}
+	s.readable.Broadcast()
+	s.lock.Unlock()
+	s.doneOnce.Do(func() { close(s.done) })
+	s.circuit.closeStream(s.streamID)
+}
+
+// exchange sends one DATA cell and buffers the reply's data.
+func (s *streamConn) exchange(ctx context.Context, data []byte) (int, error) {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+	s.lock.Lock()
+	ended, endErr := s.ended, s.endErr
+	s.lock.Unlock()
+	if ended {
+		if endErr == nil {
+			endErr = io.ErrClosedPipe
+		}
+		return 0, endErr
+	}
+
+	reply, err := s.circuit.relay(ctx, encryption.RelayCell{Command: encryption.RELAY_DATA, StreamID: s.streamID, Data: data})
+	if err != nil {
+		if ctx.Err() == nil {
+			s.finish(err)
+		}
+		return 0, err
+	}
+	if reply.Command == encryption.RELAY_END {
+		var endErr error
+		if len(reply.Data) > 0 {
+			endErr = errors.New(string(reply.Data))
+		}
+		s.finish(endErr)
+		if endErr == nil {
+			endErr = io.ErrClosedPipe
+		}
+		return 0, endErr
+	}
+	s.lock.Lock()
+	s.buffer = append(s.buffer, reply.Data...)
+	s.readable.Broadcast()
+	s.lock.Unlock()
+	return len(reply.Data), nil
+}
+
+func (s *streamConn) pollLoop() {
+	interval := minPollInterval
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.activity:
+			interval = minPollInterval
+		case <-time.After(interval):
+		}
+		s.lock.Lock()
+		full := len(s.buffer) >= maxReadBuffered
+		s.lock.Unlock()
+		if full {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), pollTimeout)
+		n, err := s.exchange(ctx, nil)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				s.finish(fmt.Errorf("%w: the exit did not answer a poll within %v", utils.ErrDeadlineExceeded, pollTimeout))
+			}
+			return
+		}
+		if n > 0 {
+			interval = minPollInterval
+		} else {
+			interval = min(2*interval, maxPollInterval)
+		}
+	}
+}
+
+func (s *streamConn) Read(b []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for len(s.buffer) == 0 && !s.ended && !s.closed {
+		if !s.readDeadline.IsZero() {
+			wait := time.Until(s.readDeadline)
+			if wait <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			}
+			timer := time.AfterFunc(wait, func() {
+				s.lock.Lock()
+				s.readable.Broadcast()
+				s.lock.Unlock()
+			})
+			s.readable.Wait()
+			timer.Stop()
+			continue
+		}
+		s.readable.Wait()
+	}
+	if s.closed {
+		return 0, net.ErrClosed
+	}
+	if len(s.buffer) == 0 {
+		if s.endErr != nil {
+			return 0, s.endErr
+		}
+		return 0, io.EOF
+	}
+	wasFull := len(s.buffer) >= maxReadBuffered
+	n := copy(b, s.buffer)
+	s.buffer = s.buffer[n:]
+	if wasFull {
+		s.notify()
+	}
+	return n, nil
+}
+
+func (s *streamConn) Write(b []byte) (int, error) {
+	s.lock.Lock()
+	closed, deadline := s.closed, s.writeDeadline
+	s.lock.Unlock()
+	if closed {
+		return 0, net.ErrClosed
+	}
+	ctx := context.Background()
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	written := 0
+	for written < len(b) {
+		chunk := b[written:min(len(b), written+maxCellData)]
+		if _, err := s.exchange(ctx, chunk); err != nil {
+			if ctx.Err() != nil {
+				err = os.ErrDeadlineExceeded
+			}
+			return written, err
+		}
+		written += len(chunk)
+	}
+	s.notify()
+	return written, nil
+}
+
+// Close ends the stream at the exit.
+func (s *streamConn) Close() error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.closed = true
+	ended := s.ended
+	s.readable.Broadcast()
+	s.lock.Unlock()
+	s.doneOnce.Do(func() { close(s.done) })
+	if ended {
+		return nil
+	}
+
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), endTimeout)
+	defer cancel()
+	_, err := s.circuit.relay(ctx, encryption.RelayCell{Command: encryption.RELAY_END, StreamID: s.streamID})
+	s.circuit.closeStream(s.streamID)
+	s.circuit.client.logf("Stream %d on circuit %d to %s closed", s.streamID, s.circuit.id, s.remote)
+	return err
+}
+
+func (s *streamConn) LocalAddr() net.Addr {
+	return streamAddr(fmt.Sprintf("circuit-%d/stream-%d", s.circuit.id, s.streamID))
+}
+
+func (s *streamConn) RemoteAddr() net.Addr {
+	return s.remote
+}
+
+func (s *streamConn) SetDeadline(t time.Time) error {
+	s.SetReadDeadline(t)
+	return s.SetWriteDeadline(t)
+}
+
+func (s *streamConn) SetReadDeadline(t time.Time) error {
+	s.lock.Lock()
+	s.readDeadline = t
+	s.readable.Broadcast()
+	s.lock.Unlock()
+	return nil
+}
+
+func (s *streamConn) SetWriteDeadline(t time.Time) error {
+	s.lock.Lock()
+	s.writeDeadline = t
+	s.lock.Unlock()
+	return nil
+}
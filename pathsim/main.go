@@ -0,0 +1,195 @@
+// pathsim shows how clients spread circuits over relays with the directory's
+// flags and bandwidth weights. It simulates a generated network, or the relays
+// currently registered in etcd, and compares the weighted selection with
+// choosing every position by bandwidth alone.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	encryption "onion_routing/encryption"
+	netdir "onion_routing/netdir"
+	onionclient "onion_routing/onionclient"
+)
+
+func main() {
+	relays := flag.Int("relays", 40, "relays in the generated network")
+	exitFraction := flag.Float64("exit-fraction", 0.2, "fraction of generated relays that allow exit streams")
+	circuits := flag.Int("circuits", 100000, "circuits to simulate")
+	hops := flag.Int("hops", 3, "relays per circuit")
+	destination := flag.String("destination", "example.com:443", "destination the exits must accept")
+	seed := flag.Int64("seed", 1, "seed for the generated network")
+	useEtcd := flag.Bool("etcd", false, "simulate the relays registered in etcd instead of a generated network")
+	authorityKeys := flag.String("directory-authorities", "", "comma separated base64 public keys of the directory authorities whose countersignatures relays in etcd need (required with -etcd)")
+	perRelay := flag.Bool("v", false, "also print every relay")
+	flag.Parse()
+
+	var consensus *netdir.Consensus
+	if *useEtcd {
+		if *authorityKeys == "" {
+			log.Fatalf("-etcd needs -directory-authorities")
+		}
+		directory := netdir.NewEtcdDirectory()
+		for _, key := range strings.Split(*authorityKeys, ",") {
+			authority, err := encryption.ParseIdentityKey(strings.TrimSpace(key))
+			if err != nil {
+				log.Fatalf("Invalid -directory-authorities: %v", err)
+			}
+			directory.Authorities = append(directory.Authorities, authority)
+		}
+		defer directory.Close()
+		var err error
+		consensus, err = directory.Consensus(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to fetch relays: %v", err)
+		}
+	} else {
+		consensus = netdir.NewConsensus(generateNetwork(*relays, *exitFraction, rand.New(rand.NewSource(*seed))), time.Now())
+	}
+
+	w := consensus.Weights
+	fmt.Printf("Bandwidth weights (of %d): Wgg=%d Wgd=%d Wmg=%d Wmm=%d Wme=%d Wmd=%d Wee=%d Wed=%d\n\n",
+		netdir.WeightScale, w.Wgg, w.Wgd, w.Wmg, w.Wmm, w.Wme, w.Wmd, w.Wee, w.Wed)
+
+	weighted, err := onionclient.SimulateLoad(consensus, *hops, *circuits, *destination)
+	if err != nil {
+		log.Fatalf("Simulation failed: %v", err)
+	}
+	flat := *consensus
+	s := netdir.WeightScale
+	flat.Weights = netdir.BandwidthWeights{Wgg: s, Wgd: s, Wmg: s, Wmm: s, Wme: s, Wmd: s, Wee: s, Wed: s}
+	unweighted, err := onionclient.SimulateLoad(&flat, *hops, *circuits, *destination)
+	if err != nil {
+		log.Fatalf("Simulation failed: %v", err)
+	}
+
+	fmt.Println("Position weights:")
+	printClasses(weighted)
+	fmt.Println("\nBandwidth only:")
+	printClasses(unweighted)
+	if *perRelay {
+		fmt.Println("\nPer relay (position weights):")
+		printRelays(weighted)
+	}
+}
+
+// generateNetwork makes relays with log-uniform bandwidths between 50 KB/s
+// and 10 MB/s and uptimes of up to 30 days, each in its own /16.
+func generateNetwork(count int, exitFraction float64, rng *rand.Rand) []netdir.RelayNode {
+	now := time.Now()
+	nodes := make([]netdir.RelayNode, count)
+	for i := range nodes {
+		bandwidth := 50 * 1024 * math.Pow(200, rng.Float64())
+		startedAt := now.Add(-time.Duration(rng.Float64() * float64(30*24*time.Hour)))
+		nodes[i] = netdir.RelayNode{
+			Address:      fmt.Sprintf("10.%d.0.1:9001", i),
+			Bandwidth:    int64(bandwidth),
+			StartedAt:    startedAt,
+			FirstSeen:    startedAt.Add(-time.Duration(rng.Float64() * float64(30*24*time.Hour))),
+			AllowStreams: rng.Float64() < exitFraction,
+		}
+	}
+	return nodes
+}
+
+func relayClass(node netdir.RelayNode) string {
+	guard, exit := node.HasFlag(netdir.FlagGuard), node.HasFlag(netdir.FlagExit)
+	switch {
+	case guard && exit:
+		return "Guard+Exit"
+	case guard:
+		return "Guard"
+	case exit:
+		return "Exit"
+	}
+	return "Middle"
+}
+
+// printClasses shows for each class of relay its share of the bandwidth, its
+// share of the picks per position, and how loaded it is: picks per unit of
+// bandwidth relative to the network average (1.00 is a fair share).
+func printClasses(loads []onionclient.RelayLoad) {
+	type classLoad struct {
+		relays                     int
+		bandwidth                  float64
+		guard, middle, exit, total int
+	}
+	classes := map[string]*classLoad{}
+	var bandwidth float64
+	var guard, middle, exit, total int
+	for _, load := range loads {
+		name := relayClass(load.Relay)
+		c := classes[name]
+		if c == nil {
+			c = &classLoad{}
+			classes[name] = c
+		}
+		c.relays++
+		c.bandwidth += float64(load.Relay.Bandwidth)
+		c.guard += load.Guard
+		c.middle += load.Middle
+		c.exit += load.Exit
+		c.total += load.Total()
+		bandwidth += float64(load.Relay.Bandwidth)
+		guard += load.Guard
+		middle += load.Middle
+		exit += load.Exit
+		total += load.Total()
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "class\trelays\tbandwidth\tguard picks\tmiddle picks\texit picks\tload\t")
+	for _, name := range []string{"Guard", "Middle", "Exit", "Guard+Exit"} {
+		c := classes[name]
+		if c == nil {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%.2f\t\n", name, c.relays,
+			percent(c.bandwidth, bandwidth), percent(float64(c.guard), float64(guard)),
+			percent(float64(c.middle), float64(middle)), percent(float64(c.exit), float64(exit)),
+			relativeLoad(float64(c.total), c.bandwidth, float64(total), bandwidth))
+	}
+	tw.Flush()
+}
+
+func printRelays(loads []onionclient.RelayLoad) {
+	sorted := append([]onionclient.RelayLoad{}, loads...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Relay.Bandwidth > sorted[j].Relay.Bandwidth })
+	var bandwidth, total float64
+	for _, load := range loads {
+		bandwidth += float64(load.Relay.Bandwidth)
+		total += float64(load.Total())
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "relay\tflags\tKB/s\tguard\tmiddle\texit\tload\t")
+	for _, load := range sorted {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%.2f\t\n", load.Relay.Address, strings.Join(load.Relay.Flags, ","),
+			load.Relay.Bandwidth/1024, load.Guard, load.Middle, load.Exit,
+			relativeLoad(float64(load.Total()), float64(load.Relay.Bandwidth), total, bandwidth))
+	}
+	tw.Flush()
+}
+
+func percent(part float64, whole float64) string {
+	if whole == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*part/whole)
+}
+
+func relativeLoad(picks float64, bandwidth float64, totalPicks float64, totalBandwidth float64) float64 {
+	if bandwidth == 0 || totalPicks == 0 {
+		return 0
+	}
+	return (picks / bandwidth) / (totalPicks / totalBandwidth)
+}
@@ -0,0 +1,693 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: protofiles/control.proto
+
+package protofiles
+
+import (
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type RelayEvent_Type int32
+
+const (
+	RelayEvent_UNKNOWN           RelayEvent_Type = 0
+	RelayEvent_CIRCUIT_CREATED   RelayEvent_Type = 1
+	RelayEvent_CIRCUIT_EXPIRED   RelayEvent_Type = 2
+	RelayEvent_CIRCUIT_CLOSED    RelayEvent_Type = 3
+	RelayEvent_ERROR             RelayEvent_Type = 4
+	RelayEvent_BANDWIDTH         RelayEvent_Type = 5
+	RelayEvent_ONION_KEY_ROTATED RelayEvent_Type = 6
+	RelayEvent_DRAINED           RelayEvent_Type = 7
+)
+
+var RelayEvent_Type_name = map[int32]string{
+	0: "UNKNOWN",
+	1: "CIRCUIT_CREATED",
+	2: "CIRCUIT_EXPIRED",
+	3: "CIRCUIT_CLOSED",
+	4: "ERROR",
+	5: "BANDWIDTH",
+	6: "ONION_KEY_ROTATED",
+	7: "DRAINED",
+}
+
+var RelayEvent_Type_value = map[string]int32{
+	"UNKNOWN":           0,
+	"CIRCUIT_CREATED":   1,
+	"CIRCUIT_EXPIRED":   2,
+	"CIRCUIT_CLOSED":    3,
+	"ERROR":             4,
+	"BANDWIDTH":         5,
+	"ONION_KEY_ROTATED": 6,
+	"DRAINED":           7,
+}
+
+func (x RelayEvent_Type) String() string {
+	return proto.EnumName(RelayEvent_Type_name, int32(x))
+}
+
+func (RelayEvent_Type) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_a50bc9d50d76c7b6, []int{12, 0}
+}
+
+type CircuitSummary struct {
+	CircuitId            uint32   `protobuf:"varint,1,opt,name=circuit_id,json=circuitId,proto3" json:"circuit_id,omitempty"`
+	AgeSeconds           int64    `protobuf:"varint,2,opt,name=age_seconds,json=ageSeconds,proto3" json:"age_seconds,omitempty"`
+	ExpiresInSeconds     int64    `protobuf:"varint,3,opt,name=expires_in_seconds,json=expiresInSeconds,proto3" json:"expires_in_seconds,omitempty"`
+	BytesForward         uint64   `protobuf:"varint,4,opt,name=bytes_forward,json=bytesForward,proto3" json:"bytes_forward,omitempty"`
+	BytesBackward        uint64   `protobuf:"varint,5,opt,name=bytes_backward,json=bytesBackward,proto3" json:"bytes_backward,omitempty"`
+	IsExitNode           bool     `protobuf:"varint,6,opt,name=is_exit_node,json=isExitNode,proto3" json:"is_exit_node,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CircuitSummary) Reset()         { *m = CircuitSummary{} }
+func (m *CircuitSummary) String() string { return proto.CompactTextString(m) }
+func (*CircuitSummary) ProtoMessage()    {}
+func (*CircuitSummary) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a50bc9d50d76c7b6, []int{0}
+}
+
+func (m *CircuitSummary) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CircuitSummary.Unmarshal(m, b)
+}
+func (m *CircuitSummary) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CircuitSummary.Marshal(b, m, deterministic)
+}
+func (m *CircuitSummary) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CircuitSummary.Merge(m, src)
+}
+func (m *CircuitSummary) XXX_Size() int {
+	return xxx_messageInfo_CircuitSummary.Size(m)
+}
+func (m *CircuitSummary) XXX_DiscardUnknown() {
+	xxx_messageInfo_CircuitSummary.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CircuitSummary proto.InternalMessageInfo
+
+func (m *CircuitSummary) GetCircuitId() uint32 {
+	if m != nil {
+		return m.CircuitId
+	}
+	return 0
+}
+
+func (m *CircuitSummary) GetAgeSeconds() int64 {
+	if m != nil {
+		return m.AgeSeconds
+	}
+	return 0
+}
+
+func (m *CircuitSummary) GetExpiresInSeconds() int64 {
+	if m != nil {
+		return m.ExpiresInSeconds
+	}
+	return 0
+}
+
+func (m *CircuitSummary) GetBytesForward() uint64 {
+	if m != nil {
+		return m.BytesForward
+	}
+	return 0
+}
+
+func (m *CircuitSummary) GetBytesBackward() uint64 {
+	if m != nil {
+		return m.BytesBackward
+	}
+	return 0
+}
+
+func (m *CircuitSummary) GetIsExitNode() bool {
+	if m != nil {
+		return m.IsExitNode
+	}
+	return false
+}
+
+type ListCircuitsRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListCircuitsRequest) Reset()         { *m = ListCircuitsRequest{} }
+func (m *ListCircuitsRequest) String() string { return proto.CompactTextString(m) }
+func (*ListCircuitsRequest) ProtoMessage()    {}
+func (*ListCircuitsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a50bc9d50d76c7b6, []int{1}
+}
+
+func (m *ListCircuitsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListCircuitsRequest.Unmarshal(m, b)
+}
+func (m *ListCircuitsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListCircuitsRequest.Marshal(b, m, deterministic)
+}
+func (m *ListCircuitsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListCircuitsRequest.Merge(m, src)
+}
+func (m *ListCircuitsRequest) XXX_Size() int {
+	return xxx_messageInfo_ListCircuitsRequest.Size(m)
+}
+func (m *ListCircuitsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListCircuitsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListCircuitsRequest proto.InternalMessageInfo
+
+type ListCircuitsResponse struct {
+	Circuits             []*CircuitSummary `protobuf:"bytes,1,rep,name=circuits,proto3" json:"circuits,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *ListCircuitsResponse) Reset()         { *m = ListCircuitsResponse{} }
+func (m *ListCircuitsResponse) String() string { return proto.CompactTextString(m) }
+func (*ListCircuitsResponse) ProtoMessage()    {}
+func (*ListCircuitsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a50bc9d50d76c7b6, []int{2}
+}
+
+func (m *ListCircuitsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListCircuitsResponse.Unmarshal(m, b)
+}
+func (m *ListCircuitsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListCircuitsResponse.Marshal(b, m, deterministic)
+}
+func (m *ListCircuitsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListCircuitsResponse.Merge(m, src)
+}
+func (m *ListCircuitsResponse) XXX_Size() int {
+	return xxx_messageInfo_ListCircuitsResponse.Size(m)
+}
+func (m *ListCircuitsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListCircuitsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListCircuitsResponse proto.InternalMessageInfo
+
+func (m *ListCircuitsResponse) GetCircuits() []*CircuitSummary {
+	if m != nil {
+		return m.Circuits
+	}
+	return nil
+}
+
+type CloseCircuitRequest struct {
+	CircuitId            uint32   `protobuf:"varint,1,opt,name=circuit_id,json=circuitId,proto3" json:"circuit_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CloseCircuitRequest) Reset()         { *m = CloseCircuitRequest{} }
+func (m *CloseCircuitRequest) String() string { return proto.CompactTextString(m) }
+func (*CloseCircuitRequest) ProtoMessage()    {}
+func (*CloseCircuitRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a50bc9d50d76c7b6, []int{3}
+}
+
+func (m *CloseCircuitRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CloseCircuitRequest.Unmarshal(m, b)
+}
+func (m *CloseCircuitRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CloseCircuitRequest.Marshal(b, m, deterministic)
+}
+func (m *CloseCircuitRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CloseCircuitRequest.Merge(m, src)
+}
+func (m *CloseCircuitRequest) XXX_Size() int {
+	return xxx_messageInfo_CloseCircuitRequest.Size(m)
+}
+func (m *CloseCircuitRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_CloseCircuitRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CloseCircuitRequest proto.InternalMessageInfo
+
+func (m *CloseCircuitRequest) GetCircuitId() uint32 {
+	if m != nil {
+		return m.CircuitId
+	}
+	return 0
+}
+
+type CloseCircuitResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CloseCircuitResponse) Reset()         { *m = CloseCircuitResponse{} }
+func (m *CloseCircuitResponse) String() string { return proto.CompactTextString(m) }
+func (*CloseCircuitResponse) ProtoMessage()    {}
+func (*CloseCircuitResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a50bc9d50d76c7b6, []int{4}
+}
+
+func (m *CloseCircuitResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CloseCircuitResponse.Unmarshal(m, b)
+}
+func (m *CloseCircuitResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CloseCircuitResponse.Marshal(b, m, deterministic)
+}
+func (m *CloseCircuitResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CloseCircuitResponse.Merge(m, src)
+}
+func (m *CloseCircuitResponse) XXX_Size() int {
+	return xxx_messageInfo_CloseCircuitResponse.Size(m)
+}
+func (m *CloseCircuitResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_CloseCircuitResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CloseCircuitResponse proto.InternalMessageInfo
+
+type RotateOnionKeyRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RotateOnionKeyRequest) Reset()         { *m = RotateOnionKeyRequest{} }
+func (m *RotateOnionKeyRequest) String() string { return proto.CompactTextString(m) }
+func (*RotateOnionKeyRequest) ProtoMessage()    {}
+func (*RotateOnionKeyRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a50bc9d50d76c7b6, []int{5}
+}
+
+func (m *RotateOnionKeyRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RotateOnionKeyRequest.Unmarshal(m, b)
+}
+func (m *RotateOnionKeyRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RotateOnionKeyRequest.Marshal(b, m, deterministic)
+}
+func (m *RotateOnionKeyRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RotateOnionKeyRequest.Merge(m, src)
+}
+func (m *RotateOnionKeyRequest) XXX_Size() int {
+	return xxx_messageInfo_RotateOnionKeyRequest.Size(m)
+}
+func (m *RotateOnionKeyRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_RotateOnionKeyRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RotateOnionKeyRequest proto.InternalMessageInfo
+
+type RotateOnionKeyResponse struct {
+	RotatedAtUnix        int64    `protobuf:"varint,1,opt,name=rotated_at_unix,json=rotatedAtUnix,proto3" json:"rotated_at_unix,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RotateOnionKeyResponse) Reset()         { *m = RotateOnionKeyResponse{} }
+func (m *RotateOnionKeyResponse) String() string { return proto.CompactTextString(m) }
+func (*RotateOnionKeyResponse) ProtoMessage()    {}
+func (*RotateOnionKeyResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a50bc9d50d76c7b6, []int{6}
+}
+
+func (m *RotateOnionKeyResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RotateOnionKeyResponse.Unmarshal(m, b)
+}
+func (m *RotateOnionKeyResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RotateOnionKeyResponse.Marshal(b, m, deterministic)
+}
+func (m *RotateOnionKeyResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RotateOnionKeyResponse.Merge(m, src)
+}
+func (m *RotateOnionKeyResponse) XXX_Size() int {
+	return xxx_messageInfo_RotateOnionKeyResponse.Size(m)
+}
+func (m *RotateOnionKeyResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_RotateOnionKeyResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RotateOnionKeyResponse proto.InternalMessageInfo
+
+func (m *RotateOnionKeyResponse) GetRotatedAtUnix() int64 {
+	if m != nil {
+		return m.RotatedAtUnix
+	}
+	return 0
+}
+
+type SetPaddingRequest struct {
+	Enabled              bool     `protobuf:"varint,1,opt,name=enabled,proto3" json:"enabled,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SetPaddingRequest) Reset()         { *m = SetPaddingRequest{} }
+func (m *SetPaddingRequest) String() string { return proto.CompactTextString(m) }
+func (*SetPaddingRequest) ProtoMessage()    {}
+func (*SetPaddingRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a50bc9d50d76c7b6, []int{7}
+}
+
+func (m *SetPaddingRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SetPaddingRequest.Unmarshal(m, b)
+}
+func (m *SetPaddingRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SetPaddingRequest.Marshal(b, m, deterministic)
+}
+func (m *SetPaddingRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SetPaddingRequest.Merge(m, src)
+}
+func (m *SetPaddingRequest) XXX_Size() int {
+	return xxx_messageInfo_SetPaddingRequest.Size(m)
+}
+func (m *SetPaddingRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SetPaddingRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SetPaddingRequest proto.InternalMessageInfo
+
+func (m *SetPaddingRequest) GetEnabled() bool {
+	if m != nil {
+		return m.Enabled
+	}
+	return false
+}
+
+type SetPaddingResponse struct {
+	Enabled              bool     `protobuf:"varint,1,opt,name=enabled,proto3" json:"enabled,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SetPaddingResponse) Reset()         { *m = SetPaddingResponse{} }
+func (m *SetPaddingResponse) String() string { return proto.CompactTextString(m) }
+func (*SetPaddingResponse) ProtoMessage()    {}
+func (*SetPaddingResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a50bc9d50d76c7b6, []int{8}
+}
+
+func (m *SetPaddingResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SetPaddingResponse.Unmarshal(m, b)
+}
+func (m *SetPaddingResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SetPaddingResponse.Marshal(b, m, deterministic)
+}
+func (m *SetPaddingResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SetPaddingResponse.Merge(m, src)
+}
+func (m *SetPaddingResponse) XXX_Size() int {
+	return xxx_messageInfo_SetPaddingResponse.Size(m)
+}
+func (m *SetPaddingResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_SetPaddingResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SetPaddingResponse proto.InternalMessageInfo
+
+func (m *SetPaddingResponse) GetEnabled() bool {
+	if m != nil {
+		return m.Enabled
+	}
+	return false
+}
+
+type DrainRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DrainRequest) Reset()         { *m = DrainRequest{} }
+func (m *DrainRequest) String() string { return proto.CompactTextString(m) }
+func (*DrainRequest) ProtoMessage()    {}
+func (*DrainRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a50bc9d50d76c7b6, []int{9}
+}
+
+func (m *DrainRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DrainRequest.Unmarshal(m, b)
+}
+func (m *DrainRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DrainRequest.Marshal(b, m, deterministic)
+}
+func (m *DrainRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DrainRequest.Merge(m, src)
+}
+func (m *DrainRequest) XXX_Size() int {
+	return xxx_messageInfo_DrainRequest.Size(m)
+}
+func (m *DrainRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DrainRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DrainRequest proto.InternalMessageInfo
+
+type DrainResponse struct {
+	ActiveCircuits       int32    `protobuf:"varint,1,opt,name=active_circuits,json=activeCircuits,proto3" json:"active_circuits,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DrainResponse) Reset()         { *m = DrainResponse{} }
+func (m *DrainResponse) String() string { return proto.CompactTextString(m) }
+func (*DrainResponse) ProtoMessage()    {}
+func (*DrainResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a50bc9d50d76c7b6, []int{10}
+}
+
+func (m *DrainResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DrainResponse.Unmarshal(m, b)
+}
+func (m *DrainResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DrainResponse.Marshal(b, m, deterministic)
+}
+func (m *DrainResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DrainResponse.Merge(m, src)
+}
+func (m *DrainResponse) XXX_Size() int {
+	return xxx_messageInfo_DrainResponse.Size(m)
+}
+func (m *DrainResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_DrainResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DrainResponse proto.InternalMessageInfo
+
+func (m *DrainResponse) GetActiveCircuits() int32 {
+	if m != nil {
+		return m.ActiveCircuits
+	}
+	return 0
+}
+
+type StreamEventsRequest struct {
+	// Event types to receive; all events are sent when empty.
+	Types                []RelayEvent_Type `protobuf:"varint,1,rep,packed,name=types,proto3,enum=onion_routing.RelayEvent_Type" json:"types,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *StreamEventsRequest) Reset()         { *m = StreamEventsRequest{} }
+func (m *StreamEventsRequest) String() string { return proto.CompactTextString(m) }
+func (*StreamEventsRequest) ProtoMessage()    {}
+func (*StreamEventsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a50bc9d50d76c7b6, []int{11}
+}
+
+func (m *StreamEventsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StreamEventsRequest.Unmarshal(m, b)
+}
+func (m *StreamEventsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StreamEventsRequest.Marshal(b, m, deterministic)
+}
+func (m *StreamEventsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StreamEventsRequest.Merge(m, src)
+}
+func (m *StreamEventsRequest) XXX_Size() int {
+	return xxx_messageInfo_StreamEventsRequest.Size(m)
+}
+func (m *StreamEventsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_StreamEventsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StreamEventsRequest proto.InternalMessageInfo
+
+func (m *StreamEventsRequest) GetTypes() []RelayEvent_Type {
+	if m != nil {
+		return m.Types
+	}
+	return nil
+}
+
+type RelayEvent struct {
+	Type                 RelayEvent_Type `protobuf:"varint,1,opt,name=type,proto3,enum=onion_routing.RelayEvent_Type" json:"type,omitempty"`
+	TimestampUnix        int64           `protobuf:"varint,2,opt,name=timestamp_unix,json=timestampUnix,proto3" json:"timestamp_unix,omitempty"`
+	CircuitId            uint32          `protobuf:"varint,3,opt,name=circuit_id,json=circuitId,proto3" json:"circuit_id,omitempty"`
+	Message              string          `protobuf:"bytes,4,opt,name=message,proto3" json:"message,omitempty"`
+	BytesForward         uint64          `protobuf:"varint,5,opt,name=bytes_forward,json=bytesForward,proto3" json:"bytes_forward,omitempty"`
+	BytesBackward        uint64          `protobuf:"varint,6,opt,name=bytes_backward,json=bytesBackward,proto3" json:"bytes_backward,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
+	XXX_unrecognized     []byte          `json:"-"`
+	XXX_sizecache        int32           `json:"-"`
+}
+
+func (m *RelayEvent) Reset()         { *m = RelayEvent{} }
+func (m *RelayEvent) String() string { return proto.CompactTextString(m) }
+func (*RelayEvent) ProtoMessage()    {}
+func (*RelayEvent) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a50bc9d50d76c7b6, []int{12}
+}
+
+func (m *RelayEvent) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RelayEvent.Unmarshal(m, b)
+}
+func (m *RelayEvent) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RelayEvent.Marshal(b, m, deterministic)
+}
+func (m *RelayEvent) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RelayEvent.Merge(m, src)
+}
+func (m *RelayEvent) XXX_Size() int {
+	return xxx_messageInfo_RelayEvent.Size(m)
+}
+func (m *RelayEvent) XXX_DiscardUnknown() {
+	xxx_messageInfo_RelayEvent.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RelayEvent proto.InternalMessageInfo
+
+func (m *RelayEvent) GetType() RelayEvent_Type {
+	if m != nil {
+		return m.Type
+	}
+	return RelayEvent_UNKNOWN
+}
+
+func (m *RelayEvent) GetTimestampUnix() int64 {
+	if m != nil {
+		return m.TimestampUnix
+	}
+	return 0
+}
+
+func (m *RelayEvent) GetCircuitId() uint32 {
+	if m != nil {
+		return m.CircuitId
+	}
+	return 0
+}
+
+func (m *RelayEvent) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *RelayEvent) GetBytesForward() uint64 {
+	if m != nil {
+		return m.BytesForward
+	}
+	return 0
+}
+
+func (m *RelayEvent) GetBytesBackward() uint64 {
+	if m != nil {
+		return m.BytesBackward
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterEnum("onion_routing.RelayEvent_Type", RelayEvent_Type_name, RelayEvent_Type_value)
+	proto.RegisterType((*CircuitSummary)(nil), "onion_routing.CircuitSummary")
+	proto.RegisterType((*ListCircuitsRequest)(nil), "onion_routing.ListCircuitsRequest")
+	proto.RegisterType((*ListCircuitsResponse)(nil), "onion_routing.ListCircuitsResponse")
+	proto.RegisterType((*CloseCircuitRequest)(nil), "onion_routing.CloseCircuitRequest")
+	proto.RegisterType((*CloseCircuitResponse)(nil), "onion_routing.CloseCircuitResponse")
+	proto.RegisterType((*RotateOnionKeyRequest)(nil), "onion_routing.RotateOnionKeyRequest")
+	proto.RegisterType((*RotateOnionKeyResponse)(nil), "onion_routing.RotateOnionKeyResponse")
+	proto.RegisterType((*SetPaddingRequest)(nil), "onion_routing.SetPaddingRequest")
+	proto.RegisterType((*SetPaddingResponse)(nil), "onion_routing.SetPaddingResponse")
+	proto.RegisterType((*DrainRequest)(nil), "onion_routing.DrainRequest")
+	proto.RegisterType((*DrainResponse)(nil), "onion_routing.DrainResponse")
+	proto.RegisterType((*StreamEventsRequest)(nil), "onion_routing.StreamEventsRequest")
+	proto.RegisterType((*RelayEvent)(nil), "onion_routing.RelayEvent")
+}
+
+func init() {
+	proto.RegisterFile("protofiles/control.proto", fileDescriptor_a50bc9d50d76c7b6)
+}
+
+var fileDescriptor_a50bc9d50d76c7b6 = []byte{
+	// 766 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x95, 0x5d, 0x73, 0xea, 0x44,
+	0x18, 0xc7, 0x4d, 0x79, 0x2b, 0x4f, 0x81, 0xe6, 0x2c, 0xa7, 0xc7, 0x88, 0x1e, 0xc5, 0x60, 0x95,
+	0x0b, 0xe1, 0x38, 0xd8, 0x0b, 0xbd, 0x13, 0x48, 0x1c, 0x33, 0x74, 0x92, 0x76, 0xa1, 0x83, 0x3a,
+	0xe3, 0x64, 0x02, 0xd9, 0x32, 0xab, 0x90, 0x60, 0x76, 0xa9, 0xf0, 0x09, 0xbc, 0xf4, 0x8b, 0xfa,
+	0x19, 0x1c, 0x87, 0xcd, 0x42, 0x21, 0xd0, 0xf6, 0xdc, 0x75, 0xff, 0xfb, 0x7b, 0x5e, 0xf2, 0x3c,
+	0xfb, 0x2f, 0xa0, 0xcd, 0xa3, 0x90, 0x87, 0xf7, 0x74, 0x4a, 0xd8, 0xbb, 0x71, 0x18, 0xf0, 0x28,
+	0x9c, 0x36, 0x85, 0x84, 0x8a, 0x61, 0x40, 0xc3, 0xc0, 0x8d, 0xc2, 0x05, 0xa7, 0xc1, 0x44, 0xff,
+	0x57, 0x81, 0x52, 0x97, 0x46, 0xe3, 0x05, 0xe5, 0xfd, 0xc5, 0x6c, 0xe6, 0x45, 0x2b, 0xf4, 0x16,
+	0x60, 0x1c, 0x2b, 0x2e, 0xf5, 0x35, 0xa5, 0xaa, 0xd4, 0x8b, 0x38, 0x2f, 0x15, 0xcb, 0x47, 0x9f,
+	0xc1, 0x99, 0x37, 0x21, 0x2e, 0x23, 0xe3, 0x30, 0xf0, 0x99, 0x76, 0x52, 0x55, 0xea, 0x29, 0x0c,
+	0xde, 0x84, 0xf4, 0x63, 0x05, 0x7d, 0x0d, 0x88, 0x2c, 0xe7, 0x34, 0x22, 0xcc, 0xa5, 0xc1, 0x96,
+	0x4b, 0x09, 0x4e, 0x95, 0x37, 0x56, 0xb0, 0xa1, 0x6b, 0x50, 0x1c, 0xad, 0x38, 0x61, 0xee, 0x7d,
+	0x18, 0xfd, 0xe5, 0x45, 0xbe, 0x96, 0xae, 0x2a, 0xf5, 0x34, 0x2e, 0x08, 0xf1, 0xc7, 0x58, 0x43,
+	0x97, 0x50, 0x8a, 0xa1, 0x91, 0x37, 0xfe, 0x43, 0x50, 0x19, 0x41, 0xc5, 0xa1, 0x1d, 0x29, 0xa2,
+	0x2a, 0x14, 0x28, 0x73, 0xc9, 0x92, 0x72, 0x37, 0x08, 0x7d, 0xa2, 0x65, 0xab, 0x4a, 0xfd, 0x14,
+	0x03, 0x65, 0xe6, 0x92, 0x72, 0x3b, 0xf4, 0x89, 0x7e, 0x01, 0xe5, 0x6b, 0xca, 0xb8, 0xfc, 0x62,
+	0x86, 0xc9, 0x9f, 0x0b, 0xc2, 0xb8, 0x7e, 0x0b, 0xaf, 0xf7, 0x65, 0x36, 0x0f, 0x03, 0x46, 0xd0,
+	0xf7, 0x70, 0x2a, 0x3f, 0x9c, 0x69, 0x4a, 0x35, 0x55, 0x3f, 0x6b, 0xbd, 0x6d, 0xee, 0xcd, 0xaf,
+	0xb9, 0x3f, 0x3b, 0xbc, 0xc5, 0xf5, 0x2b, 0x28, 0x77, 0xa7, 0x21, 0x23, 0x12, 0x90, 0x95, 0x5e,
+	0x18, 0xae, 0xfe, 0x06, 0x5e, 0xef, 0x47, 0xc5, 0x8d, 0xe8, 0x1f, 0xc2, 0x05, 0x0e, 0xb9, 0xc7,
+	0x89, 0xb3, 0xae, 0xde, 0x23, 0xab, 0x4d, 0xe7, 0x3f, 0xc0, 0x9b, 0xe4, 0x85, 0xec, 0xfd, 0x4b,
+	0x38, 0x8f, 0xc4, 0x8d, 0xef, 0x7a, 0xdc, 0x5d, 0x04, 0x74, 0x29, 0xca, 0xa5, 0x70, 0x51, 0xca,
+	0x6d, 0x7e, 0x17, 0xd0, 0xa5, 0xde, 0x80, 0x57, 0x7d, 0xc2, 0x6f, 0x3c, 0xdf, 0xa7, 0xc1, 0x64,
+	0xd3, 0xa6, 0x06, 0x39, 0x12, 0x78, 0xa3, 0x29, 0x89, 0x7b, 0x3c, 0xc5, 0x9b, 0xa3, 0xde, 0x04,
+	0xb4, 0x8b, 0xcb, 0x62, 0x4f, 0xf3, 0x25, 0x28, 0x18, 0x91, 0x47, 0x83, 0x4d, 0xc3, 0xdf, 0x41,
+	0x51, 0x9e, 0x65, 0xe8, 0x57, 0x70, 0xee, 0x8d, 0x39, 0x7d, 0x20, 0xee, 0xce, 0xa8, 0x95, 0x7a,
+	0x06, 0x97, 0x62, 0x79, 0xb3, 0x14, 0xbd, 0x07, 0xe5, 0x3e, 0x8f, 0x88, 0x37, 0x33, 0x1f, 0x48,
+	0xb0, 0xdd, 0x1d, 0xba, 0x82, 0x0c, 0x5f, 0xcd, 0x49, 0xbc, 0xa0, 0x52, 0xeb, 0xd3, 0xc4, 0x82,
+	0x30, 0x99, 0x7a, 0x2b, 0x11, 0xd1, 0x1c, 0xac, 0xe6, 0x04, 0xc7, 0xb0, 0xfe, 0xdf, 0x09, 0xc0,
+	0xe3, 0x15, 0x6a, 0x41, 0x7a, 0xad, 0x8b, 0xca, 0x2f, 0xe7, 0x10, 0xec, 0xfa, 0x51, 0x72, 0x3a,
+	0x23, 0x8c, 0x7b, 0xb3, 0x79, 0x3c, 0xdf, 0xd8, 0x0b, 0xc5, 0xad, 0xba, 0x9e, 0x6f, 0x62, 0xe3,
+	0xa9, 0xa4, 0x9d, 0x34, 0xc8, 0xcd, 0x08, 0x63, 0xde, 0x84, 0x88, 0x97, 0x9f, 0xc7, 0x9b, 0xe3,
+	0xa1, 0x33, 0x32, 0xef, 0xe5, 0x8c, 0xec, 0x11, 0x67, 0xe8, 0xff, 0x28, 0x90, 0x5e, 0xb7, 0x8e,
+	0xce, 0x20, 0x77, 0x67, 0xf7, 0x6c, 0x67, 0x68, 0xab, 0x1f, 0xa0, 0x32, 0x9c, 0x77, 0x2d, 0xdc,
+	0xbd, 0xb3, 0x06, 0x6e, 0x17, 0x9b, 0xed, 0x81, 0x69, 0xa8, 0xca, 0xae, 0x68, 0xfe, 0x7c, 0x63,
+	0x61, 0xd3, 0x50, 0x4f, 0x10, 0x82, 0xd2, 0x96, 0xbc, 0x76, 0xfa, 0xa6, 0xa1, 0xa6, 0x50, 0x1e,
+	0x32, 0x26, 0xc6, 0x0e, 0x56, 0xd3, 0xa8, 0x08, 0xf9, 0x4e, 0xdb, 0x36, 0x86, 0x96, 0x31, 0xf8,
+	0x49, 0xcd, 0xa0, 0x0b, 0x78, 0xe5, 0xd8, 0x96, 0x63, 0xbb, 0x3d, 0xf3, 0x17, 0x17, 0x3b, 0x03,
+	0x91, 0x39, 0xbb, 0xae, 0x6d, 0xe0, 0xb6, 0x65, 0x9b, 0x86, 0x9a, 0x6b, 0xfd, 0x9d, 0x86, 0x82,
+	0x98, 0x6b, 0x37, 0xfe, 0xf7, 0x84, 0x86, 0x50, 0xd8, 0xf5, 0x20, 0xd2, 0x13, 0x4b, 0x38, 0xe2,
+	0xdb, 0x4a, 0xed, 0x59, 0x46, 0x3e, 0xb0, 0x21, 0x14, 0x76, 0x3d, 0x75, 0x90, 0xf8, 0x88, 0x4d,
+	0x2b, 0xb5, 0x67, 0x19, 0x99, 0xf8, 0x37, 0x28, 0xed, 0x7b, 0x0f, 0x7d, 0x91, 0x7c, 0x38, 0xc7,
+	0x3c, 0x5b, 0xb9, 0x7c, 0x81, 0x92, 0xe9, 0x6f, 0x01, 0x1e, 0x9d, 0x86, 0xaa, 0x89, 0xa0, 0x03,
+	0xcf, 0x56, 0x3e, 0x7f, 0x86, 0x90, 0x29, 0x3b, 0x90, 0x11, 0xe6, 0x43, 0x1f, 0x27, 0xd8, 0x5d,
+	0x8b, 0x56, 0x3e, 0x39, 0x7e, 0x29, 0x73, 0x38, 0x50, 0xd8, 0xb5, 0xe1, 0xc1, 0x38, 0x8f, 0x78,
+	0xb4, 0xf2, 0xd1, 0x93, 0x86, 0xfa, 0x46, 0xe9, 0x5c, 0xfe, 0x5a, 0x33, 0xfa, 0x8d, 0x9b, 0x28,
+	0xfc, 0x9d, 0x8c, 0x79, 0x43, 0x8c, 0xa1, 0x81, 0x63, 0xf0, 0xdd, 0xe3, 0xcf, 0xd8, 0x28, 0x2b,
+	0xfe, 0xfe, 0xf6, 0xff, 0x01, 0x00, 0x64, 0xec, 0x0a, 0xb6, 0xdb, 0x06, 0x00, 0x00,
+}
@@ -0,0 +1,323 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v3.21.12
+// source: protofiles/control.proto
+
+package protofiles
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	RelayControl_ListCircuits_FullMethodName   = "/onion_routing.RelayControl/ListCircuits"
+	RelayControl_CloseCircuit_FullMethodName   = "/onion_routing.RelayControl/CloseCircuit"
+	RelayControl_RotateOnionKey_FullMethodName = "/onion_routing.RelayControl/RotateOnionKey"
+	RelayControl_SetPadding_FullMethodName     = "/onion_routing.RelayControl/SetPadding"
+	RelayControl_Drain_FullMethodName          = "/onion_routing.RelayControl/Drain"
+	RelayControl_StreamEvents_FullMethodName   = "/onion_routing.RelayControl/StreamEvents"
+)
+
+// RelayControlClient is the client API for RelayControl service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// RelayControl is the operator-facing admin service of a relay. It is only
+// served on a loopback address or a Unix socket and every call must carry the
+// relay's control cookie in the "authorization" metadata key.
+type RelayControlClient interface {
+	ListCircuits(ctx context.Context, in *ListCircuitsRequest, opts ...grpc.CallOption) (*ListCircuitsResponse, error)
+	CloseCircuit(ctx context.Context, in *CloseCircuitRequest, opts ...grpc.CallOption) (*CloseCircuitResponse, error)
+	RotateOnionKey(ctx context.Context, in *RotateOnionKeyRequest, opts ...grpc.CallOption) (*RotateOnionKeyResponse, error)
+	SetPadding(ctx context.Context, in *SetPaddingRequest, opts ...grpc.CallOption) (*SetPaddingResponse, error)
+	Drain(ctx context.Context, in *DrainRequest, opts ...grpc.CallOption) (*DrainResponse, error)
+	StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[RelayEvent], error)
+}
+
+type relayControlClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRelayControlClient(cc grpc.ClientConnInterface) RelayControlClient {
+	return &relayControlClient{cc}
+}
+
+func (c *relayControlClient) ListCircuits(ctx context.Context, in *ListCircuitsRequest, opts ...grpc.CallOption) (*ListCircuitsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListCircuitsResponse)
+	err := c.cc.Invoke(ctx, RelayControl_ListCircuits_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *relayControlClient) CloseCircuit(ctx context.Context, in *CloseCircuitRequest, opts ...grpc.CallOption) (*CloseCircuitResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CloseCircuitResponse)
+	err := c.cc.Invoke(ctx, RelayControl_CloseCircuit_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *relayControlClient) RotateOnionKey(ctx context.Context, in *RotateOnionKeyRequest, opts ...grpc.CallOption) (*RotateOnionKeyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RotateOnionKeyResponse)
+	err := c.cc.Invoke(ctx, RelayControl_RotateOnionKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *relayControlClient) SetPadding(ctx context.Context, in *SetPaddingRequest, opts ...grpc.CallOption) (*SetPaddingResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SetPaddingResponse)
+	err := c.cc.Invoke(ctx, RelayControl_SetPadding_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *relayControlClient) Drain(ctx context.Context, in *DrainRequest, opts ...grpc.CallOption) (*DrainResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DrainResponse)
+	err := c.cc.Invoke(ctx, RelayControl_Drain_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *relayControlClient) StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[RelayEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &RelayControl_ServiceDesc.Streams[0], RelayControl_StreamEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamEventsRequest, RelayEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type RelayControl_StreamEventsClient = grpc.ServerStreamingClient[RelayEvent]
+
+// RelayControlServer is the server API for RelayControl service.
+// All implementations must embed UnimplementedRelayControlServer
+// for forward compatibility.
+//
+// RelayControl is the operator-facing admin service of a relay. It is only
+// served on a loopback address or a Unix socket and every call must carry the
+// relay's control cookie in the "authorization" metadata key.
+type RelayControlServer interface {
+	ListCircuits(context.Context, *ListCircuitsRequest) (*ListCircuitsResponse, error)
+	CloseCircuit(context.Context, *CloseCircuitRequest) (*CloseCircuitResponse, error)
+	RotateOnionKey(context.Context, *RotateOnionKeyRequest) (*RotateOnionKeyResponse, error)
+	SetPadding(context.Context, *SetPaddingRequest) (*SetPaddingResponse, error)
+	Drain(context.Context, *DrainRequest) (*DrainResponse, error)
+	StreamEvents(*StreamEventsRequest, grpc.ServerStreamingServer[RelayEvent]) error
+	mustEmbedUnimplementedRelayControlServer()
+}
+
+// UnimplementedRelayControlServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedRelayControlServer struct{}
+
+func (UnimplementedRelayControlServer) ListCircuits(context.Context, *ListCircuitsRequest) (*ListCircuitsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListCircuits not implemented")
+}
+func (UnimplementedRelayControlServer) CloseCircuit(context.Context, *CloseCircuitRequest) (*CloseCircuitResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CloseCircuit not implemented")
+}
+func (UnimplementedRelayControlServer) RotateOnionKey(context.Context, *RotateOnionKeyRequest) (*RotateOnionKeyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RotateOnionKey not implemented")
+}
+func (UnimplementedRelayControlServer) SetPadding(context.Context, *SetPaddingRequest) (*SetPaddingResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetPadding not implemented")
+}
+func (UnimplementedRelayControlServer) Drain(context.Context, *DrainRequest) (*DrainResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Drain not implemented")
+}
+func (UnimplementedRelayControlServer) StreamEvents(*StreamEventsRequest, grpc.ServerStreamingServer[RelayEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamEvents not implemented")
+}
+func (UnimplementedRelayControlServer) mustEmbedUnimplementedRelayControlServer() {}
+func (UnimplementedRelayControlServer) testEmbeddedByValue()                      {}
+
+// UnsafeRelayControlServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RelayControlServer will
+// result in compilation errors.
+type UnsafeRelayControlServer interface {
+	mustEmbedUnimplementedRelayControlServer()
+}
+
+func RegisterRelayControlServer(s grpc.ServiceRegistrar, srv RelayControlServer) {
+	// If the following call pancis, it indicates UnimplementedRelayControlServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&RelayControl_ServiceDesc, srv)
+}
+
+func _RelayControl_ListCircuits_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListCircuitsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RelayControlServer).ListCircuits(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RelayControl_ListCircuits_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RelayControlServer).ListCircuits(ctx, req.(*ListCircuitsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RelayControl_CloseCircuit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CloseCircuitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RelayControlServer).CloseCircuit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RelayControl_CloseCircuit_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RelayControlServer).CloseCircuit(ctx, req.(*CloseCircuitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RelayControl_RotateOnionKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RotateOnionKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RelayControlServer).RotateOnionKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RelayControl_RotateOnionKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RelayControlServer).RotateOnionKey(ctx, req.(*RotateOnionKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RelayControl_SetPadding_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetPaddingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RelayControlServer).SetPadding(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RelayControl_SetPadding_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RelayControlServer).SetPadding(ctx, req.(*SetPaddingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RelayControl_Drain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DrainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RelayControlServer).Drain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RelayControl_Drain_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RelayControlServer).Drain(ctx, req.(*DrainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RelayControl_StreamEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RelayControlServer).StreamEvents(m, &grpc.GenericServerStream[StreamEventsRequest, RelayEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type RelayControl_StreamEventsServer = grpc.ServerStreamingServer[RelayEvent]
+
+// RelayControl_ServiceDesc is the grpc.ServiceDesc for RelayControl service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var RelayControl_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "onion_routing.RelayControl",
+	HandlerType: (*RelayControlServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListCircuits",
+			Handler:    _RelayControl_ListCircuits_Handler,
+		},
+		{
+			MethodName: "CloseCircuit",
+			Handler:    _RelayControl_CloseCircuit_Handler,
+		},
+		{
+			MethodName: "RotateOnionKey",
+			Handler:    _RelayControl_RotateOnionKey_Handler,
+		},
+		{
+			MethodName: "SetPadding",
+			Handler:    _RelayControl_SetPadding_Handler,
+		},
+		{
+			MethodName: "Drain",
+			Handler:    _RelayControl_Drain_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamEvents",
+			Handler:       _RelayControl_StreamEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "protofiles/control.proto",
+}
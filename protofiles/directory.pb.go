@@ -0,0 +1,474 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: protofiles/directory.proto
+
+package protofiles
+
+import (
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type UploadDescriptorRequest struct {
+	NodeId string `protobuf:"bytes,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
+	// The relay's record, JSON encoded, signed by its identity key as in
+	// encryption.SignedDescriptor.
+	Body                 []byte   `protobuf:"bytes,2,opt,name=body,proto3" json:"body,omitempty"`
+	IdentityKey          []byte   `protobuf:"bytes,3,opt,name=identity_key,json=identityKey,proto3" json:"identity_key,omitempty"`
+	Signature            []byte   `protobuf:"bytes,4,opt,name=signature,proto3" json:"signature,omitempty"`
+	Load                 int32    `protobuf:"varint,5,opt,name=load,proto3" json:"load,omitempty"`
+	PowDifficulty        uint32   `protobuf:"varint,6,opt,name=pow_difficulty,json=powDifficulty,proto3" json:"pow_difficulty,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *UploadDescriptorRequest) Reset()         { *m = UploadDescriptorRequest{} }
+func (m *UploadDescriptorRequest) String() string { return proto.CompactTextString(m) }
+func (*UploadDescriptorRequest) ProtoMessage()    {}
+func (*UploadDescriptorRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3412d00bfb99bd79, []int{0}
+}
+
+func (m *UploadDescriptorRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_UploadDescriptorRequest.Unmarshal(m, b)
+}
+func (m *UploadDescriptorRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_UploadDescriptorRequest.Marshal(b, m, deterministic)
+}
+func (m *UploadDescriptorRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UploadDescriptorRequest.Merge(m, src)
+}
+func (m *UploadDescriptorRequest) XXX_Size() int {
+	return xxx_messageInfo_UploadDescriptorRequest.Size(m)
+}
+func (m *UploadDescriptorRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_UploadDescriptorRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_UploadDescriptorRequest proto.InternalMessageInfo
+
+func (m *UploadDescriptorRequest) GetNodeId() string {
+	if m != nil {
+		return m.NodeId
+	}
+	return ""
+}
+
+func (m *UploadDescriptorRequest) GetBody() []byte {
+	if m != nil {
+		return m.Body
+	}
+	return nil
+}
+
+func (m *UploadDescriptorRequest) GetIdentityKey() []byte {
+	if m != nil {
+		return m.IdentityKey
+	}
+	return nil
+}
+
+func (m *UploadDescriptorRequest) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+func (m *UploadDescriptorRequest) GetLoad() int32 {
+	if m != nil {
+		return m.Load
+	}
+	return 0
+}
+
+func (m *UploadDescriptorRequest) GetPowDifficulty() uint32 {
+	if m != nil {
+		return m.PowDifficulty
+	}
+	return 0
+}
+
+type UploadDescriptorResponse struct {
+	// The relay stays listed while it sends a heartbeat at least this often.
+	HeartbeatIntervalMs  int64    `protobuf:"varint,1,opt,name=heartbeat_interval_ms,json=heartbeatIntervalMs,proto3" json:"heartbeat_interval_ms,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *UploadDescriptorResponse) Reset()         { *m = UploadDescriptorResponse{} }
+func (m *UploadDescriptorResponse) String() string { return proto.CompactTextString(m) }
+func (*UploadDescriptorResponse) ProtoMessage()    {}
+func (*UploadDescriptorResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3412d00bfb99bd79, []int{1}
+}
+
+func (m *UploadDescriptorResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_UploadDescriptorResponse.Unmarshal(m, b)
+}
+func (m *UploadDescriptorResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_UploadDescriptorResponse.Marshal(b, m, deterministic)
+}
+func (m *UploadDescriptorResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UploadDescriptorResponse.Merge(m, src)
+}
+func (m *UploadDescriptorResponse) XXX_Size() int {
+	return xxx_messageInfo_UploadDescriptorResponse.Size(m)
+}
+func (m *UploadDescriptorResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_UploadDescriptorResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_UploadDescriptorResponse proto.InternalMessageInfo
+
+func (m *UploadDescriptorResponse) GetHeartbeatIntervalMs() int64 {
+	if m != nil {
+		return m.HeartbeatIntervalMs
+	}
+	return 0
+}
+
+// A heartbeat is signed by the identity key of the relay's descriptor (see
+// encryption.SignHeartbeat); the directory answers NOT_FOUND when it has no
+// descriptor for the relay, which should upload it again.
+type HeartbeatRequest struct {
+	NodeId               string   `protobuf:"bytes,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
+	Load                 int32    `protobuf:"varint,2,opt,name=load,proto3" json:"load,omitempty"`
+	PowDifficulty        uint32   `protobuf:"varint,3,opt,name=pow_difficulty,json=powDifficulty,proto3" json:"pow_difficulty,omitempty"`
+	SentUnixMs           int64    `protobuf:"varint,4,opt,name=sent_unix_ms,json=sentUnixMs,proto3" json:"sent_unix_ms,omitempty"`
+	Signature            []byte   `protobuf:"bytes,5,opt,name=signature,proto3" json:"signature,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *HeartbeatRequest) Reset()         { *m = HeartbeatRequest{} }
+func (m *HeartbeatRequest) String() string { return proto.CompactTextString(m) }
+func (*HeartbeatRequest) ProtoMessage()    {}
+func (*HeartbeatRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3412d00bfb99bd79, []int{2}
+}
+
+func (m *HeartbeatRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HeartbeatRequest.Unmarshal(m, b)
+}
+func (m *HeartbeatRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HeartbeatRequest.Marshal(b, m, deterministic)
+}
+func (m *HeartbeatRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HeartbeatRequest.Merge(m, src)
+}
+func (m *HeartbeatRequest) XXX_Size() int {
+	return xxx_messageInfo_HeartbeatRequest.Size(m)
+}
+func (m *HeartbeatRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_HeartbeatRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HeartbeatRequest proto.InternalMessageInfo
+
+func (m *HeartbeatRequest) GetNodeId() string {
+	if m != nil {
+		return m.NodeId
+	}
+	return ""
+}
+
+func (m *HeartbeatRequest) GetLoad() int32 {
+	if m != nil {
+		return m.Load
+	}
+	return 0
+}
+
+func (m *HeartbeatRequest) GetPowDifficulty() uint32 {
+	if m != nil {
+		return m.PowDifficulty
+	}
+	return 0
+}
+
+func (m *HeartbeatRequest) GetSentUnixMs() int64 {
+	if m != nil {
+		return m.SentUnixMs
+	}
+	return 0
+}
+
+func (m *HeartbeatRequest) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+type HeartbeatResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *HeartbeatResponse) Reset()         { *m = HeartbeatResponse{} }
+func (m *HeartbeatResponse) String() string { return proto.CompactTextString(m) }
+func (*HeartbeatResponse) ProtoMessage()    {}
+func (*HeartbeatResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3412d00bfb99bd79, []int{3}
+}
+
+func (m *HeartbeatResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HeartbeatResponse.Unmarshal(m, b)
+}
+func (m *HeartbeatResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HeartbeatResponse.Marshal(b, m, deterministic)
+}
+func (m *HeartbeatResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HeartbeatResponse.Merge(m, src)
+}
+func (m *HeartbeatResponse) XXX_Size() int {
+	return xxx_messageInfo_HeartbeatResponse.Size(m)
+}
+func (m *HeartbeatResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_HeartbeatResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HeartbeatResponse proto.InternalMessageInfo
+
+// A draining relay withdraws so that it leaves the consensus at once. The
+// request is signed like a heartbeat (see encryption.SignWithdrawal).
+type WithdrawRequest struct {
+	NodeId               string   `protobuf:"bytes,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
+	SentUnixMs           int64    `protobuf:"varint,2,opt,name=sent_unix_ms,json=sentUnixMs,proto3" json:"sent_unix_ms,omitempty"`
+	Signature            []byte   `protobuf:"bytes,3,opt,name=signature,proto3" json:"signature,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WithdrawRequest) Reset()         { *m = WithdrawRequest{} }
+func (m *WithdrawRequest) String() string { return proto.CompactTextString(m) }
+func (*WithdrawRequest) ProtoMessage()    {}
+func (*WithdrawRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3412d00bfb99bd79, []int{4}
+}
+
+func (m *WithdrawRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WithdrawRequest.Unmarshal(m, b)
+}
+func (m *WithdrawRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WithdrawRequest.Marshal(b, m, deterministic)
+}
+func (m *WithdrawRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WithdrawRequest.Merge(m, src)
+}
+func (m *WithdrawRequest) XXX_Size() int {
+	return xxx_messageInfo_WithdrawRequest.Size(m)
+}
+func (m *WithdrawRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_WithdrawRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WithdrawRequest proto.InternalMessageInfo
+
+func (m *WithdrawRequest) GetNodeId() string {
+	if m != nil {
+		return m.NodeId
+	}
+	return ""
+}
+
+func (m *WithdrawRequest) GetSentUnixMs() int64 {
+	if m != nil {
+		return m.SentUnixMs
+	}
+	return 0
+}
+
+func (m *WithdrawRequest) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+type WithdrawResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WithdrawResponse) Reset()         { *m = WithdrawResponse{} }
+func (m *WithdrawResponse) String() string { return proto.CompactTextString(m) }
+func (*WithdrawResponse) ProtoMessage()    {}
+func (*WithdrawResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3412d00bfb99bd79, []int{5}
+}
+
+func (m *WithdrawResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WithdrawResponse.Unmarshal(m, b)
+}
+func (m *WithdrawResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WithdrawResponse.Marshal(b, m, deterministic)
+}
+func (m *WithdrawResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WithdrawResponse.Merge(m, src)
+}
+func (m *WithdrawResponse) XXX_Size() int {
+	return xxx_messageInfo_WithdrawResponse.Size(m)
+}
+func (m *WithdrawResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_WithdrawResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WithdrawResponse proto.InternalMessageInfo
+
+type GetConsensusRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetConsensusRequest) Reset()         { *m = GetConsensusRequest{} }
+func (m *GetConsensusRequest) String() string { return proto.CompactTextString(m) }
+func (*GetConsensusRequest) ProtoMessage()    {}
+func (*GetConsensusRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3412d00bfb99bd79, []int{6}
+}
+
+func (m *GetConsensusRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetConsensusRequest.Unmarshal(m, b)
+}
+func (m *GetConsensusRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetConsensusRequest.Marshal(b, m, deterministic)
+}
+func (m *GetConsensusRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetConsensusRequest.Merge(m, src)
+}
+func (m *GetConsensusRequest) XXX_Size() int {
+	return xxx_messageInfo_GetConsensusRequest.Size(m)
+}
+func (m *GetConsensusRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetConsensusRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetConsensusRequest proto.InternalMessageInfo
+
+// ConsensusDocument is a JSON encoded netdir.ConsensusDocument and the
+// authority's signature of it (see encryption.SignConsensus).
+type ConsensusDocument struct {
+	Body                 []byte   `protobuf:"bytes,1,opt,name=body,proto3" json:"body,omitempty"`
+	AuthorityKey         []byte   `protobuf:"bytes,2,opt,name=authority_key,json=authorityKey,proto3" json:"authority_key,omitempty"`
+	Signature            []byte   `protobuf:"bytes,3,opt,name=signature,proto3" json:"signature,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ConsensusDocument) Reset()         { *m = ConsensusDocument{} }
+func (m *ConsensusDocument) String() string { return proto.CompactTextString(m) }
+func (*ConsensusDocument) ProtoMessage()    {}
+func (*ConsensusDocument) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3412d00bfb99bd79, []int{7}
+}
+
+func (m *ConsensusDocument) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ConsensusDocument.Unmarshal(m, b)
+}
+func (m *ConsensusDocument) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ConsensusDocument.Marshal(b, m, deterministic)
+}
+func (m *ConsensusDocument) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ConsensusDocument.Merge(m, src)
+}
+func (m *ConsensusDocument) XXX_Size() int {
+	return xxx_messageInfo_ConsensusDocument.Size(m)
+}
+func (m *ConsensusDocument) XXX_DiscardUnknown() {
+	xxx_messageInfo_ConsensusDocument.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ConsensusDocument proto.InternalMessageInfo
+
+func (m *ConsensusDocument) GetBody() []byte {
+	if m != nil {
+		return m.Body
+	}
+	return nil
+}
+
+func (m *ConsensusDocument) GetAuthorityKey() []byte {
+	if m != nil {
+		return m.AuthorityKey
+	}
+	return nil
+}
+
+func (m *ConsensusDocument) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*UploadDescriptorRequest)(nil), "onion_routing.UploadDescriptorRequest")
+	proto.RegisterType((*UploadDescriptorResponse)(nil), "onion_routing.UploadDescriptorResponse")
+	proto.RegisterType((*HeartbeatRequest)(nil), "onion_routing.HeartbeatRequest")
+	proto.RegisterType((*HeartbeatResponse)(nil), "onion_routing.HeartbeatResponse")
+	proto.RegisterType((*WithdrawRequest)(nil), "onion_routing.WithdrawRequest")
+	proto.RegisterType((*WithdrawResponse)(nil), "onion_routing.WithdrawResponse")
+	proto.RegisterType((*GetConsensusRequest)(nil), "onion_routing.GetConsensusRequest")
+	proto.RegisterType((*ConsensusDocument)(nil), "onion_routing.ConsensusDocument")
+}
+
+func init() {
+	proto.RegisterFile("protofiles/directory.proto", fileDescriptor_3412d00bfb99bd79)
+}
+
+var fileDescriptor_3412d00bfb99bd79 = []byte{
+	// 497 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x94, 0xdf, 0x6e, 0xd3, 0x30,
+	0x14, 0xc6, 0x95, 0xfe, 0x83, 0x1e, 0x52, 0xe8, 0x5c, 0x4d, 0x8b, 0x2a, 0xc4, 0x42, 0xa6, 0x41,
+	0x6f, 0xda, 0x49, 0xe3, 0x0d, 0x20, 0x12, 0x4c, 0xd3, 0x06, 0x0a, 0x4c, 0x48, 0xdc, 0x44, 0x69,
+	0xe2, 0xae, 0x2e, 0x9d, 0x1d, 0xec, 0x13, 0xba, 0xbc, 0x10, 0x0f, 0xc2, 0xab, 0xf0, 0x22, 0x28,
+	0x69, 0x92, 0x76, 0xee, 0xba, 0xee, 0xee, 0xe4, 0x3b, 0xc7, 0xf6, 0xef, 0x1c, 0x7f, 0x0e, 0xf4,
+	0x63, 0x29, 0x50, 0x4c, 0xd8, 0x9c, 0xaa, 0x93, 0x88, 0x49, 0x1a, 0xa2, 0x90, 0xe9, 0x28, 0x17,
+	0x49, 0x47, 0x70, 0x26, 0xb8, 0x2f, 0x45, 0x82, 0x8c, 0x5f, 0x3b, 0x7f, 0x0d, 0x38, 0xb8, 0x8a,
+	0xe7, 0x22, 0x88, 0x5c, 0xaa, 0x42, 0xc9, 0x62, 0x14, 0xd2, 0xa3, 0xbf, 0x12, 0xaa, 0x90, 0x1c,
+	0xc0, 0x13, 0x2e, 0x22, 0xea, 0xb3, 0xc8, 0x32, 0x6c, 0x63, 0xd0, 0xf6, 0x5a, 0xd9, 0xe7, 0x59,
+	0x44, 0x08, 0x34, 0xc6, 0x22, 0x4a, 0xad, 0x9a, 0x6d, 0x0c, 0x4c, 0x2f, 0x8f, 0xc9, 0x6b, 0x30,
+	0x59, 0x44, 0x39, 0x32, 0x4c, 0xfd, 0x9f, 0x34, 0xb5, 0xea, 0x79, 0xee, 0x59, 0xa9, 0x9d, 0xd3,
+	0x94, 0xbc, 0x84, 0xb6, 0x62, 0xd7, 0x3c, 0xc0, 0x44, 0x52, 0xab, 0x91, 0xe7, 0x57, 0x42, 0xb6,
+	0x69, 0x86, 0x61, 0x35, 0x6d, 0x63, 0xd0, 0xf4, 0xf2, 0x98, 0x1c, 0xc3, 0xf3, 0x58, 0x2c, 0xfc,
+	0x88, 0x4d, 0x26, 0x2c, 0x4c, 0xe6, 0x98, 0x5a, 0x2d, 0xdb, 0x18, 0x74, 0xbc, 0x4e, 0x2c, 0x16,
+	0x6e, 0x25, 0x3a, 0x97, 0x60, 0x6d, 0xf6, 0xa0, 0x62, 0xc1, 0x15, 0x25, 0xa7, 0xb0, 0x3f, 0xa5,
+	0x81, 0xc4, 0x31, 0x0d, 0xd0, 0x67, 0x1c, 0xa9, 0xfc, 0x1d, 0xcc, 0xfd, 0x1b, 0x95, 0xb7, 0x54,
+	0xf7, 0x7a, 0x55, 0xf2, 0xac, 0xc8, 0x5d, 0x28, 0xe7, 0x8f, 0x01, 0xdd, 0x4f, 0xa5, 0xfe, 0x98,
+	0x69, 0xe4, 0xe0, 0xb5, 0x07, 0xc1, 0xeb, 0xf7, 0x80, 0x13, 0x1b, 0x4c, 0x45, 0x39, 0xfa, 0x09,
+	0x67, 0xb7, 0x19, 0x53, 0x23, 0x67, 0x82, 0x4c, 0xbb, 0xe2, 0xec, 0xf6, 0x42, 0xdd, 0x9d, 0x59,
+	0x53, 0x9b, 0x99, 0xd3, 0x83, 0xbd, 0x35, 0xce, 0x65, 0xc7, 0xce, 0x0c, 0x5e, 0x7c, 0x67, 0x38,
+	0x8d, 0x64, 0xb0, 0xd8, 0xc9, 0xae, 0x03, 0xd4, 0x1e, 0x06, 0xa8, 0xeb, 0x00, 0x04, 0xba, 0xab,
+	0xb3, 0x8a, 0xf3, 0xf7, 0xa1, 0xf7, 0x91, 0xe2, 0x87, 0x2c, 0xe6, 0x2a, 0x51, 0x05, 0x83, 0x33,
+	0x83, 0xbd, 0x4a, 0x73, 0x45, 0x98, 0xdc, 0x50, 0x8e, 0x95, 0x93, 0x8c, 0x35, 0x27, 0x1d, 0x41,
+	0x27, 0x48, 0x70, 0x2a, 0x64, 0x69, 0xa5, 0xa5, 0xcd, 0xcc, 0x4a, 0xdc, 0xf0, 0x92, 0x8e, 0x75,
+	0xfa, 0xaf, 0x06, 0x6d, 0xb7, 0x34, 0x3e, 0x09, 0xa1, 0xab, 0xdb, 0x83, 0xbc, 0x19, 0xdd, 0x79,
+	0x07, 0xa3, 0x2d, 0x6f, 0xa0, 0xff, 0x76, 0x67, 0x5d, 0xe1, 0xb3, 0x4b, 0x68, 0x57, 0x57, 0x41,
+	0x0e, 0xb5, 0x55, 0xba, 0x99, 0xfa, 0xf6, 0xf6, 0x82, 0x62, 0xbf, 0x6f, 0x60, 0xae, 0x4f, 0x91,
+	0x38, 0xda, 0x8a, 0x7b, 0x46, 0xbc, 0xb1, 0xeb, 0xe6, 0xbc, 0xcf, 0xe1, 0x69, 0x79, 0x5f, 0xe4,
+	0x95, 0x56, 0xad, 0x99, 0xa6, 0x7f, 0xb8, 0x35, 0xbf, 0x44, 0x7c, 0x7f, 0xfc, 0xe3, 0xc8, 0xfd,
+	0x3a, 0xfc, 0x22, 0xc5, 0x8c, 0x86, 0x38, 0xfc, 0x9c, 0x15, 0x0f, 0xbd, 0x65, 0xf1, 0xc9, 0xea,
+	0x0f, 0x34, 0x6e, 0xe5, 0xf1, 0xbb, 0xff, 0x03, 0x00, 0x13, 0xce, 0x0d, 0xa7, 0x96, 0x04, 0x00,
+	0x00,
+}
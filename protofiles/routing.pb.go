@@ -255,7 +255,20 @@ func (m *GetRandomResponse) GetReply() []byte {
 }
 
 type RelayRequest struct {
-	Message              []byte   `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	Message []byte `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	// Set when the outermost layer is a CREATE cell, so that relays can apply
+	// rate limits and proof-of-work before decrypting it.
+	Create bool `protobuf:"varint,2,opt,name=create,proto3" json:"create,omitempty"`
+	// Proof-of-work nonce for the outermost CREATE layer.
+	PowNonce uint64 `protobuf:"varint,3,opt,name=pow_nonce,json=powNonce,proto3" json:"pow_nonce,omitempty"`
+	// Number of relays the CREATE has passed through before this one. Relays
+	// set it when forwarding and refuse circuits longer than their maximum.
+	HopCount uint32 `protobuf:"varint,4,opt,name=hop_count,json=hopCount,proto3" json:"hop_count,omitempty"`
+	// Set by a relay forwarding the cell: its identity key and its signature
+	// of the request (see encryption.SignForwardedCell). Cells from relays in
+	// the consensus are not rate limited.
+	RelayIdentity        []byte   `protobuf:"bytes,5,opt,name=relay_identity,json=relayIdentity,proto3" json:"relay_identity,omitempty"`
+	RelaySignature       []byte   `protobuf:"bytes,6,opt,name=relay_signature,json=relaySignature,proto3" json:"relay_signature,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -293,6 +306,41 @@ func (m *RelayRequest) GetMessage() []byte {
 	return nil
 }
 
+func (m *RelayRequest) GetCreate() bool {
+	if m != nil {
+		return m.Create
+	}
+	return false
+}
+
+func (m *RelayRequest) GetPowNonce() uint64 {
+	if m != nil {
+		return m.PowNonce
+	}
+	return 0
+}
+
+func (m *RelayRequest) GetHopCount() uint32 {
+	if m != nil {
+		return m.HopCount
+	}
+	return 0
+}
+
+func (m *RelayRequest) GetRelayIdentity() []byte {
+	if m != nil {
+		return m.RelayIdentity
+	}
+	return nil
+}
+
+func (m *RelayRequest) GetRelaySignature() []byte {
+	if m != nil {
+		return m.RelaySignature
+	}
+	return nil
+}
+
 type RelayResponse struct {
 	Reply                []byte   `protobuf:"bytes,1,opt,name=reply,proto3" json:"reply,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -348,25 +396,32 @@ func init() {
 }
 
 var fileDescriptor_34181ec3bf593d6c = []byte{
-	// 316 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x92, 0x51, 0x4b, 0xc3, 0x30,
-	0x10, 0xc7, 0xa9, 0xa0, 0xc2, 0xd9, 0xa1, 0x06, 0x1f, 0xca, 0x14, 0x2c, 0x95, 0x41, 0x7d, 0x68,
-	0x07, 0xf3, 0x1b, 0x38, 0x71, 0xfa, 0x32, 0x47, 0x86, 0x08, 0x22, 0x48, 0xda, 0x9d, 0xa3, 0xd2,
-	0x26, 0x35, 0x49, 0x85, 0x7d, 0x09, 0x3f, 0xb3, 0xac, 0xcb, 0xb4, 0x6b, 0xdd, 0xdc, 0x5b, 0xef,
-	0xfa, 0xcf, 0x2f, 0xdc, 0xef, 0x02, 0x4e, 0x2e, 0x85, 0x16, 0x6f, 0x49, 0x8a, 0xaa, 0x2b, 0x45,
-	0xa1, 0x13, 0x3e, 0x0d, 0xcb, 0x16, 0x69, 0x09, 0x9e, 0x08, 0xfe, 0x6a, 0x9a, 0x9e, 0x0f, 0xf6,
-	0x40, 0x22, 0x6a, 0x8a, 0x1f, 0x05, 0x2a, 0x4d, 0x1c, 0xd8, 0xcf, 0x50, 0x29, 0x36, 0x45, 0xc7,
-	0x72, 0x2d, 0xdf, 0xa6, 0xcb, 0xd2, 0xeb, 0x40, 0xcb, 0x24, 0x55, 0x2e, 0xb8, 0x42, 0x72, 0x02,
-	0xbb, 0x12, 0xf3, 0x74, 0x66, 0x82, 0x8b, 0xc2, 0x73, 0xe1, 0xe8, 0x36, 0x89, 0x04, 0x67, 0x71,
-	0x9c, 0x2c, 0xa1, 0x36, 0x58, 0xdc, 0xa4, 0x2c, 0xee, 0x5d, 0xc2, 0x71, 0x25, 0xf1, 0x1f, 0x6c,
-	0x80, 0x9a, 0x32, 0x3e, 0x11, 0xd9, 0x5a, 0x58, 0x25, 0xb1, 0x11, 0xe6, 0x83, 0x4d, 0x31, 0x65,
-	0xb3, 0xad, 0x46, 0x35, 0xc9, 0x4d, 0xc0, 0xde, 0xd7, 0x0e, 0x90, 0x87, 0xb9, 0x4d, 0xba, 0x90,
-	0x39, 0x46, 0xf9, 0x89, 0x92, 0xdc, 0xc1, 0x41, 0x29, 0xca, 0x94, 0xa7, 0xe1, 0x8a, 0xf1, 0xb0,
-	0xaa, 0xbb, 0x7d, 0xf6, 0xf7, 0x4f, 0x73, 0xed, 0x13, 0x90, 0x3e, 0x4b, 0xe3, 0x22, 0x65, 0x1a,
-	0x7f, 0x94, 0x91, 0xf3, 0xda, 0x99, 0xba, 0xee, 0xb6, 0xbb, 0x3e, 0x60, 0xc0, 0x8f, 0x15, 0xaf,
-	0xc3, 0x22, 0x8b, 0x50, 0xaa, 0x06, 0xb6, 0x2e, 0xbe, 0x81, 0x6d, 0x78, 0xef, 0xbd, 0xc0, 0x61,
-	0xe9, 0x6d, 0x28, 0x26, 0x68, 0xa6, 0xbf, 0x37, 0xd2, 0xe7, 0x2d, 0x3a, 0xea, 0x37, 0x6c, 0x54,
-	0x37, 0xd2, 0xb0, 0xb1, 0xb2, 0x84, 0xeb, 0xce, 0xf3, 0xc5, 0xcd, 0x38, 0x18, 0x49, 0xf1, 0x8e,
-	0xb1, 0x0e, 0x4a, 0xf1, 0x81, 0x31, 0xdf, 0xfd, 0x7d, 0xee, 0xd1, 0x5e, 0xf9, 0x7d, 0xf5, 0x1d,
-	0x00, 0x00, 0xff, 0xff, 0x1d, 0xd2, 0x40, 0x57, 0x03, 0x03, 0x00, 0x00,
+	// 423 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x84, 0x53, 0x61, 0x6b, 0x13, 0x41,
+	0x10, 0xe5, 0x6a, 0x1b, 0xe3, 0x78, 0xb1, 0xba, 0x88, 0x2c, 0x69, 0xc1, 0x23, 0x12, 0x3c, 0x3f,
+	0x24, 0x85, 0xfa, 0x0f, 0x8c, 0x58, 0xfb, 0x25, 0x96, 0x0d, 0x22, 0x88, 0x70, 0x6c, 0x2e, 0x63,
+	0xba, 0x72, 0xd9, 0x39, 0x77, 0xf7, 0x2c, 0xf9, 0x13, 0xfe, 0x30, 0x7f, 0x95, 0xdc, 0xde, 0xa6,
+	0x9e, 0x69, 0xd3, 0x7e, 0xbb, 0xf7, 0xe6, 0xed, 0x1b, 0x66, 0xde, 0x1c, 0xf0, 0xd2, 0x90, 0xa3,
+	0xef, 0xaa, 0x40, 0x7b, 0x62, 0xa8, 0x72, 0x4a, 0x2f, 0xc7, 0x9e, 0x62, 0x3d, 0xd2, 0x8a, 0x74,
+	0x16, 0xc8, 0x41, 0x0a, 0xf1, 0x99, 0x41, 0x74, 0x02, 0x7f, 0x56, 0x68, 0x1d, 0xe3, 0xf0, 0x70,
+	0x85, 0xd6, 0xca, 0x25, 0xf2, 0x28, 0x89, 0xd2, 0x58, 0x6c, 0xe0, 0x60, 0x08, 0xbd, 0xa0, 0xb4,
+	0x25, 0x69, 0x8b, 0xec, 0x39, 0x1c, 0x18, 0x2c, 0x8b, 0x75, 0x10, 0x36, 0x60, 0x90, 0xc0, 0xd3,
+	0x0f, 0x6a, 0x4e, 0x5a, 0xe6, 0xb9, 0xda, 0x98, 0xc6, 0x10, 0xe9, 0xa0, 0x8a, 0xf4, 0xe0, 0x0d,
+	0x3c, 0x6b, 0x29, 0xee, 0x33, 0x3b, 0x43, 0x27, 0xa4, 0x5e, 0xd0, 0x6a, 0xa7, 0x59, 0x4b, 0x71,
+	0xa7, 0xd9, 0x9f, 0x08, 0x62, 0x81, 0x85, 0x5c, 0xdf, 0x3b, 0x2b, 0x7b, 0x01, 0x9d, 0xdc, 0xa0,
+	0x74, 0xc8, 0xf7, 0x92, 0x28, 0xed, 0x8a, 0x80, 0xd8, 0x11, 0x3c, 0x2a, 0xe9, 0x2a, 0xd3, 0xa4,
+	0x73, 0xe4, 0x0f, 0x92, 0x28, 0xdd, 0x17, 0xdd, 0x92, 0xae, 0xa6, 0x35, 0xae, 0x8b, 0x97, 0x54,
+	0x66, 0x39, 0x55, 0xda, 0xf1, 0xfd, 0x24, 0x4a, 0x7b, 0xa2, 0x7b, 0x49, 0xe5, 0xa4, 0xc6, 0x6c,
+	0x08, 0x4f, 0x4c, 0xdd, 0x3b, 0x53, 0x0b, 0xd4, 0x4e, 0xb9, 0x35, 0x3f, 0xf0, 0x2d, 0x7b, 0x9e,
+	0x3d, 0x0f, 0x24, 0x7b, 0x0d, 0x87, 0x8d, 0xcc, 0xaa, 0xa5, 0x96, 0xae, 0x32, 0xc8, 0x3b, 0x5e,
+	0xd7, 0xbc, 0x9e, 0x6d, 0xd8, 0x3a, 0x8d, 0x30, 0xcb, 0x5d, 0x33, 0x9f, 0xfe, 0xde, 0x03, 0xf6,
+	0xa9, 0x0e, 0x5c, 0x34, 0x79, 0xcf, 0xd0, 0xfc, 0x42, 0xc3, 0x3e, 0xc2, 0x63, 0x9f, 0x65, 0x80,
+	0x47, 0xe3, 0xff, 0x8e, 0x62, 0xdc, 0xbe, 0x88, 0xfe, 0xf1, 0xed, 0xc5, 0xd0, 0xf6, 0x0b, 0xb0,
+	0x89, 0x2c, 0xf2, 0xaa, 0x90, 0x0e, 0xaf, 0x53, 0x65, 0x2f, 0xb7, 0xde, 0x6c, 0x5f, 0x44, 0x3f,
+	0xd9, 0x2d, 0x08, 0xc6, 0x9f, 0x5b, 0xd1, 0x4f, 0xab, 0xd5, 0x1c, 0x8d, 0xbd, 0x61, 0xbb, 0x7d,
+	0x1b, 0xfd, 0x64, 0xb7, 0xa0, 0xb1, 0x3d, 0xfd, 0x06, 0x87, 0x7e, 0x6f, 0x53, 0x5a, 0x60, 0x98,
+	0xfe, 0x1c, 0xe2, 0x6b, 0x4a, 0x5c, 0x4c, 0x6e, 0x6c, 0xa3, 0x7d, 0x33, 0xfd, 0xe3, 0xdb, 0x8b,
+	0x8d, 0xfb, 0xbb, 0xe1, 0xd7, 0x57, 0xef, 0x67, 0xa3, 0x0b, 0x43, 0x3f, 0x30, 0x77, 0x23, 0xbf,
+	0xf8, 0x51, 0xd8, 0xfc, 0xc9, 0xbf, 0x3f, 0x72, 0xde, 0xf1, 0xdf, 0x6f, 0xff, 0x0e, 0x00, 0x17,
+	0xa7, 0x3a, 0xcf, 0xa6, 0x03, 0x00, 0x00,
 }
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	encryption "onion_routing/encryption"
+	utils "onion_routing/utils"
+)
+
+const relayEnvPrefix = "ONION_RELAY_"
+
+// relayStateDir holds the files that must outlive the session logs.
+const relayStateDir = "state"
+
+type RelayConfig struct {
+	NodeID      int                `yaml:"node_id" flag:"node-id" env:"NODE_ID" usage:"numeric relay id; the relay registers as node<id>"`
+	ListenAddr  string             `yaml:"listen_addr" flag:"listen-addr" env:"LISTEN_ADDR" usage:"address the relay serves circuits on (a free localhost port when empty)"`
+	LogsDir     string             `yaml:"logs_dir" flag:"logs-dir" env:"LOGS_DIR" usage:"directory for relay session logs"`
+	TLS         utils.TLSFiles     `yaml:"tls"`
+	Etcd        utils.EtcdSettings `yaml:"etcd"`
+	MetricsAddr string             `yaml:"metrics_addr" flag:"metrics-addr" env:"METRICS_ADDR" usage:"address for the Prometheus metrics endpoint, e.g. localhost:9100 (disabled when empty)"`
+	Directory   struct {
+		Servers       []string `yaml:"servers" flag:"directory-servers" env:"DIRECTORY_SERVERS" usage:"comma separated addresses of directory servers to upload the descriptor to (the relay registers in etcd itself when empty)"`
+		Authorities   []string `yaml:"authorities" flag:"directory-authorities" env:"DIRECTORY_AUTHORITIES" usage:"comma separated base64 public keys of the directory authorities; cells forwarded by relays they list are not rate limited"`
+		MinSignatures int      `yaml:"min_signatures" flag:"directory-min-signatures" env:"DIRECTORY_MIN_SIGNATURES" usage:"authority countersignatures (or consensus documents listing it) a relay descriptor needs"`
+	} `yaml:"directory"`
+	Control struct {
+		Addr         string        `yaml:"addr" flag:"control-addr" env:"CONTROL_ADDR" usage:"loopback address or unix:<path> for the control port (disabled when empty)"`
+		Cookie       string        `yaml:"cookie" flag:"control-cookie" env:"CONTROL_COOKIE" usage:"path of the control cookie file (default state/relay<id>.control_cookie)"`
+		DrainTimeout time.Duration `yaml:"drain_timeout" flag:"control-drain-timeout" env:"CONTROL_DRAIN_TIMEOUT" usage:"stop a draining relay after this long even if circuits are still open"`
+	} `yaml:"control"`
+	BandwidthRate    int64         `yaml:"bandwidth_rate" flag:"bandwidth-rate" env:"BANDWIDTH_RATE" usage:"bytes per second the relay offers, published for bandwidth-weighted path selection"`
+	Family           string        `yaml:"family" flag:"family" env:"FAMILY" usage:"name shared by relays run by the same operator; clients never put two of them in one circuit"`
+	MaxCircuitLength int           `yaml:"max_circuit_length" flag:"max-circuit-length" env:"MAX_CIRCUIT_LENGTH" usage:"refuse to be relay number N+1 or later of a circuit"`
+	OnionKeyLifetime time.Duration `yaml:"onion_key_lifetime" flag:"onion-key-lifetime" env:"ONION_KEY_LIFETIME" usage:"rotate the onion key after this long (0 rotates it only when the replay cache fills up)"`
+	IdentityKey      string        `yaml:"identity_key" flag:"identity-key" env:"IDENTITY_KEY" usage:"file with the Ed25519 key the relay signs its descriptor with, created if missing (default state/relay<id>.identity_key)"`
+	Circuit          struct {
+		IdleTimeout    time.Duration `yaml:"idle_timeout" flag:"circuit-idle-timeout" env:"CIRCUIT_IDLE_TIMEOUT" usage:"forget circuits idle this long when the client does not ask for a timeout (reloadable)"`
+		MinIdleTimeout time.Duration `yaml:"min_idle_timeout" flag:"circuit-min-idle-timeout" env:"CIRCUIT_MIN_IDLE_TIMEOUT" usage:"shortest idle timeout granted to clients (reloadable)"`
+		MaxIdleTimeout time.Duration `yaml:"max_idle_timeout" flag:"circuit-max-idle-timeout" env:"CIRCUIT_MAX_IDLE_TIMEOUT" usage:"longest idle timeout granted to clients (reloadable)"`
+		MaxCellTimeout time.Duration `yaml:"max_cell_timeout" flag:"circuit-max-cell-timeout" env:"CIRCUIT_MAX_CELL_TIMEOUT" usage:"give up on the next hop or the server after this long, even if the client's deadline is later (reloadable)"`
+	} `yaml:"circuit"`
+	ReplayCache struct {
+		Capacity          int     `yaml:"capacity" flag:"replay-cache-capacity" env:"REPLAY_CACHE_CAPACITY" usage:"CREATE handshakes remembered per onion key"`
+		FalsePositiveRate float64 `yaml:"false_positive_rate" flag:"replay-cache-fp-rate" env:"REPLAY_CACHE_FP_RATE" usage:"target false positive rate of the replay cache"`
+	} `yaml:"replay_cache"`
+	DoS  DoSConfig `yaml:"dos"`
+	Exit struct {
+		AllowStreams bool     `yaml:"allow_streams" flag:"exit-allow-streams" env:"EXIT_ALLOW_STREAMS" usage:"open TCP streams requested by clients when this relay is the exit (reloadable)"`
+		Policy       []string `yaml:"policy" flag:"exit-policy" env:"EXIT_POLICY" usage:"comma separated accept|reject <address>:<ports> rules for destinations of this exit (reloadable)"`
+		ServerPorts  []int    `yaml:"server_ports" flag:"exit-server-ports" env:"EXIT_SERVER_PORTS" usage:"comma separated ports on this host that requests may be forwarded to when this relay is the exit (reloadable)"`
+	} `yaml:"exit"`
+	Padding struct {
+		Enabled bool `yaml:"enabled" flag:"padding" env:"PADDING" usage:"send padding cells to other relays (reloadable)"`
+	} `yaml:"padding"`
+}
+
+// DoSConfig bounds what unauthenticated CREATE floods can cost the relay.
+// All of it is reloadable.
+type DoSConfig struct {
+	CreateRate        float64       `yaml:"create_rate" flag:"dos-create-rate" env:"DOS_CREATE_RATE" usage:"CREATE cells per second allowed from one client address"`
+	CreateBurst       int           `yaml:"create_burst" flag:"dos-create-burst" env:"DOS_CREATE_BURST" usage:"CREATE cells a client address may send in a burst"`
+	CellRate          float64       `yaml:"cell_rate" flag:"dos-cell-rate" env:"DOS_CELL_RATE" usage:"other cells per second allowed from one client address"`
+	CellBurst         int           `yaml:"cell_burst" flag:"dos-cell-burst" env:"DOS_CELL_BURST" usage:"other cells a client address may send in a burst"`
+	OverloadThreshold float64       `yaml:"overload_threshold" flag:"dos-overload-threshold" env:"DOS_OVERLOAD_THRESHOLD" usage:"CREATE cells per second above which the relay raises its proof-of-work difficulty"`
+	PowMinDifficulty  uint32        `yaml:"pow_min_difficulty" flag:"dos-pow-min-difficulty" env:"DOS_POW_MIN_DIFFICULTY" usage:"lowest proof-of-work difficulty in leading zero bits (0 = no work when idle)"`
+	PowMaxDifficulty  uint32        `yaml:"pow_max_difficulty" flag:"dos-pow-max-difficulty" env:"DOS_POW_MAX_DIFFICULTY" usage:"highest proof-of-work difficulty in leading zero bits"`
+	AdjustInterval    time.Duration `yaml:"adjust_interval" flag:"dos-adjust-interval" env:"DOS_ADJUST_INTERVAL" usage:"how often the CREATE rate is measured and the difficulty adjusted"`
+}
+
+var (
+	relayConfig     RelayConfig
+	relayConfigLock sync.Mutex
+)
+
+func defaultRelayConfig() RelayConfig {
+	cfg := RelayConfig{
+		NodeID:  1,
+		LogsDir: "logs/relay",
+		TLS: utils.TLSFiles{
+			CA:   "certificates/ca.crt",
+			Cert: "certificates/relay_node.crt",
+			Key:  "certificates/relay_node.key",
+		},
+		Etcd:             utils.DefaultEtcdSettings(),
+		MaxCircuitLength: 8,
+		BandwidthRate:    1 << 20,
+		OnionKeyLifetime: 1 * time.Hour,
+	}
+	cfg.Control.DrainTimeout = 10 * time.Minute
+	cfg.Circuit.IdleTimeout = 5 * time.Second
+	cfg.Circuit.MinIdleTimeout = 5 * time.Second
+	cfg.Circuit.MaxIdleTimeout = 10 * time.Minute
+	cfg.Circuit.MaxCellTimeout = 2 * time.Minute
+	cfg.ReplayCache.Capacity = 100000
+	cfg.ReplayCache.FalsePositiveRate = 0.001
+	cfg.DoS = DoSConfig{
+		CreateRate:        5,
+		CreateBurst:       20,
+		CellRate:          500,
+		CellBurst:         1000,
+		OverloadThreshold: 50,
+		PowMinDifficulty:  0,
+		PowMaxDifficulty:  20,
+		AdjustInterval:    5 * time.Second,
+	}
+	cfg.Directory.MinSignatures = 1
+	if _, port, err := net.SplitHostPort(utils.ServerAddr); err == nil {
+		if p, err := strconv.Atoi(port); err == nil {
+			cfg.Exit.ServerPorts = []int{p}
+		}
+	}
+	cfg.Padding.Enabled = true
+	return cfg
+}
+
+func (c RelayConfig) Validate() error {
+	if c.NodeID < 0 {
+		return utils.ConfigError("node_id", "must not be negative, got %d", c.NodeID)
+	}
+	if c.ListenAddr != "" {
+		if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+			return utils.ConfigError("listen_addr", "%v", err)
+		}
+	}
+	if c.LogsDir == "" {
+		return utils.ConfigError("logs_dir", "must be set")
+	}
+	if err := c.TLS.Validate("tls"); err != nil {
+		return err
+	}
+	if err := c.Etcd.Validate("etcd"); err != nil {
+		return err
+	}
+	for _, addr := range c.Directory.Servers {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return utils.ConfigError("directory.servers", "%v", err)
+		}
+	}
+	for _, key := range c.Directory.Authorities {
+		if _, err := encryption.ParseIdentityKey(key); err != nil {
+			return utils.ConfigError("directory.authorities", "%v", err)
+		}
+	}
+	if len(c.Directory.Authorities) > 0 && (c.Directory.MinSignatures < 1 || c.Directory.MinSignatures > len(c.Directory.Authorities)) {
+		return utils.ConfigError("directory.min_signatures", "must be between 1 and the number of authorities, got %d", c.Directory.MinSignatures)
+	}
+	if c.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsAddr); err != nil {
+			return utils.ConfigError("metrics_addr", "%v", err)
+		}
+	}
+	if c.Control.DrainTimeout <= 0 {
+		return utils.ConfigError("control.drain_timeout", "must be positive, got %v", c.Control.DrainTimeout)
+	}
+	if c.Control.Addr != "" && !strings.HasPrefix(c.Control.Addr, "unix:") {
+		if _, _, err := net.SplitHostPort(c.Control.Addr); err != nil {
+			return utils.ConfigError("control.addr", "%v", err)
+		}
+	}
+	if c.MaxCircuitLength < 1 {
+		return utils.ConfigError("max_circuit_length", "must be at least 1, got %d", c.MaxCircuitLength)
+	}
+	if c.OnionKeyLifetime < 0 {
+		return utils.ConfigError("onion_key_lifetime", "must not be negative, got %v", c.OnionKeyLifetime)
+	}
+	if c.BandwidthRate <= 0 {
+		return utils.ConfigError("bandwidth_rate", "must be positive, got %d", c.BandwidthRate)
+	}
+	if c.Circuit.MinIdleTimeout < time.Second {
+		return utils.ConfigError("circuit.min_idle_timeout", "must be at least 1s, got %v", c.Circuit.MinIdleTimeout)
+	}
+	if c.Circuit.MaxIdleTimeout < c.Circuit.MinIdleTimeout {
+		return utils.ConfigError("circuit.max_idle_timeout", "must not be below circuit.min_idle_timeout (%v), got %v", c.Circuit.MinIdleTimeout, c.Circuit.MaxIdleTimeout)
+	}
+	if c.Circuit.IdleTimeout < c.Circuit.MinIdleTimeout || c.Circuit.IdleTimeout > c.Circuit.MaxIdleTimeout {
+		return utils.ConfigError("circuit.idle_timeout", "must be between circuit.min_idle_timeout and circuit.max_idle_timeout, got %v", c.Circuit.IdleTimeout)
+	}
+	if c.Circuit.MaxCellTimeout <= 0 {
+		return utils.ConfigError("circuit.max_cell_timeout", "must be positive, got %v", c.Circuit.MaxCellTimeout)
+	}
+	if _, err := utils.ParseExitPolicy(c.Exit.Policy); err != nil {
+		return utils.ConfigError("exit.policy", "%v", err)
+	}
+	for _, port := range c.Exit.ServerPorts {
+		if port < 1 || port > 65535 {
+			return utils.ConfigError("exit.server_ports", "invalid port %d", port)
+		}
+	}
+	if c.ReplayCache.Capacity <= 0 {
+		return utils.ConfigError("replay_cache.capacity", "must be positive, got %d", c.ReplayCache.Capacity)
+	}
+	if c.ReplayCache.FalsePositiveRate <= 0 || c.ReplayCache.FalsePositiveRate >= 1 {
+		return utils.ConfigError("replay_cache.false_positive_rate", "must be between 0 and 1, got %v", c.ReplayCache.FalsePositiveRate)
+	}
+	return c.DoS.Validate()
+}
+
+func (d DoSConfig) Validate() error {
+	if d.CreateRate <= 0 {
+		return utils.ConfigError("dos.create_rate", "must be positive, got %v", d.CreateRate)
+	}
+	if d.CreateBurst < 1 {
+		return utils.ConfigError("dos.create_burst", "must be at least 1, got %d", d.CreateBurst)
+	}
+	if d.CellRate <= 0 {
+		return utils.ConfigError("dos.cell_rate", "must be positive, got %v", d.CellRate)
+	}
+	if d.CellBurst < 1 {
+		return utils.ConfigError("dos.cell_burst", "must be at least 1, got %d", d.CellBurst)
+	}
+	if d.OverloadThreshold <= 0 {
+		return utils.ConfigError("dos.overload_threshold", "must be positive, got %v", d.OverloadThreshold)
+	}
+	if d.PowMaxDifficulty > 32 {
+		return utils.ConfigError("dos.pow_max_difficulty", "must be at most 32, got %d", d.PowMaxDifficulty)
+	}
+	if d.PowMinDifficulty > d.PowMaxDifficulty {
+		return utils.ConfigError("dos.pow_min_difficulty", "must not exceed dos.pow_max_difficulty (%d), got %d", d.PowMaxDifficulty, d.PowMinDifficulty)
+	}
+	if d.AdjustInterval <= 0 {
+		return utils.ConfigError("dos.adjust_interval", "must be positive, got %v", d.AdjustInterval)
+	}
+	return nil
+}
+
+// loadRelayConfig parses the command line and builds the relay configuration.
+// A positional node id (as used by `make relay`) takes precedence over all
+// other sources. It returns a function that re-reads the configuration with
+// the same file, environment and flags.
+func loadRelayConfig() (RelayConfig, func() (RelayConfig, error)) {
+	cfg := defaultRelayConfig()
+	configPath := flag.String("config", os.Getenv(relayEnvPrefix+"CONFIG"), "path to the relay's YAML config file")
+	configFlags := utils.RegisterConfigFlags(flag.CommandLine, &cfg)
+	flag.Parse()
+
+	load := func() (RelayConfig, error) {
+		loaded := defaultRelayConfig()
+		err := utils.LoadConfig(*configPath, &loaded, relayEnvPrefix, configFlags)
+		if err != nil {
+			return loaded, err
+		}
+		if args := flag.Args(); len(args) >= 1 {
+			id, err := strconv.Atoi(args[0])
+			if err != nil {
+				return loaded, fmt.Errorf("invalid node id %q; expecting integer value", args[0])
+			}
+			loaded.NodeID = id
+		}
+		return loaded, loaded.Validate()
+	}
+
+	cfg, err := load()
+	if err != nil {
+		log.Fatalf("Invalid relay configuration: %v", err)
+	}
+	return cfg, load
+}
+
+// reloadRelayConfig re-reads the configuration on SIGHUP and applies the
+// settings that can change while circuits are running. Listen addresses,
+// certificates, etcd settings and the node id need a restart.
+func reloadRelayConfig(load func() (RelayConfig, error)) {
+	cfg, err := load()
+	if err != nil {
+		log.Printf("Ignoring configuration reload: %v", err)
+		return
+	}
+	relayConfigLock.Lock()
+	defer relayConfigLock.Unlock()
+	paddingEnabled.Store(cfg.Padding.Enabled)
+	relayConfig.Padding = cfg.Padding
+	relayConfig.DoS = cfg.DoS
+	relayConfig.Exit = cfg.Exit
+	relayConfig.Circuit = cfg.Circuit
+	createRateLimiter.configure(cfg.DoS.CreateRate, cfg.DoS.CreateBurst)
+	cellRateLimiter.configure(cfg.DoS.CellRate, cfg.DoS.CellBurst)
+	log.Println("Relay configuration reloaded")
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+// Connections to the next relays and to the server are kept open and shared
+// by all circuits, instead of a TLS handshake for every cell. gRPC lets an
+// unused connection go idle and reconnects when it is used again.
+
+var (
+	nextHopConns     = make(map[string]*grpc.ClientConn)
+	nextHopConnsLock sync.Mutex
+)
+
+func nextHopConn(addr string) (*grpc.ClientConn, error) {
+	nextHopConnsLock.Lock()
+	defer nextHopConnsLock.Unlock()
+	if conn, exists := nextHopConns[addr]; exists {
+		return conn, nil
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(relayCredsAsClient))
+	if err != nil {
+		return nil, err
+	}
+	nextHopConns[addr] = conn
+	return conn, nil
+}
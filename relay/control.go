@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	routingpb "onion_routing/protofiles"
+	utils "onion_routing/utils"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	controlCookie  []byte
+	paddingEnabled atomic.Bool
+	draining       atomic.Bool
+)
+
+type RelayControlServer struct {
+	routingpb.UnimplementedRelayControlServer
+}
+
+// writeControlCookie creates a fresh random cookie and stores it hex encoded at
+// path, readable only by the relay's user. Controllers send it back as the
+// "authorization" metadata value.
+func writeControlCookie(path string) error {
+	cookie := make([]byte, 32)
+	if _, err := rand.Read(cookie); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	encoded := []byte(hex.EncodeToString(cookie))
+	if err := os.WriteFile(path, encoded, 0600); err != nil {
+		return err
+	}
+	controlCookie = encoded
+	return nil
+}
+
+func authorizeControl(ctx context.Context) error {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get("authorization")) == 0 {
+		return status.Error(codes.Unauthenticated, "missing control cookie")
+	}
+	if subtle.ConstantTimeCompare([]byte(md.Get("authorization")[0]), controlCookie) != 1 {
+		return status.Error(codes.PermissionDenied, "invalid control cookie")
+	}
+	return nil
+}
+
+func controlUnaryAuth(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := authorizeControl(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func controlStreamAuth(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := authorizeControl(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// listenControl binds the control port. Only "unix:<path>" and loopback TCP
+// addresses are accepted. A socket left at the path by an earlier run is
+// replaced; any other file there is left alone.
+func listenControl(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if info, err := os.Lstat(path); err == nil {
+			if info.Mode()&os.ModeSocket == 0 {
+				return nil, fmt.Errorf("control socket path %q exists and is not a socket", path)
+			}
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+		}
+		return net.Listen("unix", path)
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(host)
+	if host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("control address %q is not a loopback address or unix socket", addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+func startControlServer(addr string, cookiePath string) {
+	err := writeControlCookie(cookiePath)
+	if err != nil {
+		log.Fatalf("Failed to write control cookie: %v", err)
+	}
+	listener, err := listenControl(addr)
+	if err != nil {
+		log.Fatalf("Control port failed to listen: %v", err)
+	}
+	log.Printf("Control port listening on %s (cookie: %s)", addr, cookiePath)
+	err = newControlServer().Serve(listener)
+	if err != nil {
+		log.Printf("Control port stopped: %v", err)
+	}
+}
+
+// newControlServer returns the control service, accepting only calls that
+// present the control cookie.
+func newControlServer() *grpc.Server {
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(controlUnaryAuth),
+		grpc.StreamInterceptor(controlStreamAuth),
+	)
+	routingpb.RegisterRelayControlServer(server, &RelayControlServer{})
+	return server
+}
+
+func (s *RelayControlServer) ListCircuits(ctx context.Context, req *routingpb.ListCircuitsRequest) (*routingpb.ListCircuitsResponse, error) {
+	now := time.Now()
+	resp := &routingpb.ListCircuitsResponse{}
+	circuitInfoMapLock.Lock()
+	defer circuitInfoMapLock.Unlock()
+	for id, cinfo := range circuitInfoMap {
+		resp.Circuits = append(resp.Circuits, &routingpb.CircuitSummary{
+			CircuitId:        uint32(id),
+			AgeSeconds:       int64(now.Sub(cinfo.CreatedAt).Seconds()),
+			ExpiresInSeconds: int64(cinfo.ExpTime.Sub(now).Seconds()),
+			BytesForward:     cinfo.BytesForward,
+			BytesBackward:    cinfo.BytesBackward,
+			IsExitNode:       cinfo.IsExitNode,
+		})
+	}
+	return resp, nil
+}
+
+func (s *RelayControlServer) CloseCircuit(ctx context.Context, req *routingpb.CloseCircuitRequest) (*routingpb.CloseCircuitResponse, error) {
+	circuitInfoMapLock.Lock()
+	defer circuitInfoMapLock.Unlock()
+	if _, exists := circuitInfoMap[uint16(req.CircuitId)]; !exists {
+		return nil, status.Error(codes.NotFound, utils.ErrCircuitNotFound.Error())
+	}
+	deleteCircuitLocked(uint16(req.CircuitId), routingpb.RelayEvent_CIRCUIT_CLOSED)
+	return &routingpb.CloseCircuitResponse{}, nil
+}
+
+func (s *RelayControlServer) RotateOnionKey(ctx context.Context, req *routingpb.RotateOnionKeyRequest) (*routingpb.RotateOnionKeyResponse, error) {
+	rotatedAt := rotateOnionKey()
+	return &routingpb.RotateOnionKeyResponse{RotatedAtUnix: rotatedAt.Unix()}, nil
+}
+
+func (s *RelayControlServer) SetPadding(ctx context.Context, req *routingpb.SetPaddingRequest) (*routingpb.SetPaddingResponse, error) {
+	paddingEnabled.Store(req.Enabled)
+	log.Printf("Padding enabled: %v", req.Enabled)
+	return &routingpb.SetPaddingResponse{Enabled: paddingEnabled.Load()}, nil
+}
+
+func (s *RelayControlServer) Drain(ctx context.Context, req *routingpb.DrainRequest) (*routingpb.DrainResponse, error) {
+	startDrain()
+	return &routingpb.DrainResponse{ActiveCircuits: atomic.LoadInt32(&load)}, nil
+}
+
+func (s *RelayControlServer) StreamEvents(req *routingpb.StreamEventsRequest, stream routingpb.RelayControl_StreamEventsServer) error {
+	wanted := make(map[routingpb.RelayEvent_Type]bool)
+	for _, t := range req.Types {
+		wanted[t] = true
+	}
+	events := relayEvents.subscribe()
+	defer relayEvents.unsubscribe(events)
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event := <-events:
+			if len(wanted) > 0 && !wanted[event.Type] {
+				continue
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// startDrain withdraws the relay from the directory and stops accepting new
+// circuits. Once the existing circuits have expired or been closed, or
+// control.drain_timeout has passed, the relay server is stopped.
+func startDrain() {
+	if !draining.CompareAndSwap(false, true) {
+		return
+	}
+	log.Println("Draining relay")
+	if etcdClient != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		_, err := etcdClient.Revoke(ctx, etcdLeaseID)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to withdraw relay record: %v", err)
+		}
+	}
+	withdrawFromDirectories()
+	relayConfigLock.Lock()
+	deadline := time.Now().Add(relayConfig.Control.DrainTimeout)
+	relayConfigLock.Unlock()
+	go func() {
+		for atomic.LoadInt32(&load) > 0 && time.Now().Before(deadline) {
+			time.Sleep(1 * time.Second)
+		}
+		if remaining := atomic.LoadInt32(&load); remaining > 0 {
+			log.Printf("Drain timed out with %d circuits open", remaining)
+		}
+		log.Println("Relay drained")
+		relayEvents.publish(&routingpb.RelayEvent{Type: routingpb.RelayEvent_DRAINED})
+		time.Sleep(1 * time.Second) // let event subscribers see DRAINED
+		relayServer.GracefulStop()
+	}()
+}
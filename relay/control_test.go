@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	routingpb "onion_routing/protofiles"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// startTestControl serves the control service on a unix socket in a
+// temporary directory and returns a client for it and the cookie.
+func startTestControl(t *testing.T) (routingpb.RelayControlClient, string) {
+	t.Helper()
+	dir := t.TempDir()
+	cookiePath := filepath.Join(dir, "relay.control_cookie")
+	if err := writeControlCookie(cookiePath); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(cookiePath); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("cookie file: %v, mode %v, want 0600", err, info.Mode().Perm())
+	}
+	cookie, err := os.ReadFile(cookiePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := listenControl("unix:" + filepath.Join(dir, "control.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newControlServer()
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	conn, err := grpc.NewClient("unix:"+filepath.Join(dir, "control.sock"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return routingpb.NewRelayControlClient(conn), string(cookie)
+}
+
+func TestControlCookieAuth(t *testing.T) {
+	client, cookie := startTestControl(t)
+	tests := []struct {
+		name   string
+		cookie string
+		want   codes.Code
+	}{
+		{"no cookie", "", codes.Unauthenticated},
+		{"wrong cookie", strings.Repeat("0", len(cookie)), codes.PermissionDenied},
+		{"cookie prefix", cookie[:len(cookie)-1], codes.PermissionDenied},
+		{"cookie", cookie, codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.cookie != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "authorization", tt.cookie)
+			}
+			_, err := client.ListCircuits(ctx, &routingpb.ListCircuitsRequest{})
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("ListCircuits = %v, want code %v", err, tt.want)
+			}
+			if tt.want == codes.OK {
+				return
+			}
+			// streams are checked too
+			stream, err := client.StreamEvents(ctx, &routingpb.StreamEventsRequest{})
+			if err == nil {
+				_, err = stream.Recv()
+			}
+			if status.Code(err) != tt.want {
+				t.Errorf("StreamEvents = %v, want code %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestListenControlLoopbackOnly(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:0", "[::1]:0", "localhost:0"} {
+		listener, err := listenControl(addr)
+		if err != nil {
+			if strings.Contains(err.Error(), "not a loopback") {
+				t.Errorf("listenControl(%s) refused a loopback address: %v", addr, err)
+			}
+			continue // e.g. no IPv6 in the sandbox
+		}
+		listener.Close()
+	}
+	for _, addr := range []string{"0.0.0.0:0", "[::]:0", "192.0.2.1:9151", "example.com:9151", ":9151"} {
+		if listener, err := listenControl(addr); err == nil {
+			listener.Close()
+			t.Errorf("listenControl(%s) accepted a non-loopback address", addr)
+		}
+	}
+}
+
+func TestListenControlSocketPath(t *testing.T) {
+	dir := t.TempDir()
+
+	file := filepath.Join(dir, "not-a-socket")
+	if err := os.WriteFile(file, []byte("keep me"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if listener, err := listenControl("unix:" + file); err == nil {
+		listener.Close()
+		t.Fatal("listenControl replaced a regular file")
+	}
+	if data, err := os.ReadFile(file); err != nil || string(data) != "keep me" {
+		t.Errorf("regular file at the socket path was changed: %q, %v", data, err)
+	}
+
+	// a socket left behind by an earlier run is replaced
+	socket := filepath.Join(dir, "control.sock")
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	listener, err := listenControl("unix:" + socket)
+	if err != nil {
+		t.Fatalf("listenControl over a stale socket: %v", err)
+	}
+	listener.Close()
+}
+
+func TestDrainStopsAfterTimeout(t *testing.T) {
+	client, cookie := startTestControl(t)
+	savedConfig, savedLoad := relayConfig, atomic.LoadInt32(&load)
+	defer func() {
+		relayConfig = savedConfig
+		atomic.StoreInt32(&load, savedLoad)
+		draining.Store(false)
+	}()
+	// stopped by the drain once it is over; left in place for that
+	relayServer = grpc.NewServer()
+	relayConfig.Control.DrainTimeout = 100 * time.Millisecond
+	atomic.StoreInt32(&load, 2) // circuits that never close
+
+	events := relayEvents.subscribe()
+	defer relayEvents.unsubscribe(events)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", cookie)
+	resp, err := client.Drain(ctx, &routingpb.DrainRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ActiveCircuits != 2 {
+		t.Errorf("Drain reported %d active circuits, want 2", resp.ActiveCircuits)
+	}
+	if !draining.Load() {
+		t.Error("relay is not draining after Drain")
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Type == routingpb.RelayEvent_DRAINED {
+				return
+			}
+		case <-timeout:
+			t.Fatal("no DRAINED event after control.drain_timeout passed")
+		}
+	}
+}
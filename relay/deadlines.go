@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	utils "onion_routing/utils"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Clients send every cell with a deadline, and gRPC tells each hop how much
+// of it is left. A relay enforces it on its own: it calls the next hop or the
+// server with the time left less a margin, so the hops behind it give up
+// first and their error still makes it back, and never with more than
+// circuit.max_cell_timeout. Calls are made with the incoming call's context,
+// so when the client cancels, every hop abandons its call in turn.
+
+// maxHopMargin bounds the share of the remaining time a hop keeps for
+// itself.
+const maxHopMargin = 250 * time.Millisecond
+
+// forwardContext returns the context for the calls a relay makes on behalf
+// of an incoming call.
+func forwardContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	relayConfigLock.Lock()
+	limit := relayConfig.Circuit.MaxCellTimeout
+	relayConfigLock.Unlock()
+	deadline := time.Now().Add(limit)
+	if clientDeadline, ok := ctx.Deadline(); ok {
+		margin := min(time.Until(clientDeadline)/10, maxHopMargin)
+		if clientDeadline.Add(-margin).Before(deadline) {
+			deadline = clientDeadline.Add(-margin)
+		}
+	}
+	return context.WithDeadline(ctx, deadline)
+}
+
+// abandonedError returns the error for a call given up because ctx ended,
+// or nil if ctx is still live.
+func abandonedError(ctx context.Context) error {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		abandonedCallsTotal.WithLabelValues("deadline").Inc()
+		return status.Error(codes.DeadlineExceeded, utils.ErrDeadlineExceeded.Error())
+	case ctx.Err() != nil:
+		abandonedCallsTotal.WithLabelValues("cancelled").Inc()
+		return status.Error(codes.Canceled, ctx.Err().Error())
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	encryption "onion_routing/encryption"
+	netdir "onion_routing/netdir"
+	routingpb "onion_routing/protofiles"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// With directory.servers configured the relay does not register in etcd.
+// It uploads its signed descriptor to every directory server and then sends
+// them signed heartbeats; a server stops listing the relay when the
+// heartbeats stop, or at once when the relay withdraws as it drains.
+
+// directoryRetryInterval is how long the relay waits after a directory server
+// could not be reached, or before its first upload succeeded.
+const directoryRetryInterval = 3 * time.Second
+
+// directoryTimeout bounds every call to a directory server.
+const directoryTimeout = 5 * time.Second
+
+type directoryServer struct {
+	addr   string
+	client routingpb.DirectoryClient
+	// held during calls, so that a heartbeat in flight cannot list the relay
+	// again after it withdrew
+	lock sync.Mutex
+}
+
+var directoryServers []*directoryServer
+
+func newDirectoryServer(addr string) *directoryServer {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(relayCredsAsClient))
+	if err != nil {
+		log.Fatalf("Invalid directory server address %s: %v", addr, err)
+	}
+	return &directoryServer{addr: addr, client: routingpb.NewDirectoryClient(conn)}
+}
+
+// uploadLoop keeps the relay listed by the directory server, uploading the
+// descriptor again when it changes or the server has forgotten the relay.
+func (s *directoryServer) uploadLoop() {
+	var uploaded []byte // digest of the descriptor the server has
+	interval := directoryRetryInterval
+	for {
+		s.lock.Lock()
+		if draining.Load() {
+			s.lock.Unlock()
+			return
+		}
+		var err error
+		if d := currentDescriptor(); string(d.Digest()) != string(uploaded) {
+			var heartbeatInterval time.Duration
+			heartbeatInterval, err = uploadDescriptor(s.client, d)
+			if err == nil {
+				uploaded, interval = d.Digest(), heartbeatInterval
+				relayLogger.PrintLog("Uploaded descriptor to directory server %s", s.addr)
+			}
+		} else {
+			err = sendHeartbeat(s.client)
+		}
+		s.lock.Unlock()
+		if status.Code(err) == codes.NotFound {
+			uploaded = nil
+			continue
+		}
+		if err != nil {
+			directoryFailuresTotal.Inc()
+			log.Printf("Directory server %s: %v", s.addr, err)
+			interval = directoryRetryInterval
+		}
+		time.Sleep(interval)
+	}
+}
+
+// withdrawFromDirectories asks every directory server to drop the relay;
+// draining must already be set so that no heartbeat follows.
+func withdrawFromDirectories() {
+	for _, s := range directoryServers {
+		s.lock.Lock()
+		ctx, cancel := context.WithTimeout(context.Background(), directoryTimeout)
+		sent := time.Now()
+		_, err := s.client.Withdraw(ctx, &routingpb.WithdrawRequest{
+			NodeId:     nodeID,
+			SentUnixMs: sent.UnixMilli(),
+			Signature:  encryption.SignWithdrawal(identityKey, nodeID, sent),
+		})
+		cancel()
+		s.lock.Unlock()
+		if err != nil {
+			log.Printf("Failed to withdraw from directory server %s: %v", s.addr, err)
+		}
+	}
+}
+
+func uploadDescriptor(client routingpb.DirectoryClient, d encryption.SignedDescriptor) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), directoryTimeout)
+	defer cancel()
+	pow := powDifficulty.Load()
+	resp, err := client.UploadDescriptor(ctx, &routingpb.UploadDescriptorRequest{
+		NodeId:        nodeID,
+		Body:          d.Body,
+		IdentityKey:   d.IdentityKey,
+		Signature:     d.Signature,
+		Load:          atomic.LoadInt32(&load),
+		PowDifficulty: pow,
+	})
+	if err != nil {
+		return 0, err
+	}
+	publishedPowDifficulty.Store(pow)
+	return max(time.Duration(resp.HeartbeatIntervalMs)*time.Millisecond, time.Second), nil
+}
+
+func sendHeartbeat(client routingpb.DirectoryClient) error {
+	ctx, cancel := context.WithTimeout(context.Background(), directoryTimeout)
+	defer cancel()
+	currentLoad, pow, sent := atomic.LoadInt32(&load), powDifficulty.Load(), time.Now()
+	_, err := client.Heartbeat(ctx, &routingpb.HeartbeatRequest{
+		NodeId:        nodeID,
+		Load:          currentLoad,
+		PowDifficulty: pow,
+		SentUnixMs:    sent.UnixMilli(),
+		Signature:     encryption.SignHeartbeat(identityKey, nodeID, currentLoad, pow, sent),
+	})
+	if err == nil {
+		publishedPowDifficulty.Store(pow)
+	}
+	return err
+}
+
+// consensusDirectory returns the directory the relays in the consensus are
+// read from, checked against the configured authorities.
+func consensusDirectory(cfg RelayConfig) netdir.Directory {
+	var authorities []ed25519.PublicKey
+	for _, key := range cfg.Directory.Authorities {
+		authority, _ := encryption.ParseIdentityKey(key) // checked by Validate
+		authorities = append(authorities, authority)
+	}
+	if len(cfg.Directory.Servers) > 0 {
+		return &netdir.GRPCDirectory{
+			Addrs:         cfg.Directory.Servers,
+			Credentials:   relayCredsAsClient,
+			Timeout:       directoryTimeout,
+			Authorities:   authorities,
+			MinSignatures: cfg.Directory.MinSignatures,
+			Logger:        relayLogger,
+		}
+	}
+	etcdDirectory := netdir.NewEtcdDirectory()
+	etcdDirectory.Authorities = authorities
+	etcdDirectory.MinSignatures = cfg.Directory.MinSignatures
+	etcdDirectory.Logger = relayLogger
+	return etcdDirectory
+}
+
+// consensusEntry is the part of a consensus document entry the relay reads.
+type consensusEntry struct {
+	Descriptor encryption.SignedDescriptor `json:"descriptor"`
+}
+
+// directoryRelayNodes lists the relays in the consensus of the first
+// directory server that answers. It is only used to pick padding targets, so
+// the document's signature is checked but not who signed it.
+func directoryRelayNodes() ([]RelayNode, error) {
+	err := fmt.Errorf("no directory server")
+	for _, s := range directoryServers {
+		ctx, cancel := context.WithTimeout(context.Background(), directoryTimeout)
+		var resp *routingpb.ConsensusDocument
+		resp, err = s.client.GetConsensus(ctx, &routingpb.GetConsensusRequest{})
+		cancel()
+		if err == nil {
+			err = encryption.VerifyConsensus(resp.Body, ed25519.PublicKey(resp.AuthorityKey), resp.Signature)
+		}
+		var doc struct {
+			Relays []consensusEntry `json:"relays"`
+		}
+		if err == nil {
+			err = json.Unmarshal(resp.Body, &doc)
+		}
+		if err != nil {
+			continue
+		}
+		nodes := []RelayNode{}
+		for _, entry := range doc.Relays {
+			var node RelayNode
+			if entry.Descriptor.Verify() != nil || json.Unmarshal(entry.Descriptor.Body, &node) != nil {
+				continue
+			}
+			nodes = append(nodes, node)
+		}
+		return nodes, nil
+	}
+	log.Printf("Failed to fetch the consensus: %v", err)
+	return nil, err
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	encryption "onion_routing/encryption"
+	netdir "onion_routing/netdir"
+	routingpb "onion_routing/protofiles"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/peer"
+)
+
+// relayPeersInterval is how often the relays in the consensus are fetched.
+const relayPeersInterval = 30 * time.Second
+
+// Every cell costs an RSA decryption. Clients are limited per source address
+// with token buckets, a tight one for CREATE cells and a looser one for all
+// other cells, and when the relay as a whole sees more CREATE cells than it
+// is configured to handle it starts asking for proof-of-work. The difficulty
+// is published in the relay's directory record. Relays in the consensus are
+// not rate limited since they forward many clients' cells; they sign what they
+// forward with their identity key. The proof-of-work (solved by the client)
+// still applies to CREATE cells they forward.
+
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+type rateLimiter struct {
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+	rate    float64
+	burst   float64
+}
+
+var (
+	createRateLimiter = &rateLimiter{buckets: make(map[string]*tokenBucket)}
+	cellRateLimiter   = &rateLimiter{buckets: make(map[string]*tokenBucket)}
+
+	// fingerprints of the identity keys of the relays in the consensus
+	relayPeersLock sync.RWMutex
+	relayPeers     map[string]bool
+
+	powDifficulty          atomic.Uint32
+	publishedPowDifficulty atomic.Uint32
+	createCellsSeen        atomic.Int64
+
+	createRateLimitedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "onion_relay",
+		Name:      "create_rate_limited_total",
+		Help:      "CREATE cells dropped by the per-source rate limit.",
+	})
+	cellRateLimitedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "onion_relay",
+		Name:      "cell_rate_limited_total",
+		Help:      "Cells other than CREATE dropped by the per-source rate limit.",
+	})
+	powRejectedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "onion_relay",
+		Name:      "pow_rejected_total",
+		Help:      "CREATE cells dropped for missing or insufficient proof-of-work.",
+	})
+	powDifficultyGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "onion_relay",
+		Name:      "pow_difficulty",
+		Help:      "Proof-of-work difficulty (leading zero bits) currently required for CREATE cells.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(createRateLimitedTotal, cellRateLimitedTotal, powRejectedTotal, powDifficultyGauge)
+}
+
+func (l *rateLimiter) configure(rate float64, burst int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.rate = rate
+	l.burst = float64(burst)
+}
+
+// take removes a token from source's bucket and reports whether one was
+// available.
+func (l *rateLimiter) take(source string) bool {
+	return l.takeAt(source, time.Now())
+}
+
+func (l *rateLimiter) takeAt(source string, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	bucket, exists := l.buckets[source]
+	if !exists {
+		bucket = &tokenBucket{tokens: l.burst, lastSeen: now}
+		l.buckets[source] = bucket
+	}
+	bucket.tokens += now.Sub(bucket.lastSeen).Seconds() * l.rate
+	if bucket.tokens > l.burst {
+		bucket.tokens = l.burst
+	}
+	bucket.lastSeen = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// cleanupLoop forgets sources whose buckets have refilled completely.
+func (l *rateLimiter) cleanupLoop() {
+	for {
+		time.Sleep(1 * time.Minute)
+		l.lock.Lock()
+		for source, bucket := range l.buckets {
+			if bucket.tokens+time.Since(bucket.lastSeen).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, source)
+			}
+		}
+		l.lock.Unlock()
+	}
+}
+
+// peerSource returns the peer's IP address and whether req was forwarded by
+// a relay in the consensus. All relays present the same TLS certificate, so
+// only the signature by the relay's identity key tells them apart.
+func peerSource(ctx context.Context, req *routingpb.RelayRequest) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	source := p.Addr.String()
+	if host, _, err := net.SplitHostPort(source); err == nil {
+		source = host
+	}
+	if len(req.RelayIdentity) != ed25519.PublicKeySize {
+		return source, false
+	}
+	relayPeersLock.RLock()
+	known := relayPeers[encryption.Fingerprint(ed25519.PublicKey(req.RelayIdentity))]
+	relayPeersLock.RUnlock()
+	return source, known && encryption.VerifyForwardedCell(req.RelayIdentity, req.Message, req.Create, req.PowNonce, req.HopCount, req.RelaySignature)
+}
+
+// signForwarded marks req as forwarded by this relay.
+func signForwarded(req *routingpb.RelayRequest) {
+	req.RelayIdentity = identityKey.Public().(ed25519.PublicKey)
+	req.RelaySignature = encryption.SignForwardedCell(identityKey, req.Message, req.Create, req.PowNonce, req.HopCount)
+}
+
+// relayPeersLoop keeps relayPeers up to date with the relays in the
+// consensus, as checked against the directory authorities. Until the
+// consensus lists this relay, relays that just started are likely missing
+// too, so it is fetched again sooner.
+func relayPeersLoop(directory netdir.Directory) {
+	self := encryption.Fingerprint(identityKey.Public().(ed25519.PublicKey))
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), directoryTimeout)
+		consensus, err := directory.Consensus(ctx)
+		cancel()
+		peers := make(map[string]bool)
+		if err != nil {
+			log.Printf("Failed to fetch the consensus for relay peers: %v", err)
+		} else {
+			for _, node := range consensus.Relays {
+				peers[node.Identity] = true
+			}
+			relayPeersLock.Lock()
+			relayPeers = peers
+			relayPeersLock.Unlock()
+		}
+		if peers[self] {
+			time.Sleep(relayPeersInterval)
+		} else {
+			time.Sleep(directoryRetryInterval)
+		}
+	}
+}
+
+// requiredPowDifficulty is the lower of the current and the published
+// difficulty, so clients working from the last directory record still get in
+// while the difficulty is going up.
+func requiredPowDifficulty() uint32 {
+	return min(powDifficulty.Load(), publishedPowDifficulty.Load())
+}
+
+// powAdjustLoop measures the CREATE rate and moves the difficulty one bit at a
+// time between the configured bounds.
+func powAdjustLoop() {
+	for {
+		relayConfigLock.Lock()
+		dos := relayConfig.DoS
+		relayConfigLock.Unlock()
+
+		time.Sleep(dos.AdjustInterval)
+		rate := float64(createCellsSeen.Swap(0)) / dos.AdjustInterval.Seconds()
+		difficulty := nextPowDifficulty(powDifficulty.Load(), rate, dos)
+		powDifficulty.Store(difficulty)
+		powDifficultyGauge.Set(float64(difficulty))
+	}
+}
+
+// nextPowDifficulty returns the difficulty to require after a period in which
+// CREATE cells arrived at rate per second.
+func nextPowDifficulty(difficulty uint32, rate float64, dos DoSConfig) uint32 {
+	switch {
+	case rate > dos.OverloadThreshold && difficulty < dos.PowMaxDifficulty:
+		difficulty++
+	case rate < dos.OverloadThreshold/2 && difficulty > dos.PowMinDifficulty:
+		difficulty--
+	}
+	return max(min(difficulty, dos.PowMaxDifficulty), dos.PowMinDifficulty)
+}
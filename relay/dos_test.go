@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	encryption "onion_routing/encryption"
+	routingpb "onion_routing/protofiles"
+	utils "onion_routing/utils"
+
+	"google.golang.org/grpc/peer"
+)
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	l := &rateLimiter{buckets: make(map[string]*tokenBucket)}
+	l.configure(10, 3)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if !l.takeAt("10.0.0.1", start) {
+			t.Fatalf("cell %d of the burst refused", i+1)
+		}
+	}
+	if l.takeAt("10.0.0.1", start) {
+		t.Error("cell past the burst allowed")
+	}
+	if !l.takeAt("10.0.0.2", start) {
+		t.Error("another source shares the bucket")
+	}
+	// 10 per second: one token back after 100ms
+	if !l.takeAt("10.0.0.1", start.Add(100*time.Millisecond)) {
+		t.Error("no token after 100ms at 10 per second")
+	}
+	if l.takeAt("10.0.0.1", start.Add(100*time.Millisecond)) {
+		t.Error("more than one token after 100ms")
+	}
+	// a long pause refills no more than the burst
+	later := start.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !l.takeAt("10.0.0.1", later) {
+			t.Fatalf("cell %d after a pause refused", i+1)
+		}
+	}
+	if l.takeAt("10.0.0.1", later) {
+		t.Error("bucket refilled past the burst")
+	}
+}
+
+func TestNextPowDifficulty(t *testing.T) {
+	dos := DoSConfig{OverloadThreshold: 50, PowMinDifficulty: 2, PowMaxDifficulty: 4}
+	tests := []struct {
+		name       string
+		difficulty uint32
+		rate       float64
+		want       uint32
+	}{
+		{"overloaded", 2, 60, 3},
+		{"overloaded at the maximum", 4, 600, 4},
+		{"between half and the threshold", 3, 40, 3},
+		{"at the threshold", 3, 50, 3},
+		{"quiet", 3, 10, 2},
+		{"quiet at the minimum", 2, 0, 2},
+		{"below the minimum after a reload", 0, 60, 2},
+		{"above the maximum after a reload", 9, 0, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextPowDifficulty(tt.difficulty, tt.rate, dos); got != tt.want {
+				t.Errorf("nextPowDifficulty(%d, %v) = %d, want %d", tt.difficulty, tt.rate, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequiredPowDifficulty(t *testing.T) {
+	defer powDifficulty.Store(powDifficulty.Load())
+	defer publishedPowDifficulty.Store(publishedPowDifficulty.Load())
+	powDifficulty.Store(8)
+	publishedPowDifficulty.Store(6)
+	if got := requiredPowDifficulty(); got != 6 {
+		t.Errorf("while the difficulty rises clients with the published one get in: got %d, want 6", got)
+	}
+	powDifficulty.Store(4)
+	if got := requiredPowDifficulty(); got != 4 {
+		t.Errorf("after the difficulty fell: got %d, want 4", got)
+	}
+}
+
+func peerContext(addr string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(addr), Port: 4321}})
+}
+
+func TestPeerSource(t *testing.T) {
+	savedKey := identityKey
+	relayPeersLock.Lock()
+	savedPeers := relayPeers
+	relayPeersLock.Unlock()
+	defer func() {
+		identityKey = savedKey
+		relayPeersLock.Lock()
+		relayPeers = savedPeers
+		relayPeersLock.Unlock()
+	}()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identityKey = key
+	fingerprint := encryption.Fingerprint(key.Public().(ed25519.PublicKey))
+
+	signed := func() *routingpb.RelayRequest {
+		req := &routingpb.RelayRequest{Message: []byte("cell"), Create: true, PowNonce: 7, HopCount: 1}
+		signForwarded(req)
+		return req
+	}
+	tests := []struct {
+		name   string
+		peers  map[string]bool
+		req    *routingpb.RelayRequest
+		change func(*routingpb.RelayRequest)
+		want   bool
+	}{
+		{"relay in the consensus", map[string]bool{fingerprint: true}, signed(), nil, true},
+		{"relay not in the consensus", map[string]bool{}, signed(), nil, false},
+		{"unsigned cell", map[string]bool{fingerprint: true}, &routingpb.RelayRequest{Message: []byte("cell")}, nil, false},
+		{"message changed", map[string]bool{fingerprint: true}, signed(), func(r *routingpb.RelayRequest) { r.Message = []byte("other") }, false},
+		{"hop count changed", map[string]bool{fingerprint: true}, signed(), func(r *routingpb.RelayRequest) { r.HopCount = 0 }, false},
+		{"create flag dropped", map[string]bool{fingerprint: true}, signed(), func(r *routingpb.RelayRequest) { r.Create = false }, false},
+		{"bad signature", map[string]bool{fingerprint: true}, signed(), func(r *routingpb.RelayRequest) { r.RelaySignature[0] ^= 1 }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relayPeersLock.Lock()
+			relayPeers = tt.peers
+			relayPeersLock.Unlock()
+			if tt.change != nil {
+				tt.change(tt.req)
+			}
+			source, fromRelay := peerSource(peerContext("192.0.2.7"), tt.req)
+			if source != "192.0.2.7" {
+				t.Errorf("source = %q, want the peer's IP address", source)
+			}
+			if fromRelay != tt.want {
+				t.Errorf("fromRelay = %v, want %v", fromRelay, tt.want)
+			}
+		})
+	}
+}
+
+// A CREATE to a stale onion key pays for its decryption once.
+func TestUndecryptableCreateChargedOnce(t *testing.T) {
+	savedDoS, savedMaxLength := relayConfig.DoS, maxCircuitLength
+	defer func() {
+		createRateLimiter.configure(savedDoS.CreateRate, savedDoS.CreateBurst)
+		cellRateLimiter.configure(savedDoS.CellRate, savedDoS.CellBurst)
+		maxCircuitLength = savedMaxLength
+	}()
+	maxCircuitLength = 8
+	createRateLimiter.configure(0.001, 2)
+	cellRateLimiter.configure(0.001, 10)
+	if privateKey == nil {
+		privateKey, pubKey = genKeyPairs()
+	}
+	garbage := make([]byte, encryption.ENCRYPTEDHEADERSIZE+16)
+
+	ctx := peerContext("192.0.2.8")
+	for i := 0; i < 2; i++ {
+		if _, _, err := handleRequest(ctx, &routingpb.RelayRequest{Message: garbage, Create: true}); !errors.Is(err, utils.ErrInvalidCell) {
+			t.Fatalf("CREATE %d = %v, want %v", i+1, err, utils.ErrInvalidCell)
+		}
+	}
+	if _, _, err := handleRequest(ctx, &routingpb.RelayRequest{Message: garbage, Create: true}); !errors.Is(err, utils.ErrRateLimited) {
+		t.Errorf("CREATE past the burst = %v, want %v", err, utils.ErrRateLimited)
+	}
+
+	// other undecryptable cells are charged against the CREATE allowance too
+	ctx = peerContext("192.0.2.9")
+	for i := 0; i < 2; i++ {
+		if _, _, err := handleRequest(ctx, &routingpb.RelayRequest{Message: garbage}); !errors.Is(err, utils.ErrInvalidCell) {
+			t.Fatalf("cell %d = %v, want %v", i+1, err, utils.ErrInvalidCell)
+		}
+	}
+	if _, _, err := handleRequest(ctx, &routingpb.RelayRequest{Message: garbage, Create: true}); !errors.Is(err, utils.ErrRateLimited) {
+		t.Errorf("CREATE after undecryptable cells used the allowance = %v, want %v", err, utils.ErrRateLimited)
+	}
+}
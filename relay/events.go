@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	routingpb "onion_routing/protofiles"
+)
+
+// eventBus fans relay events out to control-port subscribers. Slow subscribers
+// miss events instead of blocking the data path.
+type eventBus struct {
+	lock        sync.Mutex
+	subscribers map[chan *routingpb.RelayEvent]struct{}
+}
+
+var (
+	relayEvents = &eventBus{subscribers: make(map[chan *routingpb.RelayEvent]struct{})}
+
+	bandwidthForward  uint64
+	bandwidthBackward uint64
+)
+
+func (b *eventBus) subscribe() chan *routingpb.RelayEvent {
+	ch := make(chan *routingpb.RelayEvent, 64)
+	b.lock.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.lock.Unlock()
+	return ch
+}
+
+func (b *eventBus) unsubscribe(ch chan *routingpb.RelayEvent) {
+	b.lock.Lock()
+	delete(b.subscribers, ch)
+	b.lock.Unlock()
+}
+
+func (b *eventBus) publish(event *routingpb.RelayEvent) {
+	event.TimestampUnix = time.Now().Unix()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func publishCircuitEvent(eventType routingpb.RelayEvent_Type, circuitID uint16) {
+	relayEvents.publish(&routingpb.RelayEvent{Type: eventType, CircuitId: uint32(circuitID)})
+}
+
+func publishErrorEvent(err error) {
+	relayEvents.publish(&routingpb.RelayEvent{Type: routingpb.RelayEvent_ERROR, Message: err.Error()})
+}
+
+// recordBandwidth accounts relayed bytes for the metrics endpoint, the
+// circuit's own counters and the per-second BANDWIDTH events.
+func recordBandwidth(circuitID uint16, forward int, backward int) {
+	forwardedBytesTotal.WithLabelValues("forward").Add(float64(forward))
+	forwardedBytesTotal.WithLabelValues("backward").Add(float64(backward))
+	atomic.AddUint64(&bandwidthForward, uint64(forward))
+	atomic.AddUint64(&bandwidthBackward, uint64(backward))
+
+	circuitInfoMapLock.Lock()
+	if cinfo, exists := circuitInfoMap[circuitID]; exists {
+		cinfo.BytesForward += uint64(forward)
+		cinfo.BytesBackward += uint64(backward)
+	}
+	circuitInfoMapLock.Unlock()
+}
+
+func bandwidthEventLoop() {
+	for {
+		time.Sleep(1 * time.Second)
+		forward := atomic.SwapUint64(&bandwidthForward, 0)
+		backward := atomic.SwapUint64(&bandwidthBackward, 0)
+		relayEvents.publish(&routingpb.RelayEvent{
+			Type:          routingpb.RelayEvent_BANDWIDTH,
+			BytesForward:  forward,
+			BytesBackward: backward,
+		})
+	}
+}
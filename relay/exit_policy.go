@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+
+	utils "onion_routing/utils"
+)
+
+// currentExitPolicy returns the configured exit policy. It was validated when
+// the configuration was loaded.
+func currentExitPolicy() utils.ExitPolicy {
+	relayConfigLock.Lock()
+	lines := relayConfig.Exit.Policy
+	relayConfigLock.Unlock()
+	policy, err := utils.ParseExitPolicy(lines)
+	if err != nil {
+		return utils.ExitPolicy{}
+	}
+	return policy
+}
+
+func exitAllowsStreams() bool {
+	relayConfigLock.Lock()
+	defer relayConfigLock.Unlock()
+	return relayConfig.Exit.AllowStreams
+}
+
+// checkStreamDestination checks where a client asked this exit to open a
+// stream. Loopback, private and link-local addresses are always refused.
+func checkStreamDestination(address string) (string, error) {
+	return checkExitPolicy(address, currentExitPolicy().RejectPrivate())
+}
+
+// checkServerPort checks the port a circuit forwards requests to on this
+// host, which must be one of exit.server_ports and accepted by the policy.
+func checkServerPort(port uint16) (string, error) {
+	address := fmt.Sprintf("localhost:%d", port)
+	relayConfigLock.Lock()
+	allowed := slices.Contains(relayConfig.Exit.ServerPorts, int(port))
+	relayConfigLock.Unlock()
+	if !allowed {
+		return "", fmt.Errorf("%w: %s is not a server port", utils.ErrExitPolicy, address)
+	}
+	return checkExitPolicy(address, currentExitPolicy())
+}
+
+// checkExitPolicy resolves address and returns the first of its addresses
+// policy accepts, so the check and the connection use the same IP.
+func checkExitPolicy(address string, policy utils.ExitPolicy) (string, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", fmt.Errorf("invalid port %q", portStr)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), streamDialTimeout)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return "", err
+	}
+	for _, ip := range ips {
+		if policy.Allows(ip, port) {
+			return net.JoinHostPort(ip.String(), portStr), nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", utils.ErrExitPolicy, address)
+}
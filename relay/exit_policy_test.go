@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	utils "onion_routing/utils"
+)
+
+func TestExitChecks(t *testing.T) {
+	savedConfig := relayConfig
+	defer func() { relayConfig = savedConfig }()
+	relayConfig.Exit.Policy = []string{"accept *:*"}
+	relayConfig.Exit.ServerPorts = []int{45034}
+
+	for _, address := range []string{"127.0.0.1:2379", "localhost:9151", "10.0.0.1:80", "[::1]:45034", "169.254.169.254:80"} {
+		if _, err := checkStreamDestination(address); !errors.Is(err, utils.ErrExitPolicy) {
+			t.Errorf("stream to %s = %v, want %v", address, err, utils.ErrExitPolicy)
+		}
+	}
+	if _, err := checkStreamDestination("93.184.216.34:80"); err != nil {
+		t.Errorf("stream to a public address = %v", err)
+	}
+
+	if _, err := checkServerPort(45034); err != nil {
+		t.Errorf("request to the server port = %v", err)
+	}
+	for _, port := range []uint16{2379, 9151, 45040} {
+		if _, err := checkServerPort(port); !errors.Is(err, utils.ErrExitPolicy) {
+			t.Errorf("request to localhost:%d = %v, want %v", port, err, utils.ErrExitPolicy)
+		}
+	}
+	// the policy still applies to the server
+	relayConfig.Exit.Policy = []string{"reject *:45034"}
+	if _, err := checkServerPort(45034); !errors.Is(err, utils.ErrExitPolicy) {
+		t.Errorf("request to a server port the policy rejects = %v, want %v", err, utils.ErrExitPolicy)
+	}
+}
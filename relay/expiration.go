@@ -1,20 +1,55 @@
 package main
 
 import (
+	"encoding/binary"
 	"log"
 	"sync/atomic"
 	"time"
+
+	routingpb "onion_routing/protofiles"
 )
 
+// Clients ask for an idle timeout in the CREATE cell's Expiration field;
+// the relay grants it clamped to its configured limits and answers with the
+// granted value in the CREATED confirmation. Every data cell on the circuit,
+// including the keepalives clients send on idle circuits, restarts the timer.
+
+// grantIdleTimeout returns the idle timeout in seconds granted for a CREATE
+// asking for requested seconds (0 for the relay's default).
+func grantIdleTimeout(requested uint32) uint32 {
+	relayConfigLock.Lock()
+	limits := relayConfig.Circuit
+	relayConfigLock.Unlock()
+	timeout := limits.IdleTimeout
+	if requested > 0 {
+		timeout = min(max(time.Duration(requested)*time.Second, limits.MinIdleTimeout), limits.MaxIdleTimeout)
+	}
+	return uint32(timeout / time.Second)
+}
+
+// keepaliveReply is the exit's answer to a keepalive: the milliseconds left
+// before it forgets the circuit.
+func keepaliveReply(circuitInfo CircuitInfo) []byte {
+	remaining := max(time.Until(circuitInfo.ExpTime), 0)
+	return binary.BigEndian.AppendUint32(nil, uint32(remaining.Milliseconds()))
+}
+
+// deleteCircuitLocked removes a circuit; circuitInfoMapLock must be held.
+func deleteCircuitLocked(circuitID uint16, reason routingpb.RelayEvent_Type) {
+	log.Println("Deleted Circuit with ID:", circuitID)
+	delete(circuitInfoMap, circuitID)
+	closeCircuitStreams(circuitID)
+	atomic.AddInt32(&load, -1)
+	activeCircuitsGauge.Dec()
+	publishCircuitEvent(reason, circuitID)
+}
 
 func checkExpirations() {
 	for {
 		circuitInfoMapLock.Lock()
 		for k, v := range circuitInfoMap {
 			if time.Now().Compare(v.ExpTime) == 1 {
-				log.Println("Deleted Circuit with ID:", k)
-				delete(circuitInfoMap, k)
-				atomic.AddInt32(&load, -1)
+				deleteCircuitLocked(k, routingpb.RelayEvent_CIRCUIT_EXPIRED)
 			}
 		}
 		circuitInfoMapLock.Unlock()
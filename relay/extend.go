@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	encryption "onion_routing/encryption"
+	routingpb "onion_routing/protofiles"
+	utils "onion_routing/utils"
+
+	"google.golang.org/grpc/status"
+)
+
+// Clients build circuits one hop at a time: a CREATE to the first relay, then
+// an EXTEND through the partial circuit for every further hop. The last hop
+// forwards the CREATE carried by the EXTEND to the new relay, becomes a
+// middle hop once the new relay confirms, and answers EXTENDED with the new
+// relay's key confirmation.
+
+// handleExtendCell executes an EXTEND on the circuit's current last hop and
+// returns the relay cell to send back.
+func handleExtendCell(ctx context.Context, circuitInfo CircuitInfo, message []byte) []byte {
+	cell, err := encryption.ParseRelayCell(message)
+	if err != nil {
+		return endCell(0, err.Error())
+	}
+	if cell.Command != encryption.RELAY_EXTEND {
+		return endCell(cell.StreamID, "unknown relay command")
+	}
+	addr, powNonce, create, err := encryption.ParseExtendData(cell.Data)
+	if err != nil {
+		return endCell(cell.StreamID, err.Error())
+	}
+
+	port, ip := utils.GetPortAndIP(addr)
+	req := &routingpb.RelayRequest{
+		Message:  create,
+		Create:   true,
+		PowNonce: powNonce,
+		HopCount: circuitInfo.Hop,
+	}
+	signForwarded(req)
+	resp, _, err := sendRequestToRelayNode(ctx, fmt.Sprintf("localhost:%d", port), req)
+	if err != nil {
+		log.Printf("Extending circuit %d to %s failed: %v", circuitInfo.CircuitID, addr, err)
+		if abandoned := abandonedError(ctx); abandoned != nil {
+			return endCell(cell.StreamID, status.Convert(abandoned).Message())
+		}
+		return endCell(cell.StreamID, err.Error())
+	}
+
+	circuitInfoMapLock.Lock()
+	cinfo, exists := circuitInfoMap[circuitInfo.CircuitID]
+	if exists {
+		cinfo.ForwardIP = ip
+		cinfo.ForwardPort = port
+		cinfo.IsExitNode = false
+	}
+	circuitInfoMapLock.Unlock()
+	if !exists {
+		return endCell(cell.StreamID, utils.ErrCircuitNotFound.Error())
+	}
+	closeCircuitStreams(circuitInfo.CircuitID)
+	log.Printf("Extended circuit %d to %s", circuitInfo.CircuitID, addr)
+	return encryption.BuildRelayCell(encryption.RelayCell{Command: encryption.RELAY_EXTENDED, StreamID: cell.StreamID, Data: resp.Reply})
+}
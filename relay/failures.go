@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"strconv"
+
+	utils "onion_routing/utils"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// setErrorTrailer names this relay's hop as the origin of an error it
+// returns.
+func setErrorTrailer(ctx context.Context, hop uint32) {
+	grpc.SetTrailer(ctx, metadata.Pairs(utils.TrailerErrorHop, strconv.Itoa(int(hop))))
+}
+
+// setForwardErrorTrailer passes on where an error from the next hop came
+// from. Without an error hop the next relay never answered, so it is
+// reported as unreachable.
+func setForwardErrorTrailer(ctx context.Context, hop uint32, downstream metadata.MD) {
+	errorHop := downstream.Get(utils.TrailerErrorHop)
+	switch {
+	case len(errorHop) == 0:
+		grpc.SetTrailer(ctx, metadata.Pairs(
+			utils.TrailerErrorHop, strconv.Itoa(int(hop)),
+			utils.TrailerUnreachableHop, strconv.Itoa(int(hop+1)),
+		))
+	case errorHop[0] == "0":
+		downstream = downstream.Copy()
+		downstream.Set(utils.TrailerErrorHop, strconv.Itoa(int(hop+1)))
+		grpc.SetTrailer(ctx, downstream)
+	default:
+		grpc.SetTrailer(ctx, downstream)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	encryption "onion_routing/encryption"
+)
+
+// The exit keeps the SENDME windows of every circuit it ends (see
+// encryption.CircuitFlow). A SENDME that fails its HMAC or acknowledges cells
+// never sent, and a client overrunning its window, end the circuit.
+
+var (
+	circuitFlows     = make(map[uint16]*encryption.CircuitFlow)
+	circuitFlowsLock sync.Mutex
+)
+
+func circuitFlowFor(circuitInfo CircuitInfo) *encryption.CircuitFlow {
+	circuitFlowsLock.Lock()
+	defer circuitFlowsLock.Unlock()
+	flow, exists := circuitFlows[circuitInfo.CircuitID]
+	if !exists {
+		flow = encryption.NewCircuitFlow(circuitInfo.key3[:], true)
+		circuitFlows[circuitInfo.CircuitID] = flow
+	}
+	return flow
+}
+
+func closeCircuitFlow(circuitID uint16) {
+	circuitFlowsLock.Lock()
+	delete(circuitFlows, circuitID)
+	circuitFlowsLock.Unlock()
+}
+
+func closeStreamFlow(key streamKey) {
+	circuitFlowsLock.Lock()
+	flow, exists := circuitFlows[key.circuitID]
+	circuitFlowsLock.Unlock()
+	if exists {
+		flow.CloseStream(key.streamID)
+	}
+}
+
+// flowViolation ends a circuit whose client broke flow control.
+func flowViolation(circuitID uint16, err error) []byte {
+	log.Printf("Closing circuit %d: %v", circuitID, err)
+	closeCircuitStreams(circuitID)
+	return endCell(0, err.Error())
+}
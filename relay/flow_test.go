@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	encryption "onion_routing/encryption"
+)
+
+func TestBadSendmeEndsCircuit(t *testing.T) {
+	circuitInfo := CircuitInfo{CircuitID: 4501}
+	copy(circuitInfo.key3[:], "0123456789abcdef")
+	data := encryption.BuildRelayCell(encryption.RelayCell{Command: encryption.RELAY_DATA, StreamID: 1})
+	tests := []struct {
+		name   string
+		sendme encryption.RelayCell
+	}{
+		{"forged by a middle relay", encryption.BuildSendme([]byte("fedcba9876543210"), encryption.SENDME_FROM_CLIENT, 0, 1)},
+		{"reflected back to the exit", encryption.BuildSendme(circuitInfo.key3[:], encryption.SENDME_FROM_EXIT, 0, 1)},
+		{"for cells never sent", encryption.BuildSendme(circuitInfo.key3[:], encryption.SENDME_FROM_CLIENT, 0, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			circuitFlowFor(circuitInfo)
+			reply := handleStreamCell(context.Background(), circuitInfo, encryption.AppendRelayCells(data, []encryption.RelayCell{tt.sendme}))
+			cell, err := encryption.ParseRelayCell(reply)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cell.Command != encryption.RELAY_END || cell.StreamID != 0 {
+				t.Errorf("reply %d on stream %d, want RELAY_END for the circuit", cell.Command, cell.StreamID)
+			}
+			circuitFlowsLock.Lock()
+			_, open := circuitFlows[circuitInfo.CircuitID]
+			circuitFlowsLock.Unlock()
+			if open {
+				t.Error("the circuit's windows outlived a bad SENDME")
+			}
+		})
+	}
+}
@@ -8,7 +8,7 @@ import (
 	"log"
 	"math/rand"
 	"net"
-	"os"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"time"
@@ -21,6 +21,7 @@ import (
 
 	// "google.golang.org/protobuf/proto"
 	// ecies "github.com/ecies/go/v2"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"sync/atomic"
 
@@ -29,17 +30,25 @@ import (
 	utils "onion_routing/utils"
 
 	"google.golang.org/grpc/credentials"
-	// "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 // const (
 // 	serverAddr = "localhost:23455"
 // )
 
+// RelayNode is the relay record signed into the relay's descriptor (see
+// relay_etcd.go).
 type RelayNode struct {
 	Address string `json:"address"`
 	PubKey *rsa.PublicKey `json:"pub_key"`
-	Load int32 `json:"load"`
+	MaxCircuitLength int `json:"max_circuit_length"`
+	Family string `json:"family,omitempty"`
+	ExitPolicy []string `json:"exit_policy,omitempty"`
+	AllowStreams bool `json:"allow_streams"`
+	Bandwidth int64 `json:"bandwidth"`
+	Published time.Time `json:"published"`
 }
 
 // cell := OnionCell{
@@ -56,6 +65,8 @@ type RelayNode struct {
 // }
 
 type CircuitInfo struct {
+	CircuitID uint16
+	CellType byte
 	RequestType byte
 	BackEncryption byte
 	ForwardIP [4]byte
@@ -66,6 +77,11 @@ type CircuitInfo struct {
 	ExpTime time.Time
 	IsExitNode bool
 	KeySeed [16]byte
+	NextPowNonce uint64
+	Hop uint32 // position in the circuit, 1 for the first relay
+	CreatedAt time.Time
+	BytesForward uint64
+	BytesBackward uint64
 	key1 [8]byte 
 	key2 [16]byte
 	key3 [16]byte
@@ -83,6 +99,13 @@ var (
 	privateKey *rsa.PrivateKey
 	pubKey *rsa.PublicKey
 	load int32
+	etcdClient *clientv3.Client
+	etcdLeaseID clientv3.LeaseID
+	relayServer *grpc.Server
+	maxCircuitLength int
+	relayFamily string
+	bandwidthRate int64
+	identityKey ed25519.PrivateKey
 	circuitInfoMap = make(map[uint16]*CircuitInfo)	// map of circuit id to circuit info
 	circuitInfoMapLock sync.Mutex
 )
@@ -107,11 +130,14 @@ func handleCreateCell(cell encryption.OnionCell, ctx context.Context)(CircuitInf
 	key1, key2, key3 := encryption.DeriveKeys(cell.KeySeed[:])
 
 	cinfo := CircuitInfo{
+		CircuitID: cell.CircuitID,
+		CellType: cell.CellType,
 		RequestType: cell.RequestType,
 		BackEncryption: cell.BackEncryption,
-		Expiration: cell.Expiration,
-		ExpTime: time.Now().Add(time.Duration(cell.Expiration) * time.Second),
+		Expiration: grantIdleTimeout(cell.Expiration),
 		KeySeed: cell.KeySeed,
+		NextPowNonce: cell.NextPowNonce,
+		CreatedAt: time.Now(),
 		ForwardIP: cell.IP,
 		ForwardPort: cell.Port,
 		BackwardIP: backIPBytes,
@@ -131,27 +157,93 @@ func handleCreateCell(cell encryption.OnionCell, ctx context.Context)(CircuitInf
 // }
 
 func handleRequest(ctx context.Context, req *routingpb.RelayRequest) (CircuitInfo, []byte, error){
-	encryptedMessageHeader := req.Message[:256]
-	encryptedMessagePayload := req.Message[256:]
-	decryptedMessageHeader, err := encryption.DecryptRSA(encryptedMessageHeader, privateKey)
+	start := time.Now()
+	source, fromRelay := peerSource(ctx, req)
+	if len(req.Message) < encryption.ENCRYPTEDHEADERSIZE {
+		malformedCellsTotal.Inc()
+		return CircuitInfo{}, make([]byte, 0), utils.ErrInvalidCell
+	}
+	encryptedMessageHeader := req.Message[:encryption.ENCRYPTEDHEADERSIZE]
+	encryptedMessagePayload := req.Message[encryption.ENCRYPTEDHEADERSIZE:]
+	hop := uint32(1)
+	if req.Create {
+		if fromRelay {
+			hop = req.HopCount + 1
+		}
+		if hop > uint32(maxCircuitLength) {
+			return CircuitInfo{}, make([]byte, 0), utils.ErrCircuitTooLong
+		}
+	}
+	if !fromRelay {
+		if req.Create && !createRateLimiter.take(source) {
+			createRateLimitedTotal.Inc()
+			return CircuitInfo{}, make([]byte, 0), utils.ErrRateLimited
+		}
+		if !req.Create && !cellRateLimiter.take(source) {
+			cellRateLimitedTotal.Inc()
+			return CircuitInfo{}, make([]byte, 0), utils.ErrRateLimited
+		}
+	}
+	if req.Create {
+		if !encryption.VerifyPow(encryptedMessageHeader, req.PowNonce, requiredPowDifficulty()) {
+			powRejectedTotal.Inc()
+			return CircuitInfo{}, make([]byte, 0), utils.ErrPowRequired
+		}
+		createCellsSeen.Add(1)
+	}
+	decryptedMessageHeader, err := decryptOnionHeader(encryptedMessageHeader)
 	if err != nil {
-		log.Fatalf("Failed to decrypt message: %v", err)
+		rsaDecryptFailuresTotal.Inc()
+		if !fromRelay && !req.Create {
+			// undecryptable cells also count against the sender's CREATE
+			// allowance, which a CREATE has already paid; the sender is
+			// refused once it is used up
+			createRateLimiter.take(source)
+		}
+		log.Printf("Failed to decrypt message: %v", err)
+		return CircuitInfo{}, make([]byte, 0), utils.ErrInvalidCell
 	}
 
 	// log.Println("Decrypted message: ")
 	rebuiltCell := encryption.RebuildMessage(decryptedMessageHeader)
+	defer observeCell(rebuiltCell.CellType, start)
+	if rebuiltCell.CellType == byte(encryption.CREATE_CELL) && !req.Create {
+		// a CREATE sent without the flag would dodge the limits above;
+		// it still uses up the sender's CREATE allowance
+		if !fromRelay {
+			createRateLimiter.take(source)
+		}
+		return CircuitInfo{}, make([]byte, 0), utils.ErrInvalidCell
+	}
 	// log.Println(rebuiltCell.String()) 
 	// log.Println("Size of decrypted message: ", len(decryptedMessageHeader))
 	switch rebuiltCell.CellType {
 	case byte(encryption.CREATE_CELL): // create cell
 
 		log.Println("Create cell")
+		if draining.Load() {
+			return CircuitInfo{}, make([]byte, 0), utils.ErrRelayDraining
+		}
+		if err := createReplayCache.remember(decryptedMessageHeader); err != nil {
+			if err == utils.ErrReplayedCell {
+				replayedCreateCellsTotal.Inc()
+			}
+			return CircuitInfo{}, make([]byte, 0), err
+		}
 		circuitInfoMapLock.Lock()
 		defer circuitInfoMapLock.Unlock()
+		if _, exists := circuitInfoMap[rebuiltCell.CircuitID]; exists {
+			// the ID is reused; nothing of the old circuit carries over
+			deleteCircuitLocked(rebuiltCell.CircuitID, routingpb.RelayEvent_CIRCUIT_CLOSED)
+		}
 		circuitInfo := handleCreateCell(rebuiltCell, ctx)
+		circuitInfo.Hop = hop
 		atomic.AddInt32(&load, 1)
-		log.Println("Creating", rebuiltCell.CircuitID)
+		activeCircuitsGauge.Inc()
+		circuitInfo.ExpTime = time.Now().Add(time.Duration(circuitInfo.Expiration) * time.Second)
+		log.Println("Creating", rebuiltCell.CircuitID, "idle timeout", circuitInfo.Expiration, "s")
 		circuitInfoMap[rebuiltCell.CircuitID] = &circuitInfo
+		publishCircuitEvent(routingpb.RelayEvent_CIRCUIT_CREATED, rebuiltCell.CircuitID)
 		log.Println("Create Cell Done-Debug Message")
 		return circuitInfo, encryptedMessagePayload, nil
 
@@ -164,12 +256,15 @@ func handleRequest(ctx context.Context, req *routingpb.RelayRequest) (CircuitInf
 			return CircuitInfo{}, make([]byte, 0), utils.ErrCircuitNotFound
 		}
 		cinfo.ExpTime = time.Now().Add(time.Duration(cinfo.Expiration) * time.Second)
+		cinfo.CellType = rebuiltCell.CellType
 		cinfo.RequestType = rebuiltCell.RequestType
+		cinfo.NextPowNonce = rebuiltCell.NextPowNonce
 		decryptedMessagePayload := encryption.DecryptRC4(encryptedMessagePayload, cinfo.key1[:])
 		return *cinfo, decryptedMessagePayload, nil
 
 	case byte(encryption.PADDING_CELL):
 		log.Println("Padding cell")
+		paddingCellsTotal.WithLabelValues("received").Inc()
 	}
 	return CircuitInfo{}, make([]byte, 0), nil
 }
@@ -179,21 +274,20 @@ func handleResponse(circuitInfo CircuitInfo, respMessage []byte) ([]byte){
 	return encryptedRespMessage
 }
 
-func sendRequestToServer(serverAddr string, req *routingpb.RelayRequest, reqType int)(*routingpb.RelayResponse, error){
+func sendRequestToServer(ctx context.Context, serverAddr string, req *routingpb.RelayRequest, reqType int, opts ...grpc.CallOption)(*routingpb.RelayResponse, error){
 	log.Printf("Received Request Type : %d\n", reqType)
-	conn, err := grpc.NewClient(serverAddr, grpc.WithTransportCredentials(relayCredsAsClient))
+	conn, err := nextHopConn(serverAddr)
 	if err != nil {
 		log.Println("Received Error:", err)
 		return &routingpb.RelayResponse{}, err
 	}
-	defer conn.Close()
 	client := routingpb.NewOnionRoutingServerClient(conn)
 	var resp *routingpb.RelayResponse
 	switch reqType {
 	case 1:
 		reqToServer := &routingpb.GreetRequest{Message: req.Message}
 		relayLogger.PrintLog("Request sending to server: %v", req)
-		respFromServer, err := client.GreetServer(context.Background(), reqToServer)
+		respFromServer, err := client.GreetServer(ctx, reqToServer, opts...)
 		if err != nil {
 			// log.Println("Received Error:", err, "from IP", req)
 			return &routingpb.RelayResponse{}, err
@@ -204,7 +298,7 @@ func sendRequestToServer(serverAddr string, req *routingpb.RelayRequest, reqType
 	case 2:
 		reqToServer := &routingpb.FibonacciRequest{N : req.Message}
 		relayLogger.PrintLog("Request sending to server: %v", req)
-		respFromServer, err := client.CalculateFibonacci(context.Background(), reqToServer)
+		respFromServer, err := client.CalculateFibonacci(ctx, reqToServer, opts...)
 		if err != nil {
 			// log.Println("Received Error:", err, "from IP", req)
 			return &routingpb.RelayResponse{}, err
@@ -215,72 +309,132 @@ func sendRequestToServer(serverAddr string, req *routingpb.RelayRequest, reqType
 	case 3:
 		reqToServer := &routingpb.GetRandomRequest{N : req.Message}
 		relayLogger.PrintLog("Request sending to server: %v", req)
-		respFromServer, err := client.GetRandomNumbers(context.Background(), reqToServer)
+		respFromServer, err := client.GetRandomNumbers(ctx, reqToServer, opts...)
 		if err != nil {
 			// log.Println("Received Error:", err, "from IP", req)
 			return &routingpb.RelayResponse{}, err
 		}
 		relayLogger.PrintLog("Response received from server: %v", respFromServer)
 		resp = &routingpb.RelayResponse{Reply: respFromServer.Reply}
+
+	default:
+		return &routingpb.RelayResponse{}, fmt.Errorf("unknown request type %d", reqType)
 	}
 	return resp, nil
 }
 
-func sendRequestToRelayNode(nodeAddr string, req *routingpb.RelayRequest)(*routingpb.RelayResponse, error){
-	conn, err := grpc.NewClient(nodeAddr, grpc.WithTransportCredentials(relayCredsAsClient))
+func sendRequestToRelayNode(ctx context.Context, nodeAddr string, req *routingpb.RelayRequest)(*routingpb.RelayResponse, metadata.MD, error){
+	var trailer metadata.MD
+	conn, err := nextHopConn(nodeAddr)
 	if err != nil {
 		log.Println("Received Error:", err)
-		return &routingpb.RelayResponse{}, err
+		return &routingpb.RelayResponse{}, trailer, err
 	}
-	defer conn.Close()
 	client := routingpb.NewRelayNodeServerClient(conn)
 
 	relayLogger.PrintLog("Request sending to next Node: %v", req)
-	resp, err := client.RelayNodeRPC(context.Background(), req)
+	resp, err := client.RelayNodeRPC(ctx, req, grpc.Trailer(&trailer))
 	if err != nil {
 		log.Println("Received Error:", err)
-		return &routingpb.RelayResponse{}, err
+		return &routingpb.RelayResponse{}, trailer, err
 	}
 	relayLogger.PrintLog("Response received from next Node: %v", resp)
-	return resp, nil
+	return resp, trailer, nil
 }
 
 func (s *RelayNodeServer) RelayNodeRPC(ctx context.Context, req *routingpb.RelayRequest) (*routingpb.RelayResponse, error) {
 	relayLogger.PrintLog("Request recieved from previous Node: %v", req)
+	if ctx.Err() != nil {
+		// the client gave up while the cell was on its way
+		return &routingpb.RelayResponse{}, status.FromContextError(ctx.Err()).Err()
+	}
 
 	circuitInfo, forwardMessage, err := handleRequest(ctx, req)
 	if err != nil {
+		publishErrorEvent(err)
+		setErrorTrailer(ctx, circuitInfo.Hop)
 		return &routingpb.RelayResponse{}, err
 	}
 	nextNodeAddr := fmt.Sprintf("localhost:%d",circuitInfo.ForwardPort)
 	
-	if len(forwardMessage) == 0 {  // handling padding cell + exitNode node in route-creation
-		return &routingpb.RelayResponse{Reply: []byte("Exit Node Reached or Padding Cell")}, nil
+	if len(forwardMessage) == 0 && !circuitInfo.CreatedAt.IsZero() && circuitInfo.CellType == byte(encryption.CREATE_CELL) {
+		// last hop of the CREATE: confirm the keys
+		return &routingpb.RelayResponse{Reply: encryption.CreatedConfirmation(circuitInfo.KeySeed, circuitInfo.Expiration)}, nil
+	}
+	if len(forwardMessage) == 0 {  // handling padding cell
+		return &routingpb.RelayResponse{Reply: []byte("Padding Cell")}, nil
 	}
 	log.Println("Sending to Node with Addr: ", nextNodeAddr)
  
-	forwardReq := &routingpb.RelayRequest{Message: forwardMessage}
+	forwardReq := &routingpb.RelayRequest{
+		Message: forwardMessage,
+		Create: circuitInfo.CellType == byte(encryption.CREATE_CELL),
+		PowNonce: circuitInfo.NextPowNonce,
+	}
+	if forwardReq.Create {
+		forwardReq.HopCount = circuitInfo.Hop
+	}
+	signForwarded(forwardReq)
+	forwardCtx, cancel := forwardContext(ctx)
+	defer cancel()
+	if circuitInfo.IsExitNode && circuitInfo.RequestType == encryption.EXTEND_REQUEST {
+		respMessage := handleResponse(circuitInfo, handleExtendCell(forwardCtx, circuitInfo, forwardMessage))
+		recordBandwidth(circuitInfo.CircuitID, len(forwardMessage), len(respMessage))
+		return &routingpb.RelayResponse{Reply: respMessage}, nil
+	}
+	if circuitInfo.IsExitNode && circuitInfo.RequestType == encryption.KEEPALIVE_REQUEST {
+		respMessage := handleResponse(circuitInfo, keepaliveReply(circuitInfo))
+		recordBandwidth(circuitInfo.CircuitID, len(forwardMessage), len(respMessage))
+		return &routingpb.RelayResponse{Reply: respMessage}, nil
+	}
+	if circuitInfo.IsExitNode && circuitInfo.RequestType == encryption.STREAM_REQUEST {
+		respMessage := handleResponse(circuitInfo, handleStreamCell(forwardCtx, circuitInfo, forwardMessage))
+		recordBandwidth(circuitInfo.CircuitID, len(forwardMessage), len(respMessage))
+		return &routingpb.RelayResponse{Reply: respMessage}, nil
+	}
 	if circuitInfo.IsExitNode {
-		resp, err := sendRequestToServer(nextNodeAddr, forwardReq, int(circuitInfo.RequestType))
+		if _, err := checkServerPort(circuitInfo.ForwardPort); err != nil {
+			publishErrorEvent(err)
+			setErrorTrailer(ctx, circuitInfo.Hop)
+			return &routingpb.RelayResponse{}, err
+		}
+		resp, err := sendRequestToServer(forwardCtx, nextNodeAddr, forwardReq, int(circuitInfo.RequestType))
 		if err != nil {
+			if abandoned := abandonedError(forwardCtx); abandoned != nil {
+				err = abandoned
+			}
+			publishErrorEvent(err)
+			setErrorTrailer(ctx, circuitInfo.Hop)
 			return &routingpb.RelayResponse{}, err
 		}
 		respMessage := handleResponse(circuitInfo, []byte(resp.Reply))
+		recordBandwidth(circuitInfo.CircuitID, len(forwardMessage), len(respMessage))
 		backwardResp := &routingpb.RelayResponse{Reply: respMessage}
 		return backwardResp, nil
 	}
-	resp, err := sendRequestToRelayNode(nextNodeAddr, forwardReq)
+	resp, trailer, err := sendRequestToRelayNode(forwardCtx, nextNodeAddr, forwardReq)
+	if err != nil && len(trailer.Get(utils.TrailerErrorHop)) == 0 {
+		if abandoned := abandonedError(forwardCtx); abandoned != nil {
+			// this hop ran out of time, not the next relay
+			publishErrorEvent(abandoned)
+			setErrorTrailer(ctx, circuitInfo.Hop)
+			return &routingpb.RelayResponse{}, abandoned
+		}
+	}
 	if err != nil {
+		publishErrorEvent(err)
+		setForwardErrorTrailer(ctx, circuitInfo.Hop, trailer)
 		return &routingpb.RelayResponse{}, err
 	}
 	respMessage := handleResponse(circuitInfo, []byte(resp.Reply))
+	recordBandwidth(circuitInfo.CircuitID, len(forwardMessage), len(respMessage))
 	backwardResp := &routingpb.RelayResponse{Reply: respMessage}
 	return backwardResp, nil
 }
 
 func encryptPaddingMessage(message []byte, pubkey *rsa.PublicKey)([]byte, error){
-	messageHeader := message[:32]
-	messagePayload := message[32:]
+	messageHeader := message[:encryption.HEADERSIZE]
+	messagePayload := message[encryption.HEADERSIZE:]
 	
 	encryptedHeader, err := encryption.EncryptRSA(messageHeader, pubkey)
 	if err != nil {
@@ -291,13 +445,19 @@ func encryptPaddingMessage(message []byte, pubkey *rsa.PublicKey)([]byte, error)
 	return encryptedMessage, nil
 }
 
-func paddingLoopRandom(etcdClient *clientv3.Client, selfAddr string) {
+// paddingTimeout bounds how long a padding cell may take to be answered.
+const paddingTimeout = 10 * time.Second
+
+func paddingLoopRandom(listRelayNodes func() ([]RelayNode, error), selfAddr string) {
 	count := 1
 	for {
 		// time.Sleep(10 * time.Second)
 		time.Sleep(time.Duration(rand.Intn(10000)+15000) * time.Millisecond)
 		// time.Sleep(time.Duration(10000) * time.Millisecond)
-		nodes, err := GetAvailableRelayNodes(etcdClient)
+		if !paddingEnabled.Load() {
+			continue
+		}
+		nodes, err := listRelayNodes()
 		if err != nil || len(nodes) == 0 {
 			log.Println("No available nodes for padding.")
 			continue
@@ -331,11 +491,17 @@ func paddingLoopRandom(etcdClient *clientv3.Client, selfAddr string) {
 
 		fmt.Println("Size of encrypted message: ", len(encryptedMessage))
 
-		resp, err := client.RelayNodeRPC(context.Background(), &routingpb.RelayRequest{Message: encryptedMessage})
+		ctx, cancel := context.WithTimeout(context.Background(), paddingTimeout)
+		req := &routingpb.RelayRequest{Message: encryptedMessage}
+		signForwarded(req)
+		resp, err := client.RelayNodeRPC(ctx, req)
+		cancel()
 		if err != nil {
 			log.Printf("Padding failed to %s: %v", target.Address, err)
-		} 
-		fmt.Printf("Padding sent to %s: %s\n", target.Address, resp.Reply)
+		} else {
+			paddingCellsTotal.WithLabelValues("sent").Inc()
+			fmt.Printf("Padding sent to %s: %s\n", target.Address, resp.Reply)
+		}
 		// else {
 		// 	log.Printf("Sent padding to: %s", target.Address)
 		// }
@@ -347,34 +513,49 @@ func paddingLoopRandom(etcdClient *clientv3.Client, selfAddr string) {
 }
 
 func main(){
-	args := os.Args[1:]
-	if len(args) >= 1 {
-		id, err := strconv.Atoi(args[0])
-		if err != nil {
-			log.Fatalf("Invalid command line argument; expecting integer value")
-		}
-		nodeID = fmt.Sprintf("node%d",id)
+	cfg, loadConfig := loadRelayConfig()
+	relayConfig = cfg
+	cfg.Etcd.Apply()
+	nodeID = fmt.Sprintf("node%d", cfg.NodeID)
+	utils.OnSIGHUP(func() { reloadRelayConfig(loadConfig) })
+
+	identityKeyPath := cfg.IdentityKey
+	if identityKeyPath == "" {
+		identityKeyPath = filepath.Join(relayStateDir, fmt.Sprintf("relay%d.identity_key", cfg.NodeID))
 	}
-
+	var err error
+	identityKey, err = encryption.LoadIdentityKey(identityKeyPath)
+	if err != nil {
+		log.Fatalf("Failed to load identity key: %v", err)
+	}
+	log.Printf("Relay identity %s", encryption.Fingerprint(identityKey.Public().(ed25519.PublicKey)))
 	privateKey, pubKey = genKeyPairs()
+	onionKeyRotatedAt = time.Now()
+	createReplayCache = newReplayCache(cfg.ReplayCache.Capacity, cfg.ReplayCache.FalsePositiveRate)
+	maxCircuitLength = cfg.MaxCircuitLength
+	relayFamily = cfg.Family
+	bandwidthRate = cfg.BandwidthRate
+	paddingEnabled.Store(cfg.Padding.Enabled)
 
 	relayCredsAsClient = credentials.NewTLS(utils.LoadClientTLSConfigWithKeyLog(
-		"certificates/ca.crt", 
-		"certificates/relay_node.crt", 
-		"certificates/relay_node.key",
+		cfg.TLS.CA, 
+		cfg.TLS.Cert, 
+		cfg.TLS.Key,
 	))
 	
 	relayCredsAsServer = credentials.NewTLS(utils.LoadServerTLSConfigWithKeyLog(
-		"certificates/ca.crt", 
-		"certificates/relay_node.crt", 
-		"certificates/relay_node.key",
+		cfg.TLS.CA, 
+		cfg.TLS.Cert, 
+		cfg.TLS.Key,
 	))
 	
-    var err error
-	relayLogger = utils.NewLogger("logs/relay")
-	relayAddr, err = utils.GetAvaliableAddress()
-	if err != nil {
-		log.Fatalf("Failed to get server address: %v", err)
+	relayLogger = utils.NewLogger(cfg.LogsDir)
+	relayAddr = cfg.ListenAddr
+	if relayAddr == "" {
+		relayAddr, err = utils.GetAvaliableAddress()
+		if err != nil {
+			log.Fatalf("Failed to get server address: %v", err)
+		}
 	}
 
 	// server initialization 
@@ -384,36 +565,72 @@ func main(){
 	}
 	defer listener.Close()
 
-	server := grpc.NewServer(grpc.Creds(relayCredsAsServer))
-	routingpb.RegisterRelayNodeServerServer(server, &RelayNodeServer{})
+	relayServer = grpc.NewServer(grpc.Creds(relayCredsAsServer))
+	routingpb.RegisterRelayNodeServerServer(relayServer, &RelayNodeServer{})
 	log.Printf("Relay Node Server running on %s\n", relayAddr)
 	
 
-	// etcd registration
-	etcdClient, err := initEtcdClient()
-	if err != nil {
-		log.Fatalf("Failed to initialize etcd client: %v", err)
-	}
-	defer etcdClient.Close()
-	err = checkEtcdStatus(etcdClient)
-	if err != nil {
-		log.Fatalf("Etcd Server is unreachable: %v", err)
+	if len(cfg.Directory.Servers) > 0 {
+		// directory service registration
+		for _, addr := range cfg.Directory.Servers {
+			directoryServers = append(directoryServers, newDirectoryServer(addr))
+		}
+		for _, s := range directoryServers {
+			go s.uploadLoop()
+		}
+		go paddingLoopRandom(directoryRelayNodes, relayAddr)
+	} else {
+		// etcd registration
+		etcdClient, err = initEtcdClient()
+		if err != nil {
+			log.Fatalf("Failed to initialize etcd client: %v", err)
+		}
+		defer etcdClient.Close()
+		err = checkEtcdStatus(etcdClient)
+		if err != nil {
+			log.Fatalf("Etcd Server is unreachable: %v", err)
+		}
+		etcdLeaseID, err = createLease(etcdClient)
+		if err != nil {
+			log.Fatalf("Failed to create Etcd lease: %v", err)
+		}
+		err = registerWithEtcdServer(etcdClient, etcdLeaseID)
+		if err != nil {
+			etcdRegistrationFailuresTotal.Inc()
+			log.Fatalf("Failed to register with Etcd: %v", err)
+		}
+		go keepAliveThread(etcdClient, etcdLeaseID)
+		go periodicUpdateThread(etcdClient, etcdLeaseID)
+		go paddingLoopRandom(func() ([]RelayNode, error) { return GetAvailableRelayNodes(etcdClient) }, relayAddr)
 	}
-	leaseId, err := createLease(etcdClient)
-	if err != nil {
-		log.Fatalf("Failed to create Etcd lease: %v", err)
+	if cfg.MetricsAddr != "" {
+		go startMetricsServer(cfg.MetricsAddr)
 	}
-	err = registerWithEtcdServer(etcdClient, leaseId)
-	if err != nil {
-		log.Fatalf("Failed to register with Etcd: %v", err)
+	if len(cfg.Directory.Authorities) > 0 {
+		go relayPeersLoop(consensusDirectory(cfg))
+	} else {
+		log.Printf("No directory authorities configured; cells forwarded by other relays are rate limited like clients'")
 	}
-	go keepAliveThread(etcdClient, leaseId)
-	go periodicUpdateThread(etcdClient, leaseId)
 
-	go paddingLoopRandom(etcdClient, relayAddr)
 	go checkExpirations()
+	go requestCleanupLoop()
+	go bandwidthEventLoop()
+	createRateLimiter.configure(cfg.DoS.CreateRate, cfg.DoS.CreateBurst)
+	cellRateLimiter.configure(cfg.DoS.CellRate, cfg.DoS.CellBurst)
+	powDifficulty.Store(cfg.DoS.PowMinDifficulty)
+	go createRateLimiter.cleanupLoop()
+	go cellRateLimiter.cleanupLoop()
+	go powAdjustLoop()
+	go onionKeyRotationLoop(cfg.OnionKeyLifetime)
+	if cfg.Control.Addr != "" {
+		cookiePath := cfg.Control.Cookie
+		if cookiePath == "" {
+			cookiePath = filepath.Join(relayStateDir, fmt.Sprintf("relay%d.control_cookie", cfg.NodeID))
+		}
+		go startControlServer(cfg.Control.Addr, cookiePath)
+	}
 	
-	err = server.Serve(listener)
+	err = relayServer.Serve(listener)
 	if err != nil {
 		log.Fatalf("Relay Node server failed to server: %v", err)
 	}
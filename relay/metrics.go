@@ -0,0 +1,116 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	encryption "onion_routing/encryption"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Relay metrics are aggregated per relay; no circuit IDs or peer addresses are
+// ever used as label values.
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	activeCircuitsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "onion_relay",
+		Name:      "active_circuits",
+		Help:      "Number of circuits currently installed on this relay.",
+	})
+	cellsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "onion_relay",
+		Name:      "cells_total",
+		Help:      "Cells received, by cell type.",
+	}, []string{"cell_type"})
+	cellHandlingSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "onion_relay",
+		Name:      "cell_handling_seconds",
+		Help:      "Time spent decrypting and processing a cell on this relay, by cell type.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"cell_type"})
+	rsaDecryptFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "onion_relay",
+		Name:      "rsa_decrypt_failures_total",
+		Help:      "Cell headers that could not be decrypted with the onion key.",
+	})
+	malformedCellsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "onion_relay",
+		Name:      "malformed_cells_total",
+		Help:      "Cells too short to hold an encrypted header.",
+	})
+	paddingCellsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "onion_relay",
+		Name:      "padding_cells_total",
+		Help:      "Padding cells sent to and received from other relays.",
+	}, []string{"direction"})
+	forwardedBytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "onion_relay",
+		Name:      "forwarded_bytes_total",
+		Help:      "Bytes relayed on circuits, by direction (forward = towards the exit, backward = towards the client).",
+	}, []string{"direction"})
+	etcdRegistrationFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "onion_relay",
+		Name:      "etcd_registration_failures_total",
+		Help:      "Failed attempts to publish this relay's record to etcd.",
+	})
+	directoryFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "onion_relay",
+		Name:      "directory_failures_total",
+		Help:      "Failed descriptor uploads and heartbeats to directory servers.",
+	})
+	abandonedCallsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "onion_relay",
+		Name:      "abandoned_calls_total",
+		Help:      "Calls to the next hop or the server given up because the deadline passed or the client cancelled, by reason.",
+	}, []string{"reason"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		activeCircuitsGauge,
+		cellsTotal,
+		cellHandlingSeconds,
+		rsaDecryptFailuresTotal,
+		malformedCellsTotal,
+		paddingCellsTotal,
+		forwardedBytesTotal,
+		etcdRegistrationFailuresTotal,
+		directoryFailuresTotal,
+		abandonedCallsTotal,
+	)
+}
+
+func cellTypeLabel(cellType byte) string {
+	switch int(cellType) {
+	case encryption.CREATE_CELL:
+		return "create"
+	case encryption.DATA_CELL:
+		return "data"
+	case encryption.PADDING_CELL:
+		return "padding"
+	}
+	return "unknown"
+}
+
+// observeCell records a received cell and how long this relay took to handle it.
+func observeCell(cellType byte, start time.Time) {
+	label := cellTypeLabel(cellType)
+	cellsTotal.WithLabelValues(label).Inc()
+	cellHandlingSeconds.WithLabelValues(label).Observe(time.Since(start).Seconds())
+}
+
+// startMetricsServer serves the metrics registry on addr until the process exits.
+// It is only started when the operator asks for it.
+func startMetricsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	log.Printf("Metrics endpoint listening on http://%s/metrics", addr)
+	err := http.ListenAndServe(addr, mux)
+	if err != nil {
+		log.Printf("Metrics endpoint stopped: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/rsa"
+	"log"
+	"sync"
+	"time"
+
+	encryption "onion_routing/encryption"
+	routingpb "onion_routing/protofiles"
+)
+
+// The relay keeps its previous onion key after a rotation so that onions built
+// by clients holding a slightly stale directory record still decrypt.
+var (
+	onionKeyLock       sync.RWMutex
+	previousPrivateKey *rsa.PrivateKey
+	onionKeyRotatedAt  time.Time
+)
+
+func currentPublicKey() *rsa.PublicKey {
+	onionKeyLock.RLock()
+	defer onionKeyLock.RUnlock()
+	return pubKey
+}
+
+// decryptOnionHeader decrypts a cell header with the current onion key, falling
+// back to the previous one.
+func decryptOnionHeader(encryptedHeader []byte) ([]byte, error) {
+	onionKeyLock.RLock()
+	current, previous := privateKey, previousPrivateKey
+	onionKeyLock.RUnlock()
+
+	decrypted, err := encryption.DecryptRSA(encryptedHeader, current)
+	if err == nil || previous == nil {
+		return decrypted, err
+	}
+	return encryption.DecryptRSA(encryptedHeader, previous)
+}
+
+// rotateOnionKey replaces the onion key and republishes the relay record.
+func rotateOnionKey() time.Time {
+	newPrivateKey, newPubKey := genKeyPairs()
+
+	onionKeyLock.Lock()
+	previousPrivateKey = privateKey
+	privateKey, pubKey = newPrivateKey, newPubKey
+	onionKeyRotatedAt = time.Now()
+	rotatedAt := onionKeyRotatedAt
+	onionKeyLock.Unlock()
+	createReplayCache.rotate()
+
+	log.Println("Onion key rotated")
+	relayEvents.publish(&routingpb.RelayEvent{Type: routingpb.RelayEvent_ONION_KEY_ROTATED})
+	if etcdClient != nil {
+		err := registerWithEtcdServer(etcdClient, etcdLeaseID)
+		if err != nil {
+			etcdRegistrationFailuresTotal.Inc()
+			log.Printf("Failed to publish rotated onion key: %v", err)
+		}
+	}
+	return rotatedAt
+}
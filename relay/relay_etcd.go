@@ -6,12 +6,15 @@ import (
 	"context"
 	"encoding/json"
 	"log"
+	"reflect"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	// "math/rand"
 	// "google.golang.org/protobuf/proto"
 
+	encryption "onion_routing/encryption"
 	utils "onion_routing/utils"
 
 	"go.etcd.io/etcd/client/v3"
@@ -21,7 +24,7 @@ import (
 func initEtcdClient()(*clientv3.Client, error){
 	etcdClient, err := clientv3.New(clientv3.Config{
 		Endpoints: []string{utils.EtcdServerAddr},
-		DialTimeout: utils.EtcdTimeOutInterval * time.Second,
+		DialTimeout: utils.EtcdTimeOutInterval,
 	})
 	return etcdClient, err
 }
@@ -38,21 +41,70 @@ func createLease(etcdClient *clientv3.Client)(clientv3.LeaseID, error){
 	return leaseResp.ID, err
 }
 
-func registerWithEtcdServer(client *clientv3.Client, leaseID clientv3.LeaseID)(error){
-	key := utils.EtcdKeyPrefix + nodeID
+// RelayRecord is what a relay keeps under its etcd key: its signed
+// descriptor, and the load and proof-of-work difficulty, which change too
+// often to be signed.
+type RelayRecord struct {
+	Descriptor encryption.SignedDescriptor `json:"descriptor"`
+	Load int32 `json:"load"`
+	PowDifficulty uint32 `json:"pow_difficulty"`
+}
+
+var (
+	descriptorLock sync.Mutex
+	descriptor encryption.SignedDescriptor
+	descriptorNode RelayNode // what descriptor was signed for, without Published
+)
+
+// currentDescriptor returns the relay's signed descriptor, signing a new one
+// when the record changed (a new onion key, a reloaded exit policy) or half
+// of the descriptor's lifetime has passed.
+func currentDescriptor() encryption.SignedDescriptor {
 	relayNode := RelayNode{
 		Address: relayAddr,
-		PubKey: pubKey,
+		PubKey: currentPublicKey(),
+		MaxCircuitLength: maxCircuitLength,
+		Family: relayFamily,
+		ExitPolicy: currentExitPolicy().Strings(),
+		AllowStreams: exitAllowsStreams(),
+		Bandwidth: bandwidthRate,
+	}
+	descriptorLock.Lock()
+	defer descriptorLock.Unlock()
+	if descriptor.Body != nil && reflect.DeepEqual(relayNode, descriptorNode) && time.Since(descriptorNode.Published) < encryption.DescriptorLifetime/2 {
+		return descriptor
+	}
+	signed := relayNode
+	signed.Published = time.Now()
+	body, _ := json.Marshal(signed)
+	descriptor = encryption.SignDescriptor(body, identityKey)
+	descriptorNode = relayNode
+	descriptorNode.Published = signed.Published
+	return descriptor
+}
+
+func registerWithEtcdServer(client *clientv3.Client, leaseID clientv3.LeaseID)(error){
+	key := utils.EtcdKeyPrefix + nodeID
+	record := RelayRecord{
+		Descriptor: currentDescriptor(),
 		Load: atomic.LoadInt32(&load),
+		PowDifficulty: powDifficulty.Load(),
 	}
-	data, _ := json.Marshal(relayNode)
+	data, _ := json.Marshal(record)
 	_, err := client.Put(context.Background(), key, string(data), clientv3.WithLease(leaseID))
+	if err == nil {
+		publishedPowDifficulty.Store(record.PowDifficulty)
+	}
 	return err
 }
 
 func periodicUpdateThread(client *clientv3.Client, leaseID clientv3.LeaseID) {
-	for {
-		registerWithEtcdServer(client, leaseID)
+	for !draining.Load() {
+		err := registerWithEtcdServer(client, leaseID)
+		if err != nil {
+			etcdRegistrationFailuresTotal.Inc()
+			log.Printf("Failed to update etcd registration: %v", err)
+		}
 		time.Sleep(3*time.Second)
 	}
 }
@@ -73,8 +125,15 @@ func GetAvailableRelayNodes(etcdClient *clientv3.Client) ([]RelayNode, error) {
 		return nodes, err
 	}
 	for _, ev := range resp.Kvs {
-		var node RelayNode 
-		err := json.Unmarshal(ev.Value, &node)
+		var record RelayRecord
+		var node RelayNode
+		err := json.Unmarshal(ev.Value, &record)
+		if err == nil {
+			err = record.Descriptor.Verify()
+		}
+		if err == nil {
+			err = json.Unmarshal(record.Descriptor.Body, &node)
+		}
 		if err != nil {
 			log.Printf("Failed to decode relay node data: %v", err)
 			continue
@@ -83,4 +142,4 @@ func GetAvailableRelayNodes(etcdClient *clientv3.Client) ([]RelayNode, error) {
 	}
 	// log.Printf("Nodes: %v", nodes)
 	return nodes, nil
-}
\ No newline at end of file
+}
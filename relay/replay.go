@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	utils "onion_routing/utils"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A CREATE onion can only be decrypted with the current or the previous onion
+// key, so a replay cache only has to remember handshakes for two key
+// lifetimes. The cache keeps one Bloom filter per onion key and drops the
+// oldest one whenever the key rotates. A filter holding more than capacity
+// handshakes would report false replays too often, so the key is rotated
+// early once the current one has seen capacity CREATEs, and CREATEs are
+// refused until it has been.
+
+type bloomFilter struct {
+	bits   []uint64
+	hashes uint32
+}
+
+func newBloomFilter(capacity int, falsePositiveRate float64) *bloomFilter {
+	bitCount := math.Ceil(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	hashes := math.Round(bitCount / float64(capacity) * math.Ln2)
+	return &bloomFilter{
+		bits:   make([]uint64, int(bitCount)/64+1),
+		hashes: uint32(math.Max(hashes, 1)),
+	}
+}
+
+// positions derives the filter's bit positions from a SHA-256 digest using
+// double hashing.
+func (f *bloomFilter) positions(digest [32]byte) []uint64 {
+	h1 := binary.BigEndian.Uint64(digest[0:8])
+	h2 := binary.BigEndian.Uint64(digest[8:16]) | 1
+	size := uint64(len(f.bits) * 64)
+	positions := make([]uint64, f.hashes)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % size
+	}
+	return positions
+}
+
+func (f *bloomFilter) contains(digest [32]byte) bool {
+	for _, p := range f.positions(digest) {
+		if f.bits[p/64]&(1<<(p%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) add(digest [32]byte) {
+	for _, p := range f.positions(digest) {
+		f.bits[p/64] |= 1 << (p % 64)
+	}
+}
+
+type replayCache struct {
+	lock              sync.Mutex
+	capacity          int
+	falsePositiveRate float64
+	current           *bloomFilter
+	previous          *bloomFilter
+	inserts           int // handshakes added to current
+	full              chan struct{}
+}
+
+var (
+	createReplayCache *replayCache
+
+	replayedCreateCellsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "onion_relay",
+		Name:      "replayed_create_cells_total",
+		Help:      "CREATE cells rejected because their handshake was seen before.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(replayedCreateCellsTotal)
+}
+
+func newReplayCache(capacity int, falsePositiveRate float64) *replayCache {
+	return &replayCache{
+		capacity:          capacity,
+		falsePositiveRate: falsePositiveRate,
+		current:           newBloomFilter(capacity, falsePositiveRate),
+		previous:          newBloomFilter(capacity, falsePositiveRate),
+		full:              make(chan struct{}, 1),
+	}
+}
+
+// remember adds the decrypted CREATE header to the cache. It fails with
+// ErrReplayedCell if the header was already handled and with
+// ErrReplayCacheFull if the current generation holds capacity handshakes.
+func (c *replayCache) remember(header []byte) error {
+	digest := sha256.Sum256(header)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.current.contains(digest) || c.previous.contains(digest) {
+		return utils.ErrReplayedCell
+	}
+	if c.inserts >= c.capacity {
+		return utils.ErrReplayCacheFull
+	}
+	c.current.add(digest)
+	c.inserts++
+	if c.inserts == c.capacity {
+		select {
+		case c.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// rotate starts a new generation; called whenever the onion key rotates.
+func (c *replayCache) rotate() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.previous = c.current
+	c.current = newBloomFilter(c.capacity, c.falsePositiveRate)
+	c.inserts = 0
+}
+
+// onionKeyRotationLoop rotates the onion key, and with it the replay cache,
+// once per lifetime or as soon as the cache is full. A lifetime of 0 only
+// turns off the scheduled rotation: dropping a cache generation without
+// retiring the key it covers would let its handshakes be replayed.
+func onionKeyRotationLoop(lifetime time.Duration) {
+	for {
+		select {
+		case <-createReplayCache.full:
+			log.Println("Replay cache is full")
+			rotateOnionKey()
+			continue
+		case <-time.After(1 * time.Second):
+		}
+		if lifetime <= 0 {
+			continue
+		}
+		onionKeyLock.RLock()
+		age := time.Since(onionKeyRotatedAt)
+		onionKeyLock.RUnlock()
+		if age >= lifetime {
+			rotateOnionKey()
+		}
+	}
+}
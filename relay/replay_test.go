@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	utils "onion_routing/utils"
+)
+
+func TestBloomFilter(t *testing.T) {
+	const capacity, rate = 10000, 0.01
+	f := newBloomFilter(capacity, rate)
+	for i := 0; i < capacity; i++ {
+		f.add(sha256.Sum256([]byte(fmt.Sprintf("added %d", i))))
+	}
+	for i := 0; i < capacity; i++ {
+		if !f.contains(sha256.Sum256([]byte(fmt.Sprintf("added %d", i)))) {
+			t.Fatalf("handshake %d added but not found", i)
+		}
+	}
+	const probes = 100000
+	falsePositives := 0
+	for i := 0; i < probes; i++ {
+		if f.contains(sha256.Sum256([]byte(fmt.Sprintf("other %d", i)))) {
+			falsePositives++
+		}
+	}
+	if got := float64(falsePositives) / probes; got > 2*rate {
+		t.Errorf("false positive rate %.4f at capacity, want about %.4f", got, rate)
+	}
+}
+
+func TestReplayCacheGenerations(t *testing.T) {
+	c := newReplayCache(100, 0.001)
+	first, second := []byte("first handshake"), []byte("second handshake")
+	if err := c.remember(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.remember(first); !errors.Is(err, utils.ErrReplayedCell) {
+		t.Errorf("replay in the current generation = %v, want %v", err, utils.ErrReplayedCell)
+	}
+
+	c.rotate()
+	if err := c.remember(first); !errors.Is(err, utils.ErrReplayedCell) {
+		t.Errorf("replay in the previous generation = %v, want %v", err, utils.ErrReplayedCell)
+	}
+	if err := c.remember(second); err != nil {
+		t.Fatal(err)
+	}
+
+	// the key the first handshake was encrypted to is gone now
+	c.rotate()
+	if err := c.remember(first); err != nil {
+		t.Errorf("handshake two generations old = %v, want it forgotten", err)
+	}
+	if err := c.remember(second); !errors.Is(err, utils.ErrReplayedCell) {
+		t.Errorf("replay in the previous generation = %v, want %v", err, utils.ErrReplayedCell)
+	}
+}
+
+func TestReplayCacheFull(t *testing.T) {
+	const capacity = 5
+	c := newReplayCache(capacity, 0.001)
+	for i := 0; i < capacity; i++ {
+		select {
+		case <-c.full:
+			t.Fatalf("full signalled after %d handshakes", i)
+		default:
+		}
+		if err := c.remember([]byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-c.full:
+	default:
+		t.Fatal("full not signalled at capacity")
+	}
+	if err := c.remember([]byte("one too many")); !errors.Is(err, utils.ErrReplayCacheFull) {
+		t.Errorf("handshake past capacity = %v, want %v", err, utils.ErrReplayCacheFull)
+	}
+	c.rotate()
+	if err := c.remember([]byte("one too many")); err != nil {
+		t.Errorf("handshake after rotation = %v", err)
+	}
+}
+
+// With onion_key_lifetime 0 a full cache must still rotate the key, or every
+// later CREATE would be refused until the relay restarts.
+func TestFullReplayCacheRotatesWithoutLifetime(t *testing.T) {
+	// the loop keeps running after the test, so the cache stays in place
+	createReplayCache = newReplayCache(3, 0.001)
+	privateKey, pubKey = genKeyPairs()
+	onionKeyLock.RLock()
+	keyBefore := privateKey
+	onionKeyLock.RUnlock()
+
+	go onionKeyRotationLoop(0)
+	for i := 0; i < 3; i++ {
+		if err := createReplayCache.remember([]byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for createReplayCache.remember([]byte("after rotation")) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("CREATEs still refused after the replay cache filled up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	onionKeyLock.RLock()
+	defer onionKeyLock.RUnlock()
+	if privateKey == keyBefore || previousPrivateKey != keyBefore {
+		t.Error("the onion key was not rotated along with the replay cache")
+	}
+}
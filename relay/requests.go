@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	encryption "onion_routing/encryption"
+	routingpb "onion_routing/protofiles"
+	utils "onion_routing/utils"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Requests for the server travel in RELAY_REQUEST cells, each on its own
+// stream ID, so one circuit carries several requests at once and the replies
+// come back in whatever order the server finishes them. The exit tracks the
+// requests in flight per circuit and cancels them when the circuit goes away.
+//
+// Requests and replies are split into cell-sized fragments. The exit answers
+// every request fragment but the last with RELAY_RECEIVED; once the request
+// is complete it calls the server and answers with the first fragment of the
+// reply, and the client fetches the rest with RELAY_FETCH. Requests missing
+// fragments and replies not fetched for fragmentTimeout are dropped.
+
+const (
+	// maxCircuitRequests bounds the requests in flight on one circuit.
+	maxCircuitRequests = 64
+	fragmentTimeout    = 30 * time.Second
+)
+
+// maxReassemblyBytes bounds the requests and replies held by the exit.
+var maxReassemblyBytes = 256 << 20
+
+type exitRequest struct {
+	lock       sync.Mutex
+	cancel     context.CancelFunc
+	request    *encryption.Reassembly // nil once the reply is held
+	started    bool
+	reply      []byte
+	fetched    []bool
+	unfetched  int
+	lastActive time.Time
+}
+
+var (
+	exitRequests     = make(map[streamKey]*exitRequest)
+	exitRequestsLock sync.Mutex
+	reassemblyBytes  int // held by exitRequests
+)
+
+// handleRequestCell handles a RELAY_REQUEST or RELAY_FETCH and returns the
+// relay cell to send back. The server is called within the deadline of the
+// cell that completes the request.
+func handleRequestCell(ctx context.Context, circuitInfo CircuitInfo, flow *encryption.CircuitFlow, cell encryption.RelayCell) []byte {
+	key := streamKey{circuitID: circuitInfo.CircuitID, streamID: cell.StreamID}
+	if cell.Command == encryption.RELAY_FETCH {
+		return fetchReply(ctx, key, flow, cell)
+	}
+
+	length, seq, chunk, err := encryption.ParseFragment(cell.Data)
+	if err != nil {
+		return endCell(cell.StreamID, err.Error())
+	}
+	req, err := exitRequestFor(key, length)
+	if err != nil {
+		return endCell(cell.StreamID, err.Error())
+	}
+	req.lock.Lock()
+	if req.request == nil {
+		req.lock.Unlock()
+		return endCell(cell.StreamID, utils.ErrStreamInUse.Error())
+	}
+	req.lastActive = time.Now()
+	complete, err := req.request.Add(length, seq, chunk)
+	start := complete && !req.started
+	req.started = req.started || complete
+	req.lock.Unlock()
+	if err != nil {
+		return endCell(cell.StreamID, err.Error())
+	}
+	if !start {
+		return encryption.BuildRelayCell(encryption.RelayCell{Command: encryption.RELAY_RECEIVED, StreamID: cell.StreamID})
+	}
+
+	message := req.request.Message()
+	serverCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req.lock.Lock()
+	req.cancel = cancel
+	req.lock.Unlock()
+	reply, err := callServer(serverCtx, circuitInfo, message)
+	if err != nil {
+		log.Printf("Request on stream %d of circuit %d failed: %v", cell.StreamID, circuitInfo.CircuitID, err)
+		finishExitRequest(key)
+		if abandoned := abandonedError(ctx); abandoned != nil {
+			return endCell(cell.StreamID, status.Convert(abandoned).Message())
+		}
+		return endCell(cell.StreamID, err.Error())
+	}
+	if len(reply) > encryption.MAXMESSAGESIZE {
+		finishExitRequest(key)
+		return endCell(cell.StreamID, encryption.ErrMessageTooLarge.Error())
+	}
+	if err := takeReplyWindow(ctx, flow, cell.StreamID); err != nil {
+		finishExitRequest(key)
+		return endCell(cell.StreamID, err.Error())
+	}
+
+	count := encryption.FragmentCount(len(reply))
+	if count == 1 {
+		finishExitRequest(key)
+	} else {
+		exitRequestsLock.Lock()
+		reassemblyBytes += len(reply) - len(message)
+		exitRequestsLock.Unlock()
+		req.lock.Lock()
+		req.request = nil
+		req.reply = reply
+		req.fetched = make([]bool, count)
+		req.fetched[0] = true
+		req.unfetched = count - 1
+		req.lastActive = time.Now()
+		req.lock.Unlock()
+	}
+	return encryption.BuildRelayCell(encryption.RelayCell{Command: encryption.RELAY_RESPONSE, StreamID: cell.StreamID, Data: encryption.BuildFragment(reply, 0)})
+}
+
+// takeReplyWindow waits for room in the windows for a reply fragment; the
+// client's SENDMEs come with its other cells.
+func takeReplyWindow(ctx context.Context, flow *encryption.CircuitFlow, streamID uint16) error {
+	ctx, cancel := context.WithTimeout(ctx, fragmentTimeout)
+	defer cancel()
+	if err := flow.Take(ctx, streamID); err != nil {
+		return encryption.ErrWindowExceeded
+	}
+	return nil
+}
+
+// callServer sends a reassembled request, its type followed by its body, to
+// the server the circuit exits to.
+func callServer(ctx context.Context, circuitInfo CircuitInfo, message []byte) ([]byte, error) {
+	if len(message) < 1 {
+		return nil, encryption.ErrShortRelayCell
+	}
+	reqType := int(message[0])
+	if reqType < encryption.GREET_REQUEST || reqType > encryption.RANDOM_REQUEST {
+		return nil, fmt.Errorf("unknown request type %d", reqType)
+	}
+	serverAddr, err := checkServerPort(circuitInfo.ForwardPort)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := sendRequestToServer(ctx, serverAddr, &routingpb.RelayRequest{Message: message[1:]}, reqType,
+		grpc.MaxCallRecvMsgSize(encryption.MAXMESSAGESIZE+4096), grpc.MaxCallSendMsgSize(encryption.MAXMESSAGESIZE+4096))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Reply, nil
+}
+
+// exitRequestFor returns the request being reassembled on key, starting one
+// for a message of length bytes if there is none.
+func exitRequestFor(key streamKey, length int) (*exitRequest, error) {
+	exitRequestsLock.Lock()
+	defer exitRequestsLock.Unlock()
+	if req, exists := exitRequests[key]; exists {
+		return req, nil
+	}
+	inFlight := 0
+	for other := range exitRequests {
+		if other.circuitID == key.circuitID {
+			inFlight++
+		}
+	}
+	if inFlight >= maxCircuitRequests {
+		return nil, utils.ErrTooManyRequests
+	}
+	if reassemblyBytes+length > maxReassemblyBytes {
+		return nil, utils.ErrTooManyRequests
+	}
+	reassemblyBytes += length
+	req := &exitRequest{request: encryption.NewReassembly(length), lastActive: time.Now()}
+	exitRequests[key] = req
+	return req, nil
+}
+
+// fetchReply returns a fragment of a reply the client has not collected yet.
+func fetchReply(ctx context.Context, key streamKey, flow *encryption.CircuitFlow, cell encryption.RelayCell) []byte {
+	seq, err := encryption.ParseFetchData(cell.Data)
+	if err != nil {
+		return endCell(cell.StreamID, err.Error())
+	}
+	exitRequestsLock.Lock()
+	req, exists := exitRequests[key]
+	exitRequestsLock.Unlock()
+	if !exists {
+		return endCell(cell.StreamID, utils.ErrStreamNotFound.Error())
+	}
+	req.lock.Lock()
+	valid := req.reply != nil && seq < len(req.fetched)
+	req.lock.Unlock()
+	if !valid {
+		return endCell(cell.StreamID, encryption.ErrBadFragment.Error())
+	}
+	if err := takeReplyWindow(ctx, flow, cell.StreamID); err != nil {
+		return endCell(cell.StreamID, err.Error())
+	}
+	req.lock.Lock()
+	fragment := encryption.BuildFragment(req.reply, seq)
+	if !req.fetched[seq] {
+		req.fetched[seq] = true
+		req.unfetched--
+	}
+	req.lastActive = time.Now()
+	done := req.unfetched == 0
+	req.lock.Unlock()
+	if done {
+		finishExitRequest(key)
+	}
+	return encryption.BuildRelayCell(encryption.RelayCell{Command: encryption.RELAY_RESPONSE, StreamID: cell.StreamID, Data: fragment})
+}
+
+func finishExitRequest(key streamKey) {
+	exitRequestsLock.Lock()
+	defer exitRequestsLock.Unlock()
+	finishExitRequestLocked(key)
+}
+
+func finishExitRequestLocked(key streamKey) {
+	req, exists := exitRequests[key]
+	if !exists {
+		return
+	}
+	delete(exitRequests, key)
+	closeStreamFlow(key)
+	req.lock.Lock()
+	if req.cancel != nil {
+		req.cancel()
+	}
+	if req.reply != nil {
+		reassemblyBytes -= len(req.reply)
+	} else if req.request != nil {
+		reassemblyBytes -= req.request.Len()
+	}
+	req.lock.Unlock()
+}
+
+// cancelCircuitRequests aborts the server calls of a circuit that went away
+// and drops its partial requests and uncollected replies.
+func cancelCircuitRequests(circuitID uint16) {
+	exitRequestsLock.Lock()
+	defer exitRequestsLock.Unlock()
+	for key := range exitRequests {
+		if key.circuitID == circuitID {
+			finishExitRequestLocked(key)
+		}
+	}
+}
+
+// requestCleanupLoop drops requests whose missing fragments did not arrive
+// and replies that were not fetched in time.
+func requestCleanupLoop() {
+	for {
+		time.Sleep(fragmentTimeout / 4)
+		dropStaleRequests(time.Now())
+	}
+}
+
+func dropStaleRequests(now time.Time) {
+	exitRequestsLock.Lock()
+	defer exitRequestsLock.Unlock()
+	for key, req := range exitRequests {
+		req.lock.Lock()
+		stale := now.Sub(req.lastActive) > fragmentTimeout && (req.reply != nil || !req.started)
+		req.lock.Unlock()
+		if stale {
+			log.Printf("Dropping request on stream %d of circuit %d: fragments missing for %v", key.streamID, key.circuitID, fragmentTimeout)
+			finishExitRequestLocked(key)
+		}
+	}
+}
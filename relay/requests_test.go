@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	encryption "onion_routing/encryption"
+	netdir "onion_routing/netdir"
+	onionclient "onion_routing/onionclient"
+	routingpb "onion_routing/protofiles"
+	utils "onion_routing/utils"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func resetExitRequests(t *testing.T) {
+	t.Helper()
+	exitRequestsLock.Lock()
+	for key := range exitRequests {
+		finishExitRequestLocked(key)
+	}
+	exitRequestsLock.Unlock()
+	if reassemblyBytes != 0 {
+		t.Fatalf("reassemblyBytes = %d with no requests held", reassemblyBytes)
+	}
+}
+
+// sendFragment hands fragment seq of message to the exit on stream 1 of
+// circuitID and returns the relay cell it answers with.
+func sendFragment(t *testing.T, circuitID uint16, message []byte, seq int) encryption.RelayCell {
+	t.Helper()
+	cell := encryption.RelayCell{Command: encryption.RELAY_REQUEST, StreamID: 1, Data: encryption.BuildFragment(message, seq)}
+	reply, err := encryption.ParseRelayCell(handleRequestCell(context.Background(), CircuitInfo{CircuitID: circuitID}, nil, cell))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+func TestStalePartialRequestsDropped(t *testing.T) {
+	resetExitRequests(t)
+	defer resetExitRequests(t)
+
+	message := make([]byte, 3*encryption.FRAGMENTDATASIZE)
+	if reply := sendFragment(t, 1, message, 0); reply.Command != encryption.RELAY_RECEIVED {
+		t.Fatalf("first fragment answered with command %d, want RELAY_RECEIVED", reply.Command)
+	}
+	if reassemblyBytes != len(message) {
+		t.Fatalf("reassemblyBytes = %d, want %d", reassemblyBytes, len(message))
+	}
+
+	// a request being answered by the server is never stale
+	started, err := exitRequestFor(streamKey{circuitID: 2, streamID: 1}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	started.started = true
+
+	dropStaleRequests(time.Now())
+	if len(exitRequests) != 2 {
+		t.Fatalf("%d requests held after a cleanup within fragmentTimeout, want 2", len(exitRequests))
+	}
+	dropStaleRequests(time.Now().Add(fragmentTimeout + time.Second))
+	if _, exists := exitRequests[streamKey{circuitID: 1, streamID: 1}]; exists {
+		t.Error("partial request kept past fragmentTimeout")
+	}
+	if _, exists := exitRequests[streamKey{circuitID: 2, streamID: 1}]; !exists {
+		t.Error("started request dropped while waiting for the server")
+	}
+	if reassemblyBytes != 10 {
+		t.Errorf("reassemblyBytes = %d after the drop, want 10", reassemblyBytes)
+	}
+}
+
+func TestReassemblyBytesBounded(t *testing.T) {
+	resetExitRequests(t)
+	defer resetExitRequests(t)
+	saved := maxReassemblyBytes
+	defer func() { maxReassemblyBytes = saved }()
+	maxReassemblyBytes = 4 * encryption.FRAGMENTDATASIZE
+
+	first := make([]byte, 3*encryption.FRAGMENTDATASIZE)
+	sendFragment(t, 1, first, 0)
+	oversized := make([]byte, 2*encryption.FRAGMENTDATASIZE)
+	reply := sendFragment(t, 2, oversized, 0)
+	if reply.Command != encryption.RELAY_END || string(reply.Data) != utils.ErrTooManyRequests.Error() {
+		t.Fatalf("request past maxReassemblyBytes answered with command %d %q, want RELAY_END %q",
+			reply.Command, reply.Data, utils.ErrTooManyRequests)
+	}
+	if _, exists := exitRequests[streamKey{circuitID: 2, streamID: 1}]; exists {
+		t.Error("rejected request is held")
+	}
+
+	dropStaleRequests(time.Now().Add(fragmentTimeout + time.Second))
+	if reply := sendFragment(t, 2, oversized, 0); reply.Command != encryption.RELAY_RECEIVED {
+		t.Errorf("request rejected after the stale one was dropped: command %d %q", reply.Command, reply.Data)
+	}
+}
+
+func TestCircuitRequestsBounded(t *testing.T) {
+	resetExitRequests(t)
+	defer resetExitRequests(t)
+
+	for stream := uint16(0); stream < maxCircuitRequests; stream++ {
+		if _, err := exitRequestFor(streamKey{circuitID: 1, streamID: stream}, 1); err != nil {
+			t.Fatalf("request %d: %v", stream, err)
+		}
+	}
+	if _, err := exitRequestFor(streamKey{circuitID: 1, streamID: maxCircuitRequests}, 1); !errors.Is(err, utils.ErrTooManyRequests) {
+		t.Errorf("request past maxCircuitRequests = %v, want %v", err, utils.ErrTooManyRequests)
+	}
+	if _, err := exitRequestFor(streamKey{circuitID: 2, streamID: 0}, 1); err != nil {
+		t.Errorf("another circuit's request: %v", err)
+	}
+}
+
+// echoServer answers a greeting with the greeting.
+type echoServer struct {
+	routingpb.UnimplementedOnionRoutingServerServer
+}
+
+func (echoServer) GreetServer(ctx context.Context, req *routingpb.GreetRequest) (*routingpb.GreetResponse, error) {
+	return &routingpb.GreetResponse{Reply: req.Message}, nil
+}
+
+func serveTest(t *testing.T, server *grpc.Server) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return listener.Addr().String()
+}
+
+// The largest request, answered by the largest reply, goes through a relay
+// with the default DoS limits within the client's default request timeout.
+func TestLargestMessageWithinDefaultLimits(t *testing.T) {
+	if testing.Short() {
+		t.Skip("sends the largest message through a relay")
+	}
+	savedConfig, savedMaxLength, savedCreds, savedLogger := relayConfig, maxCircuitLength, relayCredsAsClient, relayLogger
+	defer func() {
+		relayConfig, maxCircuitLength, relayCredsAsClient, relayLogger = savedConfig, savedMaxLength, savedCreds, savedLogger
+		createRateLimiter.configure(savedConfig.DoS.CreateRate, savedConfig.DoS.CreateBurst)
+		cellRateLimiter.configure(savedConfig.DoS.CellRate, savedConfig.DoS.CellBurst)
+	}()
+	echo := grpc.NewServer(grpc.MaxRecvMsgSize(encryption.MAXMESSAGESIZE + 4096))
+	routingpb.RegisterOnionRoutingServerServer(echo, echoServer{})
+	serverAddr := serveTest(t, echo)
+	_, port, _ := net.SplitHostPort(serverAddr)
+	serverPort, _ := strconv.Atoi(port)
+
+	relayConfig = defaultRelayConfig()
+	relayConfig.Exit.ServerPorts = []int{serverPort}
+	maxCircuitLength = relayConfig.MaxCircuitLength
+	createRateLimiter.configure(relayConfig.DoS.CreateRate, relayConfig.DoS.CreateBurst)
+	cellRateLimiter.configure(relayConfig.DoS.CellRate, relayConfig.DoS.CellBurst)
+	relayCredsAsClient = insecure.NewCredentials()
+	relayLogger = utils.NewLogger(t.TempDir())
+	if privateKey == nil {
+		privateKey, pubKey = genKeyPairs()
+	}
+	if identityKey == nil {
+		_, identityKey, _ = ed25519.GenerateKey(rand.Reader)
+	}
+	if createReplayCache == nil {
+		createReplayCache = newReplayCache(relayConfig.ReplayCache.Capacity, relayConfig.ReplayCache.FalsePositiveRate)
+	}
+	relay := grpc.NewServer()
+	routingpb.RegisterRelayNodeServerServer(relay, &RelayNodeServer{})
+	relayAddr := serveTest(t, relay)
+
+	client, err := onionclient.New(
+		onionclient.WithCredentials(insecure.NewCredentials()),
+		onionclient.WithDirectory(netdir.StaticDirectory{}),
+		onionclient.WithServerAddr(serverAddr),
+		onionclient.WithPathLength(1),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	circuit, err := client.BuildCircuitThrough(context.Background(), []netdir.RelayNode{{Address: relayAddr, PubKey: currentPublicKey()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer circuit.Close()
+
+	// the request type byte makes it the largest request
+	message := bytes.Repeat([]byte("x"), encryption.MAXMESSAGESIZE-1)
+	start := time.Now()
+	reply, err := circuit.Do(context.Background(), encryption.GREET_REQUEST, message)
+	if err != nil {
+		t.Fatalf("request of %d bytes failed after %v: %v", encryption.MAXMESSAGESIZE, time.Since(start), err)
+	}
+	if !bytes.Equal(reply, message) {
+		t.Errorf("reply of %d bytes, want the %d sent", len(reply), len(message))
+	}
+	t.Logf("%d bytes each way in %v", len(message), time.Since(start))
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	encryption "onion_routing/encryption"
+	utils "onion_routing/utils"
+)
+
+// Exit nodes open TCP connections on behalf of clients. Every relay call is
+// one request and one response, so the exit buffers what the destination
+// sends and returns it with the reply to the client's next DATA cell; clients
+// poll with empty DATA cells while idle.
+
+const (
+	streamDialTimeout   = 10 * time.Second
+	streamPollWait      = 200 * time.Millisecond
+	streamMaxReplyBytes = 64 * 1024
+	streamMaxBuffered   = 256 * 1024
+)
+
+type streamKey struct {
+	circuitID uint16
+	streamID  uint16
+}
+
+type exitStream struct {
+	conn     net.Conn
+	lock     sync.Mutex
+	readable *sync.Cond // signalled when buffer grows or the destination closes
+	buffer   []byte
+	closed   bool
+}
+
+var (
+	exitStreams     = make(map[streamKey]*exitStream)
+	exitStreamsLock sync.Mutex
+)
+
+func newExitStream(conn net.Conn) *exitStream {
+	stream := &exitStream{conn: conn}
+	stream.readable = sync.NewCond(&stream.lock)
+	go stream.readLoop()
+	return stream
+}
+
+// readLoop buffers data from the destination, pausing while the client has
+// not collected what is already buffered.
+func (s *exitStream) readLoop() {
+	buf := make([]byte, 16*1024)
+	for {
+		s.lock.Lock()
+		for len(s.buffer) >= streamMaxBuffered && !s.closed {
+			s.readable.Wait()
+		}
+		s.lock.Unlock()
+
+		n, err := s.conn.Read(buf)
+		s.lock.Lock()
+		s.buffer = append(s.buffer, buf[:n]...)
+		if err != nil {
+			s.closed = true
+		}
+		s.readable.Broadcast()
+		s.lock.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// collect waits briefly for destination data and returns up to
+// streamMaxReplyBytes of it, and whether the destination has closed and
+// everything has been handed out.
+func (s *exitStream) collect() ([]byte, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.buffer) == 0 && !s.closed {
+		timer := time.AfterFunc(streamPollWait, func() {
+			s.lock.Lock()
+			s.readable.Broadcast()
+			s.lock.Unlock()
+		})
+		s.readable.Wait()
+		timer.Stop()
+	}
+	n := min(len(s.buffer), streamMaxReplyBytes)
+	data := append([]byte{}, s.buffer[:n]...)
+	s.buffer = s.buffer[n:]
+	s.readable.Broadcast()
+	return data, s.closed && len(s.buffer) == 0
+}
+
+func (s *exitStream) close() {
+	s.conn.Close()
+	s.lock.Lock()
+	s.closed = true
+	s.readable.Broadcast()
+	s.lock.Unlock()
+}
+
+func endCell(streamID uint16, reason string) []byte {
+	return encryption.BuildRelayCell(encryption.RelayCell{Command: encryption.RELAY_END, StreamID: streamID, Data: []byte(reason)})
+}
+
+// handleStreamCell executes a client's relay cell on the exit node and returns
+// the relay cell to send back, followed by the SENDMEs due to the client.
+func handleStreamCell(ctx context.Context, circuitInfo CircuitInfo, message []byte) []byte {
+	cell, sendmes, err := encryption.ParseRelayCells(message)
+	if err != nil {
+		return endCell(0, err.Error())
+	}
+	flow := circuitFlowFor(circuitInfo)
+	if err := flow.Acknowledge(sendmes); err != nil {
+		return flowViolation(circuitInfo.CircuitID, err)
+	}
+	if err := flow.Deliver(cell); err != nil {
+		return flowViolation(circuitInfo.CircuitID, err)
+	}
+	return encryption.AppendRelayCells(execStreamCell(ctx, circuitInfo, flow, cell), flow.Sendmes())
+}
+
+func execStreamCell(ctx context.Context, circuitInfo CircuitInfo, flow *encryption.CircuitFlow, cell encryption.RelayCell) []byte {
+	key := streamKey{circuitID: circuitInfo.CircuitID, streamID: cell.StreamID}
+
+	switch cell.Command {
+	case encryption.RELAY_BEGIN:
+		if !exitAllowsStreams() {
+			return endCell(cell.StreamID, utils.ErrExitPolicy.Error())
+		}
+		addr, err := checkStreamDestination(string(cell.Data))
+		if err != nil {
+			log.Printf("Stream to %s refused: %v", cell.Data, err)
+			return endCell(cell.StreamID, err.Error())
+		}
+		dialer := net.Dialer{Timeout: streamDialTimeout}
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			log.Printf("Stream connect failed: %v", err)
+			return endCell(cell.StreamID, err.Error())
+		}
+		exitStreamsLock.Lock()
+		if old, exists := exitStreams[key]; exists {
+			old.close()
+		}
+		exitStreams[key] = newExitStream(conn)
+		exitStreamsLock.Unlock()
+		return encryption.BuildRelayCell(encryption.RelayCell{Command: encryption.RELAY_CONNECTED, StreamID: cell.StreamID})
+
+	case encryption.RELAY_DATA:
+		exitStreamsLock.Lock()
+		stream, exists := exitStreams[key]
+		exitStreamsLock.Unlock()
+		if !exists {
+			return endCell(cell.StreamID, utils.ErrStreamNotFound.Error())
+		}
+		if len(cell.Data) > 0 {
+			if _, err := stream.conn.Write(cell.Data); err != nil {
+				closeExitStream(key)
+				return endCell(cell.StreamID, err.Error())
+			}
+		}
+		// with the window closed the client gets nothing until it sends a
+		// SENDME
+		if !flow.TryTake(cell.StreamID) {
+			return encryption.BuildRelayCell(encryption.RelayCell{Command: encryption.RELAY_DATA, StreamID: cell.StreamID})
+		}
+		data, finished := stream.collect()
+		if len(data) == 0 {
+			flow.Return(cell.StreamID)
+		}
+		if finished && len(data) == 0 {
+			closeExitStream(key)
+			return endCell(cell.StreamID, "")
+		}
+		return encryption.BuildRelayCell(encryption.RelayCell{Command: encryption.RELAY_DATA, StreamID: cell.StreamID, Data: data})
+
+	case encryption.RELAY_END:
+		// ends a TCP stream, or a request the client gave up on
+		closeExitStream(key)
+		finishExitRequest(key)
+		return endCell(cell.StreamID, "")
+
+	case encryption.RELAY_REQUEST, encryption.RELAY_FETCH:
+		return handleRequestCell(ctx, circuitInfo, flow, cell)
+	}
+	return endCell(cell.StreamID, "unknown relay command")
+}
+
+func closeExitStream(key streamKey) {
+	exitStreamsLock.Lock()
+	stream, exists := exitStreams[key]
+	delete(exitStreams, key)
+	exitStreamsLock.Unlock()
+	closeStreamFlow(key)
+	if exists {
+		stream.close()
+	}
+}
+
+// closeCircuitStreams tears down the streams and requests of a circuit that
+// went away.
+func closeCircuitStreams(circuitID uint16) {
+	cancelCircuitRequests(circuitID)
+	closeCircuitFlow(circuitID)
+	exitStreamsLock.Lock()
+	defer exitStreamsLock.Unlock()
+	for key, stream := range exitStreams {
+		if key.circuitID == circuitID {
+			stream.close()
+			delete(exitStreams, key)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	routingpb "onion_routing/protofiles"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+const usage = `usage: relayctl [-addr addr] -cookie path <command> [args]
+
+commands:
+  circuits             list circuits with their age and relayed bytes
+  close <circuit-id>   close a circuit
+  rotate-key           rotate the relay's onion key
+  padding on|off       switch padding cells on or off
+  drain                stop taking new circuits and shut down once idle
+  events [type...]     stream events (e.g. CIRCUIT_CREATED BANDWIDTH)
+`
+
+func main() {
+	addr := flag.String("addr", "localhost:9151", "control port address, or unix:<path>")
+	cookiePath := flag.String("cookie", "", "path of the relay's control cookie file")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 || *cookiePath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cookie, err := os.ReadFile(*cookiePath)
+	if err != nil {
+		log.Fatalf("Failed to read control cookie: %v", err)
+	}
+	target := *addr
+	if path, ok := strings.CutPrefix(target, "unix:"); ok {
+		target = "unix://" + path
+	}
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("Failed to connect to control port: %v", err)
+	}
+	defer conn.Close()
+	client := routingpb.NewRelayControlClient(conn)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", strings.TrimSpace(string(cookie)))
+
+	switch args[0] {
+	case "circuits":
+		resp, err := client.ListCircuits(ctx, &routingpb.ListCircuitsRequest{})
+		if err != nil {
+			log.Fatalf("ListCircuits failed: %v", err)
+		}
+		fmt.Printf("%-8s %-6s %-8s %-10s %-12s %-12s\n", "CIRCUIT", "EXIT", "AGE", "EXPIRES", "BYTES_FWD", "BYTES_BACK")
+		for _, c := range resp.Circuits {
+			fmt.Printf("%-8d %-6v %-8s %-10s %-12d %-12d\n", c.CircuitId, c.IsExitNode,
+				time.Duration(c.AgeSeconds)*time.Second, time.Duration(c.ExpiresInSeconds)*time.Second,
+				c.BytesForward, c.BytesBackward)
+		}
+	case "close":
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		id, err := strconv.ParseUint(args[1], 10, 16)
+		if err != nil {
+			log.Fatalf("Invalid circuit id %q", args[1])
+		}
+		_, err = client.CloseCircuit(ctx, &routingpb.CloseCircuitRequest{CircuitId: uint32(id)})
+		if err != nil {
+			log.Fatalf("CloseCircuit failed: %v", err)
+		}
+		fmt.Printf("Circuit %d closed\n", id)
+	case "rotate-key":
+		resp, err := client.RotateOnionKey(ctx, &routingpb.RotateOnionKeyRequest{})
+		if err != nil {
+			log.Fatalf("RotateOnionKey failed: %v", err)
+		}
+		fmt.Printf("Onion key rotated at %s\n", time.Unix(resp.RotatedAtUnix, 0).Format(time.RFC3339))
+	case "padding":
+		if len(args) != 2 || (args[1] != "on" && args[1] != "off") {
+			flag.Usage()
+			os.Exit(2)
+		}
+		resp, err := client.SetPadding(ctx, &routingpb.SetPaddingRequest{Enabled: args[1] == "on"})
+		if err != nil {
+			log.Fatalf("SetPadding failed: %v", err)
+		}
+		fmt.Printf("Padding enabled: %v\n", resp.Enabled)
+	case "drain":
+		resp, err := client.Drain(ctx, &routingpb.DrainRequest{})
+		if err != nil {
+			log.Fatalf("Drain failed: %v", err)
+		}
+		fmt.Printf("Draining, %d circuits still active\n", resp.ActiveCircuits)
+	case "events":
+		req := &routingpb.StreamEventsRequest{}
+		for _, name := range args[1:] {
+			t, ok := routingpb.RelayEvent_Type_value[strings.ToUpper(name)]
+			if !ok {
+				log.Fatalf("Unknown event type %q", name)
+			}
+			req.Types = append(req.Types, routingpb.RelayEvent_Type(t))
+		}
+		stream, err := client.StreamEvents(ctx, req)
+		if err != nil {
+			log.Fatalf("StreamEvents failed: %v", err)
+		}
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				log.Fatalf("Event stream closed: %v", err)
+			}
+			fmt.Printf("%s %s circuit=%d fwd=%d back=%d %s\n", time.Unix(event.TimestampUnix, 0).Format(time.RFC3339),
+				event.Type, event.CircuitId, event.BytesForward, event.BytesBackward, event.Message)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	utils "onion_routing/utils"
+)
+
+const serverEnvPrefix = "ONION_SERVER_"
+
+type ServerConfig struct {
+	Host    string         `yaml:"host" flag:"host" env:"HOST" usage:"host the test server listens on"`
+	Port    int            `yaml:"port" flag:"port" env:"PORT" usage:"port number"`
+	LogsDir string         `yaml:"logs_dir" flag:"logs-dir" env:"LOGS_DIR" usage:"directory for server session logs"`
+	TLS     utils.TLSFiles `yaml:"tls"`
+}
+
+func defaultServerConfig() ServerConfig {
+	return ServerConfig{
+		Host:    "localhost",
+		Port:    45034,
+		LogsDir: "logs/server",
+		TLS: utils.TLSFiles{
+			CA:   "certificates/ca.crt",
+			Cert: "certificates/server.crt",
+			Key:  "certificates/server.key",
+		},
+	}
+}
+
+func (c ServerConfig) Validate() error {
+	if c.Host == "" {
+		return utils.ConfigError("host", "must be set")
+	}
+	if c.Port <= 0 || c.Port > 65535 {
+		return utils.ConfigError("port", "must be between 1 and 65535, got %d", c.Port)
+	}
+	if c.LogsDir == "" {
+		return utils.ConfigError("logs_dir", "must be set")
+	}
+	return c.TLS.Validate("tls")
+}
+
+// loadServerConfig parses the command line and builds the server configuration.
+// Nothing in the test server can change without restarting its listener, so
+// there is no SIGHUP reload.
+func loadServerConfig() ServerConfig {
+	cfg := defaultServerConfig()
+	configPath := flag.String("config", os.Getenv(serverEnvPrefix+"CONFIG"), "path to the server's YAML config file")
+	configFlags := utils.RegisterConfigFlags(flag.CommandLine, &cfg)
+	flag.Parse()
+
+	cfg = defaultServerConfig()
+	err := utils.LoadConfig(*configPath, &cfg, serverEnvPrefix, configFlags)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		log.Fatalf("Invalid server configuration: %v", err)
+	}
+	return cfg
+}
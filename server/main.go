@@ -2,14 +2,15 @@ package main
 
 import (
 	"context"
-	"flag"
 	"fmt"
 	"log"
 	"net"
 	"strconv"
+	"strings"
 	"math/rand/v2"
 
 	// "os"
+	encryption "onion_routing/encryption"
 	routingpb "onion_routing/protofiles"
 	utils "onion_routing/utils"
 
@@ -84,26 +85,25 @@ func (s *OnionRoutingServer) GetRandomNumbers(ctx context.Context, req *routingp
 	serverLogger.PrintLog("Request received from client: %v", req)
 	log.Printf("N Received from client: %d\n", message)
 	randomNumbers := nRandomNumbers(message)
-	rndNums := ""
+	var rndNums strings.Builder
 	for i := 0 ; i < len(randomNumbers) ; i++ {
-		rndNums += strconv.Itoa(randomNumbers[i])
-		rndNums += ", "
+		rndNums.WriteString(strconv.Itoa(randomNumbers[i]))
+		rndNums.WriteString(", ")
 	}
-	retString := fmt.Sprintf("N-Random Numbers: %s", rndNums)
+	retString := fmt.Sprintf("N-Random Numbers: %s", rndNums.String())
 	resp := &routingpb.GetRandomResponse{Reply: []byte(retString)}
 	serverLogger.PrintLog("Response sending from server : %v", resp)
 	return resp, nil
 }
 
 func main() {
-	serverAddr := flag.Int("port", 45034, "port number")
-	flag.Parse()
-	realAddr := fmt.Sprintf("localhost:%v", *serverAddr)
-	creds := utils.LoadCredentialsAsServer("certificates/ca.crt", 
-										"certificates/server.crt", 
-										"certificates/server.key")	
-
-	serverLogger = utils.NewLogger("logs/server")
+	cfg := loadServerConfig()
+	realAddr := fmt.Sprintf("%s:%v", cfg.Host, cfg.Port)
+	creds := utils.LoadCredentialsAsServer(cfg.TLS.CA, 
+										cfg.TLS.Cert, 
+										cfg.TLS.Key)	
+
+	serverLogger = utils.NewLogger(cfg.LogsDir)
 	listener, err := net.Listen("tcp", realAddr)
 	if err != nil {
 		log.Fatalf("server failed to listen: %v", err)
@@ -118,9 +118,10 @@ func main() {
 	// 	log.Fatalf("server failed to server: %v", err)
 	// }
 
-	server := grpc.NewServer(grpc.Creds(creds))
+	// requests reassembled by the exit may be as large as a message gets
+	server := grpc.NewServer(grpc.Creds(creds), grpc.MaxRecvMsgSize(encryption.MAXMESSAGESIZE+4096))
 	routingpb.RegisterOnionRoutingServerServer(server, &OnionRoutingServer{})
-	log.Printf("Test Server running on %s\n", utils.ServerAddr)
+	log.Printf("Test Server running on %s\n", realAddr)
 	err = server.Serve(listener)
 	if err != nil {
 		log.Fatalf("Test server failed to server: %v", err)
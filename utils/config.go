@@ -0,0 +1,291 @@
+package utils
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config structs are plain Go structs whose leaf fields carry three tags:
+//
+//	yaml:"name"   key in the YAML file (nested structs become YAML sections)
+//	flag:"name"   command line flag overriding the value
+//	env:"NAME"    environment variable (after the binary's prefix) overriding the value
+//
+// Values are resolved in increasing precedence: defaults already set in the
+// struct, the YAML file, the environment, then flags given on the command line.
+
+type configFlag struct {
+	path  []int
+	raw   string
+	isSet bool
+	bool  bool
+}
+
+func (f *configFlag) String() string { return f.raw }
+
+func (f *configFlag) Set(value string) error {
+	f.raw = value
+	f.isSet = true
+	return nil
+}
+
+func (f *configFlag) IsBoolFlag() bool { return f.bool }
+
+// ConfigFlags remembers the flags registered for a config struct so that only
+// the ones given on the command line override the file and environment.
+type ConfigFlags struct {
+	flags []*configFlag
+}
+
+// RegisterConfigFlags defines a flag on fs for every field of cfg that has a
+// flag tag. cfg must be a pointer to a struct holding the defaults.
+func RegisterConfigFlags(fs *flag.FlagSet, cfg any) *ConfigFlags {
+	cf := &ConfigFlags{}
+	walkConfig(reflect.ValueOf(cfg).Elem(), nil, "", func(field reflect.StructField, value reflect.Value, path []int, yamlPath string) {
+		name := field.Tag.Get("flag")
+		if name == "" {
+			return
+		}
+		f := &configFlag{path: path, raw: formatConfigValue(value), bool: value.Kind() == reflect.Bool}
+		usage := field.Tag.Get("usage")
+		if usage == "" {
+			usage = yamlPath
+		}
+		fs.Var(f, name, usage)
+		cf.flags = append(cf.flags, f)
+	})
+	return cf
+}
+
+// LoadConfig applies the YAML file at path (if any), the environment variables
+// named envPrefix+<env tag> and the explicitly set flags to cfg.
+func LoadConfig(path string, cfg any, envPrefix string, flags *ConfigFlags) error {
+	root := reflect.ValueOf(cfg).Elem()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+		decoder := yaml.NewDecoder(strings.NewReader(string(data)))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("config %s: %w", path, err)
+		}
+	}
+
+	var envErr error
+	walkConfig(root, nil, "", func(field reflect.StructField, value reflect.Value, path []int, yamlPath string) {
+		name := field.Tag.Get("env")
+		if name == "" || envErr != nil {
+			return
+		}
+		raw, ok := os.LookupEnv(envPrefix + name)
+		if !ok {
+			return
+		}
+		if err := setConfigValue(value, raw); err != nil {
+			envErr = fmt.Errorf("config: environment variable %s%s: %w", envPrefix, name, err)
+		}
+	})
+	if envErr != nil {
+		return envErr
+	}
+
+	if flags != nil {
+		for _, f := range flags.flags {
+			if !f.isSet {
+				continue
+			}
+			field := root.FieldByIndex(f.path)
+			if err := setConfigValue(field, f.raw); err != nil {
+				return fmt.Errorf("config: flag value %q: %w", f.raw, err)
+			}
+		}
+	}
+	return nil
+}
+
+// TLSFiles are the PEM files a binary uses for mutual TLS.
+type TLSFiles struct {
+	CA   string `yaml:"ca" flag:"tls-ca" env:"TLS_CA" usage:"CA certificate used to verify peers"`
+	Cert string `yaml:"cert" flag:"tls-cert" env:"TLS_CERT" usage:"certificate presented to peers"`
+	Key  string `yaml:"key" flag:"tls-key" env:"TLS_KEY" usage:"private key of the certificate"`
+}
+
+func (t TLSFiles) Validate(section string) error {
+	files := []struct{ key, path string }{{"ca", t.CA}, {"cert", t.Cert}, {"key", t.Key}}
+	for _, f := range files {
+		key, path := f.key, f.path
+		if path == "" {
+			return ConfigError(section+"."+key, "must be set")
+		}
+		if _, err := os.Stat(path); err != nil {
+			return ConfigError(section+"."+key, "%v", err)
+		}
+	}
+	return nil
+}
+
+// EtcdSettings describes how a binary reaches the etcd directory store.
+type EtcdSettings struct {
+	Addr        string        `yaml:"addr" flag:"etcd-addr" env:"ETCD_ADDR" usage:"etcd client endpoint"`
+	DialTimeout time.Duration `yaml:"dial_timeout" flag:"etcd-dial-timeout" env:"ETCD_DIAL_TIMEOUT" usage:"timeout for connecting to etcd"`
+	LeaseTTL    int64         `yaml:"lease_ttl" flag:"etcd-lease-ttl" env:"ETCD_LEASE_TTL" usage:"TTL in seconds of a relay's registration lease"`
+	KeyPrefix   string        `yaml:"key_prefix" flag:"etcd-key-prefix" env:"ETCD_KEY_PREFIX" usage:"etcd key prefix under which relays register"`
+}
+
+func DefaultEtcdSettings() EtcdSettings {
+	return EtcdSettings{
+		Addr:        EtcdServerAddr,
+		DialTimeout: EtcdTimeOutInterval,
+		LeaseTTL:    EtcdLeaseTTL,
+		KeyPrefix:   EtcdKeyPrefix,
+	}
+}
+
+func (e EtcdSettings) Validate(section string) error {
+	if _, _, err := net.SplitHostPort(e.Addr); err != nil {
+		return ConfigError(section+".addr", "%v", err)
+	}
+	if e.DialTimeout <= 0 {
+		return ConfigError(section+".dial_timeout", "must be positive, got %v", e.DialTimeout)
+	}
+	if e.LeaseTTL <= 0 {
+		return ConfigError(section+".lease_ttl", "must be positive, got %d", e.LeaseTTL)
+	}
+	if !strings.HasPrefix(e.KeyPrefix, "/") || !strings.HasSuffix(e.KeyPrefix, "/") {
+		return ConfigError(section+".key_prefix", "must start and end with '/', got %q", e.KeyPrefix)
+	}
+	return nil
+}
+
+// Apply makes these settings the ones used by the etcd helpers.
+func (e EtcdSettings) Apply() {
+	EtcdServerAddr = e.Addr
+	EtcdTimeOutInterval = e.DialTimeout
+	EtcdLeaseTTL = e.LeaseTTL
+	EtcdKeyPrefix = e.KeyPrefix
+}
+
+// ConfigError describes an invalid config setting by its YAML path.
+func ConfigError(key string, format string, a ...any) error {
+	return fmt.Errorf("config: %s: %s", key, fmt.Sprintf(format, a...))
+}
+
+// OnSIGHUP calls reload every time the process receives SIGHUP.
+func OnSIGHUP(reload func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			reload()
+		}
+	}()
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func walkConfig(v reflect.Value, path []int, yamlPrefix string, visit func(reflect.StructField, reflect.Value, []int, string)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if key == "" {
+			key = strings.ToLower(field.Name)
+		}
+		if yamlPrefix != "" {
+			key = yamlPrefix + "." + key
+		}
+		fieldPath := append(append([]int{}, path...), i)
+		if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+			walkConfig(v.Field(i), fieldPath, key, visit)
+			continue
+		}
+		visit(field, v.Field(i), fieldPath, key)
+	}
+}
+
+func formatConfigValue(v reflect.Value) string {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	if v.Kind() == reflect.Slice {
+		parts := make([]string, v.Len())
+		for i := range parts {
+			parts[i] = fmt.Sprint(v.Index(i).Interface())
+		}
+		return strings.Join(parts, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func setConfigValue(v reflect.Value, raw string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setConfigValue(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+	return nil
+}
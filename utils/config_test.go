@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Name    string        `yaml:"name" flag:"name" env:"NAME"`
+	Timeout time.Duration `yaml:"timeout" flag:"timeout" env:"TIMEOUT"`
+	Etcd    EtcdSettings  `yaml:"etcd"`
+}
+
+func writeTestConfig(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		env   map[string]string
+		flags []string
+		want  string
+		etcd  time.Duration
+	}{
+		{name: "defaults", want: "default", etcd: 5 * time.Second},
+		{name: "file", file: "name: file\netcd:\n  dial_timeout: 1500ms\n", want: "file", etcd: 1500 * time.Millisecond},
+		{name: "env over file", file: "name: file\n", env: map[string]string{"TEST_NAME": "env", "TEST_ETCD_DIAL_TIMEOUT": "2s"}, want: "env", etcd: 2 * time.Second},
+		{name: "flag over env", file: "name: file\n", env: map[string]string{"TEST_NAME": "env"}, flags: []string{"-name", "flag", "-etcd-dial-timeout", "250ms"}, want: "flag", etcd: 250 * time.Millisecond},
+		{name: "flag over file", file: "name: file\n", flags: []string{"-name=flag"}, want: "flag", etcd: 5 * time.Second},
+		{name: "unset flag keeps env", env: map[string]string{"TEST_NAME": "env"}, flags: []string{"-timeout", "1s"}, want: "env", etcd: 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig{Name: "default", Etcd: DefaultEtcdSettings()}
+			fs := flag.NewFlagSet(tt.name, flag.ContinueOnError)
+			flags := RegisterConfigFlags(fs, &cfg)
+			if err := fs.Parse(tt.flags); err != nil {
+				t.Fatal(err)
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			path := ""
+			if tt.file != "" {
+				path = writeTestConfig(t, tt.file)
+			}
+			if err := LoadConfig(path, &cfg, "TEST_", flags); err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Name != tt.want {
+				t.Errorf("name = %q, want %q", cfg.Name, tt.want)
+			}
+			if cfg.Etcd.DialTimeout != tt.etcd {
+				t.Errorf("etcd dial timeout = %v, want %v", cfg.Etcd.DialTimeout, tt.etcd)
+			}
+		})
+	}
+}
+
+func TestLoadConfigRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		want string
+	}{
+		{name: "unknown key", file: "name: x\nnmae: y\n", want: "nmae"},
+		{name: "unknown nested key", file: "etcd:\n  adr: localhost:2379\n", want: "adr"},
+		{name: "bad duration in file", file: "timeout: soon\n", want: "soon"},
+		{name: "bad duration in env", env: map[string]string{"TEST_TIMEOUT": "soon"}, want: "TEST_TIMEOUT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			path := ""
+			if tt.file != "" {
+				path = writeTestConfig(t, tt.file)
+			}
+			var cfg testConfig
+			err := LoadConfig(path, &cfg, "TEST_", nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want an error mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEtcdSettingsApplyKeepsSubSecondTimeout(t *testing.T) {
+	saved := EtcdTimeOutInterval
+	defer func() { EtcdTimeOutInterval = saved }()
+	settings := DefaultEtcdSettings()
+	settings.DialTimeout = 500 * time.Millisecond
+	if err := settings.Validate("etcd"); err != nil {
+		t.Fatal(err)
+	}
+	settings.Apply()
+	if EtcdTimeOutInterval != 500*time.Millisecond {
+		t.Errorf("EtcdTimeOutInterval = %v, want 500ms", EtcdTimeOutInterval)
+	}
+}
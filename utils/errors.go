@@ -8,8 +8,38 @@ import (
 
 var (
 	ErrCircuitNotFound = errors.New("circuit ID not found")
+	ErrInvalidCell = errors.New("cell could not be decrypted")
+	ErrRelayDraining = errors.New("relay is draining and not accepting new circuits")
+	ErrReplayedCell = errors.New("replayed CREATE cell rejected")
+	ErrReplayCacheFull = errors.New("replay cache is full until the onion key rotates")
+	ErrRateLimited = errors.New("too many cells from this address")
+	ErrPowRequired = errors.New("CREATE cell lacks the required proof-of-work")
+	ErrExitPolicy = errors.New("exit policy rejects the destination")
+	ErrStreamNotFound = errors.New("stream ID not found")
+	ErrNoCredentials = errors.New("no TLS credentials configured")
+	ErrNoAuthorities = errors.New("no directory authorities configured")
+	ErrNotEnoughRelays = errors.New("not enough relays available")
+	ErrCircuitClosed = errors.New("circuit is closed")
+	ErrStreamRefused = errors.New("exit refused stream")
+	ErrCircuitTooLong = errors.New("circuit exceeds the relay's maximum length")
+	ErrKeyConfirmation = errors.New("relay did not confirm the circuit keys")
+	ErrNoGuardAvailable = errors.New("no entry guard is reachable")
+	ErrNoValidPath = errors.New("no relay satisfies the path constraints")
+	ErrBuildTimeout = errors.New("circuit build timed out")
+	ErrStreamInUse = errors.New("stream ID already in use on the circuit")
+	ErrTooManyRequests = errors.New("too many requests in flight on the circuit")
+	ErrRequestFailed = errors.New("exit could not complete the request")
+	ErrDeadlineExceeded = errors.New("request deadline exceeded")
 )
 
+// Relays name the hop an error came from in these gRPC trailers. A relay
+// that cannot reach the next hop at all also sets TrailerUnreachableHop, so
+// the client knows which relay to avoid. Hop 0 means the hop is not known
+// (the circuit could not be identified).
+const (
+	TrailerErrorHop       = "onion-error-hop"
+	TrailerUnreachableHop = "onion-unreachable-hop"
+)
 
 func IsEqual(err error, targetErr error) bool {
 	if err == targetErr {   // to handle the case with targetErr = nil (ErrSuccess)
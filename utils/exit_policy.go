@@ -0,0 +1,181 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ExitPolicyRule is one line of an exit policy:
+//
+//	accept|reject <address>:<ports>
+//
+// where <address> is *, an IP or a CIDR prefix and <ports> is *, a port or a
+// range like 8000-8999. IPv6 addresses are written in brackets.
+type ExitPolicyRule struct {
+	Accept  bool
+	Network *net.IPNet // nil for *
+	MinPort int
+	MaxPort int
+}
+
+// ExitPolicy decides which destinations an exit relay connects to. The first
+// matching rule wins; destinations no rule matches are accepted, so an empty
+// policy accepts everything and "reject *:*" ends a whitelist.
+type ExitPolicy []ExitPolicyRule
+
+func ParseExitPolicy(lines []string) (ExitPolicy, error) {
+	policy := ExitPolicy{}
+	for _, line := range lines {
+		rule, err := parseExitPolicyRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("exit policy rule %q: %w", line, err)
+		}
+		policy = append(policy, rule)
+	}
+	return policy, nil
+}
+
+func parseExitPolicyRule(line string) (ExitPolicyRule, error) {
+	var rule ExitPolicyRule
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return rule, fmt.Errorf("want \"accept|reject <address>:<ports>\"")
+	}
+	switch fields[0] {
+	case "accept":
+		rule.Accept = true
+	case "reject":
+	default:
+		return rule, fmt.Errorf("unknown action %q", fields[0])
+	}
+	sep := strings.LastIndex(fields[1], ":")
+	if sep < 0 {
+		return rule, fmt.Errorf("missing port")
+	}
+	addr, ports := strings.NewReplacer("[", "", "]", "").Replace(fields[1][:sep]), fields[1][sep+1:]
+
+	if addr != "*" {
+		if !strings.Contains(addr, "/") {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return rule, fmt.Errorf("invalid address %q", addr)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			addr = fmt.Sprintf("%s/%d", addr, bits)
+		}
+		_, network, err := net.ParseCIDR(addr)
+		if err != nil {
+			return rule, err
+		}
+		rule.Network = network
+	}
+
+	rule.MinPort, rule.MaxPort = 1, 65535
+	if ports != "*" {
+		low, high, isRange := strings.Cut(ports, "-")
+		if !isRange {
+			high = low
+		}
+		var err1, err2 error
+		rule.MinPort, err1 = strconv.Atoi(low)
+		rule.MaxPort, err2 = strconv.Atoi(high)
+		if err1 != nil || err2 != nil || rule.MinPort < 1 || rule.MaxPort > 65535 || rule.MinPort > rule.MaxPort {
+			return rule, fmt.Errorf("invalid ports %q", ports)
+		}
+	}
+	return rule, nil
+}
+
+func (r ExitPolicyRule) String() string {
+	action := "reject"
+	if r.Accept {
+		action = "accept"
+	}
+	addr := "*"
+	if r.Network != nil {
+		ones, _ := r.Network.Mask.Size()
+		addr = r.Network.String()
+		if r.Network.IP.To4() == nil {
+			addr = "[" + r.Network.IP.String() + "]/" + strconv.Itoa(ones)
+		}
+	}
+	ports := "*"
+	if r.MinPort != 1 || r.MaxPort != 65535 {
+		ports = strconv.Itoa(r.MinPort)
+		if r.MaxPort != r.MinPort {
+			ports += "-" + strconv.Itoa(r.MaxPort)
+		}
+	}
+	return action + " " + addr + ":" + ports
+}
+
+// privateNetworks are this host and the networks it sits on. Exits never open
+// streams to them, whatever their policy says.
+var privateNetworks = []string{
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.168.0.0/16", "[::]/128", "[::1]/128", "[fc00::]/7", "[fe80::]/10",
+}
+
+// RejectPrivate returns the policy with rules rejecting loopback, private and
+// link-local addresses put before its own.
+func (p ExitPolicy) RejectPrivate() ExitPolicy {
+	policy := make(ExitPolicy, 0, len(privateNetworks)+len(p))
+	for _, network := range privateNetworks {
+		rule, err := parseExitPolicyRule("reject " + network + ":*")
+		if err != nil {
+			panic(err)
+		}
+		policy = append(policy, rule)
+	}
+	return append(policy, p...)
+}
+
+// Strings returns the policy in the form ParseExitPolicy reads.
+func (p ExitPolicy) Strings() []string {
+	lines := make([]string, len(p))
+	for i, rule := range p {
+		lines[i] = rule.String()
+	}
+	return lines
+}
+
+// Allows reports whether the policy accepts a connection to ip:port.
+func (p ExitPolicy) Allows(ip net.IP, port int) bool {
+	for _, rule := range p {
+		if port < rule.MinPort || port > rule.MaxPort {
+			continue
+		}
+		if rule.Network != nil && !rule.Network.Contains(ip) {
+			continue
+		}
+		return rule.Accept
+	}
+	return true
+}
+
+// MayAllow reports whether the policy can accept a connection to host:port
+// before host is resolved. For an IP it is Allows; for a host name only the
+// port is known, so it is false only if every address is rejected on that
+// port. The exit checks the resolved address again.
+func (p ExitPolicy) MayAllow(host string, port int) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		return p.Allows(ip, port)
+	}
+	for _, rule := range p {
+		if port < rule.MinPort || port > rule.MaxPort {
+			continue
+		}
+		if rule.Accept {
+			return true
+		}
+		if rule.Network == nil {
+			return false
+		}
+	}
+	return true
+}
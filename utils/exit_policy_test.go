@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestParseExitPolicyRejectsMalformedRules(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"accept",
+		"accept *:80 extra",
+		"allow *:80",
+		"accept 10.0.0.1",
+		"accept 10.0.0.300:80",
+		"accept 10.0.0.0/33:*",
+		"accept *:0",
+		"accept *:65536",
+		"accept *:90-80",
+		"accept *:http",
+		"accept *:80-",
+	} {
+		if _, err := ParseExitPolicy([]string{"accept *:443", line}); err == nil {
+			t.Errorf("ParseExitPolicy accepted %q", line)
+		}
+	}
+}
+
+func TestExitPolicyAllows(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy []string
+		ip     string
+		port   int
+		want   bool
+	}{
+		{"empty policy accepts", nil, "10.0.0.1", 22, true},
+		{"cidr rejects inside", []string{"reject 10.0.0.0/8:*"}, "10.200.0.1", 80, false},
+		{"cidr ignores outside", []string{"reject 10.0.0.0/8:*"}, "11.0.0.1", 80, true},
+		{"single address", []string{"reject 192.168.1.1:*"}, "192.168.1.2", 80, true},
+		{"port range low end", []string{"accept *:8000-8999", "reject *:*"}, "10.0.0.1", 8000, true},
+		{"port range high end", []string{"accept *:8000-8999", "reject *:*"}, "10.0.0.1", 8999, true},
+		{"port below range", []string{"accept *:8000-8999", "reject *:*"}, "10.0.0.1", 7999, false},
+		{"port above range", []string{"accept *:8000-8999", "reject *:*"}, "10.0.0.1", 9000, false},
+		{"first match accepts", []string{"accept 10.0.0.1:80", "reject 10.0.0.0/8:*"}, "10.0.0.1", 80, true},
+		{"first match rejects", []string{"reject 10.0.0.0/8:*", "accept 10.0.0.1:80"}, "10.0.0.1", 80, false},
+		{"later rule after miss", []string{"accept 10.0.0.1:80", "reject 10.0.0.0/8:*"}, "10.0.0.2", 80, false},
+		{"unmatched accepted", []string{"reject *:25"}, "10.0.0.1", 80, true},
+		{"default reject", []string{"accept *:443", "reject *:*"}, "10.0.0.1", 80, false},
+		{"ipv6 loopback", []string{"reject [::1]:*"}, "::1", 80, false},
+		{"ipv6 cidr", []string{"reject [fe80::]/10:*"}, "fe80::1", 80, false},
+		{"ipv4 rule ignores ipv6", []string{"reject 0.0.0.0/0:*"}, "2001:db8::1", 80, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := ParseExitPolicy(tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := policy.Allows(net.ParseIP(tt.ip), tt.port); got != tt.want {
+				t.Errorf("Allows(%s, %d) = %v, want %v", tt.ip, tt.port, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExitPolicyMayAllowHostNames(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy []string
+		port   int
+		want   bool
+	}{
+		{"empty policy", nil, 80, true},
+		{"accepted port", []string{"reject 10.0.0.0/8:*", "accept *:80", "reject *:*"}, 80, true},
+		{"rejected port", []string{"reject 10.0.0.0/8:*", "accept *:80", "reject *:*"}, 22, false},
+		{"only some addresses rejected", []string{"reject 10.0.0.0/8:*"}, 22, true},
+		{"reject everything", []string{"reject *:*"}, 80, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := ParseExitPolicy(tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := policy.MayAllow("example.com", tt.port); got != tt.want {
+				t.Errorf("MayAllow(example.com, %d) = %v, want %v", tt.port, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExitPolicyStringsRoundTrip(t *testing.T) {
+	lines := []string{"accept 10.0.0.0/8:80", "reject 192.168.1.1/32:8000-8999", "reject [fe80::]/10:*", "reject *:*"}
+	policy, err := ParseExitPolicy(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := ParseExitPolicy(policy.Strings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(policy, again) {
+		t.Errorf("policy changed after a round trip through %q", policy.Strings())
+	}
+}
+
+func TestExitPolicyRejectPrivate(t *testing.T) {
+	policy, err := ParseExitPolicy([]string{"accept *:*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy = policy.RejectPrivate()
+	for _, ip := range []string{
+		"127.0.0.1", "127.8.9.10", "10.1.2.3", "172.16.0.1", "172.31.255.255", "192.168.1.1",
+		"169.254.169.254", "100.64.0.1", "0.0.0.0", "::1", "::", "fd00::1", "fe80::1", "::ffff:127.0.0.1",
+	} {
+		if policy.Allows(net.ParseIP(ip), 2379) {
+			t.Errorf("%s accepted by a policy accepting everything", ip)
+		}
+	}
+	for _, ip := range []string{"93.184.216.34", "172.32.0.1", "8.8.8.8", "2606:2800:220:1::1"} {
+		if !policy.Allows(net.ParseIP(ip), 80) {
+			t.Errorf("public address %s rejected", ip)
+		}
+	}
+}
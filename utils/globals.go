@@ -4,16 +4,27 @@ import (
 	"time"
 	"fmt"
 	"net"
+	"strings"
 )
 
-const (
+// Defaults for settings that every binary can override from its config file,
+// the environment or flags (see config.go).
+var (
 	ServerAddr = "localhost:45034"
 	EtcdServerAddr = "localhost:2379"
-	EtcdTimeOutInterval time.Duration = 5
+	EtcdTimeOutInterval time.Duration = 5 * time.Second
 	EtcdLeaseTTL int64 = 3
 	EtcdKeyPrefix string = "/relays/"
 )
 
+// CountersignaturePrefix is where directory authorities keep their
+// countersignatures of the descriptors of relays registered under keyPrefix,
+// as <prefix><authority>/<node>. It lies outside keyPrefix, so relay listings
+// skip it.
+func CountersignaturePrefix(keyPrefix string) string {
+	return strings.TrimSuffix(keyPrefix, "/") + "-countersignatures/"
+}
+
 func GetAvaliablePort() (int, error) {
 	listener, err := net.Listen("tcp", ":0") 
 	if err != nil {